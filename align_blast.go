@@ -0,0 +1,145 @@
+package mica
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// BlastAligner searches with one of the BLAST+ programs: blastp, blastx,
+// psiblast or deltablast. Its indexes are protein BLAST databases built with
+// `makeblastdb`.
+type BlastAligner struct {
+	// The name of the BLAST+ program, e.g., "blastp".
+	Program string
+
+	// The locations of the program's executable and of `makeblastdb`.
+	Exec, MakeBlastDB string
+
+	// The number of iterations run by psiblast.
+	Iterations int
+
+	// The PSSM that psiblast searches instead of the query, and the file it
+	// saves the PSSM of its last iteration to. (Both are optional.)
+	InPSSM, OutPSSM string
+
+	// The location of the RPS database, needed by deltablast.
+	RPSDB string
+}
+
+// NewBlastAligner returns an Aligner that searches with the BLAST+ program
+// given, with default settings.
+func NewBlastAligner(program string) *BlastAligner {
+	return &BlastAligner{
+		Program:     program,
+		Exec:        program,
+		MakeBlastDB: "makeblastdb",
+		Iterations:  1,
+		InPSSM:      "",
+		OutPSSM:     "",
+		RPSDB:       "",
+	}
+}
+
+func (a *BlastAligner) Name() string {
+	return a.Program
+}
+
+func (a *BlastAligner) CoarseIndex() string {
+	return FileBlastCoarse
+}
+
+// HasIndex returns true if 'index' is a BLAST database, which may be split
+// into volumes.
+func (a *BlastAligner) HasIndex(index string) bool {
+	return fileExists(index+".pin") == nil || fileExists(index+".pal") == nil
+}
+
+func (a *BlastAligner) Index(fasta, index string) error {
+	// e.g., `makeblastdb -dbtype prot -in coarse.fasta`
+	cmd := exec.Command(
+		a.MakeBlastDB, "-dbtype", "prot",
+		"-title", path.Base(index),
+		"-in", fasta,
+		"-out", index)
+	if err := Exec(cmd); err != nil {
+		return fmt.Errorf("Could not create BLAST database: %s", err)
+	}
+	return nil
+}
+
+func (a *BlastAligner) Search(q AlignQuery, out io.Writer) error {
+	cmd := exec.Command(a.Exec, append(a.flags(q), q.Args...)...)
+	cmd.Stdin = bytes.NewReader(q.Query)
+	cmd.Stdout = out
+	return Exec(cmd)
+}
+
+// Hits asks BLAST for XML output, which (unlike tabular output) reports the
+// ordinal of each subject.
+func (a *BlastAligner) Hits(q AlignQuery) chan ReadHit {
+	return streamHits(func(send func(Hit) error) error {
+		flags := append(a.flags(q), q.Args...)
+		cmd := exec.Command(a.Exec, append(flags, "-outfmt", "5")...)
+		cmd.Stdin = bytes.NewReader(q.Query)
+		return ExecRead(cmd, func(stdout io.Reader) error {
+			return scanBlastXML(stdout, send)
+		})
+	})
+}
+
+// flags returns the flags passed to BLAST for every search.
+func (a *BlastAligner) flags(q AlignQuery) []string {
+	flags := []string{
+		"-db", q.Index,
+		"-num_threads", fmt.Sprintf("%d", q.Threads),
+	}
+	if q.DBSize > 0 {
+		flags = append(flags, "-dbsize", fmt.Sprintf("%d", q.DBSize))
+	}
+	switch a.Program {
+	case "psiblast":
+		flags = append(flags, "-num_iterations",
+			fmt.Sprintf("%d", a.Iterations))
+		if len(a.InPSSM) > 0 {
+			flags = append(flags, "-in_pssm", a.InPSSM)
+		}
+		if len(a.OutPSSM) > 0 {
+			flags = append(flags, "-out_pssm", a.OutPSSM,
+				"-save_pssm_after_last_round")
+		}
+	case "deltablast":
+		flags = append(flags, "-rpsdb", a.RPSDB)
+	}
+	return flags
+}
+
+// SafeBlastArgs are the BLAST options accepted by CheckBlastArgs. They change
+// how hits are found and scored, but not which files BLAST reads or writes,
+// nor the format of its output.
+var SafeBlastArgs = []string{
+	"-evalue", "-max_target_seqs", "-matrix", "-word_size",
+	"-comp_based_stats", "-gapopen", "-gapextend", "-threshold",
+}
+
+// CheckBlastArgs returns an error unless 'args' are pairs of one of
+// SafeBlastArgs and its value, e.g., ["-evalue", "1e-5"].
+func CheckBlastArgs(args []string) error {
+	for i := 0; i < len(args); i += 2 {
+		safe := false
+		for _, arg := range SafeBlastArgs {
+			safe = safe || arg == args[i]
+		}
+		if !safe {
+			return fmt.Errorf("'%s' is not one of %s.",
+				args[i], strings.Join(SafeBlastArgs, ", "))
+		}
+		if i+1 >= len(args) || strings.HasPrefix(args[i+1], "-") {
+			return fmt.Errorf("No value given for '%s'.", args[i])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package mica
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+)
+
+// DmndAligner searches with DIAMOND's blastp or blastx command. Its indexes
+// are DIAMOND databases built with `diamond makedb`.
+//
+// DIAMOND writes its results in its own DAA format, which is then converted
+// to BLAST tabular format with `diamond view`.
+type DmndAligner struct {
+	// The DIAMOND command used to search: "blastp" or "blastx".
+	Command string
+
+	// The location of the 'diamond' executable.
+	Exec string
+
+	// The value of DIAMOND's '--top' parameter.
+	Top int
+
+	// When set, Search writes DIAMOND's DAA file rather than converting it
+	// to BLAST tabular format.
+	Daa bool
+}
+
+// NewDmndAligner returns an Aligner that searches with the DIAMOND command
+// given ("blastp" or "blastx"), with default settings.
+func NewDmndAligner(command string) *DmndAligner {
+	return &DmndAligner{
+		Command: command,
+		Exec:    "diamond",
+		Top:     50,
+		Daa:     false,
+	}
+}
+
+func (a *DmndAligner) Name() string {
+	return "diamond " + a.Command
+}
+
+func (a *DmndAligner) CoarseIndex() string {
+	return FileDmndCoarse
+}
+
+func (a *DmndAligner) HasIndex(index string) bool {
+	return fileExists(index+".dmnd") == nil
+}
+
+func (a *DmndAligner) Index(fasta, index string) error {
+	cmd := exec.Command(a.Exec, "makedb", "--in", fasta, "-d", index)
+	if err := Exec(cmd); err != nil {
+		return fmt.Errorf("Could not create diamond database: %s", err)
+	}
+	return nil
+}
+
+func (a *DmndAligner) Search(q AlignQuery, out io.Writer) error {
+	tmpDir, cleanup, err := q.tempDir("mica-diamond-search")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	daa, err := a.search(q, tmpDir)
+	if err != nil {
+		return err
+	}
+	if a.Daa {
+		f, err := os.Open(daa)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(out, f)
+		return err
+	}
+	cmd := exec.Command(a.Exec, "view", "-a", daa)
+	cmd.Stdout = out
+	if err := Exec(cmd); err != nil {
+		return fmt.Errorf("Error converting daa file to blast tabular: %s",
+			err)
+	}
+	return nil
+}
+
+func (a *DmndAligner) Hits(q AlignQuery) chan ReadHit {
+	return streamHits(func(send func(Hit) error) error {
+		tmpDir, cleanup, err := q.tempDir("mica-diamond-search")
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		daa, err := a.search(q, tmpDir)
+		if err != nil {
+			return err
+		}
+		cmd := exec.Command(a.Exec, "view", "-a", daa)
+		err = ExecRead(cmd, func(stdout io.Reader) error {
+			return scanBlastTabular(stdout, send)
+		})
+		if err != nil {
+			return fmt.Errorf("Error converting daa file to blast "+
+				"tabular: %s", err)
+		}
+		return nil
+	})
+}
+
+// search runs DIAMOND in 'tmpDir' and returns the name of the DAA file
+// written.
+func (a *DmndAligner) search(
+	q AlignQuery, tmpDir string) (string, error) {
+
+	queryFile, err := q.writeQuery(tmpDir)
+	if err != nil {
+		return "", err
+	}
+	daa := path.Join(tmpDir, "hits")
+	flags := []string{
+		a.Command,
+		"--sensitive",
+		"-d", q.Index,
+		"-q", queryFile,
+		"--threads", fmt.Sprintf("%d", q.Threads),
+		"-a", daa,
+		"--compress", "0",
+		"--top", fmt.Sprintf("%d", a.Top),
+		"--tmpdir", tmpDir,
+	}
+	cmd := exec.Command(a.Exec, append(flags, q.Args...)...)
+	if err := Exec(cmd); err != nil {
+		return "", err
+	}
+	return daa + ".daa", nil
+}
@@ -0,0 +1,131 @@
+package mica
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+)
+
+// HmmAligner searches profile HMMs with HMMER's `hmmsearch`. Its queries are
+// profile HMMs (in any format hmmsearch reads) rather than FASTA sequences,
+// and its indexes are plain FASTA files, which hmmsearch reads as they are.
+// The coarse database's FASTA file is its coarse index.
+//
+// hmmsearch computes e-values for a number of sequences rather than
+// residues, so AlignQuery.DBSeqs is given to it as '-Z' and DBSize is
+// ignored. The hits of Hits are the domains of hmmsearch's per-domain table
+// (see ReadHmmerDomTable).
+type HmmAligner struct {
+	// The location of the 'hmmsearch' executable.
+	Exec string
+
+	// When set, Search writes hmmsearch's per-sequence table ('--tblout')
+	// and per-domain table ('--domtblout') to these files.
+	TblOut, DomTblOut string
+}
+
+// NewHmmAligner returns an Aligner that searches profile HMMs with
+// hmmsearch, with default settings.
+func NewHmmAligner() *HmmAligner {
+	return &HmmAligner{
+		Exec:      "hmmsearch",
+		TblOut:    "",
+		DomTblOut: "",
+	}
+}
+
+func (a *HmmAligner) Name() string {
+	return "hmmsearch"
+}
+
+func (a *HmmAligner) CoarseIndex() string {
+	return FileCoarseFasta
+}
+
+func (a *HmmAligner) HasIndex(index string) bool {
+	return fileExists(index) == nil
+}
+
+// Index copies the FASTA file, which is all there is to an index.
+func (a *HmmAligner) Index(fasta, index string) error {
+	return copyFasta(fasta, index)
+}
+
+// Search writes hmmsearch's own output, along with its tables when TblOut or
+// DomTblOut are set. For a fine database built by Searcher.FineSearch, the
+// targets in every table are the original sequences.
+func (a *HmmAligner) Search(q AlignQuery, out io.Writer) error {
+	tmpDir, cleanup, err := q.tempDir("mica-hmmsearch")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	hmmFile, err := writeHMMs(q, tmpDir)
+	if err != nil {
+		return err
+	}
+	flags := a.flags(q)
+	if len(a.TblOut) > 0 {
+		flags = append(flags, "--tblout", a.TblOut)
+	}
+	if len(a.DomTblOut) > 0 {
+		flags = append(flags, "--domtblout", a.DomTblOut)
+	}
+	flags = append(append(flags, q.Args...), hmmFile, q.Index)
+	cmd := exec.Command(a.Exec, flags...)
+	cmd.Stdout = out
+	return Exec(cmd)
+}
+
+// Hits reads the per-domain table of hmmsearch, whose own output is thrown
+// away.
+func (a *HmmAligner) Hits(q AlignQuery) chan ReadHit {
+	return streamHits(func(send func(Hit) error) error {
+		tmpDir, cleanup, err := q.tempDir("mica-hmmsearch")
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		hmmFile, err := writeHMMs(q, tmpDir)
+		if err != nil {
+			return err
+		}
+		domTbl := path.Join(tmpDir, "hits.domtbl")
+		flags := append(a.flags(q), "-o", os.DevNull, "--domtblout", domTbl)
+		flags = append(append(flags, q.Args...), hmmFile, q.Index)
+		if err := Exec(exec.Command(a.Exec, flags...)); err != nil {
+			return err
+		}
+
+		f, err := os.Open(domTbl)
+		if err != nil {
+			return fmt.Errorf("Could not open per-domain table: %s", err)
+		}
+		defer f.Close()
+		return scanHmmerTable(f, true, send)
+	})
+}
+
+// flags returns the flags passed to hmmsearch for every search.
+func (a *HmmAligner) flags(q AlignQuery) []string {
+	flags := []string{"--cpu", fmt.Sprintf("%d", q.Threads)}
+	if q.DBSeqs > 0 {
+		flags = append(flags, "-Z", fmt.Sprintf("%d", q.DBSeqs))
+	}
+	return flags
+}
+
+// writeHMMs writes the profile HMMs of a search to a file in 'dir', since
+// hmmsearch reads sequences (not HMMs) from stdin.
+func writeHMMs(q AlignQuery, dir string) (string, error) {
+	hmmFile := path.Join(dir, "query.hmm")
+	if err := ioutil.WriteFile(hmmFile, q.Query, 0666); err != nil {
+		return "", fmt.Errorf("Could not write HMM file: %s", err)
+	}
+	return hmmFile, nil
+}
@@ -0,0 +1,123 @@
+package mica
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+)
+
+// MMseqsAligner searches with MMseqs2's easy-search workflow. Its indexes are
+// MMseqs2 sequence databases built with `mmseqs createdb`. Nucleotide queries
+// are translated by MMseqs2, so it serves blastx searches too.
+//
+// The size of the database that e-values are computed for can't be changed
+// in MMseqs2, so AlignQuery.DBSize is ignored.
+type MMseqsAligner struct {
+	// The location of the 'mmseqs' executable.
+	Exec string
+
+	// The e-value threshold. (MMseqs2's own default of 0.001 is much stricter
+	// than BLAST's, which is too strict for a coarse search.)
+	Evalue float64
+}
+
+// mmseqsFormat makes MMseqs2 write the columns of BLAST tabular output, with
+// identity as a percentage (rather than a fraction).
+const mmseqsFormat = "query,target,pident,alnlen,mismatch,gapopen," +
+	"qstart,qend,tstart,tend,evalue,bits"
+
+// NewMMseqsAligner returns an Aligner that searches with MMseqs2, with
+// default settings.
+func NewMMseqsAligner() *MMseqsAligner {
+	return &MMseqsAligner{
+		Exec:   "mmseqs",
+		Evalue: 10.0,
+	}
+}
+
+func (a *MMseqsAligner) Name() string {
+	return "mmseqs"
+}
+
+func (a *MMseqsAligner) CoarseIndex() string {
+	return FileMMseqsCoarse
+}
+
+func (a *MMseqsAligner) HasIndex(index string) bool {
+	return fileExists(index+".dbtype") == nil
+}
+
+func (a *MMseqsAligner) Index(fasta, index string) error {
+	cmd := exec.Command(a.Exec, "createdb", fasta, index)
+	if err := Exec(cmd); err != nil {
+		return fmt.Errorf("Could not create MMseqs2 database: %s", err)
+	}
+	return nil
+}
+
+func (a *MMseqsAligner) Search(q AlignQuery, out io.Writer) error {
+	tmpDir, cleanup, err := q.tempDir("mica-mmseqs-search")
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	results, err := a.search(q, tmpDir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(results)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(out, f)
+	return err
+}
+
+func (a *MMseqsAligner) Hits(q AlignQuery) chan ReadHit {
+	return streamHits(func(send func(Hit) error) error {
+		tmpDir, cleanup, err := q.tempDir("mica-mmseqs-search")
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+
+		results, err := a.search(q, tmpDir)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(results)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return scanBlastTabular(f, send)
+	})
+}
+
+// search runs MMseqs2 in 'tmpDir' and returns the name of the file of
+// results written.
+func (a *MMseqsAligner) search(
+	q AlignQuery, tmpDir string) (string, error) {
+
+	queryFile, err := q.writeQuery(tmpDir)
+	if err != nil {
+		return "", err
+	}
+	results := path.Join(tmpDir, "hits.m8")
+	flags := []string{
+		"easy-search", queryFile, q.Index, results, path.Join(tmpDir, "tmp"),
+		"--threads", fmt.Sprintf("%d", q.Threads),
+		"-e", fmt.Sprintf("%g", a.Evalue),
+		"--format-output", mmseqsFormat,
+	}
+	cmd := exec.Command(a.Exec, append(flags, q.Args...)...)
+	if err := Exec(cmd); err != nil {
+		return "", err
+	}
+	return results, nil
+}
@@ -0,0 +1,491 @@
+package mica
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// NativeAligner searches protein queries without any external programs. Its
+// indexes are plain FASTA files, and the coarse database's FASTA file is its
+// coarse index.
+//
+// When an index is searched, its K-mers are put in a seeds table (see
+// Seeds), which finds the exact K-mer matches between a query and each
+// subject. Each match is extended without gaps (with an X-drop), and the
+// subjects with a good enough extension are aligned to the query with
+// Smith-Waterman. Alignments are scored with BLOSUM62 and BLAST's default gap
+// penalties, and their e-values are computed with Karlin-Altschul statistics
+// for the database size given with each search (or the size of the index).
+//
+// The output of Search is in BLAST tabular format.
+type NativeAligner struct {
+	// The length of the K-mers used to find subjects.
+	SeedSize int
+
+	// K-mers in low complexity regions of the subjects (see IsLowComplexity)
+	// with this window size are skipped.
+	LowComplexity int
+
+	// An ungapped extension stops once its score falls this far below the
+	// best score seen.
+	XDrop int
+
+	// Only subjects with an ungapped extension scoring at least this much
+	// (as a raw score) are aligned.
+	UngappedCutoff int
+
+	// Hits with an e-value greater than this are not reported.
+	Evalue float64
+
+	// The greatest number of subjects reported for each query.
+	MaxTargets int
+
+	// The index searched last. (It is shared by copies of the aligner.)
+	cache *nativeCache
+}
+
+// nativeCache keeps the index searched last in memory, so that it isn't read
+// again while the same index is searched.
+type nativeCache struct {
+	sync.Mutex
+	index *nativeIndex
+}
+
+// Alignments are banded: they only pair residues within this many diagonals
+// of the match that they start from (on either side). See swAlign.
+const nativeBand = 64
+
+// The greatest number of alignments reported between a query and a subject.
+const nativeMaxHsps = 5
+
+// NewNativeAligner returns an Aligner that searches without any external
+// programs, with default settings.
+func NewNativeAligner() *NativeAligner {
+	return &NativeAligner{
+		SeedSize:       3,
+		LowComplexity:  DefaultDBConf.SeedLowComplexity,
+		XDrop:          16,
+		UngappedCutoff: 38,
+		Evalue:         10.0,
+		MaxTargets:     500,
+		cache:          &nativeCache{},
+	}
+}
+
+func (a *NativeAligner) Name() string {
+	return "native"
+}
+
+func (a *NativeAligner) CoarseIndex() string {
+	return FileCoarseFasta
+}
+
+func (a *NativeAligner) HasIndex(index string) bool {
+	return fileExists(index) == nil
+}
+
+// Index copies the FASTA file, which is all there is to an index.
+func (a *NativeAligner) Index(fasta, index string) error {
+	return copyFasta(fasta, index)
+}
+
+// copyFasta copies the FASTA file 'fasta' to 'index', for aligners whose
+// indexes are plain FASTA files.
+func copyFasta(fasta, index string) error {
+	if fasta == index {
+		return nil
+	}
+	in, err := os.Open(fasta)
+	if err != nil {
+		return fmt.Errorf("Could not open '%s': %s", fasta, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(index)
+	if err != nil {
+		return fmt.Errorf("Could not create '%s': %s", index, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("Could not write '%s': %s", index, err)
+	}
+	return out.Close()
+}
+
+// Search writes hits in BLAST tabular format.
+func (a *NativeAligner) Search(q AlignQuery, out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	for readHit := range a.Hits(q) {
+		if readHit.Err != nil {
+			return readHit.Err
+		}
+		if err := WriteBlastTabular(bw, []Hit{readHit.Hit}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// withArgs returns a copy of the aligner changed by the BLAST arguments
+// '-evalue' and '-max_target_seqs', which are the only arguments it
+// understands.
+func (a *NativeAligner) withArgs(args []string) (*NativeAligner, error) {
+	b := *a
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return nil, fmt.Errorf("Missing value for '%s'.", args[i])
+		}
+		var err error
+		switch args[i] {
+		case "-evalue":
+			b.Evalue, err = strconv.ParseFloat(args[i+1], 64)
+		case "-max_target_seqs":
+			b.MaxTargets, err = strconv.Atoi(args[i+1])
+		default:
+			return nil, fmt.Errorf(
+				"The native aligner does not understand '%s'.", args[i])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for '%s': %s", args[i], err)
+		}
+	}
+	return &b, nil
+}
+
+// Hits searches the queries concurrently, with one goroutine for each of the
+// query's Threads. Hits are still sent in the order of the queries. The only
+// Args understood are '-evalue' and '-max_target_seqs'.
+func (a *NativeAligner) Hits(q AlignQuery) chan ReadHit {
+	return streamHits(func(send func(Hit) error) error {
+		search, err := a.withArgs(q.Args)
+		if err != nil {
+			return err
+		}
+		index, err := search.load(q.Index)
+		if err != nil {
+			return err
+		}
+		queries, err := readNativeQueries(q.Query)
+		if err != nil {
+			return err
+		}
+		dbSize := q.DBSize
+		if dbSize == 0 {
+			dbSize = index.residues
+		}
+
+		results := make([][]Hit, len(queries))
+		done := make([]chan struct{}, len(queries))
+		for i := range done {
+			done[i] = make(chan struct{})
+		}
+		jobs := make(chan int)
+		go func() {
+			for i := range queries {
+				jobs <- i
+			}
+			close(jobs)
+		}()
+		for w := 0; w < max(1, q.Threads); w++ {
+			go func() {
+				mem := NewSWMemory()
+				for i := range jobs {
+					results[i] = search.searchQuery(
+						index, queries[i], dbSize, mem)
+					close(done[i])
+				}
+			}()
+		}
+
+		for i := range queries {
+			<-done[i]
+			for _, hit := range results[i] {
+				if err := send(hit); err != nil {
+					return err
+				}
+			}
+			results[i] = nil
+		}
+		return nil
+	})
+}
+
+// nativeQuery is a query read from FASTA input, with every residue outside
+// of the BLOSUM62 alphabet replaced by 'X'.
+type nativeQuery struct {
+	id       string
+	residues []byte
+}
+
+func readNativeQueries(fasta []byte) ([]nativeQuery, error) {
+	seqChan, err := ReadOriginalSeqsFrom(bytes.NewReader(fasta), nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read queries: %s", err)
+	}
+	queries := make([]nativeQuery, 0, 10)
+	for readSeq := range seqChan {
+		if readSeq.Err != nil {
+			return nil, fmt.Errorf("Could not read queries: %s", readSeq.Err)
+		}
+		queries = append(queries, nativeQuery{
+			id:       firstWord(readSeq.Seq.Name),
+			residues: blosumResidues(readSeq.Seq.Residues),
+		})
+	}
+	return queries, nil
+}
+
+// blosumResidues replaces every residue outside of the BLOSUM62 alphabet
+// with 'X', in place.
+func blosumResidues(residues []byte) []byte {
+	for i, r := range residues {
+		if r < 'A' || r > 'Z' || SeedAlphaNums[r-'A'] == -1 {
+			residues[i] = 'X'
+		}
+	}
+	return residues
+}
+
+// nativeIndex is an index loaded into memory for searching.
+type nativeIndex struct {
+	fasta string
+
+	// The identifier (the first word of the header) and the residues of each
+	// subject, and the total number of residues.
+	ids      []string
+	subjects [][]byte
+	residues uint64
+
+	// The seeds of each subject. Since seed locations can't be beyond the
+	// range of a uint16, long subjects are split into pieces (which overlap
+	// so that no K-mer is lost).
+	seeds  Seeds
+	pieces []nativePiece
+}
+
+type nativePiece struct {
+	subject, offset int
+}
+
+// nativePieceLen is the number of K-mers in each piece of a subject.
+const nativePieceLen = math.MaxUint16 + 1
+
+// load returns the index in the FASTA file 'fasta', which is read unless it
+// was the last index searched.
+func (a *NativeAligner) load(fasta string) (*nativeIndex, error) {
+	a.cache.Lock()
+	defer a.cache.Unlock()
+
+	if a.cache.index != nil && a.cache.index.fasta == fasta {
+		return a.cache.index, nil
+	}
+
+	f, err := os.Open(fasta)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open '%s': %s", fasta, err)
+	}
+	defer f.Close()
+
+	seqChan, err := ReadOriginalSeqsFrom(f, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read '%s': %s", fasta, err)
+	}
+	index := &nativeIndex{
+		fasta:    fasta,
+		ids:      make([]string, 0, 1000),
+		subjects: make([][]byte, 0, 1000),
+		seeds:    NewSeeds(a.SeedSize, a.LowComplexity),
+		pieces:   make([]nativePiece, 0, 1000),
+	}
+	for readSeq := range seqChan {
+		if readSeq.Err != nil {
+			return nil, fmt.Errorf("Could not read '%s': %s",
+				fasta, readSeq.Err)
+		}
+		subject := len(index.subjects)
+		residues := blosumResidues(readSeq.Seq.Residues)
+		index.ids = append(index.ids, firstWord(readSeq.Seq.Name))
+		index.subjects = append(index.subjects, residues)
+		index.residues += uint64(len(residues))
+
+		for off := 0; off < len(residues); off += nativePieceLen {
+			end := min(len(residues), off+nativePieceLen+a.SeedSize-1)
+			index.seeds.addFront(len(index.pieces), residues[off:end])
+			index.pieces = append(index.pieces, nativePiece{subject, off})
+		}
+	}
+	a.cache.index = index
+	return index, nil
+}
+
+// nativeSeed is an ungapped extension of a K-mer match between a query and
+// the subject 'subject', which starts at 'qpos' and 'spos'.
+type nativeSeed struct {
+	subject, qpos, spos, score int
+}
+
+// searchQuery returns the hits of a single query, with the best subjects
+// first.
+func (a *NativeAligner) searchQuery(
+	index *nativeIndex,
+	query nativeQuery,
+	dbSize uint64,
+	mem *SWMemory,
+) []Hit {
+	k, qres := a.SeedSize, query.residues
+
+	// Extend every K-mer match, but only once for each diagonal of each
+	// subject that an extension has already covered.
+	extended := make(map[int64]int, 1000)
+	seeds := make(map[int][]nativeSeed, 100)
+	locs := make([][2]uint, 0, 100)
+	for i := 0; i+k <= len(qres); i++ {
+		if !validKmer(qres[i : i+k]) {
+			continue
+		}
+		for _, loc := range index.seeds.Lookup(qres[i:i+k], &locs) {
+			piece := index.pieces[loc[0]]
+			subject := piece.subject
+			spos := piece.offset + int(loc[1])
+
+			diag := int64(subject)<<32 | int64(uint32(spos-i))
+			if end, ok := extended[diag]; ok && i < end {
+				continue
+			}
+			score, qend := xdropExtend(
+				qres, index.subjects[subject], i, spos, k, a.XDrop)
+			extended[diag] = qend
+			if score >= a.UngappedCutoff {
+				seeds[subject] = append(seeds[subject],
+					nativeSeed{subject, i, spos, score})
+			}
+		}
+	}
+
+	targets := make([][]Hit, 0, len(seeds))
+	for _, subjectSeeds := range seeds {
+		hits := a.alignSubject(index, query, subjectSeeds, dbSize, mem)
+		if len(hits) > 0 {
+			targets = append(targets, hits)
+		}
+	}
+	sort.Sort(nativeTargets(targets))
+	if a.MaxTargets > 0 && len(targets) > a.MaxTargets {
+		targets = targets[:a.MaxTargets]
+	}
+
+	hits := make([]Hit, 0, len(targets))
+	for _, target := range targets {
+		hits = append(hits, target...)
+	}
+	return hits
+}
+
+// alignSubject aligns a query to a single subject, starting from the best
+// ungapped extensions, and returns the alignments (best first) that don't
+// overlap a better one.
+func (a *NativeAligner) alignSubject(
+	index *nativeIndex,
+	query nativeQuery,
+	seeds []nativeSeed,
+	dbSize uint64,
+	mem *SWMemory,
+) []Hit {
+	sort.Stable(nativeSeedsByScore(seeds))
+
+	qres, sres := query.residues, index.subjects[seeds[0].subject]
+	alns := make([]swAlignment, 0, 1)
+	hits := make([]Hit, 0, 1)
+	for _, seed := range seeds {
+		if len(alns) == nativeMaxHsps {
+			break
+		}
+		if swContains(alns, seed.qpos, seed.spos) {
+			continue
+		}
+
+		aln := swAlign(mem, qres, sres, seed.spos-seed.qpos, nativeBand)
+		if aln.Score == 0 || swOverlaps(alns, aln) {
+			continue
+		}
+		evalue := blosumK * float64(len(qres)) * float64(dbSize) *
+			math.Exp(-blosumLambda*float64(aln.Score))
+		if evalue > a.Evalue {
+			continue
+		}
+		alns = append(alns, aln)
+		hits = append(hits, Hit{
+			QueryId:      query.id,
+			SubjectId:    index.ids[seed.subject],
+			Identity:     percent(aln.Identities, aln.Length),
+			AlignLen:     aln.Length,
+			Mismatches:   aln.Mismatches,
+			GapOpens:     aln.GapOpens,
+			QueryStart:   aln.QueryStart + 1,
+			QueryEnd:     aln.QueryEnd,
+			SubjectStart: aln.SubjectStart + 1,
+			SubjectEnd:   aln.SubjectEnd,
+			Evalue:       evalue,
+			BitScore: (blosumLambda*float64(aln.Score) -
+				math.Log(blosumK)) / math.Ln2,
+		})
+	}
+	return hits
+}
+
+// swContains returns true if one of the alignments already covers the query
+// position 'qpos' and the subject position 'spos'.
+func swContains(alns []swAlignment, qpos, spos int) bool {
+	for _, aln := range alns {
+		if qpos >= aln.QueryStart && qpos < aln.QueryEnd &&
+			spos >= aln.SubjectStart && spos < aln.SubjectEnd {
+			return true
+		}
+	}
+	return false
+}
+
+// swOverlaps returns true if 'aln' overlaps one of the alignments in both
+// the query and the subject.
+func swOverlaps(alns []swAlignment, aln swAlignment) bool {
+	for _, other := range alns {
+		if aln.QueryStart < other.QueryEnd &&
+			other.QueryStart < aln.QueryEnd &&
+			aln.SubjectStart < other.SubjectEnd &&
+			other.SubjectStart < aln.SubjectEnd {
+			return true
+		}
+	}
+	return false
+}
+
+type nativeSeedsByScore []nativeSeed
+
+func (s nativeSeedsByScore) Len() int      { return len(s) }
+func (s nativeSeedsByScore) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s nativeSeedsByScore) Less(i, j int) bool {
+	return s[i].score > s[j].score
+}
+
+// nativeTargets sorts the hits of each subject by their best (i.e., first)
+// hit, like BLAST does.
+type nativeTargets [][]Hit
+
+func (t nativeTargets) Len() int      { return len(t) }
+func (t nativeTargets) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t nativeTargets) Less(i, j int) bool {
+	if t[i][0].Evalue != t[j][0].Evalue {
+		return t[i][0].Evalue < t[j][0].Evalue
+	}
+	if t[i][0].BitScore != t[j][0].BitScore {
+		return t[i][0].BitScore > t[j][0].BitScore
+	}
+	return t[i][0].SubjectId < t[j][0].SubjectId
+}
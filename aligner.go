@@ -0,0 +1,154 @@
+package mica
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// An Aligner is a search tool that can be used for either the coarse or the
+// fine stage of a search. Each Aligner searches its own kind of index, which
+// it builds from a FASTA file of protein sequences.
+//
+// BlastAligner, DmndAligner, MMseqsAligner and NativeAligner are the Aligners
+// that come with mica. (See NewAligner.) HmmAligner searches profile HMMs
+// rather than sequences. Settings particular to a tool (like the location of
+// its executable) are fields of its Aligner, while settings that every tool
+// shares are given with each search in an AlignQuery.
+type Aligner interface {
+	// Name describes the aligner in messages, e.g., "blastp".
+	Name() string
+
+	// CoarseIndex is the name of the index of the coarse database, relative
+	// to the database's directory.
+	CoarseIndex() string
+
+	// HasIndex returns true if an index exists at the path 'index'.
+	HasIndex(index string) bool
+
+	// Index builds an index at the path 'index' of the protein sequences in
+	// the FASTA file 'fasta'.
+	Index(fasta, index string) error
+
+	// Search searches the queries against an index, and writes the tool's
+	// own output to 'out'. The output format may be changed with the
+	// query's Args.
+	Search(q AlignQuery, out io.Writer) error
+
+	// Hits searches the queries against an index, and sends each hit found
+	// on the returned channel as soon as it is parsed. If the search fails,
+	// the last value sent has its Err set. The channel must be drained.
+	//
+	// The query's Args must not change the format of the tool's output,
+	// which Hits parses.
+	//
+	// The subject of each hit is the first word of the subject's FASTA
+	// header, except for BLAST, which reports the ordinal of the subject in
+	// the index. (These are the same for the coarse database.)
+	Hits(q AlignQuery) chan ReadHit
+}
+
+// AlignerNames are the names of the aligners that NewAligner returns.
+var AlignerNames = []string{"blast", "diamond", "mmseqs", "native"}
+
+// NewAligner returns the aligner called 'name' (one of AlignerNames) with
+// default settings, for searches with the BLAST program 'program' (e.g.,
+// "blastp" or "blastx"). DIAMOND translates queries when the program is
+// blastx, while the native aligner can't search with it at all.
+func NewAligner(name, program string) (Aligner, error) {
+	switch name {
+	case "blast":
+		return NewBlastAligner(program), nil
+	case "diamond":
+		if program == "blastx" {
+			return NewDmndAligner("blastx"), nil
+		}
+		return NewDmndAligner("blastp"), nil
+	case "mmseqs":
+		return NewMMseqsAligner(), nil
+	case "native":
+		if program == "blastx" {
+			return nil, fmt.Errorf("The native aligner can't translate " +
+				"nucleotide queries.")
+		}
+		return NewNativeAligner(), nil
+	}
+	return nil, fmt.Errorf("'%s' is not one of %s.",
+		name, strings.Join(AlignerNames, ", "))
+}
+
+// AlignQuery describes a single search run by an Aligner.
+type AlignQuery struct {
+	// The path of the index to search, built by the Aligner's Index method.
+	Index string
+
+	// The FASTA formatted queries.
+	Query []byte
+
+	// The number of residues that e-values are computed for. When zero, the
+	// size of the index is used. (Not every tool supports this.)
+	DBSize uint64
+
+	// The number of sequences that e-values are computed for, by tools that
+	// count sequences rather than residues (i.e., hmmsearch). When zero, the
+	// number of sequences in the index is used.
+	DBSeqs int
+
+	// The number of threads the tool may use.
+	Threads int
+
+	// The directory in which temporary files are created. When empty, the
+	// system's temporary directory is used.
+	TempDir string
+
+	// When set, temporary files are left on disk.
+	NoCleanup bool
+
+	// Additional arguments passed to the tool, after the arguments mica
+	// passes. (For example, an e-value threshold.)
+	Args []string
+}
+
+// tempDir creates a temporary directory for the files of a single search.
+// The function returned removes it, unless NoCleanup is set.
+func (q AlignQuery) tempDir(prefix string) (string, func(), error) {
+	dir, err := ioutil.TempDir(q.TempDir, prefix)
+	if err != nil {
+		return "", nil, fmt.Errorf("Could not create temporary directory: %s",
+			err)
+	}
+	if q.NoCleanup {
+		return dir, func() {}, nil
+	}
+	return dir, func() { os.RemoveAll(dir) }, nil
+}
+
+// writeQuery writes the queries to a FASTA file in 'dir', for tools that
+// can't read them from stdin.
+func (q AlignQuery) writeQuery(dir string) (string, error) {
+	queryFile := path.Join(dir, "query.fasta")
+	if err := ioutil.WriteFile(queryFile, q.Query, 0666); err != nil {
+		return "", fmt.Errorf("Could not write query file: %s", err)
+	}
+	return queryFile, nil
+}
+
+// streamHits runs 'search' in a new goroutine and returns the channel on
+// which the hits it reports (followed by its error, if any) are sent.
+func streamHits(search func(send func(Hit) error) error) chan ReadHit {
+	hits := make(chan ReadHit, 200)
+	go func() {
+		defer close(hits)
+		err := search(func(hit Hit) error {
+			hits <- ReadHit{Hit: hit}
+			return nil
+		})
+		if err != nil {
+			hits <- ReadHit{Err: err}
+		}
+	}()
+	return hits
+}
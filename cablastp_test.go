@@ -1,7 +1,19 @@
 package mica
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
 )
@@ -42,11 +54,266 @@ func TestDBConfIO(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if dbConf != dbConfTest {
+	if *dbConf != *dbConfTest {
 		t.Fatalf("%v != %v", dbConf, dbConfTest)
 	}
 }
 
+func TestCheckpointIO(t *testing.T) {
+	cp := &Checkpoint{
+		Inputs:          []string{"a.fasta", "dir/b: c.fasta"},
+		SortLength:      true,
+		FirstId:         10,
+		Consumed:        2000,
+		BlastDBSize:     123456,
+		appending:       true,
+		generation:      3,
+		compressedSize:  1,
+		indexSize:       2,
+		coarseSize:      3,
+		coarseIndexSize: 4,
+	}
+	buf := new(bytes.Buffer)
+
+	if err := cp.write(buf); err != nil {
+		t.Fatal(err)
+	}
+	cpTest, err := readCheckpoint(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cp, cpTest) {
+		t.Fatalf("%v != %v", cp, cpTest)
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	dups := NewDuplicates()
+	first, seen := dups.Add([]byte("MKVLAAGIVGLLLA"))
+	if seen {
+		t.Fatalf("A new sequence was reported as a duplicate.")
+	}
+	if _, seen := dups.Add([]byte("MKVLAAGIVGLLLG")); seen {
+		t.Fatalf("A different sequence was reported as a duplicate.")
+	}
+	dup, seen := dups.Add([]byte("MKVLAAGIVGLLLA"))
+	if !seen || dup != first {
+		t.Fatalf("A duplicate sequence was not found.")
+	}
+
+	links := []LinkToCoarse{
+		{Diff: "", CoarseSeqId: 4, CoarseStart: 0, CoarseEnd: 10},
+		{Diff: "s3A", CoarseSeqId: 70000, CoarseStart: 5, CoarseEnd: 9},
+	}
+	dups.Compressed(first, links)
+	if got := dups.Links(dup); !reflect.DeepEqual(got, links) {
+		t.Fatalf("%v != %v", got, links)
+	}
+
+	// Only compressed sequences are saved.
+	buf := new(bytes.Buffer)
+	if err := dups.write(buf); err != nil {
+		t.Fatal(err)
+	}
+	dupsTest := NewDuplicates()
+	if err := dupsTest.read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if dupsTest.Found() != 1 || len(dupsTest.seqs) != 1 {
+		t.Fatalf("Expected 1 duplicate found and 1 sequence saved, "+
+			"but got %d and %d.", dupsTest.Found(), len(dupsTest.seqs))
+	}
+	dup, seen = dupsTest.Add([]byte("MKVLAAGIVGLLLA"))
+	if !seen {
+		t.Fatalf("A saved sequence was not found.")
+	}
+	if got := dupsTest.Links(dup); !reflect.DeepEqual(got, links) {
+		t.Fatalf("%v != %v", got, links)
+	}
+
+	// A full table still finds the sequences it has, but no others.
+	dupsTest.MaxSeqs = 1
+	if dup, seen := dupsTest.Add([]byte("MKVLAAGIVGLLLG")); seen || dup != nil {
+		t.Fatalf("A sequence was added to a full table.")
+	}
+	if _, seen := dupsTest.Add([]byte("MKVLAAGIVGLLLA")); !seen {
+		t.Fatalf("A sequence in a full table was not found.")
+	}
+}
+
+func TestShardOf(t *testing.T) {
+	seqs := []string{
+		"MKVLAAGIVGLLLAQPAMA",
+		"MKVLAAGIVGLLLAQPAMG",
+		"ACDEFGHIKLMNPQRSTVWY",
+		"MKV",
+	}
+	for _, partition := range []string{PartitionHash, PartitionMinimizer} {
+		for _, seq := range seqs {
+			shard := ShardOf([]byte(seq), 5, partition, 8)
+			if shard < 0 || shard >= 5 {
+				t.Fatalf("Shard %d (%s) out of range.", shard, partition)
+			}
+			if again := ShardOf([]byte(seq), 5, partition, 8); again != shard {
+				t.Fatalf("Shard of '%s' (%s) changed from %d to %d.",
+					seq, partition, shard, again)
+			}
+		}
+	}
+
+	// Sequences shorter than the K-mer size fall back to the hash.
+	short := []byte(seqs[3])
+	if ShardOf(short, 5, PartitionMinimizer, 8) !=
+		ShardOf(short, 5, PartitionHash, 8) {
+		t.Fatalf("A short sequence was not assigned by its hash.")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	// The first shard holds the first sequences of data/small.fasta, and the
+	// second an exact copy of one of them and two copies of each with
+	// substitutions, which can only be compressed well across shards.
+	mutate := func(seq []byte, every, phase int) []byte {
+		mut := make([]byte, len(seq))
+		copy(mut, seq)
+		for i := phase; i < len(mut); i += every {
+			mut[i] = "ACDEFGHIKLMNPQRSTVWY"[(i+int(mut[i]))%20]
+		}
+		return mut
+	}
+	seqChan, err := ReadOriginalSeqs("data/small.fasta", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantShards [2][][]byte
+	for readSeq := range seqChan {
+		if readSeq.Err != nil {
+			t.Fatal(readSeq.Err)
+		}
+		if len(wantShards[0]) < 30 {
+			wantShards[0] = append(wantShards[0], readSeq.Seq.Residues)
+		}
+	}
+	wantShards[1] = append(wantShards[1], wantShards[0][0])
+	for _, seq := range wantShards[0] {
+		wantShards[1] = append(wantShards[1],
+			mutate(seq, 17, 3), mutate(seq, 23, 11))
+	}
+	var shardFasta [2]bytes.Buffer
+	for i, seqs := range wantShards {
+		for j, seq := range seqs {
+			fmt.Fprintf(&shardFasta[i], ">seq%d.%d\n%s\n", i, j, seq)
+		}
+	}
+	want := append(wantShards[0], wantShards[1]...)
+
+	dir, err := ioutil.TempDir("", "mica-test-merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Compress each shard in memory, and copy its files to a directory.
+	shardDirs := make([]string, len(shardFasta))
+	var coarseResidues [2]int64
+	for i := range shardFasta {
+		store := NewMemStorage()
+		db, err := NewWriteStorageDB(false, DefaultDBConf.DeepCopy(), nil,
+			store)
+		if err != nil {
+			t.Fatalf("Could not create database in memory: %s", err)
+		}
+		c := NewCompressor(db)
+		c.Start()
+		if _, err := c.CompressFasta(&shardFasta[i], 0, nil); err != nil {
+			t.Fatalf("Could not compress sequences: %s", err)
+		}
+		c.Done()
+		for _, corSeq := range db.CoarseDB.Seqs {
+			coarseResidues[i] += int64(corSeq.Len())
+		}
+		if err := db.Save(); err != nil {
+			t.Fatalf("Could not save database: %s", err)
+		}
+		db.WriteClose()
+
+		shardDirs[i] = path.Join(dir, fmt.Sprintf("shard%d", i))
+		if err := os.Mkdir(shardDirs[i], 0777); err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range store.files {
+			err := ioutil.WriteFile(
+				path.Join(shardDirs[i], name), data.bytes, 0666)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	store := NewMemStorage()
+	conf := DefaultDBConf.DeepCopy()
+	conf.ReadOnly = false
+	db, err := NewWriteStorageDB(false, conf, nil, store)
+	if err != nil {
+		t.Fatalf("Could not create database in memory: %s", err)
+	}
+	stats, err := db.Merge(shardDirs)
+	if err != nil {
+		t.Fatalf("Could not merge databases: %s", err)
+	}
+	if err := db.Save(); err != nil {
+		t.Fatalf("Could not save database: %s", err)
+	}
+	db.WriteClose()
+
+	// The coarse sequences of the second shard are compressed against
+	// those of the first, so most of them must be left out of the merged
+	// database.
+	if stats.OrgSeqs != len(want) {
+		t.Fatalf("Expected %d original sequences, but merged %d.",
+			len(want), stats.OrgSeqs)
+	}
+	if stats.Duplicates == 0 {
+		t.Fatalf("Expected the exact copy to be found verbatim.")
+	}
+	added := stats.MergedResidues - coarseResidues[0]
+	if added*4 > coarseResidues[1] {
+		t.Fatalf("Expected at most a quarter of the %d coarse residues of "+
+			"the second shard to be added, but %d were.",
+			coarseResidues[1], added)
+	}
+
+	db, err = NewReadStorageDB(store)
+	if err != nil {
+		t.Fatalf("Could not open database in memory: %s", err)
+	}
+	if db.ComDB.NumSequences() != len(want) {
+		t.Fatalf("Expected %d sequences, but there are %d.",
+			len(want), db.ComDB.NumSequences())
+	}
+	for i := range want {
+		oseq, err := db.ComDB.SeqGet(db.CoarseDB, i)
+		if err != nil {
+			t.Fatalf("Could not decompress sequence %d: %s", i, err)
+		}
+		if !bytes.Equal(oseq.Residues, want[i]) {
+			t.Fatalf("Sequence %d decompressed to\n%s\nbut should be\n%s",
+				i, oseq.Residues, want[i])
+		}
+	}
+	db.ReadClose()
+
+	// The merged database has a seeds table, so it can be appended to.
+	db, err = NewWriteStorageDB(true, DefaultDBConf.DeepCopy(), nil, store)
+	if err != nil {
+		t.Fatalf("Could not open merged database for appending: %s", err)
+	}
+	if db.CoarseDB.Seeds.NumSeeds() == 0 {
+		t.Fatalf("The merged database has no seeds.")
+	}
+	db.WriteClose()
+}
+
 func TestEditScripts(t *testing.T) {
 	type test struct {
 		fromSeq, toSeq               string
@@ -134,3 +401,1489 @@ but should be
 		}
 	}
 }
+
+func TestMatchCriteria(t *testing.T) {
+	type test struct {
+		aligned1, aligned2 string
+		identity           int
+		positives          int
+		score              int
+	}
+	tests := []test{
+		{"", "", 0, 0, 0},
+		{"ARND", "ARND", 100, 100, 4 + 5 + 6 + 6},
+		{"ILVF", "VIIY", 0, 100, 3 + 2 + 3 + 3},
+		{"AWAA", "ACAA", 75, 75, 4 - 2 + 4 + 4},
+		{"AA--AA", "AAAAAA", 66, 66, 16 - 11 - 2},
+		{"A-A-A", "AAAAA", 60, 60, 12 - 2*(11+1)},
+	}
+	for _, test := range tests {
+		a1, a2 := []byte(test.aligned1), []byte(test.aligned2)
+		if id := SeqIdentity(a1, a2); id != test.identity {
+			t.Fatalf("Identity of '%s' and '%s' should be %d, but is %d.",
+				test.aligned1, test.aligned2, test.identity, id)
+		}
+		if pos := SeqPositives(a1, a2); pos != test.positives {
+			t.Fatalf("Positives of '%s' and '%s' should be %d, but is %d.",
+				test.aligned1, test.aligned2, test.positives, pos)
+		}
+		if score := AlignmentScore(a1, a2); score != test.score {
+			t.Fatalf("Score of '%s' and '%s' should be %d, but is %d.",
+				test.aligned1, test.aligned2, test.score, score)
+		}
+	}
+
+	conf := DefaultDBConf.DeepCopy()
+	conf.MatchCriterion = CriterionBits
+	conf.MatchBitsThreshold = 1.5
+	if !conf.MatchAccept()([]byte("ARND"), []byte("ARND")) {
+		t.Fatalf("Identical sequences should have at least 1.5 bits " +
+			"per residue.")
+	}
+	if conf.MatchAccept()([]byte("AWAA"), []byte("ACAA")) {
+		t.Fatalf("'AWAA' and 'ACAA' should have less than 1.5 bits " +
+			"per residue.")
+	}
+}
+
+func TestNeedlemanWunsch(t *testing.T) {
+	type test struct {
+		seq1, seq2 string
+		out1, out2 string
+	}
+
+	tests := []test{
+		{
+			"ABCD",
+			"ABCD",
+			"ABCD",
+			"ABCD",
+		},
+		{
+			"PPPGHIKLMNPQR",
+			"GAAAHIKLMN",
+			"PPPGHIKLMNPQR",
+			"GAAAHIKLMN---",
+		},
+		{
+			"GHIKLMNPQRSTVW",
+			"GAAAHIKLMNPQRSTVW",
+			"G---HIKLMNPQRSTVW",
+			"GAAAHIKLMNPQRSTVW",
+		},
+		{
+			"XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX",
+			"XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX",
+			"XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX",
+			"XXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXXX",
+		},
+		{
+			"NNNNNNNN",
+			"NNNNNNNN",
+			"NNNNNNNN",
+			"NNNNNNNN",
+		},
+		{
+			"NNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNN",
+			"NNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNN",
+			"NNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNN",
+			"NNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNNN",
+		},
+		{
+			"ABCDEFGWXYZ",
+			"ABCDEFMNPQRSTZABEGWXYZ",
+			"ABCDEF-----------GWXYZ",
+			"ABCDEFMNPQRSTZABEGWXYZ",
+		},
+		{
+			"ACDEFGHIKLMNPQRSTVWY",
+			"ACDEFGHIKLPQRSTVWY",
+			"ACDEFGHIKLMNPQRSTVWY",
+			"ACDEFGHIKL--PQRSTVWY",
+		},
+		{
+			"",
+			"ABC",
+			"---",
+			"ABC",
+		},
+	}
+	sep := strings.Repeat("-", 45)
+	mem := NewAlignMemory()
+	for _, test := range tests {
+		alignment := NWAlign([]byte(test.seq1), []byte(test.seq2), mem)
+		sout1, sout2 := string(alignment[0]), string(alignment[1])
+
+		if sout1 != test.out1 || sout2 != test.out2 {
+			t.Fatalf(
+				`Alignment for: (sequence identitiy: %d)
+%s
+%s
+%s
+%s
+resulted in
+%s
+%s
+%s
+%s
+but should have been
+%s
+%s
+%s
+%s`,
+				SeqIdentity(alignment[0], alignment[1]),
+				sep, test.seq1, test.seq2, sep,
+				sep, sout1, sout2, sep,
+				sep, test.out1, test.out2, sep)
+		}
+	}
+}
+
+// TestNeedlemanWunschGaps checks that gaps are opened when they keep enough
+// residues identical for the alignment to be accepted as a match.
+func TestNeedlemanWunschGaps(t *testing.T) {
+	seq1, seq2 := []byte("NGLFLCCA"), []byte("NIKLFDGFCA")
+	accept := IdentityAtLeast(40)
+	mem := NewAlignMemory()
+
+	alignment := NWAlign(seq1, seq2, mem)
+	if !accept(alignment[0], alignment[1]) {
+		t.Fatalf("Alignment %s/%s should have been accepted.",
+			alignment[0], alignment[1])
+	}
+	if s1, s2 := string(alignment[0]), string(alignment[1]); s1 !=
+		"N-GLF-LCCA" || s2 != "NIKLFDGFCA" {
+		t.Fatalf("Expected N-GLF-LCCA/NIKLFDGFCA but got %s/%s.", s1, s2)
+	}
+}
+
+// benchmarkNWAlign aligns a sequence of length 'n' with a copy of it that
+// has a substitution every 7 residues and an insertion every 50 residues.
+func benchmarkNWAlign(b *testing.B, n int) {
+	const residues = "ACDEFGHIKLMNPQRSTVWY"
+	seq1, seq2 := make([]byte, n), make([]byte, 0, n+n/50)
+	for i := range seq1 {
+		seq1[i] = residues[(i*7+i/3)%len(residues)]
+		switch {
+		case i%50 == 49:
+			seq2 = append(seq2, seq1[i], 'W')
+		case i%7 == 6:
+			seq2 = append(seq2, 'P')
+		default:
+			seq2 = append(seq2, seq1[i])
+		}
+	}
+
+	mem := NewAlignMemory()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		NWAlign(seq1, seq2, mem)
+	}
+}
+
+func BenchmarkNWAlign25(b *testing.B)   { benchmarkNWAlign(b, 25) }
+func BenchmarkNWAlign100(b *testing.B)  { benchmarkNWAlign(b, 100) }
+func BenchmarkNWAlign1000(b *testing.B) { benchmarkNWAlign(b, 1000) }
+
+func TestSeedEviction(t *testing.T) {
+	const residues = "ACDEFGHIKLMNPQRSTVWY"
+
+	// Each coarse sequence has 20 seeds, and the table is limited to 100.
+	// So when whole sequences are evicted, seeds are evicted (down to 90)
+	// when the 6th, 8th and 10th sequences are added. (Unless they are all
+	// wiped when the 6th is added.)
+	limitGB := 100.0 * seedLocSize / (1024 * 1024 * 1024)
+	seqs := make([]*CoarseSeq, 10)
+	for i := range seqs {
+		res := make([]byte, 23)
+		for j := range res {
+			res[j] = residues[(i*7+j*(i+3))%len(residues)]
+		}
+		seqs[i] = NewCoarseSeq(i, "", res)
+	}
+
+	// hasSeeds reports whether the first K-mer of a coarse sequence is still
+	// in the seeds table.
+	hasSeeds := func(ss *Seeds, id int) bool {
+		mem := make([][2]uint, 0, 10)
+		for _, loc := range ss.Lookup(seqs[id].Residues[0:3], &mem) {
+			if loc[0] == uint(id) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, policy := range []string{
+		EvictWipe, EvictOldest, EvictLeastHit, EvictSubsample,
+	} {
+		ss := NewSeeds(3, 10)
+		if err := ss.Limit(limitGB, policy); err != nil {
+			t.Fatal(err)
+		}
+		for i, seq := range seqs {
+			ss.Add(i, seq)
+			if i == 0 {
+				ss.Hit(0)
+			}
+			if n := ss.NumSeeds(); n > 100 {
+				t.Fatalf("Policy '%s' left %d seeds in the table after "+
+					"adding sequence %d.", policy, n, i)
+			}
+			if i == 5 && policy == EvictLeastHit && !hasSeeds(&ss, 0) {
+				t.Fatalf("Policy '%s' evicted the seeds of the only "+
+					"sequence with a hit.", policy)
+			}
+		}
+		// Subsampling evicts individual seeds, so it may need to evict
+		// more often.
+		evictions := map[string]int{
+			EvictWipe: 1, EvictOldest: 3, EvictLeastHit: 3,
+		}[policy]
+		stats := ss.Stats()
+		if evictions > 0 && stats.Evictions != evictions {
+			t.Fatalf("Policy '%s' evicted seeds %d times, but should "+
+				"have evicted seeds %d times.",
+				policy, stats.Evictions, evictions)
+		}
+		if policy == EvictOldest {
+			for id := range seqs {
+				if hasSeeds(&ss, id) != (id >= 6) {
+					t.Fatalf("Policy '%s' should leave seeds for exactly "+
+						"the 4 newest sequences.", policy)
+				}
+			}
+		}
+	}
+}
+
+func TestSortedOriginalSeqs(t *testing.T) {
+	const fasta = "data/small.fasta"
+
+	seqChan, err := ReadOriginalSeqs(fasta, []byte{'J', 'O', 'U'})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inOrder := make([]*OriginalSeq, 0, 100)
+	for readSeq := range seqChan {
+		if readSeq.Err != nil {
+			t.Fatal(readSeq.Err)
+		}
+		inOrder = append(inOrder, readSeq.Seq)
+	}
+
+	// Sort once entirely in memory, and once in many small runs.
+	for _, maxMem := range []int64{1 << 30, 2000} {
+		seqChan, err := ReadSortedOriginalSeqs(
+			[]string{fasta}, []byte{'J', 'O', 'U'}, 5, maxMem, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var prev *OriginalSeq
+		seen := make(map[int]bool)
+		for readSeq := range seqChan {
+			if readSeq.Err != nil {
+				t.Fatal(readSeq.Err)
+			}
+			oseq := readSeq.Seq
+			if prev != nil && !seqBefore(prev, oseq) {
+				t.Fatalf("Sequence %d (length %d) was sorted before "+
+					"sequence %d (length %d).",
+					prev.Id, prev.Len(), oseq.Id, oseq.Len())
+			}
+			prev = oseq
+
+			i := oseq.Id - 5
+			if i < 0 || i >= len(inOrder) || seen[i] {
+				t.Fatalf("Unexpected sequence id %d.", oseq.Id)
+			}
+			seen[i] = true
+			if oseq.Name != inOrder[i].Name ||
+				!bytes.Equal(oseq.Residues, inOrder[i].Residues) {
+				t.Fatalf("Sorted sequence %d is not the sequence at "+
+					"the same position in the input.", oseq.Id)
+			}
+		}
+		if len(seen) != len(inOrder) {
+			t.Fatalf("Expected %d sorted sequences, but got %d.",
+				len(inOrder), len(seen))
+		}
+	}
+}
+
+func TestReadFormats(t *testing.T) {
+	type test struct {
+		format, input string
+		names         []string
+		residues      []string
+	}
+	tests := []test{
+		{
+			FormatFasta,
+			">a desc\nMKV\n>b\nACDE\n",
+			[]string{"a desc", "b"},
+			[]string{"MKV", "ACDE"},
+		},
+		{
+			FormatFastq,
+			"@read1 lane=1\nacgt\nAC\n+\nIIII\nII\n@read2\nGGA\n+read2\n" +
+				"@II\n",
+			[]string{"read1 lane=1", "read2"},
+			[]string{"ACGTAC", "GGA"},
+		},
+		{
+			FormatUniProt,
+			"ID   CYC_HUMAN               Reviewed;         12 AA.\n" +
+				"AC   P99999; P00001;\n" +
+				"DE   RecName: Full=Cytochrome c {ECO:0000269};\n" +
+				"DE   AltName: Full=Other name;\n" +
+				"SQ   SEQUENCE   12 AA;  1234 MW;  0123456789ABCDEF CRC64;\n" +
+				"     MGDVEKGKKI FV\n" +
+				"//\n" +
+				"ID   NODE_HUMAN              Unreviewed;        3 AA.\n" +
+				"AC   Q11111;\n" +
+				"SQ   SEQUENCE   3 AA;  123 MW;  0123456789ABCDEF CRC64;\n" +
+				"     MKV\n" +
+				"//\n",
+			[]string{"P99999 Cytochrome c", "Q11111"},
+			[]string{"MGDVEKGKKIFV", "MKV"},
+		},
+		{
+			FormatGenBank,
+			"LOCUS       AB000001      14 bp    DNA     linear   PRI\n" +
+				"DEFINITION  Homo sapiens test gene,\n" +
+				"            complete cds.\n" +
+				"ACCESSION   AB000001\n" +
+				"VERSION     AB000001.2\n" +
+				"FEATURES             Location/Qualifiers\n" +
+				"     source          1..14\n" +
+				"ORIGIN      \n" +
+				"        1 acgtacgtac gtac\n" +
+				"//\n",
+			[]string{"AB000001.2 Homo sapiens test gene, complete cds."},
+			[]string{"ACGTACGTACGTAC"},
+		},
+	}
+
+	check := func(name string, r io.Reader, test test) {
+		seqChan, err := ReadOriginalSeqsFrom(r, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		i := 0
+		for readSeq := range seqChan {
+			if readSeq.Err != nil {
+				t.Fatalf("%s: %s", name, readSeq.Err)
+			}
+			if i >= len(test.names) {
+				t.Fatalf("%s: read too many sequences.", name)
+			}
+			if readSeq.Seq.Name != test.names[i] ||
+				string(readSeq.Seq.Residues) != test.residues[i] {
+				t.Fatalf("%s: sequence %d should be '%s' (%s), but it is "+
+					"'%s' (%s).", name, i, test.names[i], test.residues[i],
+					readSeq.Seq.Name, readSeq.Seq.Residues)
+			}
+			i++
+		}
+		if i != len(test.names) {
+			t.Fatalf("%s: expected %d sequences, but read %d.",
+				name, len(test.names), i)
+		}
+	}
+	for _, test := range tests {
+		format, err := DetectFormat(
+			bufio.NewReader(strings.NewReader(test.input)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if format != test.format {
+			t.Fatalf("Expected format '%s', but detected '%s'.",
+				test.format, format)
+		}
+		check(test.format, strings.NewReader(test.input), test)
+
+		gzipped := new(bytes.Buffer)
+		gw := gzip.NewWriter(gzipped)
+		gw.Write([]byte(test.input))
+		gw.Close()
+		check(test.format+" (gzip)", gzipped, test)
+
+		if _, err := exec.LookPath(ZstdExec); err == nil {
+			cmd := exec.Command(ZstdExec, "-c")
+			cmd.Stdin = strings.NewReader(test.input)
+			zstd, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("Could not run zstd: %s", err)
+			}
+			check(test.format+" (zstd)", bytes.NewReader(zstd), test)
+		}
+	}
+
+	// The standard library can't write bzip2, so this is the first test
+	// input compressed with bzip2.
+	bzipped := []byte("\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x14\xc4" +
+		"\xe4\x25\x00\x00\x02\xdf\x80\x00\x10\x40\x00\x00\x01\x2e\x0a\x01" +
+		"\x00\x3e\x00\x08\x00\x20\x00\x22\x10\x68\x68\xfd\x53\x05\x30\x00" +
+		"\x4d\x34\xfe\x06\x5e\x3f\x4c\x82\x4f\xe2\x68\x5d\xc9\x14\xe1\x42" +
+		"\x40\x53\x13\x90\x94")
+	check("fasta (bzip2)", bytes.NewReader(bzipped), tests[0])
+
+	if _, err := DetectFormat(bufio.NewReader(
+		strings.NewReader("not a sequence file\n"))); err == nil {
+		t.Fatalf("Detecting the format of an unknown input should fail.")
+	}
+}
+
+func TestSeqFilters(t *testing.T) {
+	input := ">sp|P1 kept one\nMKVLAAGIVA\n" +
+		">sp|P2 too short\nMKV\n" +
+		">sp|P3 too long\nMKVLAAGIVAMKVLAAGIVA\n" +
+		">sp|P4 ambiguous\nMKXXXXGIVA\n" +
+		">tr|P5 unreviewed\nMKVLAAGIVA\n" +
+		">sp|P6 fragment\nMKVLAAGIVA\n" +
+		">sp|P7 kept two\nMKVLAXGIVA\n"
+
+	rewrite, err := HeaderTemplate("{{.Accession}} len={{.Length}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters := NewSeqFilters(
+		MinLength(5),
+		MaxLength(15),
+		MaxAmbiguous(0.2),
+		HeaderInclude(regexp.MustCompile(`^sp\|`)),
+		HeaderExclude(regexp.MustCompile(`fragment`)),
+		rewrite,
+	)
+	seqChan, err := ReadOriginalSeqsFrom(strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seqChan = filters.Filter(seqChan)
+
+	kept := make([]string, 0, 2)
+	for readSeq := range seqChan {
+		if readSeq.Err != nil {
+			t.Fatal(readSeq.Err)
+		}
+		kept = append(kept, readSeq.Seq.Name)
+	}
+	expected := []string{"sp|P1 len=10", "sp|P7 len=10"}
+	if !reflect.DeepEqual(kept, expected) {
+		t.Fatalf("Expected the sequences %v to be kept, but got %v.",
+			expected, kept)
+	}
+
+	report := filters.Report()
+	if report.Read != 7 || report.Removed != 5 {
+		t.Fatalf("Expected 5 of 7 sequences removed, but got %d of %d.",
+			report.Removed, report.Read)
+	}
+	for i, removed := range []int{1, 1, 1, 1, 1, 0} {
+		if report.Filters[i].Removed != removed {
+			t.Fatalf("Expected filter '%s' to remove %d sequences, "+
+				"but it removed %d.", report.Filters[i].Name, removed,
+				report.Filters[i].Removed)
+		}
+	}
+
+	if _, err := HeaderTemplate("{{.Accession"); err == nil {
+		t.Fatalf("Parsing an invalid header template should fail.")
+	}
+}
+
+func TestAlignerHits(t *testing.T) {
+	xml := `<?xml version="1.0"?>
+<BlastOutput>
+<BlastOutput_iterations>
+<Iteration>
+  <Iteration_query-def>q1 first query</Iteration_query-def>
+  <Iteration_hits>
+    <Hit>
+      <Hit_accession>3</Hit_accession>
+      <Hit_hsps>
+        <Hsp>
+          <Hsp_bit-score>40.5</Hsp_bit-score>
+          <Hsp_evalue>1e-05</Hsp_evalue>
+          <Hsp_query-from>2</Hsp_query-from>
+          <Hsp_query-to>11</Hsp_query-to>
+          <Hsp_hit-from>5</Hsp_hit-from>
+          <Hsp_hit-to>13</Hsp_hit-to>
+          <Hsp_identity>8</Hsp_identity>
+          <Hsp_gaps>1</Hsp_gaps>
+          <Hsp_align-len>10</Hsp_align-len>
+          <Hsp_qseq>MKVLAAGIVA</Hsp_qseq>
+          <Hsp_hseq>MKV-AAGIVA</Hsp_hseq>
+        </Hsp>
+      </Hit_hsps>
+    </Hit>
+  </Iteration_hits>
+</Iteration>
+<Iteration>
+  <Iteration_query-def>q2</Iteration_query-def>
+  <Iteration_hits></Iteration_hits>
+</Iteration>
+</BlastOutput_iterations>
+</BlastOutput>
+`
+	// A fake BLAST program that reports the same hits for any search.
+	dir, err := ioutil.TempDir("", "mica-test-aligner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	xmlFile, blastp := path.Join(dir, "hits.xml"), path.Join(dir, "blastp")
+	if err := ioutil.WriteFile(xmlFile, []byte(xml), 0666); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\ncat > /dev/null\ncat " + xmlFile + "\n"
+	if err := ioutil.WriteFile(blastp, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	aligner := NewBlastAligner("blastp")
+	aligner.Exec = blastp
+	q := AlignQuery{Index: "coarse", Query: []byte(">q1\nMKVLAAGIVA\n")}
+	hits := make([]Hit, 0, 1)
+	for readHit := range aligner.Hits(q) {
+		if readHit.Err != nil {
+			t.Fatal(readHit.Err)
+		}
+		hits = append(hits, readHit.Hit)
+	}
+	expected := []Hit{{
+		QueryId:      "q1",
+		SubjectId:    "3",
+		Identity:     80,
+		AlignLen:     10,
+		Mismatches:   1,
+		GapOpens:     1,
+		QueryStart:   2,
+		QueryEnd:     11,
+		SubjectStart: 5,
+		SubjectEnd:   13,
+		Evalue:       1e-5,
+		BitScore:     40.5,
+	}}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Fatalf("Expected hits %v, but got %v.", expected, hits)
+	}
+
+	aligner.Exec = path.Join(dir, "no-such-blastp")
+	readHit := <-aligner.Hits(q)
+	if readHit.Err == nil {
+		t.Fatalf("Searching with a missing executable should fail.")
+	}
+}
+
+func TestNativeAligner(t *testing.T) {
+	mem := NewSWMemory()
+	aln := swAlign(mem,
+		[]byte("WWMKVLAAGIVAWW"), []byte("PPMKVAAGIVAPP"), 0, nativeBand)
+	// MKVLAAGIVA
+	// MKV-AAGIVA
+	if aln.QueryStart != 2 || aln.QueryEnd != 12 ||
+		aln.SubjectStart != 2 || aln.SubjectEnd != 11 ||
+		aln.Length != 10 || aln.Identities != 9 || aln.GapOpens != 1 {
+		t.Fatalf("Unexpected alignment: %+v", aln)
+	}
+
+	// The gap between the two halves of the query leaves the band of the
+	// first half's diagonal, unless the band is wide enough.
+	query := []byte("MKVLAAGIVAWHKRTPEDLCNYQFGS")
+	subject := []byte("PPMKVLAAGIVAWHKRTGGGGGGPEDLCNYQFGS")
+	aln = swAlign(mem, query, subject, 2, 3)
+	if aln.QueryStart != 0 || aln.QueryEnd != 15 || aln.GapOpens != 0 {
+		t.Fatalf("Unexpected alignment in a narrow band: %+v", aln)
+	}
+	aln = swAlign(mem, query, subject, 2, 6)
+	if aln.QueryStart != 0 || aln.QueryEnd != 26 ||
+		aln.SubjectEnd != 34 || aln.GapOpens != 1 {
+		t.Fatalf("Unexpected alignment in a wide band: %+v", aln)
+	}
+
+	// A band that misses the subject altogether finds nothing.
+	if aln = swAlign(mem, query, subject, 100, 3); aln.Score != 0 {
+		t.Fatalf("Unexpected alignment outside of the subject: %+v", aln)
+	}
+
+	subjects := ">s1 first\nMKVLAAGIVAWHKRTPEDLCNYQFGSMKVLAAG\n" +
+		">s2 second\nPPPPGGGGSSSSTTTTAAAANNNNPPPPGGGG\n" +
+		">s3 third\nMKVLAAGIVAWHKRTAEDLCNYQFGS\n"
+	dir, err := ioutil.TempDir("", "mica-test-native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	index := path.Join(dir, "subjects.fasta")
+	if err := ioutil.WriteFile(index, []byte(subjects), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	aligner := NewNativeAligner()
+	aligner.UngappedCutoff = 20
+	q := AlignQuery{
+		Index:   index,
+		Query:   []byte(">q query\nMKVLAAGIVAWHKRTPEDLCNYQFGS\n"),
+		Threads: 2,
+	}
+	buf := new(bytes.Buffer)
+	if err := aligner.Search(q, buf); err != nil {
+		t.Fatal(err)
+	}
+	hits, err := ReadBlastTabular(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("Expected 2 hits, but got %d: %v", len(hits), hits)
+	}
+	best := hits[0]
+	if best.SubjectId != "s1" || best.Identity != 100 ||
+		best.QueryStart != 1 || best.QueryEnd != 26 ||
+		best.SubjectStart != 1 || best.SubjectEnd != 26 {
+		t.Fatalf("Unexpected best hit: %+v", best)
+	}
+	if hits[1].SubjectId != "s3" || hits[1].Mismatches != 1 {
+		t.Fatalf("Unexpected second hit: %+v", hits[1])
+	}
+	if hits[1].Evalue <= best.Evalue {
+		t.Fatalf("A hit with a mismatch should have a greater e-value.")
+	}
+}
+
+func TestHitWriters(t *testing.T) {
+	hits := []Hit{
+		{QueryId: "q1", SubjectId: "s1", SubjectHeader: "s1 first",
+			OriginalId: 41, Identity: 100, AlignLen: 50, QueryStart: 1,
+			QueryEnd: 50, SubjectStart: 3, SubjectEnd: 52, Evalue: 1e-20,
+			BitScore: 95.5, Backend: "native"},
+		{QueryId: "q1", SubjectId: "s1", Identity: 80, AlignLen: 20,
+			Mismatches: 4, QueryStart: 60, QueryEnd: 79, SubjectStart: 70,
+			SubjectEnd: 89, Evalue: 0.002, BitScore: 30},
+		{QueryId: "q2", SubjectId: "s2", Identity: 90, AlignLen: 10,
+			Mismatches: 1, QueryStart: 1, QueryEnd: 10, SubjectStart: 1,
+			SubjectEnd: 10, Evalue: 5, BitScore: 18.2},
+	}
+	write := func(format string) *bytes.Buffer {
+		buf := new(bytes.Buffer)
+		hw, err := NewHitWriter(buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, hit := range hits {
+			if err := hw.Write(hit); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := hw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf
+	}
+	check := func(format string, got []Hit) {
+		if len(got) != len(hits) {
+			t.Fatalf("%s: expected %d hits, but got %d: %v",
+				format, len(hits), len(got), got)
+		}
+		for i, hit := range got {
+			if hit.QueryId != hits[i].QueryId ||
+				hit.SubjectId != hits[i].SubjectId ||
+				hit.QueryStart != hits[i].QueryStart ||
+				hit.SubjectEnd != hits[i].SubjectEnd ||
+				hit.AlignLen != hits[i].AlignLen {
+				t.Fatalf("%s: expected %+v, but got %+v",
+					format, hits[i], hit)
+			}
+		}
+	}
+
+	tabHits, err := ReadBlastTabular(write(HitFormatTabular))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(HitFormatTabular, tabHits)
+
+	xmlOut := write(HitFormatXML)
+	if !strings.Contains(xmlOut.String(), "<Hit_id>gnl|BL_ORD_ID|41<") {
+		t.Fatalf("Expected the original id in %s", xmlOut)
+	}
+	xmlHits, err := ReadBlastXML(xmlOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(HitFormatXML, xmlHits)
+	if xmlHits[1].Mismatches != 4 {
+		t.Fatalf("Expected 4 mismatches, but got %+v", xmlHits[1])
+	}
+
+	lines := strings.Split(strings.TrimSpace(write(HitFormatJSON).String()),
+		"\n")
+	if len(lines) != len(hits) {
+		t.Fatalf("Expected %d JSON lines, but got %d", len(hits), len(lines))
+	}
+	for _, field := range []string{`"query":"q1"`, `"subject":"s1"`,
+		`"subject_header":"s1 first"`, `"original_id":41`, `"bitscore":95.5`,
+		`"backend":"native"`} {
+		if !strings.Contains(lines[0], field) {
+			t.Fatalf("Expected %s in %s", field, lines[0])
+		}
+	}
+	if strings.Contains(lines[2], "backend") {
+		t.Fatalf("Expected no backend in %s", lines[2])
+	}
+
+	if _, err := NewHitWriter(new(bytes.Buffer), "html"); err == nil {
+		t.Fatalf("Expected an error for an unknown format.")
+	}
+}
+
+func TestHmmerTables(t *testing.T) {
+	tbl := "# target name  accession  query name  accession  E-value\n" +
+		"7  -  globin  PF00042.1  1.2e-30  105.3  0.1  2.3e-30  104.4  0.1" +
+		"  1.0  1  0  0  1  1  1  1  Hemoglobin subunit alpha\n"
+	domTbl := "# target name  accession  tlen  query name\n" +
+		"7  -  142  globin  PF00042.1  110  1.2e-30  105.3  0.1  1  2" +
+		"  4.5e-33  2.3e-30  104.4  0.1  2  108  25  131  20  135  0.95" +
+		"  Hemoglobin subunit alpha\n" +
+		"7  -  142  globin  PF00042.1  110  1.2e-30  105.3  0.1  2  2" +
+		"  1.5e-05  0.0077  12.0  0.0  40  60  1  21  1  22  0.80" +
+		"  Hemoglobin subunit alpha\n"
+
+	hits, err := ReadHmmerTable(strings.NewReader(tbl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Hit{
+		{QueryId: "globin", SubjectId: "7", Evalue: 1.2e-30, BitScore: 105.3},
+	}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Fatalf("Expected per-sequence hits %v, but got %v", expected, hits)
+	}
+
+	hits, err = ReadHmmerDomTable(strings.NewReader(domTbl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []Hit{
+		{QueryId: "globin", SubjectId: "7", AlignLen: 107, QueryStart: 2,
+			QueryEnd: 108, SubjectStart: 25, SubjectEnd: 131, Evalue: 2.3e-30,
+			BitScore: 104.4},
+		{QueryId: "globin", SubjectId: "7", AlignLen: 21, QueryStart: 40,
+			QueryEnd: 60, SubjectStart: 1, SubjectEnd: 21, Evalue: 0.0077,
+			BitScore: 12.0},
+	}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Fatalf("Expected per-domain hits %v, but got %v", expected, hits)
+	}
+
+	if _, err := ReadHmmerDomTable(strings.NewReader(tbl)); err == nil {
+		t.Fatalf("Expected an error reading a per-sequence table as a " +
+			"per-domain table.")
+	}
+}
+
+func TestReadBlastTabular(t *testing.T) {
+	out := "# BLASTP 2.2.28+\n" +
+		"# Fields: query id, subject id, % identity, ...\n" +
+		"q1\t3\t96.12\t1160\t45\t0\t1\t1160\t1\t1160\t0.0\t2180\n" +
+		"\n" +
+		"\t17\t50.00\t20\t9\t1\t5\t24\t30\t48\t2e-05\t40.5\r\n"
+	hits, err := ReadBlastTabular(strings.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Hit{
+		{QueryId: "q1", SubjectId: "3", Identity: 96.12, AlignLen: 1160,
+			Mismatches: 45, QueryStart: 1, QueryEnd: 1160, SubjectStart: 1,
+			SubjectEnd: 1160, BitScore: 2180},
+		{QueryId: "", SubjectId: "17", Identity: 50, AlignLen: 20,
+			Mismatches: 9, GapOpens: 1, QueryStart: 5, QueryEnd: 24,
+			SubjectStart: 30, SubjectEnd: 48, Evalue: 2e-5, BitScore: 40.5},
+	}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Fatalf("Expected hits %v, but got %v", expected, hits)
+	}
+
+	// Hits written by WriteBlastTabular are read back as they were.
+	buf := new(bytes.Buffer)
+	if err := WriteBlastTabular(buf, expected); err != nil {
+		t.Fatal(err)
+	}
+	if hits, err = ReadBlastTabular(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Fatalf("Expected written hits %v, but got %v", expected, hits)
+	}
+
+	bad := []string{
+		"q1\t3\t96.12\t1160\t45\t0\t1\t1160\t1\t1160\t0.0\n",
+		"q1\t3\t96.12\t1160\t45\t0\t1\t1160\t1\tx\t0.0\t2179.8\n",
+		"q1\t3\tabc\t1160\t45\t0\t1\t1160\t1\t1160\t0.0\t2179.8\n",
+		"q1 3 96.12 1160 45 0 1 1160 1 1160 0.0 2179.8\n",
+	}
+	for _, line := range bad {
+		if _, err := ReadBlastTabular(strings.NewReader(line)); err == nil {
+			t.Fatalf("Expected an error reading %q.", line)
+		}
+	}
+}
+
+func TestReadBlastXML(t *testing.T) {
+	xml := `<?xml version="1.0"?>
+<BlastOutput>
+<BlastOutput_program>blastp</BlastOutput_program>
+<BlastOutput_iterations>
+<Iteration>
+  <Iteration_iter-num>1</Iteration_iter-num>
+  <Iteration_query-def>q1 first query</Iteration_query-def>
+  <Iteration_hits>
+    <Hit>
+      <Hit_num>1</Hit_num>
+      <Hit_def>No definition line</Hit_def>
+      <Hit_accession> 3 </Hit_accession>
+      <Hit_hsps>
+        <Hsp>
+          <Hsp_bit-score>40.5</Hsp_bit-score>
+          <Hsp_evalue>1e-05</Hsp_evalue>
+          <Hsp_query-from>2</Hsp_query-from>
+          <Hsp_query-to>11</Hsp_query-to>
+          <Hsp_hit-from>5</Hsp_hit-from>
+          <Hsp_hit-to>13</Hsp_hit-to>
+          <Hsp_identity>8</Hsp_identity>
+          <Hsp_gaps>1</Hsp_gaps>
+          <Hsp_align-len>10</Hsp_align-len>
+          <Hsp_qseq>MKVLAAGIVA</Hsp_qseq>
+          <Hsp_hseq>MKV-AAGIVA</Hsp_hseq>
+        </Hsp>
+        <Hsp>
+          <Hsp_bit-score>20</Hsp_bit-score>
+          <Hsp_evalue>2.5</Hsp_evalue>
+          <Hsp_query-from>20</Hsp_query-from>
+          <Hsp_query-to>27</Hsp_query-to>
+          <Hsp_hit-from>40</Hsp_hit-from>
+          <Hsp_hit-to>45</Hsp_hit-to>
+          <Hsp_identity>4</Hsp_identity>
+          <Hsp_gaps>2</Hsp_gaps>
+          <Hsp_align-len>8</Hsp_align-len>
+          <Hsp_qseq>MKVLAAGI</Hsp_qseq>
+          <Hsp_hseq>M-VL-AGW</Hsp_hseq>
+        </Hsp>
+      </Hit_hsps>
+    </Hit>
+  </Iteration_hits>
+</Iteration>
+<Iteration>
+  <Iteration_iter-num>2</Iteration_iter-num>
+  <Iteration_query-def>q2</Iteration_query-def>
+  <Iteration_hits></Iteration_hits>
+</Iteration>
+<Iteration>
+  <Iteration_iter-num>3</Iteration_iter-num>
+  <Iteration_query-def>q3</Iteration_query-def>
+  <Iteration_hits>
+    <Hit>
+      <Hit_accession>0</Hit_accession>
+      <Hit_hsps>
+        <Hsp>
+          <Hsp_bit-score>30</Hsp_bit-score>
+          <Hsp_evalue>0.001</Hsp_evalue>
+          <Hsp_query-from>1</Hsp_query-from>
+          <Hsp_query-to>5</Hsp_query-to>
+          <Hsp_hit-from>1</Hsp_hit-from>
+          <Hsp_hit-to>5</Hsp_hit-to>
+          <Hsp_identity>5</Hsp_identity>
+          <Hsp_align-len>5</Hsp_align-len>
+        </Hsp>
+      </Hit_hsps>
+    </Hit>
+  </Iteration_hits>
+</Iteration>
+</BlastOutput_iterations>
+</BlastOutput>
+`
+	hits, err := ReadBlastXML(strings.NewReader(xml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Hit{
+		{QueryId: "q1", SubjectId: "3", Identity: 80, AlignLen: 10,
+			Mismatches: 1, GapOpens: 1, QueryStart: 2, QueryEnd: 11,
+			SubjectStart: 5, SubjectEnd: 13, Evalue: 1e-5, BitScore: 40.5},
+		{QueryId: "q1", SubjectId: "3", Identity: 50, AlignLen: 8,
+			Mismatches: 2, GapOpens: 2, QueryStart: 20, QueryEnd: 27,
+			SubjectStart: 40, SubjectEnd: 45, Evalue: 2.5, BitScore: 20},
+		{QueryId: "q3", SubjectId: "0", Identity: 100, AlignLen: 5,
+			QueryStart: 1, QueryEnd: 5, SubjectStart: 1, SubjectEnd: 5,
+			Evalue: 0.001, BitScore: 30},
+	}
+	if !reflect.DeepEqual(hits, expected) {
+		t.Fatalf("Expected hits %v, but got %v", expected, hits)
+	}
+
+	bad := []string{
+		"",
+		"<Other></Other>",
+		"<BlastOutput><Iteration><Iteration_hits>",
+		"<BlastOutput><Iteration><Iteration_iter-num>x</Iteration_iter-num>" +
+			"</Iteration></BlastOutput>",
+	}
+	for _, out := range bad {
+		if _, err := ReadBlastXML(strings.NewReader(out)); err == nil {
+			t.Fatalf("Expected an error reading %q.", out)
+		}
+	}
+}
+
+func TestMergeCoarseRanges(t *testing.T) {
+	ranges := []CoarseRange{
+		{CoarseId: 7, Start: 50, End: 80},
+		{CoarseId: 2, Start: 1, End: 10},
+		{CoarseId: 7, Start: 10, End: 20},
+		{CoarseId: 7, Start: 60, End: 100},
+		{CoarseId: 2, Start: 11, End: 15},
+		{CoarseId: 7, Start: 19, End: 25},
+		{CoarseId: 2, Start: 40, End: 30},
+	}
+	expected := []CoarseRange{
+		{CoarseId: 7, Start: 10, End: 25},
+		{CoarseId: 7, Start: 50, End: 100},
+		{CoarseId: 2, Start: 1, End: 15},
+		{CoarseId: 2, Start: 30, End: 40},
+	}
+	got := MergeCoarseRanges(ranges)
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %v, but got %v", expected, got)
+	}
+	if len(MergeCoarseRanges(nil)) != 0 {
+		t.Fatalf("Expected no ranges.")
+	}
+}
+
+func TestSplitQueries(t *testing.T) {
+	query := []byte(">q1 a>b\nMKV\nLAA\n>q2\nGIV\n>q3\nAWH\n")
+	tests := []struct {
+		size     int
+		expected []string
+	}{
+		{1, []string{">q1 a>b\nMKV\nLAA\n", ">q2\nGIV\n", ">q3\nAWH\n"}},
+		{2, []string{">q1 a>b\nMKV\nLAA\n>q2\nGIV\n", ">q3\nAWH\n"}},
+		{5, []string{string(query)}},
+	}
+	for _, test := range tests {
+		batches := splitQueries(query, test.size)
+		got := make([]string, len(batches))
+		for i, batch := range batches {
+			got[i] = string(batch)
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Fatalf("Batches of %d: expected %q, but got %q",
+				test.size, test.expected, got)
+		}
+	}
+	if len(splitQueries(nil, 1)) != 0 {
+		t.Fatalf("Expected no batches.")
+	}
+}
+
+func TestWithoutOutputArgs(t *testing.T) {
+	args := []string{"-evalue", "1e-5", "-outfmt", "6 qseqid", "-out",
+		"hits.txt", "-matrix", "BLOSUM62"}
+	expected := []string{"-evalue", "1e-5", "-matrix", "BLOSUM62"}
+	if got := withoutOutputArgs(args); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %q, but got %q", expected, got)
+	}
+}
+
+func TestCheckBlastArgs(t *testing.T) {
+	valid := [][]string{
+		nil,
+		{"-evalue", "1e-5", "-max_target_seqs", "10"},
+		{"-matrix", "PAM30", "-word_size", "2", "-comp_based_stats", "0"},
+	}
+	for _, args := range valid {
+		if err := CheckBlastArgs(args); err != nil {
+			t.Fatalf("Expected %q to be accepted, but got: %s", args, err)
+		}
+	}
+
+	invalid := [][]string{
+		{"-out", "/etc/passwd"},
+		{"-import_search_strategy", "strategy.asn"},
+		{"-evalue", "1e-5", "-outfmt", "6"},
+		{"-evalue"},
+		{"-evalue", "-out", "hits.txt"},
+		{"1e-5"},
+	}
+	for _, args := range invalid {
+		if err := CheckBlastArgs(args); err == nil {
+			t.Fatalf("Expected %q to be rejected.", args)
+		}
+	}
+}
+
+func TestPSISearchRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mica-test-psi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbDir := path.Join(dir, "db")
+	if err := os.Mkdir(dbDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	store := queryStorage{DirStorage(dbDir)}
+	db, err := NewWriteStorageDB(false, DefaultDBConf.DeepCopy(), nil,
+		store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCompressor(db)
+	c.Start()
+	c.Compress(0, NewOriginalSeq(0, "s0",
+		[]byte("MKVLAAGIVAWHKRTPEDLCNYQFGS")))
+	c.Done()
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+	db.WriteClose()
+	if db, err = NewReadStorageDB(store); err != nil {
+		t.Fatal(err)
+	}
+	defer db.ReadClose()
+
+	// A fake psiblast that logs the output format of every search, saves a
+	// PSSM when asked to, and always finds sequence 0 (the only coarse
+	// sequence, and the only sequence of the fine database).
+	log := path.Join(dir, "runs.log")
+	psiblast := path.Join(dir, "psiblast")
+	script := `#!/bin/sh
+fmt=raw
+while [ $# -gt 0 ]; do
+	case "$1" in
+	-outfmt) fmt=$2; shift ;;
+	-out_pssm) echo pssm > "$2"; shift ;;
+	esac
+	shift
+done
+cat > /dev/null
+echo $fmt >> ` + log + `
+if [ $fmt = raw ]; then
+	echo "raw output"
+	exit 0
+fi
+cat <<XML
+<BlastOutput><BlastOutput_iterations><Iteration>
+<Iteration_query-def>q1</Iteration_query-def>
+<Iteration_hits><Hit><Hit_accession>0</Hit_accession><Hit_hsps><Hsp>
+<Hsp_evalue>1e-30</Hsp_evalue><Hsp_align-len>26</Hsp_align-len>
+</Hsp></Hit_hsps></Hit></Iteration_hits>
+</Iteration></BlastOutput_iterations></BlastOutput>
+XML
+`
+	if err := ioutil.WriteFile(psiblast, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+	makeblastdb := path.Join(dir, "makeblastdb")
+	script = "#!/bin/sh\nwhile [ $# -gt 0 ]; do\n" +
+		"\t[ \"$1\" = -out ] && touch \"$2.pin\"\n\tshift\ndone\n"
+	if err := ioutil.WriteFile(makeblastdb, []byte(script), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSearcher(db)
+	coarse, fine := NewBlastAligner("psiblast"), NewBlastAligner("psiblast")
+	coarse.Exec, coarse.MakeBlastDB = psiblast, makeblastdb
+	fine.Exec, fine.MakeBlastDB = psiblast, makeblastdb
+	s.Coarse, s.Fine = coarse, fine
+	s.TempDir = dir
+	ps := NewPSISearcher(s)
+
+	// runs returns the output formats of the searches run by 'search'.
+	runs := func(search func() error) string {
+		os.Remove(log)
+		if err := search(); err != nil {
+			t.Fatal(err)
+		}
+		bs, err := ioutil.ReadFile(log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(strings.Fields(string(bs)), " ")
+	}
+	query := []byte(">q1\nMKVLAAGIVAWHKRTPEDLCNYQFGS\n")
+	out := new(bytes.Buffer)
+
+	// Each iteration searches the coarse and then the fine database, and
+	// only the fine search of the last iteration writes its own output.
+	ps.Iterations = 2
+	got := runs(func() error { return ps.Search(query, out) })
+	if expected := "5 5 5 raw"; got != expected {
+		t.Fatalf("Expected searches %q, but got %q", expected, got)
+	}
+	if out.String() != "raw output\n" {
+		t.Fatalf("Unexpected output %q", out)
+	}
+
+	// The second iteration converges before the last one, so its fine
+	// search is run again to write its output.
+	ps.Iterations = 3
+	out.Reset()
+	got = runs(func() error { return ps.Search(query, out) })
+	if expected := "5 5 5 5 raw"; got != expected {
+		t.Fatalf("Expected searches %q, but got %q", expected, got)
+	}
+	if out.String() != "raw output\n" {
+		t.Fatalf("Unexpected output %q", out)
+	}
+
+	// The PSSM saved is written by the search that writes the output.
+	ps.Iterations = 1
+	ps.SavePSSM = path.Join(dir, "saved.pssm")
+	out.Reset()
+	got = runs(func() error { return ps.Search(query, out) })
+	if expected := "5 raw"; got != expected {
+		t.Fatalf("Expected searches %q, but got %q", expected, got)
+	}
+	if _, err := os.Stat(ps.SavePSSM); err != nil {
+		t.Fatalf("The last PSSM was not saved: %s", err)
+	}
+}
+
+// hitCollector is a HitWriter that keeps every hit in memory.
+type hitCollector struct {
+	hits []Hit
+}
+
+func (hc *hitCollector) Write(hit Hit) error {
+	hc.hits = append(hc.hits, hit)
+	return nil
+}
+
+func (hc *hitCollector) Close() error {
+	return nil
+}
+
+// hitKeys describes each hit as a string, in sorted order.
+func hitKeys(hits []Hit) []string {
+	keys := make([]string, len(hits))
+	for i, h := range hits {
+		keys[i] = fmt.Sprintf("%s %s %d-%d %d-%d %g",
+			h.QueryId, h.SubjectId, h.QueryStart, h.QueryEnd,
+			h.SubjectStart, h.SubjectEnd, h.Evalue)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// fakeDiamond is a stand-in for DIAMOND that reports a hit of every query on
+// every subject. Its index is a copy of the FASTA file it is made from, and
+// its "daa" files are BLAST tabular output.
+const fakeDiamond = `#!/bin/sh
+cmd=$1
+shift
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--in|-q) in=$2 ;;
+	-d) db=$2 ;;
+	-a) out=$2 ;;
+	esac
+	shift
+done
+case $cmd in
+makedb) cp "$in" "$db.dmnd" ;;
+view) cat "$out" ;;
+*) awk '/^>/ { split(substr($0, 2), w, " ") }
+	FNR == NR && /^>/ { subjects[++n] = w[1]; next }
+	FNR != NR && /^>/ { for (i = 1; i <= n; i++)
+		printf "%s\t%s\t100.00\t10\t0\t0\t1\t30\t1\t10\t1e-10\t50.0\n",
+			w[1], subjects[i] }' "$db.dmnd" "$in" > "$out.daa" ;;
+esac
+`
+
+func TestCompressedQueries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mica-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Without DIAMOND, the search is still run from end to end. Every query
+	// hits every sequence, so this checks that compressed queries are
+	// expanded into all of the queries that they stand for.
+	dmnd, err := exec.LookPath("diamond")
+	if err != nil {
+		dmnd = path.Join(dir, "diamond")
+		err = ioutil.WriteFile(dmnd, []byte(fakeDiamond), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The coarse DIAMOND index of the database is made by the search.
+	dbDir := path.Join(dir, "db")
+	if err := os.Mkdir(dbDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	store := queryStorage{DirStorage(dbDir)}
+	db, err := NewWriteStorageDB(false, DefaultDBConf.DeepCopy(), nil,
+		store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open("data/small.fasta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCompressor(db)
+	c.Start()
+	_, err = c.CompressFasta(f, 0, nil)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Done()
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+	db.WriteClose()
+	if db, err = NewReadStorageDB(store); err != nil {
+		t.Fatal(err)
+	}
+	defer db.ReadClose()
+
+	s := NewSearcher(db)
+	coarse, fine := NewDmndAligner("blastx"), NewDmndAligner("blastx")
+	coarse.Exec, fine.Exec = dmnd, dmnd
+	s.Coarse, s.Fine = coarse, fine
+	s.TempDir = dir
+	s.Threads = 2
+
+	queryFile := "data/nucl_small.fasta"
+	query, err := ReadQueryFile(queryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := new(hitCollector)
+	if err := s.SearchHits(query, plain); err != nil &&
+		err != ErrNoCoarseHits {
+		t.Fatal(err)
+	}
+
+	qdb, cleanup, err := s.CompressQueries(queryFile, DefaultDBConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	compressed := new(hitCollector)
+	if err := s.SearchQueryDBHits(qdb, compressed); err != nil &&
+		err != ErrNoCoarseHits {
+		t.Fatal(err)
+	}
+
+	want, got := hitKeys(plain.hits), hitKeys(compressed.hits)
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("Searching compressed queries found\n%s\nbut searching "+
+			"the queries found\n%s", strings.Join(got, "\n"),
+			strings.Join(want, "\n"))
+	}
+}
+
+// stubAligner is an Aligner that doesn't run a search program. Its index is a
+// copy of the FASTA file it is built from, and it reports the hits it is
+// given for every search (or, when it has none, a hit for every sequence in
+// the index). Search writes the sequences in the index.
+type stubAligner struct {
+	hits    []Hit
+	indexed []string
+}
+
+func (a *stubAligner) Name() string        { return "stub" }
+func (a *stubAligner) CoarseIndex() string { return "coarse.stub" }
+
+func (a *stubAligner) HasIndex(index string) bool {
+	_, err := os.Stat(index)
+	return err == nil
+}
+
+func (a *stubAligner) Index(fasta, index string) error {
+	bs, err := ioutil.ReadFile(fasta)
+	if err != nil {
+		return err
+	}
+	a.indexed = append(a.indexed, index)
+	return ioutil.WriteFile(index, bs, 0666)
+}
+
+func (a *stubAligner) Search(q AlignQuery, out io.Writer) error {
+	bs, err := ioutil.ReadFile(q.Index)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(bs)
+	return err
+}
+
+func (a *stubAligner) Hits(q AlignQuery) chan ReadHit {
+	return streamHits(func(send func(Hit) error) error {
+		if a.hits != nil {
+			for _, hit := range a.hits {
+				if err := send(hit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		bs, err := ioutil.ReadFile(q.Index)
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(string(bs), "\n") {
+			if !strings.HasPrefix(line, ">") {
+				continue
+			}
+			hit := Hit{QueryId: "q", SubjectId: line[1:]}
+			if err := send(hit); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func TestSearcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mica-test-searcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 'seq' and 'mut' share a coarse sequence, while 'other' has its own.
+	rng := rand.New(rand.NewSource(1))
+	seq, mut := randomSeq(rng, "ACDEFGHIKLMNPQRSTVWY", 300, 20)
+	other, _ := randomSeq(rng, "ACDEFGHIKLMNPQRSTVWY", 200, 20)
+	dbDir, tmpDir := path.Join(dir, "db"), path.Join(dir, "tmp")
+	for _, d := range []string{dbDir, tmpDir} {
+		if err := os.Mkdir(d, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := queryStorage{DirStorage(dbDir)}
+	db, err := NewWriteStorageDB(false, DefaultDBConf.DeepCopy(), nil,
+		store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCompressor(db)
+	c.Start()
+	c.Compress(0, NewOriginalSeq(0, "seq first", seq))
+	c.Compress(1, NewOriginalSeq(1, "mut second", mut))
+	c.Compress(2, NewOriginalSeq(2, "other third", other))
+	c.Done()
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+	db.WriteClose()
+	if db, err = NewReadStorageDB(store); err != nil {
+		t.Fatal(err)
+	}
+	defer db.ReadClose()
+	if db.CoarseDB.NumSequences() != 2 {
+		t.Fatalf("Expected 2 coarse sequences, but there are %d.",
+			db.CoarseDB.NumSequences())
+	}
+
+	// The hit on the coarse sequence of 'other' is rejected by its e-value.
+	coarse := &stubAligner{hits: []Hit{
+		{QueryId: "q", SubjectId: "0", SubjectStart: 1, SubjectEnd: 50,
+			Evalue: 1e-10},
+		{QueryId: "q", SubjectId: "1", SubjectStart: 1, SubjectEnd: 50,
+			Evalue: 10},
+	}}
+	fine := &stubAligner{}
+	s := NewSearcher(db)
+	s.Coarse, s.Fine = coarse, fine
+	s.TempDir = tmpDir
+	query := []byte(">q\n" + string(seq[:50]) + "\n")
+
+	// The coarse index is built by the first search, and is then reused.
+	hits, err := s.CoarseSearch(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(hits, coarse.hits) {
+		t.Fatalf("Expected coarse hits %v, but got %v.", coarse.hits, hits)
+	}
+	if _, err := s.CoarseSearch(query); err != nil {
+		t.Fatal(err)
+	}
+	wantIndex := []string{path.Join(dbDir, "coarse.stub")}
+	if !reflect.DeepEqual(coarse.indexed, wantIndex) {
+		t.Fatalf("Expected the coarse indexes %v to be built, but %v were.",
+			wantIndex, coarse.indexed)
+	}
+
+	oseqs, err := s.Expand(hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, len(oseqs))
+	for i, oseq := range oseqs {
+		names[i] = oseq.Name
+	}
+	sort.Strings(names)
+	if want := []string{"mut second", "seq first"}; !reflect.DeepEqual(
+		names, want) {
+		t.Fatalf("Expected to expand %v, but expanded %v.", want, names)
+	}
+
+	// The fine search sees the expanded sequences, and its hits are about
+	// the original sequences.
+	out := new(bytes.Buffer)
+	if err := s.FineSearch(query, oseqs, out); err != nil {
+		t.Fatal(err)
+	}
+	fasta := new(bytes.Buffer)
+	if err := WriteFasta(fasta, oseqs); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != fasta.String() {
+		t.Fatalf("Expected the fine database\n%s\nbut got\n%s",
+			fasta, out)
+	}
+
+	collector := new(hitCollector)
+	if err := s.SearchHits(query, collector); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, len(collector.hits))
+	for i, hit := range collector.hits {
+		got[i] = fmt.Sprintf("%s %s %d %s", hit.SubjectId,
+			hit.SubjectHeader, hit.OriginalId, hit.Backend)
+	}
+	sort.Strings(got)
+	want := []string{"mut mut second 1 stub", "seq seq first 0 stub"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Expected fine hits %v, but got %v.", want, got)
+	}
+
+	// Every fine database is removed once it has been searched.
+	if len(fine.indexed) != 2 {
+		t.Fatalf("Expected 2 fine indexes, but %d were built.",
+			len(fine.indexed))
+	}
+	if left, err := ioutil.ReadDir(tmpDir); err != nil {
+		t.Fatal(err)
+	} else if len(left) > 0 {
+		t.Fatalf("Expected no temporary files, but %s was left.",
+			left[0].Name())
+	}
+
+	coarse.hits = []Hit{}
+	if err := s.Search(query, new(bytes.Buffer)); err != ErrNoCoarseHits {
+		t.Fatalf("Expected %s, but got %v.", ErrNoCoarseHits, err)
+	}
+}
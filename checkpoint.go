@@ -0,0 +1,374 @@
+package mica
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+const FileCheckpoint = "checkpoint"
+
+// A Checkpoint records how far compression of a list of input files has
+// progressed. Together with the state saved by DB.SaveCheckpoint, it is
+// enough to resume compression (see NewResumeDB) as if it had never been
+// interrupted.
+type Checkpoint struct {
+	// The input FASTA files, in the order given.
+	Inputs []string
+
+	// Whether the input is compressed longest first.
+	SortLength bool
+
+	// The id of the first original sequence in the input.
+	FirstId int
+
+	// The number of input sequences that have been compressed.
+	Consumed int
+
+	// The total number of residues compressed. (See DBConf.BlastDBSize.)
+	BlastDBSize uint64
+
+	// Whether the input is being appended to an existing database.
+	appending bool
+
+	// Links and seeds are saved to a new file (named by its generation) for
+	// each checkpoint, so that the previous checkpoint stays intact until
+	// the new one is complete.
+	generation int
+
+	// The sizes of the database files that are only ever added to. Anything
+	// past these sizes was written after the checkpoint, and is discarded
+	// when compression is resumed.
+	compressedSize, indexSize   int64
+	coarseSize, coarseIndexSize int64
+}
+
+// SaveCheckpoint saves the state of a database being compressed, so that
+// compression can be resumed from this point with NewResumeDB. 'cp' is
+// updated and saved along with it.
+//
+// Every sequence counted by cp.Consumed must already have been written to
+// the compressed database, and nothing may be added to the database while
+// the checkpoint is being saved.
+func (db *DB) SaveCheckpoint(cp *Checkpoint) error {
+	Vprintf("\nSaving checkpoint after %d sequences...\n", cp.Consumed)
+	timer := time.Now()
+
+	// Reopening the compressed database waits for everything queued to be
+	// written.
+	anyOrder := db.ComDB.anyOrder
+	db.ComDB.writeClose()
+	comdb, err := newWriteCompressedDB(true, db)
+	if err != nil {
+		return fmt.Errorf("Could not reopen compressed database: %s", err)
+	}
+	comdb.anyOrder = anyOrder
+	db.ComDB = comdb
+
+	cp.appending = db.appending
+	if cp.compressedSize, err = fileSize(comdb.File); err != nil {
+		return err
+	}
+	if cp.indexSize, err = fileSize(comdb.Index); err != nil {
+		return err
+	}
+
+	// New coarse sequences are added to the coarse database as usual. Links
+	// and seeds are saved to the checkpoint, since the files in the database
+	// can only be written once compression is finished.
+	coarsedb := db.CoarseDB
+	coarsedb.seqLock.RLock()
+	defer coarsedb.seqLock.RUnlock()
+
+	if err = coarsedb.saveFasta(); err != nil {
+		return fmt.Errorf("Could not write coarse database: %s", err)
+	}
+	if cp.coarseSize, err = fileSize(coarsedb.FileFasta); err != nil {
+		return err
+	}
+	cp.coarseIndexSize, err = fileSize(coarsedb.FileFastaIndex)
+	if err != nil {
+		return err
+	}
+	if !db.appending {
+		if err = db.saveParams(); err != nil {
+			return fmt.Errorf("Could not write params: %s", err)
+		}
+		if err = db.params.Sync(); err != nil {
+			return err
+		}
+	}
+
+	cp.generation++
+	state := db.checkpointState(cp.generation)
+	err = coarsedb.saveCheckpointState(state, db.Duplicates)
+	if err != nil {
+		return fmt.Errorf("Could not write checkpoint: %s", err)
+	}
+
+	// Replace the previous checkpoint in one step.
+	tmp := db.filePath(FileCheckpoint + ".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("Could not create checkpoint: %s", err)
+	}
+	if err = cp.write(f); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("Could not write checkpoint: %s", err)
+	}
+	if err = os.Rename(tmp, db.filePath(FileCheckpoint)); err != nil {
+		return fmt.Errorf("Could not write checkpoint: %s", err)
+	}
+	os.Remove(db.checkpointState(cp.generation - 1))
+
+	Vprintf("Done saving checkpoint (%s).\n", time.Since(timer))
+	return nil
+}
+
+// RemoveCheckpoint removes the last checkpoint saved, if there is one. It
+// should be called once compression is finished.
+func (db *DB) RemoveCheckpoint(cp *Checkpoint) error {
+	if cp.generation == 0 {
+		return nil
+	}
+	if err := os.Remove(db.filePath(FileCheckpoint)); err != nil {
+		return err
+	}
+	return os.Remove(db.checkpointState(cp.generation))
+}
+
+// NewResumeDB opens a database that was being compressed when its last
+// checkpoint was saved, and prepares it for compression to continue from
+// the checkpoint. The checkpoint is returned.
+//
+// Everything written to the database after the checkpoint was saved is
+// discarded. 'conf' is merged with the database's configuration in the same
+// way as NewWriteDB does when appending, keeping the options named in 'set'.
+func NewResumeDB(
+	conf *DBConf, set map[string]bool, dir string) (*DB, *Checkpoint, error) {
+
+	cp, err := ReadCheckpoint(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sizes := []struct {
+		name string
+		size int64
+	}{
+		{FileCompressed, cp.compressedSize},
+		{FileIndex, cp.indexSize},
+		{FileCoarseFasta, cp.coarseSize},
+		{FileCoarseFastaIndex, cp.coarseIndexSize},
+	}
+	for _, file := range sizes {
+		err := os.Truncate(path.Join(dir, file.name), file.size)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not restore '%s' from the "+
+				"checkpoint: %s", file.name, err)
+		}
+	}
+
+	db, err := newWriteDB(true, cp, conf, set, dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	db.BlastDBSize = cp.BlastDBSize
+	return db, cp, nil
+}
+
+// ReadCheckpoint reads the last checkpoint saved in the database directory
+// 'dir'.
+func ReadCheckpoint(dir string) (*Checkpoint, error) {
+	f, err := os.Open(path.Join(dir, FileCheckpoint))
+	if err != nil {
+		return nil, fmt.Errorf("Could not open checkpoint: %s", err)
+	}
+	defer f.Close()
+
+	cp, err := readCheckpoint(f)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read checkpoint: %s", err)
+	}
+	return cp, nil
+}
+
+func readCheckpoint(r io.Reader) (*Checkpoint, error) {
+	csvReader := csv.NewReader(r)
+	csvReader.Comma = ':'
+	csvReader.FieldsPerRecord = 2
+	csvReader.TrimLeadingSpace = true
+
+	lines, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &Checkpoint{}
+	for _, line := range lines {
+		if line[0] == "Input" {
+			cp.Inputs = append(cp.Inputs, line[1])
+			continue
+		}
+
+		n, err := strconv.ParseInt(line[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		switch line[0] {
+		case "Generation":
+			cp.generation = int(n)
+		case "SortLength":
+			cp.SortLength = n == 1
+		case "Appending":
+			cp.appending = n == 1
+		case "FirstId":
+			cp.FirstId = int(n)
+		case "Consumed":
+			cp.Consumed = int(n)
+		case "BlastDBSize":
+			cp.BlastDBSize = uint64(n)
+		case "CompressedSize":
+			cp.compressedSize = n
+		case "IndexSize":
+			cp.indexSize = n
+		case "CoarseSize":
+			cp.coarseSize = n
+		case "CoarseIndexSize":
+			cp.coarseIndexSize = n
+		default:
+			return nil, fmt.Errorf("Unknown checkpoint field '%s'.", line[0])
+		}
+	}
+	return cp, nil
+}
+
+func (cp *Checkpoint) write(w io.Writer) error {
+	csvWriter := csv.NewWriter(w)
+	csvWriter.Comma = ':'
+	csvWriter.UseCRLF = false
+
+	s := func(i int64) string {
+		return fmt.Sprintf("%d", i)
+	}
+	bs := func(b bool) string {
+		if b {
+			return "1"
+		}
+		return "0"
+	}
+	records := [][]string{
+		{"Generation", s(int64(cp.generation))},
+		{"SortLength", bs(cp.SortLength)},
+		{"Appending", bs(cp.appending)},
+		{"FirstId", s(int64(cp.FirstId))},
+		{"Consumed", s(int64(cp.Consumed))},
+		{"BlastDBSize", s(int64(cp.BlastDBSize))},
+		{"CompressedSize", s(cp.compressedSize)},
+		{"IndexSize", s(cp.indexSize)},
+		{"CoarseSize", s(cp.coarseSize)},
+		{"CoarseIndexSize", s(cp.coarseIndexSize)},
+	}
+	for _, input := range cp.Inputs {
+		records = append(records, []string{"Input", input})
+	}
+	return csvWriter.WriteAll(records)
+}
+
+// checkpointState returns the path of the file with the links, seeds and
+// duplicates saved for a checkpoint.
+func (db *DB) checkpointState(generation int) string {
+	return db.filePath(fmt.Sprintf("%s.%d", FileCheckpoint, generation))
+}
+
+// saveCheckpointState writes the seed eviction state, the links, the
+// duplicates table and the seeds of the coarse database to a new gzipped
+// file, 'name'.
+func (coarsedb *CoarseDB) saveCheckpointState(
+	name string, dups *Duplicates) error {
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gzipWriter, err := gzip.NewWriterLevel(f, gzip.BestSpeed)
+	if err != nil {
+		return err
+	}
+	if err = coarsedb.Seeds.writeLimit(gzipWriter); err != nil {
+		return err
+	}
+	if err = coarsedb.writeLinks(gzipWriter, ioutil.Discard); err != nil {
+		return err
+	}
+	if err = dups.write(gzipWriter); err != nil {
+		return err
+	}
+	if err = coarsedb.writeSeeds(gzipWriter); err != nil {
+		return err
+	}
+	if err = gzipWriter.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// loadCheckpoint loads the coarse sequences in the coarse database, along
+// with the links, duplicates and seeds saved for the checkpoint 'cp'.
+func (coarsedb *CoarseDB) loadCheckpoint(db *DB, cp *Checkpoint) error {
+	if err := coarsedb.readFasta(); err != nil {
+		return err
+	}
+
+	Vprintf("\t\tReading checkpoint %d...\n", cp.generation)
+	timer := time.Now()
+
+	f, err := os.Open(db.checkpointState(cp.generation))
+	if err != nil {
+		return fmt.Errorf("Could not open checkpoint: %s", err)
+	}
+	defer f.Close()
+
+	gr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("Could not create gzip reader: %s", err)
+	}
+	if err = coarsedb.Seeds.readLimit(gr); err != nil {
+		return err
+	}
+	if err = coarsedb.readLinksFrom(gr); err != nil {
+		return err
+	}
+	if err = db.Duplicates.read(gr); err != nil {
+		return err
+	}
+	if err = coarsedb.readSeedsFrom(gr); err != nil {
+		return err
+	}
+	if err = gr.Close(); err != nil {
+		return fmt.Errorf("Could not close gzip reader: %s", err)
+	}
+
+	Vprintf("\t\tDone reading checkpoint %d (%s).\n",
+		cp.generation, time.Since(timer))
+	return nil
+}
+
+func fileSize(f File) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
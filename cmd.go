@@ -3,8 +3,11 @@ package mica
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os/exec"
 	"strings"
+	"sync"
 )
 
 // Exec runs a command created with 'Command' in the os/exec package, and
@@ -19,13 +22,101 @@ func Exec(cmd *exec.Cmd) error {
 	fullCmd := strings.Join(cmd.Args, " ")
 
 	Vprintf("%s\n", fullCmd)
-	if err := cmd.Run(); err != nil {
-		if stderr.Len() > 0 {
-			return fmt.Errorf(
-				"Error running '%s': '%s'. \n\nstderr:\n%s",
-				fullCmd, err, stderr.String())
-		}
-		return fmt.Errorf("Error running '%s': '%s'.", fullCmd, err)
+	if err := start(cmd); err != nil {
+		return execError(fullCmd, err, &stderr)
 	}
+	if err := wait(cmd); err != nil {
+		return execError(fullCmd, err, &stderr)
+	}
+	return nil
+}
+
+// ExecRead is like Exec, except that the command's stdout is passed to 'read'
+// while the command runs. If 'read' fails, the command is killed and the
+// error from 'read' is returned.
+func ExecRead(cmd *exec.Cmd, read func(stdout io.Reader) error) error {
+	var stderr bytes.Buffer
+
+	cmd.Stderr = &stderr
+	fullCmd := strings.Join(cmd.Args, " ")
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return execError(fullCmd, err, &stderr)
+	}
+	Vprintf("%s\n", fullCmd)
+	if err := start(cmd); err != nil {
+		return execError(fullCmd, err, &stderr)
+	}
+	if err := read(stdout); err != nil {
+		cmd.Process.Kill()
+		wait(cmd)
+		return err
+	}
+
+	// Whatever 'read' left behind must be consumed before waiting.
+	io.Copy(ioutil.Discard, stdout)
+	if err := wait(cmd); err != nil {
+		return execError(fullCmd, err, &stderr)
+	}
+	return nil
+}
+
+// running are the commands started by Exec and ExecRead that haven't been
+// waited for.
+var running = struct {
+	sync.Mutex
+	cmds   map[*exec.Cmd]bool
+	killed bool
+}{cmds: make(map[*exec.Cmd]bool, 10)}
+
+// errKilled is returned by Exec and ExecRead once KillCommands was called.
+var errKilled = fmt.Errorf("Commands were killed.")
+
+// KillCommands kills every command run by Exec or ExecRead that is still
+// running, and makes Exec and ExecRead fail from then on. It is meant to give
+// up on searches when a program shuts down, so that the commands don't
+// outlive it (or the temporary files they use).
+func KillCommands() {
+	running.Lock()
+	defer running.Unlock()
+
+	running.killed = true
+	for cmd := range running.cmds {
+		cmd.Process.Kill()
+	}
+}
+
+// start starts a command, and adds it to the running commands.
+func start(cmd *exec.Cmd) error {
+	running.Lock()
+	defer running.Unlock()
+
+	if running.killed {
+		return errKilled
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	running.cmds[cmd] = true
 	return nil
 }
+
+// wait waits for a command started with start, and removes it from the
+// running commands.
+func wait(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	running.Lock()
+	delete(running.cmds, cmd)
+	running.Unlock()
+	return err
+}
+
+func execError(fullCmd string, err error, stderr *bytes.Buffer) error {
+	if stderr.Len() > 0 {
+		return fmt.Errorf(
+			"Error running '%s': '%s'. \n\nstderr:\n%s",
+			fullCmd, err, stderr.String())
+	}
+	return fmt.Errorf("Error running '%s': '%s'.", fullCmd, err)
+}
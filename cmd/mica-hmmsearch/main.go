@@ -0,0 +1,13 @@
+// Command mica-hmmsearch is an alias of 'mica hmmsearch'.
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/ndaniels/mica/internal/cli/hmmsearch"
+)
+
+func main() {
+	hmmsearch.Main(path.Base(os.Args[0]), os.Args[1:])
+}
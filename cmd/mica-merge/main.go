@@ -0,0 +1,13 @@
+// Command mica-merge is an alias of 'mica merge'.
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/ndaniels/mica/internal/cli/merge"
+)
+
+func main() {
+	merge.Main(path.Base(os.Args[0]), os.Args[1:])
+}
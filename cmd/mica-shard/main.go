@@ -0,0 +1,13 @@
+// Command mica-shard is an alias of 'mica shard'.
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/ndaniels/mica/internal/cli/shard"
+)
+
+func main() {
+	shard.Main(path.Base(os.Args[0]), os.Args[1:])
+}
@@ -0,0 +1,13 @@
+// Command mica-tune is an alias of 'mica tune'.
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/ndaniels/mica/internal/cli/tune"
+)
+
+func main() {
+	tune.Main(path.Base(os.Args[0]), os.Args[1:])
+}
@@ -0,0 +1,81 @@
+// Command mica compresses protein sequence databases and runs compressive
+// searches against them. Each of its subcommands is also installed as one of
+// the mica-* commands (e.g., 'mica compress' as mica-compress), which are
+// kept as aliases.
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ndaniels/mica/internal/cli/compress"
+	"github.com/ndaniels/mica/internal/cli/decompress"
+	"github.com/ndaniels/mica/internal/cli/hmmsearch"
+	"github.com/ndaniels/mica/internal/cli/merge"
+	"github.com/ndaniels/mica/internal/cli/reindex"
+	"github.com/ndaniels/mica/internal/cli/search"
+	"github.com/ndaniels/mica/internal/cli/serve"
+	"github.com/ndaniels/mica/internal/cli/shard"
+	"github.com/ndaniels/mica/internal/cli/stats"
+	"github.com/ndaniels/mica/internal/cli/tune"
+)
+
+// A command is a subcommand of mica. Its main function is given the name to
+// use in messages (e.g., "mica compress") and the arguments after the name
+// of the subcommand.
+type command struct {
+	name, short string
+	main        func(prog string, args []string)
+}
+
+var commands = []command{
+	{"compress", "Compress FASTA files into a database.", compress.Main},
+	{"decompress", "Write every sequence in a database to a FASTA file.",
+		decompress.Main},
+	{"search", "Search a database with " +
+		strings.Join(search.Programs, ", ") + ".", search.Main},
+	{"hmmsearch", "Search a database with profile HMMs (hmmsearch).",
+		hmmsearch.Main},
+	{"stats", "Describe a database.", stats.Main},
+	{"shard", "Split FASTA files into shards to compress separately.",
+		shard.Main},
+	{"merge", "Merge compressed databases into one.", merge.Main},
+	{"tune", "Compare compression parameters on a sample.", tune.Main},
+	{"reindex", "Rewrite the coarse FASTA file of a database.",
+		reindex.Main},
+	{"serve", "Search a database for the clients of an HTTP/JSON API.",
+		serve.Main},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	name := os.Args[1]
+	if name == "help" && len(os.Args) > 2 {
+		name = os.Args[2]
+		os.Args = []string{os.Args[0], name, "--help"}
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			cmd.main("mica "+cmd.name, os.Args[2:])
+			return
+		}
+	}
+	usage()
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "\nUsage: mica command [flags] arguments\n"+
+		"\nThe commands are:\n\n")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "    %s\t%s\n", cmd.name, cmd.short)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr,
+		"\nRun 'mica help command' for the usage of a command.\n")
+	os.Exit(1)
+}
@@ -38,11 +38,11 @@ type CoarseDB struct {
 	fastaIndexSize int64
 
 	// File pointers to each file in the "coarse" part of a mica database.
-	FileFasta      *os.File
-	FileFastaIndex *os.File
-	FileSeeds      *os.File
-	FileLinks      *os.File
-	FileLinksIndex *os.File
+	FileFasta      File
+	FileFastaIndex File
+	FileSeeds      File
+	FileLinks      File
+	FileLinksIndex File
 
 	// Ensures that adding a sequence to the coarse database is atomic.
 	seqLock *sync.RWMutex
@@ -63,13 +63,16 @@ type CoarseDB struct {
 	plain bool
 
 	// File pointers to use when 'plain' is true.
-	plainLinks *os.File
-	plainSeeds *os.File
+	plainLinks File
+	plainSeeds File
 }
 
 // newWriteCoarseDB sets up a new coarse database to be written to (or opens
-// an existing one ready for writing when 'appnd' is set).
-func newWriteCoarseDB(appnd bool, db *DB) (*CoarseDB, error) {
+// an existing one ready for writing when 'appnd' is set). When 'cp' is not
+// nil, links and seeds are loaded from the checkpoint instead.
+func newWriteCoarseDB(
+	appnd bool, cp *Checkpoint, db *DB) (*CoarseDB, error) {
+
 	var err error
 
 	Vprintln("\tOpening coarse database...")
@@ -128,7 +131,12 @@ func newWriteCoarseDB(appnd bool, db *DB) (*CoarseDB, error) {
 	}
 
 	if appnd {
-		if err = coarsedb.load(); err != nil {
+		if cp != nil {
+			err = coarsedb.loadCheckpoint(db, cp)
+		} else {
+			err = coarsedb.load()
+		}
+		if err != nil {
 			return nil, err
 		}
 
@@ -138,7 +146,7 @@ func newWriteCoarseDB(appnd bool, db *DB) (*CoarseDB, error) {
 		// to clear the file and start over (since they are not amenable to
 		// appending like the coarse fasta file is).
 		// Do the same for plain files.
-		trunc := func(f *os.File) (err error) {
+		trunc := func(f File) (err error) {
 			if err = f.Truncate(0); err != nil {
 				return
 			}
@@ -249,6 +257,16 @@ func (coarsedb *CoarseDB) CoarseSeqGet(i uint) *CoarseSeq {
 func (coarsedb *CoarseDB) Expand(
 	comdb *CompressedDB, id, start, end int) ([]OriginalSeq, error) {
 
+	return coarsedb.expand(comdb, id, start, end, make(map[int]bool))
+}
+
+// expand is like Expand, except that original sequences whose ids are in
+// `used` are skipped (and never decompressed), while those that are returned
+// are added to it. This lets callers expand several ranges without
+// decompressing any original sequence more than once.
+func (coarsedb *CoarseDB) expand(comdb *CompressedDB,
+	id, start, end int, used map[int]bool) ([]OriginalSeq, error) {
+
 	// Calculate the byte offset into the coarse links file where the links
 	// for the coarse sequence `i` starts.
 	off, err := coarsedb.linkOffset(id)
@@ -274,14 +292,13 @@ func (coarsedb *CoarseDB) Expand(
 		return nil, fmt.Errorf("Could not read number of links: %s", err)
 	}
 
-	// We use a map as a set of original sequence ids for eliminating
-	// duplicates (since a coarse sequence can point to different pieces of the
-	// same compressed sequence).
-	ids := make(map[uint32]bool, numLinks)
+	// `used` is a set of original sequence ids for eliminating duplicates
+	// (since a coarse sequence can point to different pieces of the same
+	// compressed sequence).
 	oseqs := make([]OriginalSeq, 0, numLinks)
 	s, e := uint16(start), uint16(end)
 	for i := uint32(0); i < numLinks; i++ {
-		compLink, err := coarsedb.readLink()
+		compLink, err := readLink(coarsedb.FileLinks)
 		if err != nil {
 			return nil, fmt.Errorf("Could not read link: %s", err)
 		}
@@ -292,7 +309,7 @@ func (coarsedb *CoarseDB) Expand(
 		}
 
 		// Don't decompress the same original sequence more than once.
-		if ids[compLink.OrgSeqId] {
+		if used[int(compLink.OrgSeqId)] {
 			continue
 		}
 		// !!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!
@@ -305,7 +322,7 @@ func (coarsedb *CoarseDB) Expand(
 			return nil, fmt.Errorf(
 				"Could not read compressed sequence: %s", err)
 		}
-		ids[compLink.OrgSeqId] = true
+		used[int(compLink.OrgSeqId)] = true
 		oseqs = append(oseqs, oseq)
 	}
 
@@ -24,8 +24,8 @@ const (
 // disk (unless it has been cached in 'seqCache').
 type CompressedDB struct {
 	// File pointers to be used in reading/writing compressed databases.
-	File  *os.File
-	Index *os.File
+	File  File
+	Index File
 
 	// The size of the compressed database index in bytes. Since the index
 	// contains precisely one 64-bit integer byte offset for every sequence
@@ -39,6 +39,10 @@ type CompressedDB struct {
 	writerChan chan CompressedSeq
 	writerDone chan struct{}
 
+	// When set, the writer doesn't wait for compressed sequences to arrive
+	// in order. See WriteInAnyOrder.
+	anyOrder bool
+
 	// A compressed database is stored in CSV format. Each CSV record contains
 	// the original sequence's header, followed by a list of quadruples, where
 	// each quadruple is a pointer to a region in the coarse database: a coarse
@@ -73,14 +77,19 @@ func newWriteCompressedDB(appnd bool, db *DB) (*CompressedDB, error) {
 	if appnd {
 		fileFlags = os.O_RDWR | os.O_APPEND
 	}
-	cdb.File, err = os.OpenFile(db.filePath(FileCompressed), fileFlags, 0666)
+	cdb.File, err = db.Storage.OpenFile(FileCompressed, fileFlags)
 	if err != nil {
 		return nil, err
 	}
-	cdb.Index, err = os.OpenFile(db.filePath(FileIndex), fileFlags, 0666)
+	// The index is never opened in append mode, since entries may need to
+	// be written out of order. Instead, we seek to the end of it.
+	cdb.Index, err = db.Storage.OpenFile(FileIndex, fileFlags&^os.O_APPEND)
 	if err != nil {
 		return nil, err
 	}
+	if _, err = cdb.Index.Seek(0, os.SEEK_END); err != nil {
+		return nil, err
+	}
 
 	info, err := cdb.Index.Stat()
 	if err != nil {
@@ -181,6 +190,17 @@ func (comdb *CompressedDB) writeClose() {
 	<-comdb.writerDone
 }
 
+// WriteInAnyOrder tells the writer that compressed sequences may arrive in
+// any order (e.g., when the input is compressed longest first). Records are
+// then written to the compressed database as they arrive, and each index
+// entry is written in place, so that the index is still ordered by sequence
+// id. Every id must be written exactly once before the database is closed.
+//
+// WriteInAnyOrder must be called before the first call to Write.
+func (comdb *CompressedDB) WriteInAnyOrder() {
+	comdb.anyOrder = true
+}
+
 // Write queues a new compressed sequence to be written to disk.
 func (comdb *CompressedDB) Write(cseq CompressedSeq) {
 	comdb.writerChan <- cseq
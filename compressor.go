@@ -0,0 +1,639 @@
+package mica
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// An Alphabet describes the residues of the sequences given to a Compressor,
+// and how K-mers are taken from them when looking for seeds.
+type Alphabet struct {
+	Name string
+
+	// K-mers containing the wildcard residue are never looked up in the
+	// seeds table. When zero, the alphabet has no wildcard.
+	Wildcard byte
+
+	// The number of residues between the starts of successive K-mers looked
+	// up in the seeds table.
+	SeedStep int
+}
+
+var (
+	// ProteinAlphabet is the alphabet of amino acid sequences. Every K-mer
+	// is looked up.
+	ProteinAlphabet = Alphabet{
+		Name:     "protein",
+		Wildcard: 0,
+		SeedStep: 1,
+	}
+
+	// NucleotideAlphabet is the alphabet of DNA sequences (and of protein
+	// sequences reduced with Reduce). Only every fourth K-mer is looked up,
+	// and K-mers with an 'N' are skipped.
+	NucleotideAlphabet = Alphabet{
+		Name:     "nucleotide",
+		Wildcard: 'N',
+		SeedStep: 4,
+	}
+)
+
+// A Compressor compresses original sequences into a database opened for
+// writing. Sequences are compressed concurrently by a pool of workers, and
+// each compressed sequence is written to the compressed database.
+//
+// A Compressor should be created with NewCompressor. Its public fields may be
+// changed before Start is called.
+type Compressor struct {
+	DB *DB
+
+	// The alphabet of the sequences compressed. Defaults to ProteinAlphabet.
+	Alphabet Alphabet
+
+	// When not nil, each sequence is replaced with Transform(residues)
+	// before it is compressed (e.g., Reduce). The coarse and compressed
+	// databases then contain the transformed residues.
+	Transform func(residues []byte) []byte
+
+	// The number of workers. Defaults to GOMAXPROCS.
+	Workers int
+
+	// When WindowSize is greater than zero, sequences are compressed
+	// deterministically, a window of WindowSize sequences at a time.
+	// (See compressWindow.)
+	WindowSize int
+
+	// When set, sequences identical to a sequence compressed earlier are
+	// linked to the same coarse regions instead of being compressed again.
+	// (See Duplicates.)
+	Dedup bool
+
+	jobs   chan compressJob
+	wg     *sync.WaitGroup
+	closed bool
+
+	// The number of sequences sent to the workers that haven't been written
+	// to the compressed database yet. (See Sync.)
+	pending *sync.WaitGroup
+
+	// The sequences that have been received but not yet compressed, when
+	// WindowSize is greater than zero.
+	window []compressJob
+}
+
+// compressJob values are messages sent to the pool of workers when a new
+// sequence should be compressed.
+type compressJob struct {
+	orgSeqId int
+	orgSeq   *OriginalSeq
+
+	// When plan is not nil, the worker only records how the sequence should
+	// be compressed in plan, and signals 'planned' when it's done.
+	plan    *compressPlan
+	planned *sync.WaitGroup
+
+	// The first sequence with the same residues as this one. When 'seen'
+	// is false, this sequence is the first, and it must record its links
+	// in 'dup' once it is compressed.
+	dup  *Duplicate
+	seen bool
+}
+
+// NewCompressor returns a Compressor for 'db' with default settings.
+func NewCompressor(db *DB) *Compressor {
+	return &Compressor{
+		DB:         db,
+		Alphabet:   ProteinAlphabet,
+		Transform:  nil,
+		Workers:    runtime.GOMAXPROCS(0),
+		WindowSize: 0,
+		Dedup:      false,
+	}
+}
+
+// Start launches the workers. It must be called before Compress.
+//
+// If WindowSize is greater than zero, the compressed database produced does
+// not depend on the number of workers.
+func (c *Compressor) Start() {
+	c.jobs = make(chan compressJob, 200)
+	c.wg = &sync.WaitGroup{}
+	c.pending = &sync.WaitGroup{}
+	c.window = make([]compressJob, 0, max(0, c.WindowSize))
+	for i := 0; i < max(1, c.Workers); i++ {
+		c.wg.Add(1)
+		go c.worker()
+	}
+}
+
+// Compress sends the sequence 'seq' to the workers to be compressed as the
+// original sequence with id 'id'.
+//
+// Compress returns the next original sequence id to be used.
+func (c *Compressor) Compress(id int, seq *OriginalSeq) int {
+	job := compressJob{
+		orgSeqId: id,
+		orgSeq:   c.transform(seq),
+	}
+	if c.Dedup {
+		job.dup, job.seen = c.DB.Duplicates.Add(job.orgSeq.Residues)
+	}
+	if c.WindowSize <= 0 {
+		c.pending.Add(1)
+		c.jobs <- job
+		return id + 1
+	}
+
+	c.window = append(c.window, job)
+	if len(c.window) >= c.WindowSize {
+		c.compressWindow()
+	}
+	return id + 1
+}
+
+// CompressFasta compresses every sequence in the FASTA formatted input 'r'
+// (see Compress), numbering them from 'id', and blocks until they have all
+// been compressed. Residues in 'ignore' are replaced with 'X'. The total
+// length of the sequences is added to BlastDBSize.
+//
+// CompressFasta returns the next original sequence id to be used.
+func (c *Compressor) CompressFasta(
+	r io.Reader, id int, ignore []byte) (int, error) {
+
+	seqChan, err := ReadOriginalSeqsFrom(r, ignore)
+	if err != nil {
+		return id, err
+	}
+	for readSeq := range seqChan {
+		if readSeq.Err != nil {
+			c.Sync()
+			return id, readSeq.Err
+		}
+		c.DB.BlastDBSize += uint64(readSeq.Seq.Len())
+		id = c.Compress(id, readSeq.Seq)
+	}
+	c.Sync()
+	return id, nil
+}
+
+// CompressSeq compresses 'seq' as the original sequence with id 'id' in the
+// calling goroutine, and returns the compressed sequence. Coarse sequences
+// and links are added to the coarse database as usual, but the compressed
+// sequence is not written to the compressed database.
+//
+// CompressSeq does not look for duplicates, and should not be used while
+// sequences are being compressed by the workers.
+func (c *Compressor) CompressSeq(id int, seq *OriginalSeq) CompressedSeq {
+	seq = c.transform(seq)
+	cseq := NewCompressedSeq(id, seq.Name)
+	c.compress(seq, newMemory(), directLinker{
+		coarsedb: c.DB.CoarseDB,
+		cseq:     &cseq,
+		orgSeqId: id,
+	})
+	return cseq
+}
+
+// transform returns 'seq' with Transform applied to its residues.
+func (c *Compressor) transform(seq *OriginalSeq) *OriginalSeq {
+	if c.Transform == nil {
+		return seq
+	}
+	tseq := NewOriginalSeq(seq.Id, seq.Name, c.Transform(seq.Residues))
+	tseq.Offset = seq.Offset
+	return tseq
+}
+
+// compressWindow compresses every sequence in the current window in two
+// phases, and blocks until both are complete.
+//
+// In the first phase, a compression plan is computed for each sequence by
+// the workers in parallel. Nothing is added to the coarse database in this
+// phase, so every plan depends only on the state of the database before the
+// window started. In the second phase, the plans are committed in input
+// order: coarse sequences (and their seeds) are added, and links are created.
+//
+// The result is that identical inputs produce identical databases no matter
+// how many workers there are. The price is that sequences cannot match
+// regions added to the coarse database by other sequences in the same window
+// (or by an earlier part of themselves), so smaller windows compress better
+// but leave less work to do in parallel.
+func (c *Compressor) compressWindow() {
+	if len(c.window) == 0 {
+		return
+	}
+
+	// Duplicates don't need a plan. The sequence they duplicate is always
+	// committed before them.
+	planned := &sync.WaitGroup{}
+	plans := make([]compressPlan, len(c.window))
+	for i := range c.window {
+		job := c.window[i]
+		if job.seen {
+			continue
+		}
+		job.plan, job.planned = &plans[i], planned
+		planned.Add(1)
+		c.jobs <- job
+	}
+	planned.Wait()
+
+	for i, job := range c.window {
+		if job.seen {
+			c.DB.ComDB.Write(c.linkDuplicate(job))
+			continue
+		}
+		cseq := plans[i].commit(c.DB.CoarseDB, job.orgSeqId, job.orgSeq.Name)
+		if job.dup != nil {
+			c.DB.Duplicates.Compressed(job.dup, cseq.Links)
+		}
+		c.DB.ComDB.Write(cseq)
+	}
+	c.window = c.window[:0]
+}
+
+// worker is meant to be run as a goroutine. It allocates a goroutine-specific
+// memory arena (to prevent allocation in hot spots like alignment and
+// seed lookup), and sends the compressed sequences to the compressed
+// database for writing.
+func (c *Compressor) worker() {
+	mem := newMemory()
+	for job := range c.jobs {
+		if job.plan != nil {
+			c.compress(job.orgSeq, mem, job.plan)
+			job.planned.Done()
+			continue
+		}
+
+		if job.seen {
+			c.DB.ComDB.Write(c.linkDuplicate(job))
+			c.pending.Done()
+			continue
+		}
+
+		cseq := NewCompressedSeq(job.orgSeqId, job.orgSeq.Name)
+		c.compress(job.orgSeq, mem, directLinker{
+			coarsedb: c.DB.CoarseDB,
+			cseq:     &cseq,
+			orgSeqId: job.orgSeqId,
+		})
+		if job.dup != nil {
+			c.DB.Duplicates.Compressed(job.dup, cseq.Links)
+		}
+		c.DB.ComDB.Write(cseq)
+		c.pending.Done()
+	}
+	c.wg.Done()
+}
+
+// linkDuplicate creates a compressed sequence for a duplicate of a sequence
+// that has already been compressed (or is being compressed by another
+// worker), by linking it to the same coarse regions with the same diffs.
+func (c *Compressor) linkDuplicate(job compressJob) CompressedSeq {
+	coarsedb := c.DB.CoarseDB
+	cseq := NewCompressedSeq(job.orgSeqId, job.orgSeq.Name)
+	for _, link := range c.DB.Duplicates.Links(job.dup) {
+		cseq.Add(link)
+		coarsedb.CoarseSeqGet(link.CoarseSeqId).AddLink(
+			NewLinkToCompressed(
+				uint32(job.orgSeqId), link.CoarseStart, link.CoarseEnd))
+		coarsedb.Seeds.Hit(int(link.CoarseSeqId))
+	}
+	return cseq
+}
+
+// Sync blocks until every sequence given to Compress so far has been
+// compressed and sent to the compressed database. Note that in windowed
+// mode, this ends the current window early.
+func (c *Compressor) Sync() {
+	c.compressWindow()
+	c.pending.Wait()
+}
+
+// Done 'joins' the worker goroutines. (Blocks until all workers are finished
+// compressing sequences.) Calling Done more than once has no effect.
+func (c *Compressor) Done() {
+	if c.closed {
+		return
+	}
+	c.compressWindow()
+	c.closed = true
+	close(c.jobs)
+	c.wg.Wait()
+}
+
+// A linker is told about each piece of an original sequence found by
+// 'compress', in order. Each piece is either a match with a region of a
+// coarse sequence, or a region of the original sequence that could not be
+// matched (and must therefore be added to the coarse database).
+type linker interface {
+	match(corSeq *CoarseSeq, corSeqId, corStart, corEnd int,
+		alignment [2][]byte)
+	unmatched(orgSub *OriginalSeq)
+}
+
+// directLinker adds links and coarse sequences to the database as soon as
+// they are found.
+type directLinker struct {
+	coarsedb *CoarseDB
+	cseq     *CompressedSeq
+	orgSeqId int
+}
+
+func (lk directLinker) match(corSeq *CoarseSeq,
+	corSeqId, corStart, corEnd int, alignment [2][]byte) {
+
+	lk.cseq.Add(NewLinkToCoarse(
+		uint(corSeqId), uint(corStart), uint(corEnd), alignment))
+	corSeq.AddLink(NewLinkToCompressed(
+		uint32(lk.orgSeqId), uint16(corStart), uint16(corEnd)))
+}
+
+func (lk directLinker) unmatched(orgSub *OriginalSeq) {
+	addWithoutMatch(lk.cseq, lk.coarsedb, lk.orgSeqId, orgSub)
+}
+
+// compressPlan records the pieces of an original sequence found by
+// 'compress' without changing the database, so that they can be committed
+// later.
+type compressPlan struct {
+	steps []planStep
+}
+
+// planStep is either a link to a coarse sequence (when corSeq is not nil)
+// or an unmatched region of the original sequence.
+type planStep struct {
+	corSeq       *CoarseSeq
+	link         LinkToCoarse
+	unmatchedSub *OriginalSeq
+}
+
+func (plan *compressPlan) match(corSeq *CoarseSeq,
+	corSeqId, corStart, corEnd int, alignment [2][]byte) {
+
+	// The edit script is computed now, since the alignment lives in the
+	// worker's memory arena.
+	plan.steps = append(plan.steps, planStep{
+		corSeq: corSeq,
+		link: NewLinkToCoarse(
+			uint(corSeqId), uint(corStart), uint(corEnd), alignment),
+	})
+}
+
+func (plan *compressPlan) unmatched(orgSub *OriginalSeq) {
+	plan.steps = append(plan.steps, planStep{unmatchedSub: orgSub})
+}
+
+// commit applies the plan to the coarse database and returns the resulting
+// compressed sequence.
+func (plan *compressPlan) commit(coarsedb *CoarseDB,
+	orgSeqId int, name string) CompressedSeq {
+
+	cseq := NewCompressedSeq(orgSeqId, name)
+	for _, step := range plan.steps {
+		if step.corSeq == nil {
+			addWithoutMatch(&cseq, coarsedb, orgSeqId, step.unmatchedSub)
+			continue
+		}
+		cseq.Add(step.link)
+		step.corSeq.AddLink(NewLinkToCompressed(
+			uint32(orgSeqId), step.link.CoarseStart, step.link.CoarseEnd))
+	}
+	return cseq
+}
+
+// compress will convert an original sequence into a compressed sequence.
+// The process involves finding commonality in the original sequence with
+// other sequences in the coarse database, and linking those common
+// sub-sequences to sub-sequences in the coarse database. Each piece of the
+// compressed sequence is passed to 'lk' as soon as it is found.
+//
+// K-mers are taken every c.Alphabet.SeedStep residues, and K-mers with the
+// wildcard residue of the alphabet are skipped.
+//
+// N.B. `mem` is used in alignment and seed lookups to prevent allocation.
+// Think of them as goroutine-specific memory arenas.
+func (c *Compressor) compress(orgSeq *OriginalSeq, mem *memory, lk linker) {
+
+	// cseqExt and oseqExt will contain `extSeedSize` residues after the end
+	// of any particular seed in coarse and original sequences, respectively.
+	// If the residues are not equivalent, that particular seed is skipped.
+	var cseqExt, oseqExt []byte
+
+	// Convenient aliases.
+	db := c.DB
+	coarsedb := db.CoarseDB
+	mapSeedSize := db.MapSeedSize
+	extSeedSize := db.ExtSeedSize
+	extAccept, matchAccept := db.ExtAccept(), db.MatchAccept()
+	olen := orgSeq.Len()
+	step, wildcard := max(1, c.Alphabet.SeedStep), c.Alphabet.Wildcard
+
+	// Keep track of two pointers. 'current' refers to the residue index in the
+	// original sequence that extension is currently originating from.
+	// 'lastMatch' refers to the residue index of the *end* of the last match
+	// with a coarse sequence in the compressed database.
+	lastMatch, current := 0, 0
+
+	// Iterate through the original sequence a 'kmer' at a time.
+	limit := olen - mapSeedSize - extSeedSize
+	for current = 0; current+step <= limit; current += step {
+		kmer := orgSeq.Residues[current : current+mapSeedSize]
+		if wildcard != 0 && bytes.IndexByte(kmer, wildcard) > -1 {
+			continue
+		}
+		seeds := coarsedb.Seeds.Lookup(kmer, &mem.seeds)
+
+		// Before trying to extend this with seeds, check to see if there is
+		// a low complexity region within `db.MinMatchLen` residues from
+		// `current`. If there is, skip ahead to the end of it.
+		if db.LowComplexity > 0 {
+			skip := skipLowComplexity(
+				orgSeq.Residues[current:], db.MinMatchLen, db.LowComplexity)
+			if skip > 0 {
+				current += skip
+				continue
+			}
+		}
+
+		// Each seed location corresponding to the current K-mer must be
+		// used to attempt to extend a match.
+		for _, seedLoc := range seeds {
+			corSeqId := int(seedLoc[0])
+			corResInd := int(seedLoc[1])
+			corSeq := coarsedb.CoarseSeqGet(uint(corSeqId))
+
+			// If the seed extension extends beyond the end of the coarse
+			// sequence pointed to by seedLoc, then move along.
+			extCorStart := corResInd + mapSeedSize
+			extOrgStart := current + mapSeedSize
+			if extCorStart+extSeedSize >= corSeq.Len() {
+				continue
+			}
+
+			// If the seed extensions in each sequence are not equivalent,
+			// skip this seedLoc.
+			cseqExt = corSeq.Residues[extCorStart : extCorStart+extSeedSize]
+			oseqExt = orgSeq.Residues[extOrgStart : extOrgStart+extSeedSize]
+			if !bytes.Equal(cseqExt, oseqExt) {
+				continue
+			}
+
+			// The "match" between coarse and original sequence will
+			// occur somewhere between the the residue index of the seed and
+			// the end of the sequence for the coarse sequence, and the
+			// position of the "current" pointer and the end of the sequence
+			// for the original sequence.
+			corMatch, orgMatch := extendMatch(
+				corSeq.Residues[corResInd:], orgSeq.Residues[current:],
+				db.GappedWindowSize, db.UngappedWindowSize,
+				db.MatchKmerSize, extAccept,
+				mem)
+
+			// Now try to grow the match backwards from the seed, into the
+			// residues of the original sequence that haven't been matched
+			// yet.
+			corBack, orgBack := extendMatchBackward(
+				corSeq.Residues[:corResInd],
+				orgSeq.Residues[lastMatch:current],
+				db.GappedWindowSize, db.UngappedWindowSize,
+				db.MatchKmerSize, extAccept,
+				mem)
+			corStart := corResInd - corBack
+			orgMatchStart := current - orgBack
+			corMatch = corSeq.Residues[corStart : corResInd+len(corMatch)]
+			orgMatch = orgSeq.Residues[orgMatchStart : current+len(orgMatch)]
+
+			// If the part of the original sequence does not exceed the
+			// minimum match length, then we don't accept the match and move
+			// on to the next one.
+			if len(orgMatch) < db.MinMatchLen {
+				continue
+			}
+
+			alignment := NWAlign(corMatch, orgMatch, mem.align)
+			if !matchAccept(alignment[0], alignment[1]) {
+				continue
+			}
+
+			// The match is good, so it now starts wherever backward
+			// extension left off.
+			current = orgMatchStart
+			accCorStart, accCorMatch := corStart, corMatch
+			accOrgLen := len(orgMatch)
+
+			// If we end up extending a match because we're close to
+			// some boundary (either a sequence or a match boundary), then
+			// we need to perform another alignment.
+			changed := false
+
+			// If we're close to the end of the original sequence, extend
+			// the match to the end. The coarse side of the match grows by
+			// as many residues (if it can), so that the new residues are
+			// aligned against something.
+			if len(orgMatch)+db.MatchExtend >= orgSeq.Len()-int(current) {
+				grow := orgSeq.Len() - (current + len(orgMatch))
+				corEnd := min(corSeq.Len(), corStart+len(corMatch)+grow)
+				corMatch = corSeq.Residues[corStart:corEnd]
+				orgMatch = orgSeq.Residues[current:]
+				changed = true
+			}
+
+			// And if we're close to the end of the last match, extend this
+			// match backwards.
+			if current-lastMatch <= db.MatchExtend {
+				grow := current - lastMatch
+				corEnd := corStart + len(corMatch)
+				corStart = max(0, corStart-grow)
+				corMatch = corSeq.Residues[corStart:corEnd]
+				orgMatch = orgSeq.Residues[lastMatch : current+len(orgMatch)]
+				current = lastMatch
+				changed = true
+			}
+
+			// If we've extended our match, we need another alignment. If
+			// it isn't similar enough, then we settle for the match we
+			// already accepted (and align it again, since the alignment
+			// above reused its memory).
+			if changed {
+				alignment = NWAlign(corMatch, orgMatch, mem.align)
+				if !matchAccept(alignment[0], alignment[1]) {
+					corStart, corMatch = accCorStart, accCorMatch
+					current = orgMatchStart
+					orgMatch = orgSeq.Residues[current : current+accOrgLen]
+					alignment = NWAlign(corMatch, orgMatch, mem.align)
+				}
+			}
+
+			// Otherwise, we accept the first valid match and move on to the
+			// next kmer after the match ends.
+			corEnd := corStart + len(corMatch)
+			orgStart := current
+			orgEnd := orgStart + len(orgMatch)
+
+			// If there are residues between the end of the last match
+			// and the start of this match, then that means no good match
+			// could be found for those residues. Thus, they are added to
+			// the coarse database. (A pathological LinkToCoarse is
+			// created with an empty diff script that points to the added
+			// region in the coarse database in its entirety.)
+			if orgStart-lastMatch > 0 {
+				lk.unmatched(orgSeq.NewSubSequence(
+					uint(lastMatch), uint(current)))
+			}
+
+			// Keep track of which coarse sequences are useful, so that
+			// their seeds are kept when the seeds table is full.
+			coarsedb.Seeds.Hit(corSeqId)
+
+			// For the given match, add a LinkToCoarse to the portion of
+			// the coarse sequence matched. This serves as a component
+			// of a compressed original sequence. Also, add a
+			// LinkToCompressed to the coarse sequence matched. This
+			// serves as a bridge to expand coarse sequences into their
+			// original sequences.
+			lk.match(corSeq, corSeqId, corStart, corEnd, alignment)
+
+			// Skip the current pointer ahead to the end of this match.
+			// Update the lastMatch pointer to point at the end of this
+			// match.
+			lastMatch = orgEnd
+			current = orgEnd - 1
+
+			// Don't process any more seedLocs for this K-mer once we've
+			// found a match.
+			break
+		}
+	}
+
+	// If there are any leftover residues, then no good match for them
+	// could be found. Therefore, add them to the coarse database and
+	// create the appropriate links.
+	if orgSeq.Len()-lastMatch > 0 {
+		lk.unmatched(
+			orgSeq.NewSubSequence(uint(lastMatch), uint(orgSeq.Len())))
+	}
+}
+
+// addWithoutMatch adds a portion of an original sequence that could not be
+// matched to anything in the coarse database to the coarse database.
+// A LinkToCompressed is created and automatically added to the new coarse
+// sequence.
+//
+// An appropriate link is also added to the given compressed sequence.
+func addWithoutMatch(cseq *CompressedSeq,
+	coarsedb *CoarseDB, orgSeqId int, orgSub *OriginalSeq) {
+
+	// Explicitly copy residues to avoid pinning memory.
+	subCpy := make([]byte, len(orgSub.Residues))
+	copy(subCpy, orgSub.Residues)
+
+	corSeqId, corSeq := coarsedb.Add(subCpy)
+	corSeq.AddLink(
+		NewLinkToCompressed(uint32(orgSeqId), 0, uint16(len(subCpy))))
+
+	cseq.Add(
+		NewLinkToCoarseNoDiff(uint(corSeqId), 0, uint(len(subCpy))))
+}
@@ -0,0 +1,531 @@
+package mica
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestSkipLowComplexity(t *testing.T) {
+	type test struct {
+		seq                    string
+		windowSize, regionSize int
+		skipped                int
+		leftover               string
+	}
+	tests := []test{
+		{"ABCDDDDDDDDDDDDDDDDDDXYZ", 10, 5, 21, "XYZ"},
+		{"DDDDDDABCDEF", 10, 5, 6, "ABCDEF"},
+		{"DDDDDDABCDEFFFFFFFFFFXYZXYZ", 10, 5, 6, "ABCDEFFFFFFFFFFXYZXYZ"},
+		{"ABCDEFFFFFFFFFFFFFFFXYZXYZ", 10, 5, 20, "XYZXYZ"},
+	}
+	for _, test := range tests {
+		skipped := skipLowComplexity(
+			[]byte(test.seq), test.windowSize, test.regionSize)
+		if skipped != test.skipped {
+			t.Fatalf("Skipping low complexity regions in '%s' with a "+
+				"window size of %d and a region size of %d should have "+
+				"skipped %d residues, but it actually skipped %d residues.",
+				test.seq, test.windowSize, test.regionSize,
+				test.skipped, skipped)
+		}
+
+		leftover := test.seq[skipped:]
+		if leftover != test.leftover {
+			t.Fatalf("Skipping low complexity regions in '%s' with a "+
+				"window size of %d and a region size of %d skipped %d "+
+				"residues and returned '%s' as leftovers but should have "+
+				"returned '%s'.",
+				test.seq, test.windowSize, test.regionSize,
+				test.skipped, leftover, test.leftover)
+		}
+	}
+}
+
+func TestExtendMatch(t *testing.T) {
+	flagMatchKmerSize := 3
+	flagUngappedWindowSize := 10
+	flagExtSeqIdThreshold := 50
+	flagGappedWindowSize := 25
+
+	type test struct {
+		rseq, oseq   string
+		rmseq, omseq string
+	}
+	tests := []test{
+		{
+			"ABCDEFGHIKLMNPQR",
+			"ABCDEFGHIKLMNPQR",
+			"ABCDEFGHIKLMNPQR",
+			"ABCDEFGHIKLMNPQR",
+		},
+		{
+			"ABCDEFGHIKLMNPQRSTVW",
+			"ABCDEFGAAAHIKLMNPQRSTVW",
+			"ABCDEFGHIKLMNPQRSTVW",
+			"ABCDEFGAAAHIKLMNPQRSTVW",
+		},
+		{
+			"ABCDEFGHIKLMNPQRSTVW",
+			"ABCDEFGAAAHIKLMNPQRSTBBBBBBBBBBBBBBBBBBBVW",
+			"ABCDEF",
+			"ABCDEF",
+		},
+	}
+	sep := strings.Repeat("-", 45)
+	mem := newMemory()
+	for _, test := range tests {
+		corMatch, orgMatch := extendMatch(
+			[]byte(test.rseq), []byte(test.oseq),
+			flagGappedWindowSize, flagUngappedWindowSize,
+			flagMatchKmerSize, IdentityAtLeast(flagExtSeqIdThreshold),
+			mem)
+		scorMatch, sorgMatch := string(corMatch), string(orgMatch)
+
+		if scorMatch != test.rmseq || sorgMatch != test.omseq {
+			t.Fatalf(
+				`Extending a match for:
+%s
+%s
+%s
+%s
+resulted in
+%s
+%s
+%s
+%s
+but should have been
+%s
+%s
+%s
+%s`,
+				sep, test.rseq, test.oseq, sep,
+				sep, scorMatch, sorgMatch, sep,
+				sep, test.rmseq, test.omseq, sep)
+		}
+	}
+}
+
+func TestExtendMatchBackward(t *testing.T) {
+	flagMatchKmerSize := 3
+	flagUngappedWindowSize := 10
+	flagExtSeqIdThreshold := 50
+	flagGappedWindowSize := 25
+
+	// Each test is the residues before a seed in the coarse and original
+	// sequences, and the number of residues of each in the match.
+	type test struct {
+		rseq, oseq   string
+		rmlen, omlen int
+	}
+	tests := []test{
+		// A seed in the middle of both sequences.
+		{"WWWWWABCDEFGHIKLMNPQR", "YYABCDEFGHIKLMNPQR", 15, 15},
+
+		// A gap just before the seed.
+		{"ABCDEFGHIKLMNPQRSTVW", "ABCDEFGHIKLMNPQRSAAATVW", 20, 23},
+
+		// A seed at the start of either sequence.
+		{"", "ABCDEFGHIK", 0, 0},
+		{"ABCDEFGHIK", "", 0, 0},
+
+		// The residues before the seed aren't similar, so gapped extension
+		// is rejected right away.
+		{"ACDEFGHIKLMNPQR", "WWWWWWWWWWWWWWW", 0, 0},
+	}
+	mem := newMemory()
+	for _, test := range tests {
+		rmlen, omlen := extendMatchBackward(
+			[]byte(test.rseq), []byte(test.oseq),
+			flagGappedWindowSize, flagUngappedWindowSize,
+			flagMatchKmerSize, IdentityAtLeast(flagExtSeqIdThreshold),
+			mem)
+		if rmlen != test.rmlen || omlen != test.omlen {
+			t.Fatalf("Extending a match backward from the end of\n%s\n%s\n"+
+				"matched %d and %d residues, but should have matched %d "+
+				"and %d.", test.rseq, test.oseq, rmlen, omlen,
+				test.rmlen, test.omlen)
+		}
+	}
+}
+
+func TestReverseInto(t *testing.T) {
+	small := make([]byte, 0, 2)
+	if got := reverseInto(small, []byte("ABCDE")); string(got) != "EDCBA" {
+		t.Fatalf("Expected EDCBA, but got %s.", got)
+	}
+
+	// A destination that is big enough is reused.
+	big := make([]byte, 0, 10)
+	got := reverseInto(big, []byte("ABC"))
+	if string(got) != "CBA" || &got[0] != &big[:1][0] {
+		t.Fatalf("Expected CBA in the same memory, but got %s.", got)
+	}
+	if got := reverseInto(big, nil); len(got) != 0 {
+		t.Fatalf("Expected nothing, but got %s.", got)
+	}
+}
+
+// unmatchedCounter is a linker that counts the residues that could not be
+// matched.
+type unmatchedCounter struct {
+	directLinker
+	residues *int
+}
+
+func (lk unmatchedCounter) unmatched(orgSub *OriginalSeq) {
+	*lk.residues += orgSub.Len()
+	lk.directLinker.unmatched(orgSub)
+}
+
+func TestBackwardExtensionRatio(t *testing.T) {
+	// The copy has a substitution every 5 residues in its first 80
+	// residues, so none of its K-mers there is a seed, and it's farther
+	// than MatchExtend from the start. Only extending the first seed after
+	// it backward can match those residues.
+	rng := rand.New(rand.NewSource(1))
+	seq, _ := randomSeq(rng, "ACDEFGHIKLMNPQRSTVWY", 300, 20)
+	mut := make([]byte, len(seq))
+	copy(mut, seq)
+	for i := 2; i < 80; i += 5 {
+		mut[i] = "ACDEFGHIKLMNPQRSTVWY"[(i+int(mut[i]))%20]
+	}
+
+	c := newTestCompressor()
+	c.CompressSeq(0, NewOriginalSeq(0, "seq", seq))
+	unmatched := 0
+	cmut := NewCompressedSeq(1, "mut")
+	c.compress(NewOriginalSeq(1, "mut", mut), newMemory(), unmatchedCounter{
+		directLinker: directLinker{
+			coarsedb: c.DB.CoarseDB,
+			cseq:     &cmut,
+			orgSeqId: 1,
+		},
+		residues: &unmatched,
+	})
+	if got := decompressTest(t, c, cmut); !bytes.Equal(got, mut) {
+		t.Fatalf("Compressing\n%s\ndecompressed to\n%s", mut, got)
+	}
+	if unmatched > 0 || len(c.DB.CoarseDB.Seqs) != 1 {
+		t.Fatalf("Expected every residue of the copy to be matched, but "+
+			"%d residues were added to the coarse database.", unmatched)
+	}
+}
+
+func TestUngappedExtension(t *testing.T) {
+	flagMatchKmerSize := 3
+	flagUngappedWindowSize := 10
+	flagExtSeqIdThreshold := 50
+
+	type test struct {
+		rseq, oseq string
+		answer     int
+	}
+	tests := []test{
+		{"A", "A", 0},
+		{"AB", "AB", 0},
+		{"ABC", "ABC", 3},
+		{"ABCD", "ABCD", 3},
+		{"ABCYEFG", "ABCZEFG", 3},
+		{"ABCYEFGH", "ABCZEFGH", 8},
+		{"ABCDEFGHIJKLMNOP", "ABCDEFGHIJKLMNOP", 15},
+		{"ABCDEF", "ABC", 3},
+		{"ABC", "ABCDEF", 3},
+		{"ABCDEFGHIKLMNPQR", "ABCDEFGHIKLMNPQR", 15},
+	}
+
+	for _, test := range tests {
+		tval := alignUngapped(
+			[]byte(test.rseq), []byte(test.oseq),
+			flagUngappedWindowSize, flagMatchKmerSize,
+			IdentityAtLeast(flagExtSeqIdThreshold))
+		if tval != test.answer {
+			t.Fatalf("Ungapped extension on '%s' and '%s' should yield a "+
+				"length of %d, but 'alignUngapped' returned %d.",
+				test.rseq, test.oseq, test.answer, tval)
+		}
+	}
+}
+
+// newTestCompressor returns a Compressor for an in-memory database with the
+// default configuration.
+func newTestCompressor() *Compressor {
+	conf := DefaultDBConf.DeepCopy()
+	db := &DB{
+		DBConf:     conf,
+		Duplicates: NewDuplicates(),
+		CoarseDB: &CoarseDB{
+			Seqs:    make([]*CoarseSeq, 0, 10),
+			Seeds:   NewSeeds(conf.MapSeedSize, conf.SeedLowComplexity),
+			seqLock: &sync.RWMutex{},
+		},
+	}
+	return NewCompressor(db)
+}
+
+// decompressTest is CompressedSeq.Decompress, except that the coarse
+// sequences are read from memory.
+func decompressTest(t *testing.T, c *Compressor, cseq CompressedSeq) []byte {
+	residues := make([]byte, 0, 100)
+	for _, lk := range cseq.Links {
+		editScript, err := NewEditScriptParse(lk.Diff)
+		if err != nil {
+			t.Fatalf("Could not parse diff '%s': %s", lk.Diff, err)
+		}
+		corSeq := c.DB.CoarseDB.Seqs[lk.CoarseSeqId]
+		subCorres := corSeq.Residues[lk.CoarseStart:lk.CoarseEnd]
+		residues = append(residues, editScript.Apply(subCorres)...)
+	}
+	return residues
+}
+
+// randomSeq returns a random sequence of 'n' residues from 'alphabet', and a
+// copy of it with a substitution every 'every' residues.
+func randomSeq(
+	rng *rand.Rand, alphabet string, n, every int) (seq, mut []byte) {
+
+	seq, mut = make([]byte, n), make([]byte, n)
+	for i := range seq {
+		seq[i] = alphabet[rng.Intn(len(alphabet))]
+		mut[i] = seq[i]
+		if i%every == every-1 {
+			mut[i] = alphabet[(strings.IndexByte(alphabet, seq[i])+1)%
+				len(alphabet)]
+		}
+	}
+	return seq, mut
+}
+
+// compressPair compresses 'seq' and then 'mut' with 'c', checks that both
+// decompress to 'want' and 'wantMut', and that 'mut' was compressed against
+// the coarse sequence added for 'seq'.
+func compressPair(t *testing.T, c *Compressor,
+	seq, mut, want, wantMut []byte) {
+
+	cseq := c.CompressSeq(0, NewOriginalSeq(0, "seq", seq))
+	cmut := c.CompressSeq(1, NewOriginalSeq(1, "mut", mut))
+	if got := decompressTest(t, c, cseq); !bytes.Equal(got, want) {
+		t.Fatalf("Compressing\n%s\ndecompressed to\n%s", want, got)
+	}
+	if got := decompressTest(t, c, cmut); !bytes.Equal(got, wantMut) {
+		t.Fatalf("Compressing\n%s\ndecompressed to\n%s", wantMut, got)
+	}
+
+	if len(cseq.Links) != 1 || len(c.DB.CoarseDB.Seqs) != 1 {
+		t.Fatalf("The first sequence should be added to the coarse "+
+			"database as is, but it has %d links and there are %d coarse "+
+			"sequences.", len(cseq.Links), len(c.DB.CoarseDB.Seqs))
+	}
+	for _, lk := range cmut.Links {
+		if lk.CoarseSeqId != 0 {
+			t.Fatalf("The mutated sequence should only be linked to the "+
+				"first sequence, but it has a link to coarse sequence %d.",
+				lk.CoarseSeqId)
+		}
+	}
+}
+
+func TestCompressProtein(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seq, mut := randomSeq(rng, "ACDEFGHIKLMNPQRSTVWY", 300, 20)
+
+	c := newTestCompressor()
+	compressPair(t, c, seq, mut, seq, mut)
+}
+
+func TestCompressNucleotide(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seq, mut := randomSeq(rng, "ACGT", 600, 30)
+
+	// K-mers with a wildcard must never be used as seeds.
+	for i := 100; i < 110; i++ {
+		mut[i] = 'N'
+	}
+
+	c := newTestCompressor()
+	c.Alphabet = NucleotideAlphabet
+	compressPair(t, c, seq, mut, seq, mut)
+}
+
+func TestCompressTransform(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seq, mut := randomSeq(rng, "ACDEFGHIKLMNPQRSTVWY", 600, 30)
+
+	c := newTestCompressor()
+	c.Alphabet = NucleotideAlphabet
+	c.Transform = Reduce
+	compressPair(t, c, seq, mut, Reduce(seq), Reduce(mut))
+}
+
+func TestMemStorageDB(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	seq, mut := randomSeq(rng, "ACDEFGHIKLMNPQRSTVWY", 300, 20)
+	input := fmt.Sprintf(">seq\n%s\n>mut\n%s\n>dup\n%s\n", seq, mut, seq)
+	want := [][]byte{seq, mut, seq}
+
+	store := NewMemStorage()
+	db, err := NewWriteStorageDB(false, DefaultDBConf.DeepCopy(), nil,
+		store)
+	if err != nil {
+		t.Fatalf("Could not create database in memory: %s", err)
+	}
+	c := NewCompressor(db)
+	c.Dedup = true
+	c.Start()
+	next, err := c.CompressFasta(strings.NewReader(input), 0, nil)
+	if err != nil {
+		t.Fatalf("Could not compress sequences: %s", err)
+	}
+	if next != len(want) {
+		t.Fatalf("The next sequence id should be %d, but it is %d.",
+			len(want), next)
+	}
+	c.Done()
+	if err := db.Save(); err != nil {
+		t.Fatalf("Could not save database: %s", err)
+	}
+	db.WriteClose()
+
+	db, err = NewReadStorageDB(store)
+	if err != nil {
+		t.Fatalf("Could not open database in memory: %s", err)
+	}
+	defer db.ReadClose()
+	if db.ComDB.NumSequences() != len(want) {
+		t.Fatalf("Expected %d sequences, but there are %d.",
+			len(want), db.ComDB.NumSequences())
+	}
+	if db.BlastDBSize != uint64(len(seq)+len(mut)+len(seq)) {
+		t.Fatalf("Expected a BLAST database size of %d, but got %d.",
+			len(seq)+len(mut)+len(seq), db.BlastDBSize)
+	}
+	if db.CoarseDB.NumSequences() != 1 {
+		t.Fatalf("Expected 1 coarse sequence, but there are %d.",
+			db.CoarseDB.NumSequences())
+	}
+	for i := range want {
+		oseq, err := db.ComDB.SeqGet(db.CoarseDB, i)
+		if err != nil {
+			t.Fatalf("Could not decompress sequence %d: %s", i, err)
+		}
+		if !bytes.Equal(oseq.Residues, want[i]) {
+			t.Fatalf("Sequence %d decompressed to\n%s\nbut should be\n%s",
+				i, oseq.Residues, want[i])
+		}
+	}
+}
+
+func TestAppendFlagMerge(t *testing.T) {
+	store := NewMemStorage()
+	conf := DefaultDBConf.DeepCopy()
+	conf.ReadOnly = false
+	db, err := NewWriteStorageDB(false, conf, nil, store)
+	if err != nil {
+		t.Fatalf("Could not create database in memory: %s", err)
+	}
+	c := NewCompressor(db)
+	c.Start()
+	input := ">seq\nMKVLAAGIVALLLAAGCSSSKEETPAAQEASATEASATTTTPAAE\n"
+	if _, err := c.CompressFasta(strings.NewReader(input), 0, nil); err != nil {
+		t.Fatalf("Could not compress sequences: %s", err)
+	}
+	c.Done()
+	if err := db.Save(); err != nil {
+		t.Fatalf("Could not save database: %s", err)
+	}
+	db.WriteClose()
+
+	// Options that can't change are refused when they are set explicitly.
+	guards := []struct {
+		flag   string
+		change func(*DBConf)
+	}{
+		{"map-seed-size", func(conf *DBConf) { conf.MapSeedSize = 5 }},
+		{"read-only", func(conf *DBConf) { conf.ReadOnly = true }},
+	}
+	for _, guard := range guards {
+		conf := DefaultDBConf.DeepCopy()
+		guard.change(conf)
+		set := map[string]bool{guard.flag: true}
+		if db, err := NewWriteStorageDB(true, conf, set, store); err == nil {
+			db.WriteClose()
+			t.Fatalf("Expected an error changing '%s'.", guard.flag)
+		} else if !strings.Contains(err.Error(), "cannot be changed") {
+			t.Fatalf("Expected an error changing '%s', but got: %s",
+				guard.flag, err)
+		}
+	}
+
+	// Options set explicitly override the database's, while the others
+	// (like the different map seed size and read-only setting here) are
+	// read from it.
+	conf = DefaultDBConf.DeepCopy()
+	conf.MinMatchLen = 55
+	conf.MapSeedSize = 5
+	set := map[string]bool{"min-match-len": true}
+	db, err = NewWriteStorageDB(true, conf, set, store)
+	if err != nil {
+		t.Fatalf("Could not open database for appending: %s", err)
+	}
+	defer db.WriteClose()
+	if db.MinMatchLen != 55 {
+		t.Fatalf("Expected the min match length to be overridden with 55, "+
+			"but it is %d.", db.MinMatchLen)
+	}
+	if db.MapSeedSize != DefaultDBConf.MapSeedSize || db.ReadOnly {
+		t.Fatalf("Expected the map seed size (%d) and read-only setting "+
+			"(false) of the database, but got %d and %t.",
+			DefaultDBConf.MapSeedSize, db.MapSeedSize, db.ReadOnly)
+	}
+}
+
+func TestDeterministicWorkers(t *testing.T) {
+	compress := func(workers int) MemStorage {
+		conf := DefaultDBConf.DeepCopy()
+		conf.ReadOnly = false
+		store := NewMemStorage()
+		db, err := NewWriteStorageDB(false, conf, nil, store)
+		if err != nil {
+			t.Fatalf("Could not create database in memory: %s", err)
+		}
+		f, err := os.Open("data/small.fasta")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		c := NewCompressor(db)
+		c.Workers = workers
+		c.WindowSize = 50
+		c.Dedup = true
+		c.Start()
+		if _, err := c.CompressFasta(f, 0, nil); err != nil {
+			t.Fatalf("Could not compress sequences: %s", err)
+		}
+		c.Done()
+		if err := db.Save(); err != nil {
+			t.Fatalf("Could not save database: %s", err)
+		}
+		db.WriteClose()
+		return store
+	}
+
+	one, four := compress(1), compress(4)
+	if len(one.files) != len(four.files) {
+		t.Fatalf("Expected %d files, but got %d.",
+			len(one.files), len(four.files))
+	}
+	for name, data := range one.files {
+		other, ok := four.files[name]
+		if !ok {
+			t.Fatalf("%s is missing with 4 workers.", name)
+		}
+		if !bytes.Equal(data.bytes, other.bytes) {
+			t.Fatalf("%s differs with 1 and 4 workers.", name)
+		}
+	}
+}
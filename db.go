@@ -6,13 +6,15 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"sync"
 )
 
 const (
-	FileParams      = "params"
-	FileBlastCoarse = "blastdb-coarse"
-	FileDmndCoarse  = "blastdb-dmnd"
-	FileBlastFine   = "blastdb-fine"
+	FileParams       = "params"
+	FileBlastCoarse  = "blastdb-coarse"
+	FileDmndCoarse   = "blastdb-dmnd"
+	FileMMseqsCoarse = "mmseqsdb-coarse"
+	FileBlastFine    = "blastdb-fine"
 )
 
 // A DB represents a mica database, which has three main components:
@@ -36,12 +38,25 @@ type DB struct {
 	// The coarse database component.
 	CoarseDB *CoarseDB
 
+	// The files of the database. (A DirStorage of Path for databases on
+	// disk.)
+	Storage Storage
+
+	// The distinct sequences compressed so far, which are used to find exact
+	// duplicates during compression. (Only when writing.)
+	Duplicates *Duplicates
+
 	// Whether the database is being appended to. This can only occur if the
 	// database was *initially* created without the read-only flag set.
 	appending bool
 
 	// File pointers.
-	coarseFasta, coarseSeeds, coarseLinks, compressed, index, params *os.File
+	coarseFasta, coarseSeeds, coarseLinks, compressed, index, params File
+
+	// Serialize expansion (which seeks in the database's files) and the
+	// building of missing search indexes, so that a database opened for
+	// reading can be searched by more than one Searcher at a time.
+	expandLock, indexLock sync.Mutex
 }
 
 // NewWriteDB creates a new mica database, and prepares it for writing (or
@@ -55,9 +70,21 @@ type DB struct {
 //
 // 'conf' should be a database configuration, typically defined (initially) from
 // command line parameters. Note that if 'appnd' is set, then the configuration
-// will be read from disk---only options explicitly set via the command line
-// will be overwritten.
-func NewWriteDB(appnd bool, conf *DBConf, dir string) (*DB, error) {
+// will be read from disk---only the options named in 'set' (the flags set
+// explicitly on the command line) will be overwritten. (See
+// DBConf.FlagMerge.)
+func NewWriteDB(
+	appnd bool, conf *DBConf, set map[string]bool, dir string) (*DB, error) {
+
+	return newWriteDB(appnd, nil, conf, set, dir)
+}
+
+// newWriteDB is NewWriteDB, except that when 'cp' is not nil, the database
+// is opened to resume compression from the checkpoint 'cp'. (See
+// NewResumeDB.)
+func newWriteDB(appnd bool, cp *Checkpoint, conf *DBConf,
+	set map[string]bool, dir string) (*DB, error) {
+
 	Vprintf("Opening database in %s...\n", dir)
 
 	if strings.HasSuffix(dir, ".tar") || strings.HasSuffix(dir, ".gz") {
@@ -93,18 +120,63 @@ func NewWriteDB(appnd bool, conf *DBConf, dir string) (*DB, error) {
 	}
 
 	db := &DB{
-		DBConf:    conf,
-		Name:      path.Base(dir),
-		Path:      dir,
-		params:    nil,
-		appending: appnd,
+		DBConf:     conf,
+		Name:       path.Base(dir),
+		Path:       dir,
+		Storage:    DirStorage(dir),
+		Duplicates: NewDuplicates(),
+		params:     nil,
+		appending:  appnd,
 	}
+	if err := db.openWrite(appnd, cp, set); err != nil {
+		return nil, err
+	}
+
+	Vprintf("Done opening database in %s.\n", dir)
+	return db, nil
+}
+
+// NewWriteStorageDB is NewWriteDB, except that the files of the database are
+// kept in 'store' instead of a directory on disk. If 'appnd' is set, 'store'
+// must already hold a database, whose configuration is merged with 'conf'
+// like NewWriteDB does.
+//
+// A database in a MemStorage can be used to compress sequences without
+// touching the disk (e.g., in tests). No BLAST or DIAMOND databases are
+// created when it is saved.
+func NewWriteStorageDB(appnd bool, conf *DBConf, set map[string]bool,
+	store Storage) (*DB, error) {
+
+	db := &DB{
+		DBConf:     conf,
+		Name:       storageName(store),
+		Path:       storagePath(store),
+		Storage:    store,
+		Duplicates: NewDuplicates(),
+		params:     nil,
+		appending:  appnd,
+	}
+	if err := db.openWrite(appnd, nil, set); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// openWrite opens each component of 'db' for writing (or appending when
+// 'appnd' is set). When 'cp' is not nil, compression is resumed from it.
+// When appending, the options named in 'set' are kept from db.DBConf.
+func (db *DB) openWrite(
+	appnd bool, cp *Checkpoint, set map[string]bool) error {
+
+	var err error
 
 	// Do a sanity check and make sure we can access the `makeblastdb`
 	// executable. Otherwise we might do a lot of work for nothing...
-	if err = execExists(db.BlastMakeBlastDB); err != nil {
-		return nil, fmt.Errorf(
-			"Could not find 'makeblastdb' executable: %s", err)
+	if db.onDisk() {
+		if err = execExists(db.BlastMakeBlastDB); err != nil {
+			return fmt.Errorf(
+				"Could not find 'makeblastdb' executable: %s", err)
+		}
 	}
 
 	// Now try to load the configuration parameters from the 'params' file.
@@ -114,70 +186,83 @@ func NewWriteDB(appnd bool, conf *DBConf, dir string) (*DB, error) {
 	// does not exist yet.
 	db.params, err = db.openWriteFile(appnd, FileParams)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if appnd {
 		// If we're appending, we need some way of merging the configuration
-		// on disk and the configuration supplied by the user. The hack here is
-		// to inspect the command line arguments, and override the existing
-		// configuration only with options explicitly set on the command line.
+		// on disk and the configuration supplied by the user. The existing
+		// configuration is only overridden with the options explicitly set
+		// on the command line.
 		paramConf, err := LoadDBConf(db.params)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		db.DBConf, err = db.DBConf.FlagMerge(paramConf)
+		db.DBConf, err = db.DBConf.FlagMerge(paramConf, set)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		// If it's a read only database, we can't append!
-		if db.ReadOnly {
-			return nil, fmt.Errorf("Appending to a read-only database is " +
+		// If it's a read only database, we can't append! (Unless we're
+		// resuming, in which case the database isn't finished yet.)
+		if db.ReadOnly && cp == nil {
+			return fmt.Errorf("Appending to a read-only database is " +
 				"not possible.")
 		}
 	}
 
 	db.ComDB, err = newWriteCompressedDB(appnd, db)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	db.CoarseDB, err = newWriteCoarseDB(appnd, db)
+	db.CoarseDB, err = newWriteCoarseDB(appnd, cp, db)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	Vprintf("Done opening database in %s.\n", dir)
-	return db, nil
+	if cp != nil {
+		db.appending = cp.appending
+	}
+	return nil
 }
 
 func (db *DB) filePath(name string) string {
 	return path.Join(db.Path, name)
 }
 
-func (db *DB) openWriteFile(appnd bool, name string) (*os.File, error) {
-	var f *os.File
-	var err error
+// onDisk returns true if the files of the database are in a directory, and
+// BLAST and DIAMOND databases should be made for it.
+func (db *DB) onDisk() bool {
+	_, ok := db.Storage.(DirStorage)
+	return ok
+}
+
+// storageName and storagePath return the name and the path of a database
+// kept in 'store'. A database that isn't on disk has no path.
+func storageName(store Storage) string {
+	if p := storagePath(store); len(p) > 0 {
+		return path.Base(p)
+	}
+	return "memory"
+}
 
+func storagePath(store Storage) string {
+	switch store := store.(type) {
+	case DirStorage:
+		return string(store)
+	case queryStorage:
+		return string(store.DirStorage)
+	}
+	return ""
+}
+
+func (db *DB) openWriteFile(appnd bool, name string) (File, error) {
 	if appnd {
-		f, err = os.OpenFile(
-			path.Join(db.Path, name), os.O_RDWR, 0666)
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		f, err = os.Create(path.Join(db.Path, name))
-		if err != nil {
-			return nil, err
-		}
+		return db.Storage.OpenFile(name, os.O_RDWR)
 	}
-	return f, nil
+	return storageCreate(db.Storage, name)
 }
 
 // NewReadDB opens a mica database for reading. An error is returned if
 // there is a problem accessing any of the files on disk.
-//
-// Also, if the 'makeblastdb' or 'blastp' executales are not found, then an
-// error is returned.
 func NewReadDB(dir string) (*DB, error) {
 	Vprintf("Opening database in %s...\n", dir)
 
@@ -197,49 +282,66 @@ func NewReadDB(dir string) (*DB, error) {
 	db := &DB{
 		Name:        path.Base(dir),
 		Path:        dir,
+		Storage:     DirStorage(dir),
 		coarseSeeds: nil,
 		params:      nil,
 		appending:   false,
 	}
+	if err := db.openRead(); err != nil {
+		return nil, err
+	}
+
+	Vprintf("Done opening database in %s.\n", dir)
+	return db, nil
+}
+
+// NewReadStorageDB is NewReadDB, except that the files of the database are
+// read from 'store' instead of a directory on disk.
+func NewReadStorageDB(store Storage) (*DB, error) {
+	db := &DB{
+		Name:        storageName(store),
+		Path:        storagePath(store),
+		Storage:     store,
+		coarseSeeds: nil,
+		params:      nil,
+		appending:   false,
+	}
+	if err := db.openRead(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// openRead opens each component of 'db' for reading.
+func (db *DB) openRead() error {
+	var err error
 
 	db.params, err = db.openReadFile(FileParams)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Now try to load the configuration parameters from the 'params' file.
 	db.DBConf, err = LoadDBConf(db.params)
 	if err != nil {
-		return nil, err
-	}
-
-	// Do a sanity check and make sure we can access the `makeblastdb`
-	// and `blastp` executables. Otherwise we might do a lot of work for
-	// nothing...
-	if err = execExists(db.BlastMakeBlastDB); err != nil {
-		return nil, fmt.Errorf(
-			"Could not find 'makeblastdb' executable: %s", err)
+		return err
 	}
 
+	// N.B. No external programs are needed to read a database. (Searching
+	// may not need any either, with a NativeAligner.)
 	db.ComDB, err = newReadCompressedDB(db)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	db.CoarseDB, err = newReadCoarseDB(db)
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	Vprintf("Done opening database in %s.\n", dir)
-	return db, nil
+	return nil
 }
 
-func (db *DB) openReadFile(name string) (*os.File, error) {
-	f, err := os.Open(path.Join(db.Path, name))
-	if err != nil {
-		return nil, err
-	}
-	return f, nil
+func (db *DB) openReadFile(name string) (File, error) {
+	return storageOpen(db.Storage, name)
 }
 
 // Save will write the contents of the database to disk. This should be called
@@ -248,6 +350,8 @@ func (db *DB) openReadFile(name string) (*os.File, error) {
 // After the database is saved, a blastp database is created from the coarse
 // database.
 //
+// If the database isn't on disk, no blastp or diamond database is created.
+//
 // N.B. The compressed database is written as each sequence is processed, so
 // this call will only save the coarse database. This may take a *very* long
 // time if the database is not read only (since the seeds table has to be
@@ -257,15 +361,7 @@ func (db *DB) Save() error {
 
 	// Only write the params file when the database is first created.
 	if !db.appending {
-		// Make sure the params file is truncated so that we overwrite any
-		// previous configuration.
-		if err = db.params.Truncate(0); err != nil {
-			return err
-		}
-		if _, err = db.params.Seek(0, os.SEEK_SET); err != nil {
-			return err
-		}
-		if err = db.DBConf.Write(db.params); err != nil {
+		if err = db.saveParams(); err != nil {
 			return err
 		}
 	}
@@ -277,6 +373,11 @@ func (db *DB) Save() error {
 		return err
 	}
 
+	// BLAST and DIAMOND can only search databases on disk.
+	if !db.onDisk() {
+		return nil
+	}
+
 	// Now we need to construct a blastp database from the coarse fasta file.
 	// e.g., `makeblastdb -dbtype prot -in coarse.fasta`
 	blastdbCmd := exec.Command(
@@ -308,6 +409,20 @@ func (db *DB) Save() error {
 	return nil
 }
 
+// saveParams writes the database configuration to the params file,
+// overwriting any previous configuration.
+func (db *DB) saveParams() (err error) {
+	// Make sure the params file is truncated so that we overwrite any
+	// previous configuration.
+	if err = db.params.Truncate(0); err != nil {
+		return
+	}
+	if _, err = db.params.Seek(0, os.SEEK_SET); err != nil {
+		return
+	}
+	return db.DBConf.Write(db.params)
+}
+
 // ReadClose closes all appropriate files after reading from a database.
 func (db *DB) ReadClose() {
 	db.params.Close()
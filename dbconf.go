@@ -2,7 +2,6 @@ package mica
 
 import (
 	"encoding/csv"
-	"flag"
 	"fmt"
 	"io"
 	"strconv"
@@ -16,6 +15,9 @@ type DBConf struct {
 	UngappedWindowSize  int
 	ExtSeqIdThreshold   int
 	MatchSeqIdThreshold int
+	MatchCriterion      string
+	ExtBitsThreshold    float64
+	MatchBitsThreshold  float64
 	MatchExtend         int
 	MapSeedSize         int
 	ExtSeedSize         int
@@ -36,6 +38,9 @@ var(
 		UngappedWindowSize:  10,
 		ExtSeqIdThreshold:   60,
 		MatchSeqIdThreshold: 70,
+		MatchCriterion:      CriterionIdentity,
+		ExtBitsThreshold:    1.0,
+		MatchBitsThreshold:  1.2,
 		MatchExtend:         30,
 		MapSeedSize:         6,
 		ExtSeedSize:         0,
@@ -56,6 +61,9 @@ var(
 		UngappedWindowSize:  10,
 		ExtSeqIdThreshold:   60,
 		MatchSeqIdThreshold: 70,
+		MatchCriterion:      CriterionIdentity,
+		ExtBitsThreshold:    1.0,
+		MatchBitsThreshold:  1.2,
 		MatchExtend:         30,
 		MapSeedSize:         6,
 		ExtSeedSize:         0,
@@ -83,6 +91,9 @@ func (conf *DBConf) DeepCopy() *DBConf {
 		UngappedWindowSize:  conf.UngappedWindowSize,
 		ExtSeqIdThreshold:   conf.ExtSeqIdThreshold,
 		MatchSeqIdThreshold: conf.MatchSeqIdThreshold,
+		MatchCriterion:      conf.MatchCriterion,
+		ExtBitsThreshold:    conf.ExtBitsThreshold,
+		MatchBitsThreshold:  conf.MatchBitsThreshold,
 		MatchExtend:         conf.MatchExtend,
 		MapSeedSize:         conf.MapSeedSize,
 		ExtSeedSize:         conf.ExtSeedSize,
@@ -104,7 +115,7 @@ func LoadDBConf(r io.Reader) (conf *DBConf, err error) {
 			err = perr.(error)
 		}
 	}()
-	conf = DefaultDBConf
+	conf = DefaultDBConf.DeepCopy()
 	csvReader := csv.NewReader(r)
 	csvReader.Comma = ':'
 	csvReader.Comment = '#'
@@ -134,6 +145,15 @@ func LoadDBConf(r io.Reader) (conf *DBConf, err error) {
 			}
 			return uint64(ui64)
 		}
+		atof := func() float64 {
+			var f64 float64
+			var err error
+			f64, err = strconv.ParseFloat(strings.TrimSpace(line[1]), 64)
+			if err != nil {
+				panic(err)
+			}
+			return f64
+		}
 		switch line[0] {
 		case "MinMatchLen":
 			conf.MinMatchLen = atoi()
@@ -147,6 +167,15 @@ func LoadDBConf(r io.Reader) (conf *DBConf, err error) {
 			conf.ExtSeqIdThreshold = atoi()
 		case "MatchSeqIdThreshold":
 			conf.MatchSeqIdThreshold = atoi()
+		case "MatchCriterion":
+			conf.MatchCriterion = strings.TrimSpace(line[1])
+			if err := ValidCriterion(conf.MatchCriterion); err != nil {
+				return nil, err
+			}
+		case "ExtBitsThreshold":
+			conf.ExtBitsThreshold = atof()
+		case "MatchBitsThreshold":
+			conf.MatchBitsThreshold = atof()
 		case "MatchExtend":
 			conf.MatchExtend = atoi()
 		case "MapSeedSize":
@@ -189,15 +218,18 @@ func LoadDBConf(r io.Reader) (conf *DBConf, err error) {
 	return conf, nil
 }
 
-func (flagConf *DBConf) FlagMerge(fileConf *DBConf) (*DBConf, error) {
-	only := make(map[string]bool, 0)
-	flag.Visit(func(f *flag.Flag) { only[f.Name] = true })
+// FlagMerge merges the configuration of an existing database 'fileConf' into
+// 'flagConf', which comes from the command line: only the options named in
+// 'only' (the flags set explicitly, e.g., by flag.FlagSet.Visit) are kept
+// from 'flagConf'. The map seed size and read-only setting can't be changed.
+func (flagConf *DBConf) FlagMerge(
+	fileConf *DBConf, only map[string]bool) (*DBConf, error) {
 
-	if only["map-seed-size"] {
+	if only["map-seed-size"] && flagConf.MapSeedSize != fileConf.MapSeedSize {
 		return flagConf, fmt.Errorf("The map seed size cannot be changed for " +
 			"an existing database.")
 	}
-	if only["read-only"] {
+	if only["read-only"] && flagConf.ReadOnly != fileConf.ReadOnly {
 		return flagConf, fmt.Errorf("The read-only setting cannot be changed " +
 			"for an existing database.")
 	}
@@ -220,9 +252,21 @@ func (flagConf *DBConf) FlagMerge(fileConf *DBConf) (*DBConf, error) {
 	if !only["match-seq-id-threshold"] {
 		flagConf.MatchSeqIdThreshold = fileConf.MatchSeqIdThreshold
 	}
+	if !only["match-criterion"] {
+		flagConf.MatchCriterion = fileConf.MatchCriterion
+	}
+	if !only["ext-bits-threshold"] {
+		flagConf.ExtBitsThreshold = fileConf.ExtBitsThreshold
+	}
+	if !only["match-bits-threshold"] {
+		flagConf.MatchBitsThreshold = fileConf.MatchBitsThreshold
+	}
 	if !only["match-extend"] {
 		flagConf.MatchExtend = fileConf.MatchExtend
 	}
+	if !only["map-seed-size"] {
+		flagConf.MapSeedSize = fileConf.MapSeedSize
+	}
 	if !only["ext-seed-size"] {
 		flagConf.ExtSeedSize = fileConf.ExtSeedSize
 	}
@@ -235,7 +279,7 @@ func (flagConf *DBConf) FlagMerge(fileConf *DBConf) (*DBConf, error) {
 	if !only["plain"] {
 		flagConf.SavePlain = fileConf.SavePlain
 	}
-	if !only["compressed"] {
+	if !only["compress-source"] {
 		flagConf.SaveCompressed = fileConf.SaveCompressed
 	}
 	if !only["read-only"] {
@@ -264,6 +308,9 @@ func (dbConf DBConf) Write(w io.Writer) error {
 	su := func(i uint64) string {
 		return fmt.Sprintf("%d", i)
 	}
+	sf := func(f float64) string {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
 	bs := func(b bool) string {
 		if b {
 			return "1"
@@ -277,6 +324,9 @@ func (dbConf DBConf) Write(w io.Writer) error {
 		{"UngappedWindowSize", s(dbConf.UngappedWindowSize)},
 		{"ExtSeqIdThreshold", s(dbConf.ExtSeqIdThreshold)},
 		{"MatchSeqIdThreshold", s(dbConf.MatchSeqIdThreshold)},
+		{"MatchCriterion", dbConf.MatchCriterion},
+		{"ExtBitsThreshold", sf(dbConf.ExtBitsThreshold)},
+		{"MatchBitsThreshold", sf(dbConf.MatchBitsThreshold)},
 		{"MatchExtend", s(dbConf.MatchExtend)},
 		{"MapSeedSize", s(dbConf.MapSeedSize)},
 		{"ExtSeedSize", s(dbConf.ExtSeedSize)},
@@ -0,0 +1,193 @@
+package mica
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Duplicates remembers how every distinct original sequence was compressed,
+// so that sequences with exactly the same residues can be linked to the same
+// regions of the coarse database without being compressed again.
+//
+// Sequences are identified by the MD5 digest of their residues. The chance
+// of two different sequences having the same digest is negligible, even for
+// billions of sequences.
+//
+// Since the links of every distinct sequence are kept in memory (and saved
+// with every checkpoint), the table only remembers the first MaxSeqs
+// sequences.
+type Duplicates struct {
+	// The greatest number of distinct sequences remembered, or 0 for no
+	// limit.
+	MaxSeqs int
+
+	lock *sync.Mutex
+	seqs map[[md5.Size]byte]*Duplicate
+
+	// Signalled whenever a sequence has been compressed.
+	compressed *sync.Cond
+
+	// The number of sequences found to be duplicates.
+	found int64
+}
+
+// A Duplicate is the first sequence seen with some particular residues.
+type Duplicate struct {
+	// The links of the compressed sequence. Only valid once 'done' is set.
+	links []LinkToCoarse
+	done  bool
+}
+
+// DefaultMaxDuplicates is the default MaxSeqs of a Duplicates.
+const DefaultMaxDuplicates = 1000000
+
+// NewDuplicates creates an empty table of sequences.
+func NewDuplicates() *Duplicates {
+	lock := &sync.Mutex{}
+	return &Duplicates{
+		MaxSeqs:    DefaultMaxDuplicates,
+		lock:       lock,
+		seqs:       make(map[[md5.Size]byte]*Duplicate, 10000),
+		compressed: sync.NewCond(lock),
+	}
+}
+
+// Add looks up an original sequence by its residues. If a sequence with the
+// same residues has been added before, its Duplicate is returned along with
+// true. Otherwise, a new Duplicate is returned (along with false), and
+// Compressed must be called with it once the sequence has been compressed.
+// Once the table is full, nil is returned for new sequences instead.
+func (dups *Duplicates) Add(residues []byte) (*Duplicate, bool) {
+	digest := md5.Sum(residues)
+
+	dups.lock.Lock()
+	defer dups.lock.Unlock()
+
+	if dup, ok := dups.seqs[digest]; ok {
+		atomic.AddInt64(&dups.found, 1)
+		return dup, true
+	}
+	if dups.MaxSeqs > 0 && len(dups.seqs) >= dups.MaxSeqs {
+		return nil, false
+	}
+	dup := &Duplicate{}
+	dups.seqs[digest] = dup
+	return dup, false
+}
+
+// Found returns the number of sequences passed to Add that were duplicates.
+func (dups *Duplicates) Found() int64 {
+	return atomic.LoadInt64(&dups.found)
+}
+
+// Compressed records the links of the compressed sequence that 'dup' stands
+// for.
+func (dups *Duplicates) Compressed(dup *Duplicate, links []LinkToCoarse) {
+	dups.lock.Lock()
+	dup.links, dup.done = links, true
+	dups.compressed.Broadcast()
+	dups.lock.Unlock()
+}
+
+// Links returns the links of the compressed sequence that 'dup' stands for.
+// If that sequence hasn't been compressed yet, Links blocks until it has.
+func (dups *Duplicates) Links(dup *Duplicate) []LinkToCoarse {
+	dups.lock.Lock()
+	for !dup.done {
+		dups.compressed.Wait()
+	}
+	dups.lock.Unlock()
+	return dup.links
+}
+
+// write writes every sequence (that has been compressed) to 'w'.
+func (dups *Duplicates) write(w io.Writer) error {
+	dups.lock.Lock()
+	defer dups.lock.Unlock()
+
+	bw := func(data interface{}) error {
+		return binary.Write(w, binary.BigEndian, data)
+	}
+	numDone := int64(0)
+	for _, dup := range dups.seqs {
+		if dup.done {
+			numDone++
+		}
+	}
+	if err := bw([]int64{dups.found, numDone}); err != nil {
+		return err
+	}
+	for digest, dup := range dups.seqs {
+		if !dup.done {
+			continue
+		}
+		if err := bw(digest); err != nil {
+			return err
+		}
+		if err := bw(uint32(len(dup.links))); err != nil {
+			return err
+		}
+		for _, link := range dup.links {
+			err := bw([]uint32{
+				uint32(link.CoarseSeqId), uint32(link.CoarseStart),
+				uint32(link.CoarseEnd), uint32(len(link.Diff)),
+			})
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, link.Diff); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// read adds every sequence written by 'write' to the table.
+func (dups *Duplicates) read(r io.Reader) error {
+	dups.lock.Lock()
+	defer dups.lock.Unlock()
+
+	br := func(data interface{}) error {
+		return binary.Read(r, binary.BigEndian, data)
+	}
+	header := make([]int64, 2)
+	if err := br(header); err != nil {
+		return fmt.Errorf("Could not read duplicates: %s", err)
+	}
+	dups.found = header[0]
+
+	var digest [md5.Size]byte
+	var numLinks uint32
+	fields := make([]uint32, 4)
+	for i := int64(0); i < header[1]; i++ {
+		if err := br(&digest); err != nil {
+			return fmt.Errorf("Could not read duplicate: %s", err)
+		}
+		if err := br(&numLinks); err != nil {
+			return fmt.Errorf("Could not read duplicate: %s", err)
+		}
+		links := make([]LinkToCoarse, numLinks)
+		for j := range links {
+			if err := br(fields); err != nil {
+				return fmt.Errorf("Could not read duplicate link: %s", err)
+			}
+			diff := make([]byte, fields[3])
+			if _, err := io.ReadFull(r, diff); err != nil {
+				return fmt.Errorf("Could not read duplicate link: %s", err)
+			}
+			links[j] = LinkToCoarse{
+				Diff:        string(diff),
+				CoarseSeqId: uint(fields[0]),
+				CoarseStart: uint16(fields[1]),
+				CoarseEnd:   uint16(fields[2]),
+			}
+		}
+		dups.seqs[digest] = &Duplicate{links: links, done: true}
+	}
+	return nil
+}
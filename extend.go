@@ -0,0 +1,243 @@
+package mica
+
+const (
+	memSeqSize = 10000
+	numSeeds   = 100
+)
+
+// memory is a goroutine-specific memory arena, specifically used in each
+// compression worker goroutine. Its purpose is to reduce the amount of
+// memory allocation in hot-spots: sequence alignment and seed lookup.
+type memory struct {
+	align *AlignMemory
+	seeds [][2]uint
+
+	// Reversed sequences used in backward extension.
+	revCor, revOrg []byte
+}
+
+func newMemory() *memory {
+	return &memory{
+		align: NewAlignMemory(),
+		seeds: make([][2]uint, 0, numSeeds),
+
+		revCor: make([]byte, 0, memSeqSize),
+		revOrg: make([]byte, 0, memSeqSize),
+	}
+}
+
+// extendMatch uses a combination of ungapped and gapped extension to find
+// quality candidates for compression.
+func extendMatch(corRes, orgRes []byte,
+	gappedWindowSize, ungappedWindowSize, kmerSize int, accept AcceptFunc,
+	mem *memory) (corMatchRes, orgMatchRes []byte) {
+
+	// Starting at seedLoc.resInd and current (from 'compress'), corMatchLen
+	// and orgMatchLen correspond to the length of the match in each of
+	// the coarse and the original sequence, respectively.
+	// At the end of the loop, the slices [seedLoc.resInd:corMatchLen]
+	// and [current:orgMatchLen] will correspond to the match. (Again, this
+	// is in the context of the inner loop in 'compress'. For this particular
+	// function, corMatchLen and orgMatch start at 0, so that the matches
+	// eventually returned correspond to the [:corMatchLen] and [:orgMatchLen]
+	// slices.)
+	corMatchLen, orgMatchLen := 0, 0
+	for {
+		// If the match has consumed either of the coarse or original
+		// sequence, then we must quit with what we have.
+		if corMatchLen == len(corRes) || orgMatchLen == len(orgRes) {
+			break
+		}
+
+		// Ungapped extension returns an integer corresponding to the
+		// number of residues that the match was extended by.
+		matchLen := alignUngapped(
+			corRes[corMatchLen:], orgRes[orgMatchLen:],
+			ungappedWindowSize, kmerSize, accept)
+
+		// Since ungapped extension increases the coarse and
+		// original sequence match portions equivalently, add the
+		// match length to both.
+		corMatchLen += matchLen
+		orgMatchLen += matchLen
+
+		// Gapped extension returns an alignment corresponding to the
+		// window starting after the previous ungapped extension
+		// ended plus the gapped window size. (It is bounded by the
+		// length of each sequence.)
+		alignment := NWAlign(
+			corRes[corMatchLen:min(len(corRes), corMatchLen+gappedWindowSize)],
+			orgRes[orgMatchLen:min(len(orgRes), orgMatchLen+gappedWindowSize)],
+			mem.align)
+
+		// If the alignment isn't similar enough, then gapped
+		// extension has failed. We therefore quit and are forced to
+		// be satisfied with whatever corMatchLen and orgMatchLen are
+		// set to.
+		if !accept(alignment[0], alignment[1]) {
+			break
+		}
+
+		// We live to die another day.
+		// We need to add to the corMatch{Pos,Len} and orgMatch{Pos,Len}
+		// just like we did for ungapped extension. However, an
+		// alignment can correspond to two different sized subsequences
+		// of the coarse and original sequence. Therefore, only
+		// increase each by the corresponding sizes from the
+		// alignment.
+		corMatchLen += alignLen(alignment[0])
+		orgMatchLen += alignLen(alignment[1])
+	}
+
+	return corRes[:corMatchLen], orgRes[:orgMatchLen]
+}
+
+// extendMatchBackward is just like extendMatch, except it extends a match
+// backwards from the *end* of corRes and orgRes. The number of residues in the
+// match from each of corRes and orgRes is returned.
+//
+// This is done by reversing both sequences and extending them forward.
+func extendMatchBackward(corRes, orgRes []byte,
+	gappedWindowSize, ungappedWindowSize, kmerSize int, accept AcceptFunc,
+	mem *memory) (corMatchLen, orgMatchLen int) {
+
+	if len(corRes) == 0 || len(orgRes) == 0 {
+		return 0, 0
+	}
+	mem.revCor = reverseInto(mem.revCor, corRes)
+	mem.revOrg = reverseInto(mem.revOrg, orgRes)
+	corMatch, orgMatch := extendMatch(mem.revCor, mem.revOrg,
+		gappedWindowSize, ungappedWindowSize, kmerSize, accept, mem)
+	return len(corMatch), len(orgMatch)
+}
+
+// reverseInto writes the reverse of 'src' to 'dst', growing it if
+// necessary, and returns the result.
+func reverseInto(dst, src []byte) []byte {
+	if cap(dst) < len(src) {
+		dst = make([]byte, len(src))
+	}
+	dst = dst[:len(src)]
+	for i, j := 0, len(src)-1; j >= 0; i, j = i+1, j-1 {
+		dst[i] = src[j]
+	}
+	return dst
+}
+
+// skipLowComplexity looks for a low complexity region starting at the
+// beginning of `seq` and up to `windowSize`. If one is found, `x` is returned
+// where `x` corresponds to the position of the first residue after
+// the low complexity region has ended. If a low complexity region isn't
+// found, `0` is returned.
+//
+// N.B. regionSize is the number of contiguous positions in the sequence
+// that must contain the same residue in order to qualify as a low complexity
+// region.
+func skipLowComplexity(seq []byte, windowSize, regionSize int) int {
+	upto := min(len(seq), windowSize+regionSize)
+	last, repeats, i, found := byte(0), 1, 0, false
+	for i = 0; i < upto; i++ {
+		if seq[i] == last {
+			repeats++
+			if repeats >= regionSize {
+				found = true
+				break
+			}
+			continue
+		}
+
+		// The last residue isn't the same as this residue, so reset.
+		last = seq[i]
+		repeats = 1
+	}
+	if !found { // no low complexity region was found.
+		return 0
+	}
+
+	// We're in a low complexity region. Consume as many residues equal
+	// to `last` as possible.
+	//
+	// N.B. `i` is already set to where we left off in the last loop.
+	for ; i < len(seq); i++ {
+		if seq[i] != last { // end of low complexity region
+			break
+		}
+	}
+	return i
+}
+
+// alignLen computes the length of a sequence in an alignment.
+// (i.e., the number of residues that aren't "-".)
+func alignLen(seq []byte) (length int) {
+	for _, res := range seq {
+		if res != '-' {
+			length++
+		}
+	}
+	return
+}
+
+// alignUngapped takes a coarse and an original sub-sequence and returns a
+// length corresponding to the number of amino acids scanned by greedily
+// consuming successive K-mer matches in N-mer windows.
+//
+// The algorithm works by attempting to find *exact* K-mer matches between the
+// sequences in N-mer windows. If N residues are scanned and no K-mer match
+// is found, the the current value of length is returned (which may be 0).
+// If a K-mer match is found, the current value of length is set to the total
+// number of amino acid residues scanned, and a search for the next K-mer match
+// for the next N-mer window is started.
+func alignUngapped(rseq []byte, oseq []byte,
+	windowSize, kmerSize int, accept AcceptFunc) int {
+
+	length, scanned, successive := 0, 0, 0
+	tryNextWindow := true
+	for tryNextWindow {
+		tryNextWindow = false
+		for i := 0; i < windowSize; i++ {
+			// If we've scanned all residues in one of the sub-sequences, then
+			// there is nothing left to do for ungapped extension. Therefore,
+			// quit and return the number of residues scanned up until the
+			// *last* match.
+			if scanned >= len(rseq) || scanned >= len(oseq) {
+				break
+			}
+
+			if rseq[scanned] == oseq[scanned] {
+				successive++
+			} else {
+				successive = 0
+			}
+
+			scanned++
+			if successive == kmerSize {
+				// Get the residues between matches: i.e., after the last
+				// match to the start of this match. But only if there is at
+				// least one residue in that range.
+				if (scanned-kmerSize)-length > 0 {
+					ok := accept(
+						rseq[length:scanned-kmerSize],
+						oseq[length:scanned-kmerSize])
+
+					// If the residues aren't similar enough, then this
+					// K-mer match is no good. But keep trying until the window
+					// is closed. (We "keep trying" by decrementing successive
+					// matches by 1.)
+					if !ok {
+						successive--
+						continue
+					}
+				}
+
+				// If we're here, then we've found a valid match. Update the
+				// length to indicate the number of residues scanned and make
+				// sure we try the next Ungapped window.
+				length = scanned
+				successive = 0
+				tryNextWindow = true
+				break
+			}
+		}
+	}
+	return length
+}
@@ -1,12 +1,8 @@
 package mica
 
 import (
-	"compress/gzip"
 	"io"
 	"os"
-	"strings"
-
-	"github.com/TuftsBCB/io/fasta"
 )
 
 // ReadOriginalSeq is the value sent over `chan ReadOriginalSeq` when a new
@@ -16,34 +12,52 @@ type ReadOriginalSeq struct {
 	Err error
 }
 
-// ReadOriginalSeqs reads a FASTA formatted file and returns a channel that
-// each new sequence is sent to.
+// ReadOriginalSeqs reads a file of sequences and returns a channel that
+// each new sequence is sent to. If the file name is "-", the sequences are
+// read from stdin. (See ReadOriginalSeqsFrom.)
 func ReadOriginalSeqs(
 	fileName string,
 	ignore []byte,
 ) (chan ReadOriginalSeq, error) {
-	var f io.Reader
-	var err error
+	if fileName == "-" {
+		return ReadOriginalSeqsFrom(os.Stdin, ignore)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ReadOriginalSeqsFrom(f, ignore)
+}
 
-	f, err = os.Open(fileName)
+// ReadOriginalSeqsFrom is ReadOriginalSeqs, except that the sequences are
+// read from 'r'. Residues in 'ignore' are replaced with 'X'.
+//
+// The input may be FASTA, FASTQ, a UniProt flat file or a GenBank flat file
+// (see DetectFormat), and may be compressed with gzip, bzip2 or zstd.
+func ReadOriginalSeqsFrom(
+	r io.Reader,
+	ignore []byte,
+) (chan ReadOriginalSeq, error) {
+	br, done, err := decompressInput(r)
 	if err != nil {
 		return nil, err
 	}
-	if strings.HasSuffix(fileName, ".gz") {
-		f, err = gzip.NewReader(f)
-		if err != nil {
-			return nil, err
-		}
+	reader, err := newSequenceReader(br)
+	if err != nil {
+		done()
+		return nil, err
 	}
-
-	reader := fasta.NewReader(f)
 	seqChan := make(chan ReadOriginalSeq, 200)
 	go func() {
 		for i := 0; true; i++ {
 			sequence, err := reader.Read()
 			if err == io.EOF {
-				close(seqChan)
-				break
+				// Decompression may still fail after the last sequence.
+				err = done()
+				if err == nil {
+					close(seqChan)
+					break
+				}
 			}
 			if err != nil {
 				seqChan <- ReadOriginalSeq{
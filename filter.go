@@ -0,0 +1,279 @@
+package mica
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// A SeqFilter removes sequences from a stream of original sequences, or
+// changes them. (See SeqFilters.)
+type SeqFilter interface {
+	// Name describes the filter in a FilterReport.
+	Name() string
+
+	// Keep returns false if 'seq' should be removed. It may change 'seq'.
+	Keep(seq *OriginalSeq) (bool, error)
+}
+
+// MinLength removes sequences with fewer than 'n' residues.
+func MinLength(n int) SeqFilter {
+	return lengthFilter{min: n, max: -1}
+}
+
+// MaxLength removes sequences with more than 'n' residues.
+func MaxLength(n int) SeqFilter {
+	return lengthFilter{min: 0, max: n}
+}
+
+type lengthFilter struct {
+	min, max int
+}
+
+func (f lengthFilter) Name() string {
+	if f.max < 0 {
+		return fmt.Sprintf("min-length %d", f.min)
+	}
+	return fmt.Sprintf("max-length %d", f.max)
+}
+
+func (f lengthFilter) Keep(seq *OriginalSeq) (bool, error) {
+	if seq.Len() < f.min {
+		return false, nil
+	}
+	return f.max < 0 || seq.Len() <= f.max, nil
+}
+
+// AmbiguousResidues are the residues counted by MaxAmbiguous: an unknown
+// amino acid, or one of two.
+const AmbiguousResidues = "XBZJ"
+
+// MaxAmbiguous removes sequences where more than the fraction 'frac' of the
+// residues are in AmbiguousResidues.
+func MaxAmbiguous(frac float64) SeqFilter {
+	return ambiguousFilter(frac)
+}
+
+type ambiguousFilter float64
+
+func (f ambiguousFilter) Name() string {
+	return fmt.Sprintf("max-ambiguous %g", float64(f))
+}
+
+func (f ambiguousFilter) Keep(seq *OriginalSeq) (bool, error) {
+	if seq.Len() == 0 {
+		return true, nil
+	}
+	ambiguous := 0
+	for _, residue := range seq.Residues {
+		if strings.IndexByte(AmbiguousResidues, residue) > -1 {
+			ambiguous++
+		}
+	}
+	return float64(ambiguous)/float64(seq.Len()) <= float64(f), nil
+}
+
+// HeaderInclude removes sequences whose header doesn't match 're'.
+func HeaderInclude(re *regexp.Regexp) SeqFilter {
+	return headerFilter{re: re, include: true}
+}
+
+// HeaderExclude removes sequences whose header matches 're'.
+func HeaderExclude(re *regexp.Regexp) SeqFilter {
+	return headerFilter{re: re, include: false}
+}
+
+type headerFilter struct {
+	re      *regexp.Regexp
+	include bool
+}
+
+func (f headerFilter) Name() string {
+	if f.include {
+		return fmt.Sprintf("header-include '%s'", f.re)
+	}
+	return fmt.Sprintf("header-exclude '%s'", f.re)
+}
+
+func (f headerFilter) Keep(seq *OriginalSeq) (bool, error) {
+	return f.re.MatchString(seq.Name) == f.include, nil
+}
+
+// HeaderFields are the fields that can be used in the template given to
+// HeaderTemplate.
+type HeaderFields struct {
+	// The original header, its first word and the rest of it.
+	Name, Accession, Description string
+
+	// The number of the sequence in its input, starting at 0.
+	Id int
+
+	// The number of residues in the sequence.
+	Length int
+}
+
+// HeaderTemplate rewrites the header of every sequence with the template
+// 'tpl' (see text/template), which is executed with the HeaderFields of the
+// sequence. For example, '{{.Accession}} len={{.Length}}'. No sequences are
+// removed.
+func HeaderTemplate(tpl string) (SeqFilter, error) {
+	t, err := template.New("header").Parse(tpl)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse header template: %s", err)
+	}
+	return headerTemplate{tpl: tpl, t: t}, nil
+}
+
+type headerTemplate struct {
+	tpl string
+	t   *template.Template
+}
+
+func (f headerTemplate) Name() string {
+	return fmt.Sprintf("header-template '%s'", f.tpl)
+}
+
+func (f headerTemplate) Keep(seq *OriginalSeq) (bool, error) {
+	fields := HeaderFields{
+		Name:   seq.Name,
+		Id:     seq.Id,
+		Length: seq.Len(),
+	}
+	words := strings.SplitN(strings.TrimSpace(seq.Name), " ", 2)
+	fields.Accession = words[0]
+	if len(words) > 1 {
+		fields.Description = strings.TrimSpace(words[1])
+	}
+
+	buf := new(bytes.Buffer)
+	if err := f.t.Execute(buf, fields); err != nil {
+		return false, fmt.Errorf("Could not rewrite header '%s': %s",
+			seq.Name, err)
+	}
+	seq.Name = strings.Replace(buf.String(), "\n", " ", -1)
+	return true, nil
+}
+
+// SeqFilters applies a list of filters, in order, to streams of original
+// sequences, and counts how many sequences each filter removed. A sequence
+// removed by one filter isn't given to the filters after it.
+//
+// The same SeqFilters may be used for several streams at once, and its
+// report covers all of them.
+type SeqFilters struct {
+	filters []SeqFilter
+
+	lock          *sync.Mutex
+	read, removed int
+	removedBy     []int
+}
+
+// NewSeqFilters returns a SeqFilters that applies 'filters' in order.
+func NewSeqFilters(filters ...SeqFilter) *SeqFilters {
+	return &SeqFilters{
+		filters:   filters,
+		lock:      &sync.Mutex{},
+		removedBy: make([]int, len(filters)),
+	}
+}
+
+// Len returns the number of filters.
+func (fs *SeqFilters) Len() int {
+	return len(fs.filters)
+}
+
+// Filter returns a channel of the sequences sent on 'seqChan' that were
+// kept by every filter. Sequences keep their ids. Errors are passed on, and
+// an error returned by a filter ends the stream.
+func (fs *SeqFilters) Filter(
+	seqChan chan ReadOriginalSeq) chan ReadOriginalSeq {
+
+	filtered := make(chan ReadOriginalSeq, 200)
+	go func() {
+		defer close(filtered)
+		for readSeq := range seqChan {
+			if readSeq.Err != nil {
+				filtered <- readSeq
+				continue
+			}
+			keep, err := fs.keep(readSeq.Seq)
+			if err != nil {
+				filtered <- ReadOriginalSeq{Err: err}
+				for range seqChan {
+				}
+				return
+			}
+			if keep {
+				filtered <- readSeq
+			}
+		}
+	}()
+	return filtered
+}
+
+// keep applies every filter to 'seq', and records which one removed it.
+func (fs *SeqFilters) keep(seq *OriginalSeq) (bool, error) {
+	for i, f := range fs.filters {
+		keep, err := f.Keep(seq)
+		if err != nil {
+			return false, err
+		}
+		if !keep {
+			fs.lock.Lock()
+			fs.read++
+			fs.removed++
+			fs.removedBy[i]++
+			fs.lock.Unlock()
+			return false, nil
+		}
+	}
+	fs.lock.Lock()
+	fs.read++
+	fs.lock.Unlock()
+	return true, nil
+}
+
+// Report returns the number of sequences removed by each filter so far.
+func (fs *SeqFilters) Report() FilterReport {
+	fs.lock.Lock()
+	defer fs.lock.Unlock()
+
+	report := FilterReport{
+		Read:    fs.read,
+		Removed: fs.removed,
+		Filters: make([]FilterCount, len(fs.filters)),
+	}
+	for i, f := range fs.filters {
+		report.Filters[i] = FilterCount{f.Name(), fs.removedBy[i]}
+	}
+	return report
+}
+
+// FilterReport describes the sequences removed by a SeqFilters.
+type FilterReport struct {
+	// The number of sequences read, and the number of them removed.
+	Read, Removed int
+
+	// The number of sequences removed by each filter.
+	Filters []FilterCount
+}
+
+// FilterCount is the number of sequences removed by one filter.
+type FilterCount struct {
+	Name    string
+	Removed int
+}
+
+func (report FilterReport) String() string {
+	lines := make([]string, 0, len(report.Filters)+1)
+	lines = append(lines, fmt.Sprintf("Filters removed %d of %d sequences.",
+		report.Removed, report.Read))
+	for _, count := range report.Filters {
+		lines = append(lines, fmt.Sprintf("\t%s: %d removed",
+			count.Name, count.Removed))
+	}
+	return strings.Join(lines, "\n")
+}
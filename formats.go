@@ -0,0 +1,334 @@
+package mica
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/TuftsBCB/io/fasta"
+	"github.com/TuftsBCB/seq"
+)
+
+// Formats of sequence input. (See DetectFormat.)
+const (
+	FormatFasta   = "fasta"
+	FormatFastq   = "fastq"
+	FormatUniProt = "uniprot"
+	FormatGenBank = "genbank"
+)
+
+// ZstdExec is the location of the 'zstd' executable, which is used to
+// decompress zstd compressed input.
+var ZstdExec = "zstd"
+
+// The magic numbers of compressed input.
+var (
+	magicGzip  = []byte{0x1f, 0x8b}
+	magicBzip2 = []byte("BZh")
+	magicZstd  = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// A sequenceReader reads one sequence at a time from some input, and
+// returns io.EOF after the last one.
+type sequenceReader interface {
+	Read() (seq.Sequence, error)
+}
+
+// decompressInput returns a reader of the contents of 'r', which are
+// decompressed if they start with the magic number of gzip, bzip2 or zstd.
+//
+// The function returned must be called once everything has been read. It
+// returns any error that occurred while decompressing (zstd input is
+// decompressed by another process).
+func decompressInput(r io.Reader) (*bufio.Reader, func() error, error) {
+	done := func() error { return nil }
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, magicGzip):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not read gzip input: %s", err)
+		}
+		return bufio.NewReader(gr), done, nil
+	case bytes.HasPrefix(magic, magicBzip2):
+		return bufio.NewReader(bzip2.NewReader(br)), done, nil
+	case bytes.HasPrefix(magic, magicZstd):
+		cmd := exec.Command(ZstdExec, "-dc")
+		cmd.Stdin = br
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not run zstd: %s", err)
+		}
+		if err := cmd.Start(); err != nil {
+			return nil, nil, fmt.Errorf("Could not run zstd: %s", err)
+		}
+		done = func() error {
+			if err := cmd.Wait(); err != nil {
+				return fmt.Errorf("Could not decompress zstd input: %s", err)
+			}
+			return nil
+		}
+		return bufio.NewReader(stdout), done, nil
+	}
+	return br, done, nil
+}
+
+// DetectFormat returns the format of the (uncompressed) sequence input in
+// 'r', by looking at the first few bytes after any leading whitespace:
+//
+//	'>'      FormatFasta
+//	'@'      FormatFastq
+//	'ID   '  FormatUniProt (a UniProt flat file, e.g., uniprot_sprot.dat)
+//	'LOCUS'  FormatGenBank (a GenBank or GenPept flat file)
+//
+// Empty input is FormatFasta. Nothing is consumed from 'r'.
+func DetectFormat(r *bufio.Reader) (string, error) {
+	for n := 64; ; n *= 2 {
+		bs, err := r.Peek(n)
+		start := bytes.TrimLeft(bs, " \t\r\n")
+		if len(start) >= 5 || (err != nil && len(start) > 0) {
+			switch {
+			case start[0] == '>':
+				return FormatFasta, nil
+			case start[0] == '@':
+				return FormatFastq, nil
+			case bytes.HasPrefix(start, []byte("ID   ")):
+				return FormatUniProt, nil
+			case bytes.HasPrefix(start, []byte("LOCUS")):
+				return FormatGenBank, nil
+			}
+			return "", fmt.Errorf("Could not detect the format of input "+
+				"starting with '%s'.", firstLine(start))
+		}
+		if err != nil {
+			return FormatFasta, nil
+		}
+	}
+}
+
+func firstLine(bs []byte) []byte {
+	if i := bytes.IndexByte(bs, '\n'); i > -1 {
+		return bytes.TrimSpace(bs[:i])
+	}
+	return bytes.TrimSpace(bs)
+}
+
+// newSequenceReader detects the format of 'r' and returns a reader for it.
+func newSequenceReader(r *bufio.Reader) (sequenceReader, error) {
+	format, err := DetectFormat(r)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FormatFastq:
+		return &fastqReader{r: r}, nil
+	case FormatUniProt:
+		return &uniprotReader{r: r}, nil
+	case FormatGenBank:
+		return &genbankReader{r: r}, nil
+	}
+	return fasta.NewReader(r), nil
+}
+
+// readLine returns the next line of 'r' without its line ending. io.EOF is
+// only returned when there are no more lines.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		err = nil
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// fastqReader reads FASTQ records. The qualities are discarded.
+type fastqReader struct {
+	r       *bufio.Reader
+	records int
+}
+
+func (fr *fastqReader) Read() (seq.Sequence, error) {
+	var header string
+	var err error
+	for len(header) == 0 {
+		if header, err = readLine(fr.r); err != nil {
+			return seq.Sequence{}, err
+		}
+		header = strings.TrimSpace(header)
+	}
+	fr.records++
+	if header[0] != '@' {
+		return seq.Sequence{}, fmt.Errorf("FASTQ record %d does not start "+
+			"with '@': %s", fr.records, header)
+	}
+
+	// The sequence may be wrapped over several lines, up to the '+' line.
+	// Then there are as many quality scores as residues.
+	residues := new(bytes.Buffer)
+	for {
+		line, err := readLine(fr.r)
+		if err != nil {
+			return seq.Sequence{}, fmt.Errorf("FASTQ record %d (%s) is "+
+				"incomplete.", fr.records, header[1:])
+		}
+		if strings.HasPrefix(line, "+") {
+			break
+		}
+		residues.WriteString(strings.TrimSpace(line))
+	}
+	for quals := 0; quals < residues.Len(); {
+		line, err := readLine(fr.r)
+		if err != nil {
+			return seq.Sequence{}, fmt.Errorf("FASTQ record %d (%s) has "+
+				"fewer quality scores than residues.", fr.records, header[1:])
+		}
+		quals += len(strings.TrimSpace(line))
+	}
+	return seq.NewSequenceString(
+		header[1:], strings.ToUpper(residues.String())), nil
+}
+
+// uniprotReader reads the records of a UniProt flat file. The name of each
+// sequence is its primary accession followed by its description (the first
+// full name in its 'DE' lines).
+type uniprotReader struct {
+	r       *bufio.Reader
+	records int
+}
+
+func (ur *uniprotReader) Read() (seq.Sequence, error) {
+	var accession, description string
+	var inSequence, started bool
+	residues := new(bytes.Buffer)
+	for {
+		line, err := readLine(ur.r)
+		if err == io.EOF {
+			if started {
+				return seq.Sequence{}, fmt.Errorf("UniProt record %d does "+
+					"not end with '//'.", ur.records+1)
+			}
+			return seq.Sequence{}, io.EOF
+		}
+		if err != nil {
+			return seq.Sequence{}, err
+		}
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		started = true
+
+		code, content := line, ""
+		if len(line) > 5 {
+			code, content = line[:2], strings.TrimSpace(line[5:])
+		}
+		switch {
+		case strings.HasPrefix(code, "//"):
+			ur.records++
+			if len(accession) == 0 {
+				return seq.Sequence{}, fmt.Errorf("UniProt record %d has "+
+					"no accession.", ur.records)
+			}
+			return seq.NewSequenceString(
+				joinHeader(accession, description), residues.String()), nil
+		case inSequence:
+			residues.WriteString(strings.Replace(line, " ", "", -1))
+		case code == "AC" && len(accession) == 0:
+			accession = strings.TrimSpace(strings.Split(content, ";")[0])
+		case code == "DE" && len(description) == 0:
+			if i := strings.Index(content, "Full="); i > -1 {
+				description = content[i+len("Full="):]
+				if j := strings.Index(description, "{"); j > -1 {
+					description = description[:j]
+				}
+				description = strings.TrimSpace(
+					strings.TrimRight(description, "; "))
+			}
+		case code == "SQ":
+			inSequence = true
+		}
+	}
+}
+
+// genbankReader reads the records of a GenBank (or GenPept) flat file. The
+// name of each sequence is its accession and version followed by its
+// definition.
+type genbankReader struct {
+	r       *bufio.Reader
+	records int
+}
+
+func (gr *genbankReader) Read() (seq.Sequence, error) {
+	var accession, version, keyword string
+	var inSequence, started bool
+	definition := make([]string, 0, 2)
+	residues := new(bytes.Buffer)
+	for {
+		line, err := readLine(gr.r)
+		if err == io.EOF {
+			if started {
+				return seq.Sequence{}, fmt.Errorf("GenBank record %d does "+
+					"not end with '//'.", gr.records+1)
+			}
+			return seq.Sequence{}, io.EOF
+		}
+		if err != nil {
+			return seq.Sequence{}, err
+		}
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		started = true
+
+		// Keywords start at the beginning of a line. Continuation lines
+		// start with spaces.
+		content := strings.TrimSpace(line)
+		if line[0] != ' ' {
+			fields := strings.Fields(line)
+			keyword = fields[0]
+			content = strings.TrimSpace(line[len(keyword):])
+		}
+		switch {
+		case strings.HasPrefix(line, "//"):
+			gr.records++
+			if len(version) > 0 {
+				accession = version
+			}
+			if len(accession) == 0 {
+				return seq.Sequence{}, fmt.Errorf("GenBank record %d has "+
+					"no accession.", gr.records)
+			}
+			return seq.NewSequenceString(
+				joinHeader(accession, strings.Join(definition, " ")),
+				strings.ToUpper(residues.String())), nil
+		case inSequence:
+			// Sequence lines start with the position of their first residue.
+			for _, field := range strings.Fields(line)[1:] {
+				residues.WriteString(field)
+			}
+		case keyword == "DEFINITION":
+			definition = append(definition, content)
+		case keyword == "ACCESSION" && len(accession) == 0:
+			if fields := strings.Fields(content); len(fields) > 0 {
+				accession = fields[0]
+			}
+		case keyword == "VERSION" && len(version) == 0:
+			if fields := strings.Fields(content); len(fields) > 0 {
+				version = fields[0]
+			}
+		case keyword == "ORIGIN":
+			inSequence = true
+		}
+	}
+}
+
+func joinHeader(accession, description string) string {
+	if len(description) == 0 {
+		return accession
+	}
+	return accession + " " + description
+}
@@ -0,0 +1,190 @@
+package mica
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// The formats that a HitWriter can write hits in.
+const (
+	// BLAST tabular format, i.e., BLAST's `-outfmt 6`.
+	HitFormatTabular = "tab"
+
+	// JSON Lines: one JSON object for each hit, with the fields of Hit.
+	HitFormatJSON = "jsonl"
+
+	// BLAST XML, i.e., BLAST's `-outfmt 5`. Only the elements that describe
+	// hits are written (e.g., without the aligned sequences). Like BLAST does
+	// for the ordinal of a subject in its database, the id of each hit is
+	// its OriginalId, e.g., "gnl|BL_ORD_ID|42".
+	HitFormatXML = "xml"
+)
+
+// HitFormats are all of the formats that a HitWriter can write hits in.
+var HitFormats = []string{HitFormatTabular, HitFormatJSON, HitFormatXML}
+
+// ValidHitFormat returns an error if 'format' is not one of HitFormats.
+func ValidHitFormat(format string) error {
+	for _, f := range HitFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown hit format '%s'. Valid formats are %s.",
+		format, strings.Join(HitFormats, ", "))
+}
+
+// A HitWriter writes hits in one of HitFormats. The hits of each query must
+// be written one after the other (as every aligner reports them). Close must
+// be called once every hit is written, but it does not close the underlying
+// writer.
+type HitWriter interface {
+	Write(hit Hit) error
+	Close() error
+}
+
+// NewHitWriter returns a HitWriter that writes hits in 'format' (one of
+// HitFormats) to 'w'.
+func NewHitWriter(w io.Writer, format string) (HitWriter, error) {
+	bw := bufio.NewWriter(w)
+	switch format {
+	case HitFormatTabular:
+		return &tabularHitWriter{bw}, nil
+	case HitFormatJSON:
+		return &jsonHitWriter{bw, json.NewEncoder(bw)}, nil
+	case HitFormatXML:
+		return newXMLHitWriter(bw)
+	}
+	return nil, ValidHitFormat(format)
+}
+
+type tabularHitWriter struct {
+	w *bufio.Writer
+}
+
+func (hw *tabularHitWriter) Write(hit Hit) error {
+	return WriteBlastTabular(hw.w, []Hit{hit})
+}
+
+func (hw *tabularHitWriter) Close() error {
+	return hw.w.Flush()
+}
+
+type jsonHitWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (hw *jsonHitWriter) Write(hit Hit) error {
+	if err := hw.enc.Encode(hit); err != nil {
+		return fmt.Errorf("Could not write JSON output: %s", err)
+	}
+	return nil
+}
+
+func (hw *jsonHitWriter) Close() error {
+	return hw.w.Flush()
+}
+
+// xmlHitWriter writes one Iteration element for each query, once all of its
+// hits have been written.
+type xmlHitWriter struct {
+	w    *bufio.Writer
+	enc  *xml.Encoder
+	iter xmlIteration
+}
+
+const xmlHeader = `<?xml version="1.0"?>
+<!DOCTYPE BlastOutput PUBLIC "-//NCBI//NCBI BlastOutput/EN" ` +
+	`"http://www.ncbi.nlm.nih.gov/dtd/NCBI_BlastOutput.dtd">
+<BlastOutput>
+  <BlastOutput_program>mica</BlastOutput_program>
+  <BlastOutput_iterations>
+`
+
+const xmlFooter = `
+  </BlastOutput_iterations>
+</BlastOutput>
+`
+
+func newXMLHitWriter(w *bufio.Writer) (*xmlHitWriter, error) {
+	if _, err := io.WriteString(w, xmlHeader); err != nil {
+		return nil, fmt.Errorf("Could not write XML output: %s", err)
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("    ", "  ")
+	return &xmlHitWriter{w: w, enc: enc}, nil
+}
+
+func (hw *xmlHitWriter) Write(hit Hit) error {
+	if len(hw.iter.QueryId) > 0 && hit.QueryId != hw.iter.QueryId {
+		if err := hw.flush(); err != nil {
+			return err
+		}
+	}
+	if len(hw.iter.QueryId) == 0 {
+		hw.iter.IterNum++
+		hw.iter.QueryId = hit.QueryId
+		hw.iter.QueryDef = hit.QueryId
+	}
+
+	hits := hw.iter.Hits
+	id := fmt.Sprintf("gnl|BL_ORD_ID|%d", hit.OriginalId)
+	if len(hits) == 0 || hits[len(hits)-1].Accession != hit.SubjectId ||
+		hits[len(hits)-1].Id != id {
+		def := hit.SubjectHeader
+		if len(def) == 0 {
+			def = hit.SubjectId
+		}
+		hits = append(hits, xmlHit{
+			Num:       len(hits) + 1,
+			Id:        id,
+			Def:       def,
+			Accession: hit.SubjectId,
+		})
+	}
+	xhit := &hits[len(hits)-1]
+
+	identities := int(math.Floor(hit.Identity*float64(hit.AlignLen)/100 + 0.5))
+	xhit.Hsps = append(xhit.Hsps, xmlHsp{
+		Num:       len(xhit.Hsps) + 1,
+		BitScore:  hit.BitScore,
+		Evalue:    hit.Evalue,
+		QueryFrom: hit.QueryStart,
+		QueryTo:   hit.QueryEnd,
+		HitFrom:   hit.SubjectStart,
+		HitTo:     hit.SubjectEnd,
+		Identity:  identities,
+		Gaps:      hit.AlignLen - identities - hit.Mismatches,
+		AlignLen:  hit.AlignLen,
+	})
+	hw.iter.Hits = hits
+	return nil
+}
+
+// flush writes the Iteration element of the current query.
+func (hw *xmlHitWriter) flush() error {
+	start := xml.StartElement{Name: xml.Name{Local: "Iteration"}}
+	if err := hw.enc.EncodeElement(hw.iter, start); err != nil {
+		return fmt.Errorf("Could not write XML output: %s", err)
+	}
+	hw.iter = xmlIteration{IterNum: hw.iter.IterNum}
+	return nil
+}
+
+func (hw *xmlHitWriter) Close() error {
+	if len(hw.iter.QueryId) > 0 {
+		if err := hw.flush(); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(hw.w, xmlFooter); err != nil {
+		return fmt.Errorf("Could not write XML output: %s", err)
+	}
+	return hw.w.Flush()
+}
@@ -0,0 +1,470 @@
+package mica
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Hit is a single alignment (an HSP, in BLAST parlance) reported by a search
+// tool. Coordinates are 1-based and inclusive, just like they are in BLAST
+// output.
+//
+// The names of the fields in JSON output (see HitFormatJSON) are given by
+// their tags.
+type Hit struct {
+	QueryId   string `json:"query"`
+	SubjectId string `json:"subject"`
+
+	// The entire FASTA header of the subject, when it is known. (The hits
+	// of Searcher.SearchHits have the headers of original sequences.)
+	SubjectHeader string `json:"subject_header,omitempty"`
+
+	// The id of the original sequence that the subject is (i.e., its
+	// position, from 0, in the input of the database). Only the hits of
+	// Searcher.SearchHits have it.
+	OriginalId int `json:"original_id"`
+
+	// Percent identity of the alignment, in the range 0-100.
+	Identity   float64 `json:"identity"`
+	AlignLen   int     `json:"align_len"`
+	Mismatches int     `json:"mismatches"`
+	GapOpens   int     `json:"gap_opens"`
+
+	QueryStart   int `json:"query_start"`
+	QueryEnd     int `json:"query_end"`
+	SubjectStart int `json:"subject_start"`
+	SubjectEnd   int `json:"subject_end"`
+
+	Evalue   float64 `json:"evalue"`
+	BitScore float64 `json:"bitscore"`
+
+	// The name of the aligner that found the hit (see Aligner.Name), when it
+	// is known.
+	Backend string `json:"backend,omitempty"`
+}
+
+// CoarseSeqId interprets the subject of a hit from a coarse search as the
+// identifier of a coarse sequence. (Coarse sequences are written to the
+// coarse FASTA file with their identifiers as their headers.)
+func (h Hit) CoarseSeqId() (int, error) {
+	id, err := strconv.Atoi(h.SubjectId)
+	if err != nil {
+		return 0, fmt.Errorf("Subject '%s' is not a coarse sequence "+
+			"identifier: %s", h.SubjectId, err)
+	}
+	return id, nil
+}
+
+// ReadHit is a hit sent on the channels returned by Aligner.Hits. Exactly one
+// of Hit and Err is set.
+type ReadHit struct {
+	Hit Hit
+	Err error
+}
+
+// ReadBlastTabular parses hits in BLAST tabular format (i.e., `-outfmt 6`,
+// which is also what `diamond view` produces). Blank lines and comment lines
+// starting with '#' are skipped.
+func ReadBlastTabular(r io.Reader) ([]Hit, error) {
+	hits := make([]Hit, 0, 100)
+	err := scanBlastTabular(r, func(hit Hit) error {
+		hits = append(hits, hit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+// scanBlastTabular is ReadBlastTabular, except that each hit is given to
+// 'fn' as soon as it is parsed. Scanning stops at the first error returned by
+// 'fn'.
+func scanBlastTabular(r io.Reader, fn func(Hit) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 || line[0] == '#' {
+			continue
+		}
+		hit, err := parseBlastTabularLine(line)
+		if err != nil {
+			return err
+		}
+		if err := fn(hit); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error reading tabular output: %s", err)
+	}
+	return nil
+}
+
+// WriteBlastTabular writes hits in BLAST tabular format, with numbers
+// formatted like BLAST formats them. (ReadBlastTabular reads them back.)
+func WriteBlastTabular(w io.Writer, hits []Hit) error {
+	for _, hit := range hits {
+		_, err := fmt.Fprintf(w,
+			"%s\t%s\t%.2f\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			hit.QueryId, hit.SubjectId, hit.Identity, hit.AlignLen,
+			hit.Mismatches, hit.GapOpens, hit.QueryStart, hit.QueryEnd,
+			hit.SubjectStart, hit.SubjectEnd,
+			blastEvalue(hit.Evalue), blastBitScore(hit.BitScore))
+		if err != nil {
+			return fmt.Errorf("Could not write tabular output: %s", err)
+		}
+	}
+	return nil
+}
+
+// blastEvalue formats an e-value the way BLAST's tabular output does.
+func blastEvalue(evalue float64) string {
+	switch {
+	case evalue < 1.0e-180:
+		return "0.0"
+	case evalue < 0.0099:
+		return fmt.Sprintf("%.0e", evalue)
+	case evalue < 0.1:
+		return fmt.Sprintf("%.3f", evalue)
+	case evalue < 1.0:
+		return fmt.Sprintf("%.2f", evalue)
+	case evalue < 10.0:
+		return fmt.Sprintf("%.1f", evalue)
+	}
+	return fmt.Sprintf("%.0f", evalue)
+}
+
+// blastBitScore formats a bit score the way BLAST's tabular output does.
+func blastBitScore(bits float64) string {
+	switch {
+	case bits > 9999:
+		return fmt.Sprintf("%.3e", bits)
+	case bits > 99.9:
+		return fmt.Sprintf("%.0f", bits)
+	}
+	return fmt.Sprintf("%.1f", bits)
+}
+
+// parseBlastTabularLine parses a single line of BLAST tabular output. The
+// columns are: query id, subject id, percent identity, alignment length,
+// mismatches, gap opens, query start, query end, subject start, subject end,
+// e-value and bit score. e.g.,
+//
+//	YAL001C  897745  96.12  1160  45  0  1  1160  1  1160  0e+00  2179.8
+func parseBlastTabularLine(line string) (hit Hit, err error) {
+	// Fields are split on tabs alone, since the query of an unnamed
+	// sequence is empty.
+	fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+	if len(fields) < 12 {
+		return Hit{}, fmt.Errorf("Line in tabular output is too short: %s",
+			line)
+	}
+
+	atoi := func(i int) int {
+		if err != nil {
+			return 0
+		}
+		var n int
+		if n, err = strconv.Atoi(fields[i]); err != nil {
+			err = fmt.Errorf("Could not parse column %d of '%s': %s",
+				i+1, line, err)
+		}
+		return n
+	}
+	atof := func(i int) float64 {
+		if err != nil {
+			return 0
+		}
+		var f float64
+		if f, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			err = fmt.Errorf("Could not parse column %d of '%s': %s",
+				i+1, line, err)
+		}
+		return f
+	}
+	hit = Hit{
+		QueryId:      fields[0],
+		SubjectId:    fields[1],
+		Identity:     atof(2),
+		AlignLen:     atoi(3),
+		Mismatches:   atoi(4),
+		GapOpens:     atoi(5),
+		QueryStart:   atoi(6),
+		QueryEnd:     atoi(7),
+		SubjectStart: atoi(8),
+		SubjectEnd:   atoi(9),
+		Evalue:       atof(10),
+		BitScore:     atof(11),
+	}
+	return hit, err
+}
+
+// ReadHmmerTable parses the per-sequence table written by HMMER's hmmsearch
+// with '--tblout'. Each hit is a target sequence, with the e-value and score
+// of the full sequence. (Its coordinates are zero, since the table has none.)
+// Comment lines starting with '#' are skipped.
+func ReadHmmerTable(r io.Reader) ([]Hit, error) {
+	return readHmmerTable(r, false)
+}
+
+// ReadHmmerDomTable parses the per-domain table written by hmmsearch with
+// '--domtblout'. Each hit is a domain: its query coordinates are those of the
+// HMM, its subject coordinates are those of the domain's alignment (whose
+// length is AlignLen), and its e-value and score are the domain's
+// independent e-value and score. Identity, mismatches and gap opens aren't
+// reported by hmmsearch, so they are zero.
+func ReadHmmerDomTable(r io.Reader) ([]Hit, error) {
+	return readHmmerTable(r, true)
+}
+
+func readHmmerTable(r io.Reader, domains bool) ([]Hit, error) {
+	hits := make([]Hit, 0, 100)
+	err := scanHmmerTable(r, domains, func(hit Hit) error {
+		hits = append(hits, hit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+// scanHmmerTable is ReadHmmerTable (or ReadHmmerDomTable, when 'domains' is
+// set), except that each hit is given to 'fn' as soon as it is parsed.
+// Scanning stops at the first error returned by 'fn'.
+func scanHmmerTable(r io.Reader, domains bool, fn func(Hit) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 || line[0] == '#' {
+			continue
+		}
+		hit, err := parseHmmerTableLine(line, domains)
+		if err != nil {
+			return err
+		}
+		if err := fn(hit); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error reading hmmsearch table: %s", err)
+	}
+	return nil
+}
+
+// parseHmmerTableLine parses a single line of either of hmmsearch's tables.
+// Columns are separated by spaces, and the last one (the description of the
+// target) may have spaces of its own. The columns read from the per-domain
+// table are: target name (1), query name (4), i-Evalue (13), score (14), hmm
+// from and to (16, 17) and ali from and to (18, 19). e.g.,
+//
+//	12  -  1160  PF00001  PF00001.21  257  1.2e-30  105.3  0.1  1  1
+//	    4.5e-33  2.3e-30  104.4  0.1  1  257  33  290  30  295  0.95  -
+//
+// From the per-sequence table, they are: target name (1), query name (3),
+// full sequence E-value (5) and score (6).
+func parseHmmerTableLine(line string, domains bool) (hit Hit, err error) {
+	fields := strings.Fields(line)
+	columns := 18
+	if domains {
+		columns = 22
+	}
+	if len(fields) < columns {
+		return Hit{}, fmt.Errorf("Line in hmmsearch table is too short: %s",
+			line)
+	}
+
+	atoi := func(i int) int {
+		if err != nil {
+			return 0
+		}
+		var n int
+		if n, err = strconv.Atoi(fields[i]); err != nil {
+			err = fmt.Errorf("Could not parse column %d of '%s': %s",
+				i+1, line, err)
+		}
+		return n
+	}
+	atof := func(i int) float64 {
+		if err != nil {
+			return 0
+		}
+		var f float64
+		if f, err = strconv.ParseFloat(fields[i], 64); err != nil {
+			err = fmt.Errorf("Could not parse column %d of '%s': %s",
+				i+1, line, err)
+		}
+		return f
+	}
+	if !domains {
+		hit = Hit{
+			QueryId:   fields[2],
+			SubjectId: fields[0],
+			Evalue:    atof(4),
+			BitScore:  atof(5),
+		}
+		return hit, err
+	}
+	hit = Hit{
+		QueryId:      fields[3],
+		SubjectId:    fields[0],
+		QueryStart:   atoi(15),
+		QueryEnd:     atoi(16),
+		SubjectStart: atoi(17),
+		SubjectEnd:   atoi(18),
+		Evalue:       atof(12),
+		BitScore:     atof(13),
+	}
+	hit.AlignLen = hit.SubjectEnd - hit.SubjectStart + 1
+	return hit, err
+}
+
+// ReadBlastXML parses hits from BLAST XML output (i.e., `-outfmt 5`).
+//
+// The subject of each hit is its accession, which for a database built by
+// `makeblastdb` without `-parse_seqids` is the ordinal of the sequence in the
+// database. (For the coarse database, this is the coarse sequence id.)
+func ReadBlastXML(r io.Reader) ([]Hit, error) {
+	hits := make([]Hit, 0, 100)
+	err := scanBlastXML(r, func(hit Hit) error {
+		hits = append(hits, hit)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hits, nil
+}
+
+// scanBlastXML is ReadBlastXML, except that the hits of each query are given
+// to 'fn' as soon as BLAST has reported them. Scanning stops at the first
+// error returned by 'fn'.
+func scanBlastXML(r io.Reader, fn func(Hit) error) error {
+	dec := xml.NewDecoder(r)
+	sawOutput := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			if !sawOutput {
+				return fmt.Errorf("Could not parse BLAST search results: " +
+					"no BlastOutput element found")
+			}
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("Could not parse BLAST search results: %s", err)
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local == "BlastOutput" {
+			sawOutput = true
+		}
+		if start.Name.Local != "Iteration" {
+			continue
+		}
+
+		iter := xmlIteration{}
+		if err := dec.DecodeElement(&iter, &start); err != nil {
+			return fmt.Errorf("Could not parse BLAST search results: %s", err)
+		}
+		if err := iter.scan(fn); err != nil {
+			return err
+		}
+	}
+}
+
+// scan gives every HSP of the iteration to 'fn' as a Hit.
+func (iter xmlIteration) scan(fn func(Hit) error) error {
+	queryId := firstWord(iter.QueryDef)
+	for _, xhit := range iter.Hits {
+		for _, hsp := range xhit.Hsps {
+			err := fn(Hit{
+				QueryId:      queryId,
+				SubjectId:    strings.TrimSpace(xhit.Accession),
+				Identity:     percent(hsp.Identity, hsp.AlignLen),
+				AlignLen:     hsp.AlignLen,
+				Mismatches:   hsp.AlignLen - hsp.Identity - hsp.Gaps,
+				GapOpens:     gapOpens(hsp.Qseq) + gapOpens(hsp.Hseq),
+				QueryStart:   hsp.QueryFrom,
+				QueryEnd:     hsp.QueryTo,
+				SubjectStart: hsp.HitFrom,
+				SubjectEnd:   hsp.HitTo,
+				Evalue:       hsp.Evalue,
+				BitScore:     hsp.BitScore,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The elements of BLAST XML output that are read by ReadBlastXML and written
+// by a HitWriter.
+type xmlIteration struct {
+	IterNum  int      `xml:"Iteration_iter-num"`
+	QueryId  string   `xml:"Iteration_query-ID"`
+	QueryDef string   `xml:"Iteration_query-def"`
+	Hits     []xmlHit `xml:"Iteration_hits>Hit"`
+}
+
+type xmlHit struct {
+	Num       int      `xml:"Hit_num"`
+	Id        string   `xml:"Hit_id"`
+	Def       string   `xml:"Hit_def"`
+	Accession string   `xml:"Hit_accession"`
+	Hsps      []xmlHsp `xml:"Hit_hsps>Hsp"`
+}
+
+type xmlHsp struct {
+	Num       int     `xml:"Hsp_num"`
+	BitScore  float64 `xml:"Hsp_bit-score"`
+	Evalue    float64 `xml:"Hsp_evalue"`
+	QueryFrom int     `xml:"Hsp_query-from"`
+	QueryTo   int     `xml:"Hsp_query-to"`
+	HitFrom   int     `xml:"Hsp_hit-from"`
+	HitTo     int     `xml:"Hsp_hit-to"`
+	Identity  int     `xml:"Hsp_identity"`
+	Gaps      int     `xml:"Hsp_gaps"`
+	AlignLen  int     `xml:"Hsp_align-len"`
+	Qseq      string  `xml:"Hsp_qseq,omitempty"`
+	Hseq      string  `xml:"Hsp_hseq,omitempty"`
+}
+
+// gapOpens counts the number of runs of '-' in an aligned sequence.
+func gapOpens(aligned string) int {
+	opens := 0
+	for i := 0; i < len(aligned); i++ {
+		if aligned[i] == '-' && (i == 0 || aligned[i-1] != '-') {
+			opens++
+		}
+	}
+	return opens
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100.0 * float64(part) / float64(whole)
+}
+
+// firstWord returns everything in s up to the first space. This is how BLAST
+// and DIAMOND turn FASTA headers into sequence identifiers.
+func firstWord(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i > -1 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,170 @@
+// Package cli holds what the subcommands of the mica command (and the
+// mica-* commands, which are aliases of them) have in common: their usage
+// messages, the flags they share and the handling of fatal errors.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+
+	"github.com/ndaniels/mica"
+)
+
+func init() {
+	log.SetFlags(0)
+}
+
+// NewFlagSet returns an empty flag set for the command 'prog'. Its usage
+// message shows 'usage' after "[flags]", followed by every flag with its
+// default value and help. It exits the program, like a flag error does.
+func NewFlagSet(prog, usage string) *flag.FlagSet {
+	flags := flag.NewFlagSet(prog, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "\nUsage: %s [flags] %s\n", prog, usage)
+		PrintDefaults(flags)
+		os.Exit(1)
+	}
+	return flags
+}
+
+// PrintDefaults prints every flag in 'flags' with its default value and
+// help. (This is mica.PrintFlagDefaults for a flag set.)
+func PrintDefaults(flags *flag.FlagSet) {
+	flags.VisitAll(func(fg *flag.Flag) {
+		fmt.Printf("--%s=\"%s\"\n\t%s\n", fg.Name, fg.DefValue, fg.Usage)
+	})
+}
+
+// Fatalf prints the message to stderr and exits with a non-zero status.
+func Fatalf(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, v...)
+	os.Exit(1)
+}
+
+// Common holds the flags shared by the commands that compress, decompress
+// and search databases.
+type Common struct {
+	GoMaxProcs int
+	Quiet      bool
+	CpuProfile string
+	MemProfile string
+}
+
+// NewCommon adds the common flags to 'flags' and returns their values.
+func NewCommon(flags *flag.FlagSet) *Common {
+	c := &Common{GoMaxProcs: runtime.NumCPU()}
+	flags.IntVar(&c.GoMaxProcs, "p", c.GoMaxProcs,
+		"The maximum number of CPUs that can be executing simultaneously.")
+	flags.BoolVar(&c.Quiet, "quiet", c.Quiet,
+		"When set, the only outputs will be errors echoed to stderr.")
+	flags.StringVar(&c.CpuProfile, "cpuprofile", c.CpuProfile,
+		"When set, a CPU profile will be written to the file specified.")
+	flags.StringVar(&c.MemProfile, "memprofile", c.MemProfile,
+		"When set, a memory profile will be written to the file specified.")
+	return c
+}
+
+// Setup applies the common flags once they have been parsed: it limits the
+// number of CPUs used and, unless 'quiet' is set, enables verbose output.
+func (c *Common) Setup() {
+	runtime.GOMAXPROCS(c.GoMaxProcs)
+	if !c.Quiet {
+		mica.Verbose = true
+	}
+}
+
+// StartCPUProfile starts the CPU profile, if one was asked for.
+func (c *Common) StartCPUProfile() {
+	if len(c.CpuProfile) > 0 {
+		f, err := os.Create(c.CpuProfile)
+		if err != nil {
+			Fatalf("%s\n", err)
+		}
+		pprof.StartCPUProfile(f)
+	}
+}
+
+// StopProfiles stops the CPU profile and writes the last memory profile
+// (with a '.last' extension), if they were asked for.
+func (c *Common) StopProfiles() {
+	if len(c.CpuProfile) > 0 {
+		pprof.StopCPUProfile()
+	}
+	if len(c.MemProfile) > 0 {
+		WriteMemProfile(fmt.Sprintf("%s.last", c.MemProfile))
+	}
+}
+
+// WriteMemProfile writes a heap profile to the named file.
+func WriteMemProfile(name string) {
+	f, err := os.Create(name)
+	if err != nil {
+		Fatalf("%s\n", err)
+	}
+	pprof.WriteHeapProfile(f)
+	f.Close()
+}
+
+// SplitArgs splits the arguments at the first 'marker' (e.g.,
+// "--blast-args"), and returns the arguments before it and after it.
+func SplitArgs(args []string, marker string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == marker {
+			return args[:i], args[i+1:]
+		}
+	}
+	return args, nil
+}
+
+// RenameFlags returns the arguments with the flags named in 'renames'
+// (without dashes) replaced by their new names. This lets the mica-*
+// commands keep accepting the flag names they used before the mica command
+// settled on one name for each. Arguments after "--" or "--blast-args" are
+// left alone.
+func RenameFlags(args []string, renames map[string]string) []string {
+	renamed := make([]string, len(args))
+	copy(renamed, args)
+	for i, arg := range renamed {
+		if arg == "--" || arg == "--blast-args" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		dashes := "-"
+		if strings.HasPrefix(arg, "--") {
+			dashes = "--"
+		}
+		name, value := arg[len(dashes):], ""
+		if j := strings.Index(name, "="); j > -1 {
+			name, value = name[:j], name[j:]
+		}
+		if newName, ok := renames[name]; ok {
+			renamed[i] = dashes + newName + value
+		}
+	}
+	return renamed
+}
+
+// DirSize returns the total size, in bytes, of the files in the directory
+// 'dir' and its subdirectories.
+func DirSize(dir string) (int64, error) {
+	size := int64(0)
+	err := filepath.Walk(dir,
+		func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				size += info.Size()
+			}
+			return nil
+		})
+	return size, err
+}
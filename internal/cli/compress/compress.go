@@ -0,0 +1,642 @@
+// Package compress implements 'mica compress' (and mica-compress), which
+// compresses FASTA files into a mica database.
+package compress
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+// makeblastdb -dbtype prot -input_type fasta
+
+const interval = 1000
+
+var (
+	// Used to compute the number of sequences compressed per second.
+	timer time.Time
+
+	// Any residue in `ignoredResidues` will be replaced with an X.
+	// These should correspond to the residues NOT in blosum.Alphabet62.
+	ignoredResidues = []byte{'J', 'O', 'U'}
+
+	// A default configuration.
+	dbConf = mica.DefaultDBConf
+
+	// Flags that affect the higher level operation of compression.
+	// Flags that control algorithmic parameters are stored in `dbConf`.
+	// The flags shared with other commands are stored in `common`.
+	common          *cli.Common
+	flagAppend      = false
+	flagOverwrite   = false
+	flagMaxSeedsGB  = 8.0
+	flagEviction    = mica.EvictLeastHit
+	flagMemStats    = ""
+	flagMemInterval = false
+	flagDeterminism = false
+	flagWindowSize  = 1000
+	flagSortLength  = false
+	flagSortMem     = 1024
+	flagSortTempDir = ""
+	flagCheckpoint  = 0
+	flagResume      = false
+	flagDedup       = false
+	flagDedupMax    = mica.DefaultMaxDuplicates
+	flagMinLength   = 0
+	flagMaxLength   = 0
+	flagMaxAmbig    = 1.0
+	flagInclude     = ""
+	flagExclude     = ""
+	flagHeader      = ""
+)
+
+// parseFlags parses the command line arguments 'args' of the command 'prog'.
+func parseFlags(prog string, args []string) *flag.FlagSet {
+	flags := cli.NewFlagSet(prog,
+		"database-directory fasta-file [fasta-file ...]\n"+
+			"\nA fasta-file of '-' is read from stdin.")
+	common = cli.NewCommon(flags)
+
+	flags.IntVar(&dbConf.MinMatchLen, "min-match-len",
+		dbConf.MinMatchLen,
+		"The minimum size of a match.")
+	flags.IntVar(&dbConf.MatchKmerSize, "match-kmer-size",
+		dbConf.MatchKmerSize,
+		"The size of kmer fragments to match in ungapped extension.")
+	flags.IntVar(&dbConf.GappedWindowSize, "gapped-window-size",
+		dbConf.GappedWindowSize,
+		"The size of the gapped match window.")
+	flags.IntVar(&dbConf.UngappedWindowSize, "ungapped-window-size",
+		dbConf.UngappedWindowSize,
+		"The size of the ungapped match window.")
+	flags.IntVar(&dbConf.ExtSeqIdThreshold, "ext-seq-id-threshold",
+		dbConf.ExtSeqIdThreshold,
+		"The sequence identity threshold of [un]gapped extension. \n"+
+			"\t(An integer in the inclusive range from 0 to 100.)")
+	flags.IntVar(&dbConf.MatchSeqIdThreshold, "match-seq-id-threshold",
+		dbConf.MatchSeqIdThreshold,
+		"The sequence identity threshold of an entire match.")
+	flags.StringVar(&dbConf.MatchCriterion, "match-criterion",
+		dbConf.MatchCriterion,
+		"How to decide whether an alignment is good enough to be a \n"+
+			"\tmatch. 'identity' uses the sequence identity thresholds.\n"+
+			"\t'positives' uses the same thresholds as the percent of \n"+
+			"\tcolumns with a positive BLOSUM62 score. 'bits' uses the \n"+
+			"\tbit score thresholds.")
+	flags.Float64Var(&dbConf.ExtBitsThreshold, "ext-bits-threshold",
+		dbConf.ExtBitsThreshold,
+		"The BLOSUM62 bits per residue threshold of [un]gapped \n"+
+			"\textension, when --match-criterion is 'bits'.")
+	flags.Float64Var(&dbConf.MatchBitsThreshold, "match-bits-threshold",
+		dbConf.MatchBitsThreshold,
+		"The BLOSUM62 bits per residue threshold of an entire match, \n"+
+			"\twhen --match-criterion is 'bits'.")
+	flags.IntVar(&dbConf.MatchExtend, "match-extend",
+		dbConf.MatchExtend,
+		"The maximum number of residues to blindly extend a \n"+
+			"\tmatch without regard to sequence identity. This is \n"+
+			"\tto avoid small sequences in the coarse database.")
+	flags.IntVar(&dbConf.MapSeedSize, "map-seed-size",
+		dbConf.MapSeedSize,
+		"The size of a seed in the K-mer map. This size combined with\n"+
+			"\t'ext-seed-size' forms the total seed size.")
+	flags.IntVar(&dbConf.ExtSeedSize, "ext-seed-size",
+		dbConf.ExtSeedSize,
+		"The additional residues to require for each seed match.")
+	flags.IntVar(&dbConf.LowComplexity, "low-complexity",
+		dbConf.LowComplexity,
+		"The window size used to detect regions of low complexity.\n"+
+			"\tLow complexity regions are repetitions of a single amino\n"+
+			"\tacid residue. Low complexity regions are skipped when\n"+
+			"\ttrying to extend a match.")
+	flags.IntVar(&dbConf.SeedLowComplexity, "seed-low-complexity",
+		dbConf.SeedLowComplexity,
+		"The seed window size used to detect regions of low complexity.\n"+
+			"\tLow complexity regions are repetitions of a single amino\n"+
+			"\tacid residue. Low complexity regions matching this window\n"+
+			"\tsize are not included in the seeds table.")
+	flags.BoolVar(&dbConf.SavePlain, "plain",
+		dbConf.SavePlain,
+		"When set, additional plain-text versions of files that are \n"+
+			"\tnormally encoded in binary are saved with a '.plain' \n"+
+			"\textension. Note that the original binary files are also saved.")
+	flags.BoolVar(&dbConf.SaveCompressed, "compress-source",
+		dbConf.SaveCompressed,
+		"When set compresses the source sequence files to use less disk\n"+
+			"\tspace.")
+	flags.BoolVar(&dbConf.ReadOnly, "read-only",
+		dbConf.ReadOnly,
+		"When set, the database created will be read-only (i.e., it \n"+
+			"\tcannot be appended to), but it will be smaller.")
+	flags.StringVar(&dbConf.BlastMakeBlastDB, "makeblastdb",
+		dbConf.BlastMakeBlastDB,
+		"The location of the 'makeblastdb' executable.")
+	flags.StringVar(&dbConf.Dmnd, "diamond",
+		dbConf.Dmnd,
+		"The location of the 'diamond' executable.")
+
+	flags.BoolVar(&flagAppend, "append", flagAppend,
+		"When set, compressed sequences will be added to existing database.\n"+
+			"\tThe parameters used to create the initial database are\n"+
+			"\tautomatically used by default. They can still be overriden\n"+
+			"\ton the command line.")
+	flags.BoolVar(&flagOverwrite, "overwrite", flagOverwrite,
+		"When set, any existing database will be destroyed.")
+	flags.IntVar(&flagCheckpoint, "checkpoint", flagCheckpoint,
+		"When set, a checkpoint is saved in the database every time this\n"+
+			"\tmany sequences have been compressed, so that compression can\n"+
+			"\tbe resumed with 'resume' if it is interrupted. (Rounded up to\n"+
+			"\ta multiple of 'window-size' when 'deterministic' is set.)\n"+
+			"\tSetting to zero disables checkpoints.")
+	flags.BoolVar(&flagResume, "resume", flagResume,
+		"When set, compression continues from the last checkpoint saved\n"+
+			"\tin the database. The same input files (and 'sort-length'\n"+
+			"\tsetting) must be given as when the checkpoint was saved.")
+	flags.BoolVar(&flagDeterminism, "deterministic", flagDeterminism,
+		"When set, the database created depends only on the input and\n"+
+			"\tthe parameters used, and not on the number of CPUs. Sequences\n"+
+			"\tare compressed in windows (see 'window-size'), where matches\n"+
+			"\tare found in parallel and committed in input order.")
+	flags.IntVar(&flagWindowSize, "window-size", flagWindowSize,
+		"The number of sequences in each window when 'deterministic' is\n"+
+			"\tset. Sequences cannot match residues added to the coarse\n"+
+			"\tdatabase by other sequences in the same window, so smaller\n"+
+			"\twindows give better compression but less parallelism.")
+	flags.BoolVar(&flagSortLength, "sort-length", flagSortLength,
+		"When set, sequences are compressed longest first (like CD-HIT),\n"+
+			"\tso that long sequences are added to the coarse database\n"+
+			"\tbefore the shorter sequences that may match them. Sequence\n"+
+			"\tids (and the order of the compressed database) still follow\n"+
+			"\tthe order of the input.")
+	flags.IntVar(&flagSortMem, "sort-mem", flagSortMem,
+		"The memory, in megabytes, used to sort sequences when\n"+
+			"\t'sort-length' is set. Larger inputs are sorted in runs that\n"+
+			"\tare written to temporary files and merged.")
+	flags.StringVar(&flagSortTempDir, "sort-temp-dir", flagSortTempDir,
+		"The directory used for temporary files when 'sort-length' is\n"+
+			"\tset. By default, the system's temporary directory is used.")
+	flags.BoolVar(&flagDedup, "dedup", flagDedup,
+		"When set, a sequence with exactly the same residues as a\n"+
+			"\tsequence compressed earlier is not compressed again. It is\n"+
+			"\tlinked to the same regions of the coarse database instead.")
+	flags.IntVar(&flagDedupMax, "dedup-max", flagDedupMax,
+		"The greatest number of distinct sequences remembered by\n"+
+			"\t'dedup'. Each is kept in memory (and in every checkpoint)\n"+
+			"\twith its links. Sequences seen after that are only found to\n"+
+			"\tbe duplicates of the ones remembered. 0 means no limit.")
+	flags.IntVar(&flagMinLength, "min-length", flagMinLength,
+		"When set, sequences with fewer residues are not compressed.")
+	flags.IntVar(&flagMaxLength, "max-length", flagMaxLength,
+		"When set, sequences with more residues are not compressed.")
+	flags.Float64Var(&flagMaxAmbig, "max-ambiguous", flagMaxAmbig,
+		"Sequences where more than this fraction of the residues are\n"+
+			"\tambiguous (X, B, Z or J) are not compressed.")
+	flags.StringVar(&flagInclude, "header-include", flagInclude,
+		"When set, only sequences whose header matches this regular\n"+
+			"\texpression are compressed.")
+	flags.StringVar(&flagExclude, "header-exclude", flagExclude,
+		"When set, sequences whose header matches this regular\n"+
+			"\texpression are not compressed.")
+	flags.StringVar(&flagHeader, "header-template", flagHeader,
+		"When set, the header of each sequence is rewritten with this\n"+
+			"\ttemplate (in Go's text/template syntax), using the fields\n"+
+			"\t.Name, .Accession (the first word of the header),\n"+
+			"\t.Description (the rest of it), .Id and .Length.\n"+
+			"\tFor example, '{{.Accession}} len={{.Length}}'.\n"+
+			"\tFilters are applied in the order of the flags above, after\n"+
+			"\tresidues are replaced, and a report of how many sequences\n"+
+			"\teach one removed is printed at the end.")
+	flags.Float64Var(&flagMaxSeedsGB, "max-seeds", flagMaxSeedsGB,
+		"When set, seeds will be evicted from the in memory seeds table\n"+
+			"\twhen the memory used by seeds exceeds the specified number,\n"+
+			"\tin gigabytes. (See 'seed-eviction'.)\n"+
+			"\tEach seed corresponds to 16 bytes of memory.\n"+
+			"\tSetting to zero disables this behavior.")
+	flags.StringVar(&flagEviction, "seed-eviction", flagEviction,
+		"How seeds are evicted when the seeds table exceeds 'max-seeds'.\n"+
+			"\t'oldest' evicts the seeds of the oldest coarse sequences.\n"+
+			"\t'least-hit' evicts the seeds of the coarse sequences matched\n"+
+			"\tthe fewest times. 'subsample' keeps an evenly spaced subset\n"+
+			"\tof the seeds of the most frequent K-mers (and then evicts\n"+
+			"\tthe oldest, if necessary). 'wipe' evicts every seed.")
+	flags.StringVar(&flagMemStats, "memstats", flagMemStats,
+		"When set, memory statistics will be written to the file specified.")
+	flags.BoolVar(&flagMemInterval, "mem-interval", flagMemInterval,
+		"When set, memory profile/stats will be written at some interval.")
+
+	flags.Parse(args)
+	common.Setup()
+	return flags
+}
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command).
+func Main(prog string, args []string) {
+	flags := parseFlags(prog, args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+
+	// If both 'append' and 'overwrite' flags are set, quit because the
+	// combination doesn't make sense.
+	if flagAppend && flagOverwrite {
+		cli.Fatalf("Both the 'append' and 'overwrite' flags are set. It does " +
+			"not make sense to set both of these flags.")
+	}
+	if flagResume && (flagAppend || flagOverwrite) {
+		cli.Fatalf("The 'resume' flag cannot be combined with the 'append' " +
+			"or 'overwrite' flags.")
+	}
+	for _, arg := range flags.Args()[1:] {
+		if arg == "-" && (flagResume || flagCheckpoint > 0) {
+			cli.Fatalf("Sequences read from stdin ('-') cannot be " +
+				"checkpointed or resumed.\n")
+		}
+	}
+	if err := mica.ValidCriterion(dbConf.MatchCriterion); err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+	if err := mica.ValidEvictPolicy(flagEviction); err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+	filters, err := seqFilters()
+	if err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+
+	// If the overwrite flag is set, remove whatever directory that may
+	// already be there.
+	if flagOverwrite {
+		if err := os.RemoveAll(flags.Arg(0)); err != nil {
+			cli.Fatalf("Could not remove existing database '%s': %s.",
+				flags.Arg(0), err)
+		}
+	}
+
+	// Create a new database for writing. If we're appending, we load
+	// the coarse database into memory, and setup the database for writing.
+	// If we're resuming, the database is restored to its last checkpoint.
+	var db *mica.DB
+	var cp *mica.Checkpoint
+	if flagResume {
+		db, cp, err = mica.NewResumeDB(dbConf, setFlags(flags), flags.Arg(0))
+		if err != nil {
+			cli.Fatalf("%s\n", err)
+		}
+		if !sameInputs(cp.Inputs, flags.Args()[1:]) ||
+			cp.SortLength != flagSortLength {
+			cli.Fatalf("Compression must be resumed with the same input files "+
+				"(and 'sort-length' setting) that were being compressed "+
+				"when the checkpoint was saved: %s\n",
+				strings.Join(cp.Inputs, " "))
+		}
+	} else {
+		db, err = mica.NewWriteDB(flagAppend, dbConf, setFlags(flags),
+			flags.Arg(0))
+		if err != nil {
+			cli.Fatalf("%s\n", err)
+		}
+	}
+	mica.Vprintln("")
+	if flagMaxSeedsGB > 0 {
+		err := db.CoarseDB.Seeds.Limit(flagMaxSeedsGB, flagEviction)
+		if err != nil {
+			cli.Fatalf("%s\n", err)
+		}
+	}
+
+	windowSize := 0
+	if flagDeterminism {
+		if flagWindowSize < 1 {
+			cli.Fatalf("The window size must be at least 1.\n")
+		}
+		windowSize = flagWindowSize
+	}
+	if flagSortLength {
+		if flagSortMem < 1 {
+			cli.Fatalf("The sort memory must be at least 1 megabyte.\n")
+		}
+		db.ComDB.WriteInAnyOrder()
+	}
+	compressor := mica.NewCompressor(db)
+	compressor.WindowSize = windowSize
+	compressor.Dedup = flagDedup
+	db.Duplicates.MaxSeqs = flagDedupMax
+	compressor.Start()
+	mainQuit := make(chan struct{}, 0)
+
+	// Sequences are numbered from firstId in input order. When resuming,
+	// the first 'consumed' sequences were compressed before the checkpoint.
+	firstId, consumed := db.ComDB.NumSequences(), 0
+	if cp != nil {
+		firstId, consumed = cp.FirstId, cp.Consumed
+	} else if flagCheckpoint > 0 {
+		cp = &mica.Checkpoint{
+			Inputs:     flags.Args()[1:],
+			SortLength: flagSortLength,
+			FirstId:    firstId,
+		}
+	}
+
+	// Checkpoints are only saved between windows, so that a resumed run
+	// compresses the same windows as an uninterrupted one.
+	checkpointEvery := flagCheckpoint
+	if windowSize > 0 && checkpointEvery%windowSize != 0 {
+		checkpointEvery += windowSize - checkpointEvery%windowSize
+	}
+
+	// If the process is killed, try to clean up elegantly.
+	// The idea is to preserve the integrity of the database.
+	attachSignalHandler(db, mainQuit, compressor)
+
+	// Start the CPU profile after all of the data has been read.
+	common.StartCPUProfile()
+
+	// Compresses every sequence sent on seqChan. When sequences are sorted,
+	// they already carry the id of their position in the input. Otherwise,
+	// they are numbered as they arrive.
+	//
+	// false is returned if main needs to quit.
+	skip := consumed
+	compressAll := func(seqChan chan mica.ReadOriginalSeq) bool {
+		if firstId+consumed == 0 {
+			timer = time.Now()
+		}
+		for readSeq := range seqChan {
+			// Do a non-blocking receive to see if main needs to quit.
+			select {
+			case <-mainQuit:
+				<-mainQuit // wait for cleanup to finish before exiting main.
+				return false
+			default:
+			}
+
+			if readSeq.Err != nil {
+				log.Fatal(readSeq.Err)
+			}
+			if skip > 0 {
+				skip--
+				continue
+			}
+			id := firstId + consumed
+			if flagSortLength {
+				id = readSeq.Seq.Id
+			}
+			dbConf.BlastDBSize += uint64(readSeq.Seq.Len())
+			compressor.Compress(id, readSeq.Seq)
+			consumed++
+			verboseOutput(db, firstId+consumed)
+
+			if checkpointEvery > 0 && consumed%checkpointEvery == 0 {
+				compressor.Sync()
+				cp.Consumed, cp.BlastDBSize = consumed, dbConf.BlastDBSize
+				if err := db.SaveCheckpoint(cp); err != nil {
+					cli.Fatalf("Could not save checkpoint: %s\n", err)
+				}
+			}
+		}
+		return true
+	}
+	if flagSortLength {
+		inputs := make([]chan mica.ReadOriginalSeq, 0, flags.NArg()-1)
+		for _, arg := range flags.Args()[1:] {
+			seqChan, err := readInput(arg, filters)
+			if err != nil {
+				log.Fatal(err)
+			}
+			inputs = append(inputs, seqChan)
+		}
+		seqChan := mica.SortOriginalSeqs(inputs, firstId,
+			int64(flagSortMem)<<20, flagSortTempDir)
+		if !compressAll(seqChan) {
+			return
+		}
+	} else {
+		for _, arg := range flags.Args()[1:] {
+			seqChan, err := readInput(arg, filters)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !compressAll(seqChan) {
+				return
+			}
+		}
+	}
+	if filters.Len() > 0 {
+		mica.Vprintf("\n%s\n", filters.Report())
+	}
+	mica.Vprintln("\n")
+	mica.Vprintf("Wrote %s.\n", mica.FileCompressed)
+	mica.Vprintf("Wrote %s.\n", mica.FileIndex)
+
+	cleanup(db, compressor)
+
+	// The database is complete, so the checkpoint isn't needed any more.
+	if cp != nil {
+		if err := db.RemoveCheckpoint(cp); err != nil {
+			cli.Fatalf("Could not remove checkpoint: %s\n", err)
+		}
+	}
+}
+
+// seqFilters returns the filters set on the command line, in order.
+func seqFilters() (*mica.SeqFilters, error) {
+	filters := make([]mica.SeqFilter, 0, 6)
+	if flagMinLength > 0 {
+		filters = append(filters, mica.MinLength(flagMinLength))
+	}
+	if flagMaxLength > 0 {
+		filters = append(filters, mica.MaxLength(flagMaxLength))
+	}
+	if flagMaxAmbig < 1.0 {
+		filters = append(filters, mica.MaxAmbiguous(flagMaxAmbig))
+	}
+	if len(flagInclude) > 0 {
+		re, err := regexp.Compile(flagInclude)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'header-include': %s", err)
+		}
+		filters = append(filters, mica.HeaderInclude(re))
+	}
+	if len(flagExclude) > 0 {
+		re, err := regexp.Compile(flagExclude)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid 'header-exclude': %s", err)
+		}
+		filters = append(filters, mica.HeaderExclude(re))
+	}
+	if len(flagHeader) > 0 {
+		f, err := mica.HeaderTemplate(flagHeader)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, f)
+	}
+	return mica.NewSeqFilters(filters...), nil
+}
+
+// readInput reads the sequences in the input file 'arg' that are kept by
+// 'filters'.
+func readInput(
+	arg string, filters *mica.SeqFilters) (chan mica.ReadOriginalSeq, error) {
+
+	seqChan, err := mica.ReadOriginalSeqs(arg, ignoredResidues)
+	if err != nil {
+		return nil, err
+	}
+	if filters.Len() == 0 {
+		return seqChan, nil
+	}
+	return filters.Filter(seqChan), nil
+}
+
+// sameInputs returns true if the two lists of input files are the same.
+func sameInputs(inputs1, inputs2 []string) bool {
+	if len(inputs1) != len(inputs2) {
+		return false
+	}
+	for i := range inputs1 {
+		if inputs1[i] != inputs2[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// setFlags returns the names of the flags set explicitly on the command line,
+// whose values override the configuration of an existing database.
+func setFlags(flags *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool, 10)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// When the program ends (either by SIGTERM or when all of the input sequences
+// are compressed), 'cleanup' is executed. It writes all CPU/memory profiles
+// if they're enabled, waits for the compression workers to finish, saves
+// the database to disk and closes all file handles.
+func cleanup(db *mica.DB, compressor *mica.Compressor) {
+	mica.Vprintln("Cleaning up and saving.")
+	common.StopProfiles()
+	if len(flagMemStats) > 0 {
+		writeMemStats(fmt.Sprintf("%s.last", flagMemStats))
+	}
+	compressor.Done()
+	if stats := db.CoarseDB.Seeds.Stats(); stats.Evictions > 0 {
+		mica.Vprintf("Seeds table: %s.\n", stats)
+	}
+	if dups := db.Duplicates.Found(); dups > 0 {
+		mica.Vprintf("Short-circuited %d exact duplicates.\n", dups)
+	}
+	if err := db.Save(); err != nil {
+		cli.Fatalf("Could not save database: %s\n", err)
+	}
+	db.WriteClose()
+}
+
+// Runs a goroutine to listen for SIGTERM and SIGKILL.
+func attachSignalHandler(db *mica.DB, mainQuit chan struct{},
+	compressor *mica.Compressor) {
+
+	sigChan := make(chan os.Signal, 1)
+	go func() {
+		<-sigChan
+		mainQuit <- struct{}{}
+		cleanup(db, compressor)
+		mainQuit <- struct{}{}
+		os.Exit(0)
+	}()
+	signal.Notify(sigChan, os.Interrupt, os.Kill)
+}
+
+// The output generated after each sequence is compressed (or more precisely,
+// after some interval of sequences has been compressed).
+func verboseOutput(db *mica.DB, orgSeqId int) {
+
+	if orgSeqId%interval == 0 {
+		if !common.Quiet {
+			secElapsed := time.Since(timer).Seconds()
+			seqsPerSec := float64(interval) / float64(secElapsed)
+
+			fmt.Printf(
+				"\r%d sequences compressed (%0.4f seqs/sec, "+
+					"%d duplicates)",
+				orgSeqId, seqsPerSec, db.Duplicates.Found())
+			timer = time.Now()
+		}
+		if flagMemInterval {
+			if len(common.MemProfile) > 0 {
+				cli.WriteMemProfile(
+					fmt.Sprintf("%s.%d", common.MemProfile, orgSeqId))
+			}
+			if len(flagMemStats) > 0 {
+				writeMemStats(
+					fmt.Sprintf("%s.%d", flagMemStats, orgSeqId))
+			}
+		}
+	}
+}
+
+// A nasty function to format the runtime.MemStats struct for human
+// consumption.
+func writeMemStats(name string) {
+	f, err := os.Create(name)
+	if err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+
+	kb := uint64(1024)
+	mb := kb * 1024
+
+	ms := &runtime.MemStats{}
+	runtime.ReadMemStats(ms)
+	fmt.Fprintf(f,
+		`Alloc: %d MB
+TotalAlloc: %d MB
+Sys: %d MB
+Lookups: %d
+Mallocs: %d
+Frees: %d
+
+HeapAlloc: %d MB
+HeapSys: %d MB
+HeapIdle: %d MB
+HeapInuse: %d MB
+HeapReleased: %d B
+HeapObjects: %d
+
+StackInuse: %d
+StackSys: %d
+MSpanInuse: %d
+MSpanSys: %d
+MCacheInuse: %d
+MCacheSys: %d
+BuckHashSys: %d
+
+NextGC: %d
+LastGC: %d
+PauseTotalNs: %d s
+PauseNs: %d
+NumGC: %d
+`,
+		ms.Alloc/mb, ms.TotalAlloc/mb,
+		ms.Sys/mb, ms.Lookups, ms.Mallocs,
+		ms.Frees, ms.HeapAlloc/mb, ms.HeapSys/mb,
+		ms.HeapIdle/mb,
+		ms.HeapInuse/mb, ms.HeapReleased, ms.HeapObjects,
+		ms.StackInuse, ms.StackSys, ms.MSpanInuse, ms.MSpanSys,
+		ms.MCacheInuse, ms.MCacheSys, ms.BuckHashSys,
+		ms.NextGC, ms.LastGC, ms.PauseTotalNs/1000000000,
+		ms.PauseNs, ms.NumGC)
+
+	f.Close()
+}
@@ -0,0 +1,78 @@
+// Package decompress implements 'mica decompress' (and mica-decompress),
+// which writes every original sequence in a mica database to a FASTA file.
+package decompress
+
+import (
+	"flag"
+	"os"
+
+	"github.com/TuftsBCB/io/fasta"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+// The flags shared with other commands.
+var common *cli.Common
+
+// parseFlags parses the command line arguments 'args' of the command 'prog'.
+func parseFlags(prog string, args []string) *flag.FlagSet {
+	flags := cli.NewFlagSet(prog, "database-directory out-fasta-file")
+	common = cli.NewCommon(flags)
+
+	flags.Parse(args)
+	common.Setup()
+	return flags
+}
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command).
+func Main(prog string, args []string) {
+	flags := parseFlags(prog, args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+
+	// Open the fasta file specified for writing.
+	outFasta, err := os.Create(flags.Arg(1))
+	if err != nil {
+		cli.Fatalf("Could not write to '%s': %s\n", flags.Arg(1), err)
+	}
+	fastaWriter := fasta.NewWriter(outFasta)
+	fastaWriter.Asterisk = true
+
+	// Create a new database for writing. If we're appending, we load
+	// the coarse database into memory, and setup the database for writing.
+	db, err := mica.NewReadDB(flags.Arg(0))
+	if err != nil {
+		cli.Fatalf("Could not open '%s' database: %s\n", flags.Arg(0), err)
+	}
+	mica.Vprintln("")
+
+	// Start the CPU profile after all of the data has been read.
+	common.StartCPUProfile()
+
+	numSeqs := db.ComDB.NumSequences()
+	for orgSeqId := 0; orgSeqId < numSeqs; orgSeqId++ {
+		oseq, err := db.ComDB.ReadSeq(db.CoarseDB, orgSeqId)
+		if err != nil {
+			cli.Fatalf("Error reading seq id '%d': %s\n", orgSeqId, err)
+		}
+		if err := fastaWriter.Write(oseq.FastaSeq()); err != nil {
+			mica.Vprintf("Error writing seq '%s': %s\n", oseq.Name, err)
+		}
+	}
+
+	cleanup(db)
+	if err = fastaWriter.Flush(); err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+	if err = outFasta.Close(); err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+}
+
+func cleanup(db *mica.DB) {
+	common.StopProfiles()
+	db.ReadClose()
+}
@@ -0,0 +1,130 @@
+// Package hmmsearch implements 'mica hmmsearch' (and mica-hmmsearch), which
+// searches a mica database with profile HMMs, using HMMER's hmmsearch for
+// both stages of the search.
+package hmmsearch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+// The coarse FASTA file is searched with the HMMs (with a relaxed e-value),
+// and the coarse sequences that they hit are expanded into the original
+// sequences. These are written to a fine FASTA file, which the HMMs are
+// searched against again. E-values are computed for the number of original
+// sequences in the database (hmmsearch's '-Z'), so that they are those of a
+// search of the entire database.
+
+var (
+	// The flags shared with other commands.
+	common *cli.Common
+
+	// Flags that affect the operation of search.
+	flagHmmsearch = "hmmsearch"
+	flagTblOut    = ""
+	flagDomTblOut = ""
+	flagOutFmt    = ""
+)
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command).
+func Main(prog string, args []string) {
+	args, hmmArgs := cli.SplitArgs(args, "--hmmsearch-args")
+	searcher := mica.NewSearcher(nil)
+
+	flags := cli.NewFlagSet(prog,
+		"database-directory hmm-file "+
+			"[--hmmsearch-args HMMSEARCH_ARGUMENTS]")
+	common = cli.NewCommon(flags)
+	flags.StringVar(&flagHmmsearch, "hmmsearch", flagHmmsearch,
+		"The location of the 'hmmsearch' executable.")
+	flags.Float64Var(&searcher.CoarseEval, "coarse-eval", searcher.CoarseEval,
+		"The e-value threshold for the coarse search. This will NOT\n"+
+			"\tbe used on the fine search. The fine search thresholds can\n"+
+			"\tbe set in the 'hmmsearch-args' argument.")
+	flags.BoolVar(&searcher.NoCleanup, "no-cleanup", searcher.NoCleanup,
+		"When set, the temporary fine FASTA file that is created will\n"+
+			"\tNOT be deleted.")
+	flags.StringVar(&searcher.TempDir, "temp-dir", searcher.TempDir,
+		"The directory used for temporary files (including the fine\n"+
+			"\tdatabase). By default, the system's temporary directory is\n"+
+			"\tused.")
+	flags.StringVar(&flagTblOut, "tblout", flagTblOut,
+		"When set, the per-sequence table of the fine search is\n"+
+			"\twritten to this file, as hmmsearch's '--tblout' does. Its\n"+
+			"\ttargets are original sequences.")
+	flags.StringVar(&flagDomTblOut, "domtblout", flagDomTblOut,
+		"When set, the per-domain table of the fine search is written\n"+
+			"\tto this file, as hmmsearch's '--domtblout' does. Its targets\n"+
+			"\tare original sequences.")
+	flags.StringVar(&flagOutFmt, "outfmt", flagOutFmt,
+		"When set, MICA parses the domains found by the fine search\n"+
+			"\tand writes them as hits in this format: "+
+			strings.Join(mica.HitFormats, ", ")+".\n"+
+			"\tBy default, the output of hmmsearch is passed on as is.")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+	}
+	common.Setup()
+
+	if len(flagOutFmt) > 0 {
+		if err := mica.ValidHitFormat(flagOutFmt); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid 'outfmt': %s\n", err)
+			flags.Usage()
+		}
+		if len(flagTblOut) > 0 || len(flagDomTblOut) > 0 {
+			fmt.Fprintln(os.Stderr, "The 'tblout' and 'domtblout' flags "+
+				"can't be set along with 'outfmt'.")
+			flags.Usage()
+		}
+	}
+
+	coarse := mica.NewHmmAligner()
+	coarse.Exec = flagHmmsearch
+	fine := mica.NewHmmAligner()
+	fine.Exec = flagHmmsearch
+	fine.TblOut, fine.DomTblOut = flagTblOut, flagDomTblOut
+	searcher.Coarse, searcher.Fine = coarse, fine
+	searcher.Threads = common.GoMaxProcs
+	searcher.FineArgs = hmmArgs
+
+	db, err := mica.NewReadDB(flags.Arg(0))
+	if err != nil {
+		cli.Fatalf("Could not open '%s' database: %s\n", flags.Arg(0), err)
+	}
+	searcher.DB = db
+	common.StartCPUProfile()
+
+	hmms, err := ioutil.ReadFile(flags.Arg(1))
+	if err != nil {
+		cli.Fatalf("Could not read HMM file: %s\n", err)
+	}
+	if err := search(searcher, hmms); err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+
+	common.StopProfiles()
+	db.ReadClose()
+}
+
+// search runs the search for the HMMs and writes hmmsearch's output to
+// stdout, either as is or in the format given to 'outfmt'.
+func search(searcher *mica.Searcher, hmms []byte) error {
+	if len(flagOutFmt) == 0 {
+		return searcher.Search(hmms, os.Stdout)
+	}
+	hw, err := mica.NewHitWriter(os.Stdout, flagOutFmt)
+	if err != nil {
+		return err
+	}
+	if err := searcher.SearchHits(hmms, hw); err != nil {
+		return err
+	}
+	return hw.Close()
+}
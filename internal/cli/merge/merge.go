@@ -0,0 +1,82 @@
+// Package merge implements 'mica merge' (and mica-merge), which merges
+// compressed databases into one.
+package merge
+
+import (
+	"flag"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+var (
+	flagMakeBlastDB = mica.DefaultDBConf.BlastMakeBlastDB
+	flagDmnd        = mica.DefaultDBConf.Dmnd
+	flagReadOnly    = false
+	flagQuiet       = false
+)
+
+// parseFlags parses the command line arguments 'args' of the command 'prog'.
+func parseFlags(prog string, args []string) *flag.FlagSet {
+	flags := cli.NewFlagSet(prog,
+		"output-database-directory "+
+			"database-directory [database-directory ...]\n"+
+			"\nMerges compressed databases (e.g., the shards written by\n"+
+			"'mica shard', compressed separately) into one database. The\n"+
+			"coarse sequences of each database after the first are\n"+
+			"compressed against those of the databases before it, so that\n"+
+			"redundancy between databases is removed.")
+
+	flags.StringVar(&flagMakeBlastDB, "makeblastdb", flagMakeBlastDB,
+		"The location of the 'makeblastdb' executable.")
+	flags.StringVar(&flagDmnd, "diamond", flagDmnd,
+		"The location of the 'diamond' executable.")
+	flags.BoolVar(&flagReadOnly, "read-only", flagReadOnly,
+		"When set, the merged database will be read-only (i.e., it\n"+
+			"\tcannot be appended to), but it will be smaller.")
+	flags.BoolVar(&flagQuiet, "quiet", flagQuiet,
+		"When set, the only outputs will be errors echoed to stderr.")
+
+	flags.Parse(args)
+	return flags
+}
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command).
+func Main(prog string, args []string) {
+	flags := parseFlags(prog, args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+	if !flagQuiet {
+		mica.Verbose = true
+	}
+
+	// The merged database uses the parameters of the first shard.
+	first, err := mica.NewReadDB(flags.Arg(1))
+	if err != nil {
+		cli.Fatalf("Could not open '%s' database: %s\n", flags.Arg(1), err)
+	}
+	conf := first.DBConf.DeepCopy()
+	first.ReadClose()
+	conf.ReadOnly = flagReadOnly
+	conf.BlastDBSize = 0
+	conf.BlastMakeBlastDB = flagMakeBlastDB
+	conf.Dmnd = flagDmnd
+
+	db, err := mica.NewWriteDB(false, conf, nil, flags.Arg(0))
+	if err != nil {
+		cli.Fatalf("Could not create '%s' database: %s\n", flags.Arg(0), err)
+	}
+	mica.Vprintln("")
+
+	stats, err := db.Merge(flags.Args()[1:])
+	if err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+	if err := db.Save(); err != nil {
+		cli.Fatalf("Could not save database: %s\n", err)
+	}
+	db.WriteClose()
+	mica.Vprintf("Merged %s.\n", stats)
+}
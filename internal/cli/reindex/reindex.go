@@ -0,0 +1,68 @@
+// Package reindex implements 'mica reindex' (and mica-reindexer), which
+// rewrites the coarse FASTA file of a database and its index.
+package reindex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command).
+func Main(prog string, args []string) {
+	flags := cli.NewFlagSet(prog,
+		"database-directory\n"+
+			"\nWrites the coarse sequences of the database, named by their\n"+
+			"ids, to coarse.fasta.new and their index to\n"+
+			"coarse.fasta.index.new in the current directory.")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+
+	db, err := mica.NewReadDB(flags.Arg(0))
+	if err != nil {
+		cli.Fatalf("Failed to open database: %s\n", err)
+	}
+
+	byteOff := int64(0)
+	buf := new(bytes.Buffer)
+	newFastaFile, err := os.Create("coarse.fasta.new")
+	if err != nil {
+		cli.Fatalf("Failed to create new sequence file: %s\n", err)
+	}
+
+	newFastaIndex, err := os.Create("coarse.fasta.index.new")
+	if err != nil {
+		cli.Fatalf("Failed to create new index file: %s\n", err)
+	}
+
+	err = db.CoarseDB.LoadSeqs()
+	if err != nil {
+		cli.Fatalf("Failed to load coarse db sequences into memory: %s\n", err)
+	}
+
+	for i, seq := range db.CoarseDB.Seqs {
+		buf.Reset()
+		fmt.Fprintf(buf, ">%d\n%s\n", i, string(seq.Residues))
+		if _, err = newFastaFile.Write(buf.Bytes()); err != nil {
+			return
+		}
+
+		err = binary.Write(newFastaIndex, binary.BigEndian, byteOff)
+		if err != nil {
+			cli.Fatalf("Failed to write to new index file: %s\n", err)
+		}
+
+		byteOff += int64(buf.Len())
+	}
+
+	newFastaFile.Close()
+	newFastaIndex.Close()
+
+}
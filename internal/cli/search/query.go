@@ -0,0 +1,48 @@
+package search
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ndaniels/mica"
+)
+
+// searchCompressedQueries compresses the nucleotide queries in the file
+// 'queryFile' into a temporary query database, and searches its coarse
+// queries. The fine search's output is written to 'out' like search does.
+func searchCompressedQueries(
+	searcher *mica.Searcher, queryFile string, out io.Writer) error {
+
+	if len(flagQueryDBConf) > 0 {
+		f, err := os.Open(flagQueryDBConf)
+		if err != nil {
+			return fmt.Errorf("Could not open query database "+
+				"configuration: %s", err)
+		}
+		queryDBConf, err = mica.LoadDBConf(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("Could not load query database "+
+				"configuration: %s", err)
+		}
+	}
+
+	qdb, cleanup, err := searcher.CompressQueries(queryFile, queryDBConf)
+	if err != nil {
+		return fmt.Errorf("Could not compress queries: %s", err)
+	}
+	defer cleanup()
+
+	if len(flagOutFmt) == 0 {
+		return searcher.SearchQueryDB(qdb, out)
+	}
+	hw, err := mica.NewHitWriter(out, flagOutFmt)
+	if err != nil {
+		return err
+	}
+	if err := searcher.SearchQueryDBHits(qdb, hw); err != nil {
+		return err
+	}
+	return hw.Close()
+}
@@ -0,0 +1,423 @@
+// Package search implements 'mica search' (and mica-search, mica-psearch,
+// mica-xsearch, mica-psisearch and mica-deltasearch), which searches a mica
+// database with one of the BLAST programs. Either stage of the search may use
+// DIAMOND or MMseqs2 instead.
+package search
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+// A BLAST database is created on the reference sequence after compression.
+// The search program will blast the input query sequences against this
+// database (with a relaxed e-value), and expand the hits using links into an
+// in memory FASTA file. This FASTA file is passed to the stdin of a
+// `makeblastdb` command, which outputs the fine BLAST database. Finally, the
+// query sequences are blasted against this new database, and the hits are
+// returned unmodified.
+
+// Programs are the search programs, each of which is a subcommand of
+// 'mica search'.
+var Programs = []string{"blastp", "blastx", "psiblast", "deltablast"}
+
+// Aligners are the names of the aligners that may be given to
+// 'coarse-aligner' and 'fine-aligner'.
+var Aligners = mica.AlignerNames
+
+var (
+	// The flags shared with other commands.
+	common *cli.Common
+
+	// Flags that affect the operation of search.
+	// Flags that control the search are stored in the Searcher, while flags
+	// that control the aligners are used to build them once flags are
+	// parsed.
+	flagCoarseAligner  = ""
+	flagFineAligner    = ""
+	flagMakeBlastDB    = "makeblastdb"
+	flagProgramExec    = ""
+	flagIterations     = 1
+	flagInclusionEval  = 0.002
+	flagSavePSSM       = ""
+	flagRPSDB          = ""
+	flagDmnd           = "diamond"
+	flagDmndCoarseTop  = 50
+	flagDmndFineTop    = 60
+	flagDmndDaa        = false
+	flagMMseqs         = "mmseqs"
+	flagCoarseDmnd     = false
+	flagDmndFine       = ""
+	flagOutFmt         = ""
+	flagIterativeQuery = false
+	flagQueryBatch     = 1
+	flagCompressQuery  = false
+	flagQueryDBConf    = ""
+
+	// The configuration used to compress queries. (It is replaced when
+	// 'query-dbconf' is set.)
+	queryDBConf = mica.DefaultDBConf.DeepCopy()
+)
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command). The first argument is the search
+// program, one of Programs.
+func Main(prog string, args []string) {
+	if len(args) == 0 || !validProgram(args[0]) {
+		fmt.Fprintf(os.Stderr,
+			"\nUsage: %s %s [flags] database-directory query-fasta-file "+
+				"[--blast-args BLAST_ARGUMENTS]\n"+
+				"\nRun '%s PROGRAM --help' for the flags of each program.\n",
+			prog, strings.Join(Programs, "|"), prog)
+		os.Exit(1)
+	}
+	Program(prog+" "+args[0], args[0], args[1:])
+}
+
+func validProgram(program string) bool {
+	for _, p := range Programs {
+		if p == program {
+			return true
+		}
+	}
+	return false
+}
+
+// Program runs the command 'prog', which searches with 'program' (one of
+// Programs), with the command line arguments 'args'.
+func Program(prog, program string, args []string) {
+	args, blastArgs := cli.SplitArgs(args, "--blast-args")
+	searcher := mica.NewSearcher(nil)
+	flags := parseFlags(prog, program, searcher, args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+	}
+	if program == "deltablast" && len(flagRPSDB) == 0 {
+		fmt.Fprintln(os.Stderr, "The '--rps-db' flag must be set.")
+		flags.Usage()
+	}
+	searcher.Threads = common.GoMaxProcs
+	searcher.FineArgs = blastArgs
+
+	db, err := mica.NewReadDB(flags.Arg(0))
+	if err != nil {
+		cli.Fatalf("Could not open '%s' database: %s\n", flags.Arg(0), err)
+	}
+	searcher.DB = db
+	common.StartCPUProfile()
+
+	out, err := output()
+	if err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+	if flagCompressQuery {
+		mica.Vprintln("\nProcessing queries with query-side compression...")
+		err := searchCompressedQueries(searcher, flags.Arg(1), out)
+		if err != nil {
+			cli.Fatalf("Error processing queries with query-side "+
+				"compression: %s\n", err)
+		}
+	} else {
+		query, err := mica.ReadQueryFile(flags.Arg(1))
+		if err != nil {
+			cli.Fatalf("Could not read input fasta query: %s\n", err)
+		}
+		err = search(newQuerySearcher(searcher, program), query, out)
+		if err != nil {
+			cli.Fatalf("%s\n", err)
+		}
+	}
+	if out != os.Stdout {
+		if err := out.Close(); err != nil {
+			cli.Fatalf("%s\n", err)
+		}
+	}
+
+	cleanup(db)
+}
+
+// parseFlags configures 'searcher' for 'program' from the command line
+// arguments 'args' of the command 'prog'.
+func parseFlags(
+	prog, program string,
+	searcher *mica.Searcher,
+	args []string,
+) *flag.FlagSet {
+	flags := cli.NewFlagSet(prog,
+		"database-directory query-fasta-file "+
+			"[--blast-args BLAST_ARGUMENTS]")
+	common = cli.NewCommon(flags)
+
+	flagProgramExec = program
+	if program == "blastx" {
+		flagCoarseDmnd = true
+	}
+
+	flags.StringVar(&flagMakeBlastDB, "makeblastdb", flagMakeBlastDB,
+		"The location of the 'makeblastdb' executable.")
+	flags.StringVar(&flagProgramExec, program, flagProgramExec,
+		fmt.Sprintf("The location of the '%s' executable.", program))
+	flags.StringVar(&flagDmnd, "diamond", flagDmnd,
+		"The location of the 'diamond' executable.")
+	flags.StringVar(&flagMMseqs, "mmseqs", flagMMseqs,
+		"The location of the 'mmseqs' executable.")
+	flags.Float64Var(&searcher.CoarseEval, "coarse-eval", searcher.CoarseEval,
+		"The e-value threshold for the coarse search. This will NOT\n"+
+			"\tbe used on the fine search. The fine search e-value threshold\n"+
+			"\tcan be set in the 'blast-args' argument.")
+	flags.BoolVar(&searcher.NoCleanup, "no-cleanup", searcher.NoCleanup,
+		"When set, the temporary fine BLAST database that is created\n"+
+			"\twill NOT be deleted.")
+	flags.StringVar(&searcher.TempDir, "temp-dir", searcher.TempDir,
+		"The directory used for temporary files (including the fine\n"+
+			"\tdatabase). By default, the system's temporary directory is\n"+
+			"\tused.")
+	flags.StringVar(&flagCoarseAligner, "coarse-aligner", flagCoarseAligner,
+		"The aligner used for the coarse search: blast, diamond,\n"+
+			"\tmmseqs or native (which needs no external programs). By\n"+
+			"\tdefault, BLAST is used (unless 'coarse-diamond' is set).")
+	flags.IntVar(&flagDmndCoarseTop, "dmnd-coarse-match", flagDmndCoarseTop,
+		"The matching threshold for coarse search with diamond")
+	flags.StringVar(&flagOutFmt, "outfmt", flagOutFmt,
+		"When set, MICA parses the hits of the fine search itself and\n"+
+			"\twrites them in this format, whichever aligner found them:\n"+
+			"\t"+strings.Join(mica.HitFormats, ", ")+". Each hit's subject\n"+
+			"\tis an original sequence. By default, the output of the fine\n"+
+			"\tsearch's aligner is passed on as is.")
+
+	switch program {
+	case "blastp", "blastx":
+		flags.StringVar(&flagFineAligner, "fine-aligner", flagFineAligner,
+			"The aligner used for the fine search: blast, diamond,\n"+
+				"\tmmseqs or native. By default, BLAST is used (unless\n"+
+				"\t'dmnd-fine' is set). The fine search's output is that of\n"+
+				"\tthe aligner.")
+		flags.BoolVar(&flagCoarseDmnd, "coarse-diamond", flagCoarseDmnd,
+			"When set, DIAMOND is used for the coarse search.")
+		flags.StringVar(&flagDmndFine, "dmnd-fine", flagDmndFine,
+			"When set, DIAMOND is used for the fine search, and its\n"+
+				"\tresults are written to this file in BLAST tabular format.\n"+
+				"\t(See 'daa-file'.)")
+		flags.IntVar(&flagDmndFineTop, "dmnd-fine-match", flagDmndFineTop,
+			"The matching threshold for fine search with diamond\n"+
+				"\t(assuming diamond fine search is enabled).")
+		flags.BoolVar(&flagDmndDaa, "daa-file", flagDmndDaa,
+			"When set, will not convert diamonds final output into blast\n"+
+				"\ttabular format. A '.daa' extension is added to the file\n"+
+				"\tgiven to 'dmnd-fine'.")
+	case "psiblast":
+		flags.IntVar(&flagIterations, "iterations", flagIterations,
+			"Number of PSIBLAST iterations to perform. Each iteration\n"+
+				"\tsearches the coarse database and then the fine\n"+
+				"\tdatabase with the PSSM refined by the previous one.\n"+
+				"\tIterations stop early once the fine hits included in\n"+
+				"\tthe PSSM don't change.")
+		flags.Float64Var(&flagInclusionEval, "inclusion-eval",
+			flagInclusionEval,
+			"The e-value threshold for including a fine hit in the PSSM.")
+		flags.StringVar(&flagSavePSSM, "save-pssm", flagSavePSSM,
+			"When set, the last PSSM of each query is saved to this file.\n"+
+				"\tWith more than one query, the position of the query is\n"+
+				"\tadded to its name, e.g., 'query.pssm.2'.")
+	case "deltablast":
+		flags.StringVar(&flagRPSDB, "rps-db", flagRPSDB,
+			"The location of the 'rps' database. (Required.)")
+	}
+	if program == "psiblast" {
+		// Each query is always searched on its own, with its own PSSM.
+		flags.IntVar(&searcher.Workers, "query-workers", searcher.Workers,
+			"The number of queries searched at the same time. Threads\n"+
+				"\t('p') are shared between them.")
+	} else {
+		flags.BoolVar(&flagIterativeQuery, "iterative-queries",
+			flagIterativeQuery,
+			"When set, queries are searched in batches of 'query-batch'\n"+
+				"\tqueries, each with its own coarse hits and fine\n"+
+				"\tdatabase, instead of all at once. Results are written in\n"+
+				"\tthe order of the queries.")
+		flags.IntVar(&flagQueryBatch, "query-batch", flagQueryBatch,
+			"The number of queries in each batch when\n"+
+				"\t'iterative-queries' is set.")
+		flags.IntVar(&searcher.Workers, "query-workers", searcher.Workers,
+			"The number of batches searched at the same time when\n"+
+				"\t'iterative-queries' is set. Threads ('p') are shared\n"+
+				"\tbetween them.")
+	}
+	if program == "blastx" {
+		flags.BoolVar(&flagCompressQuery, "compress-query",
+			flagCompressQuery,
+			"When set, the nucleotide queries are compressed into a\n"+
+				"\ttemporary query database, whose coarse queries are\n"+
+				"\tsearched on the coarse database. This may result in very\n"+
+				"\tbad performance on a machine without a fast hard drive.")
+		flags.StringVar(&flagQueryDBConf, "query-dbconf",
+			flagQueryDBConf,
+			"Alternative conf file to use for query compression")
+	}
+
+	flags.Parse(args)
+	common.Setup()
+
+	if len(flagOutFmt) > 0 {
+		if err := mica.ValidHitFormat(flagOutFmt); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid 'outfmt': %s\n", err)
+			flags.Usage()
+		}
+		if flagDmndDaa {
+			fmt.Fprintln(os.Stderr,
+				"The 'daa-file' and 'outfmt' flags can't both be set.")
+			flags.Usage()
+		}
+	}
+
+	if flagCompressQuery && flagIterativeQuery {
+		fmt.Fprintln(os.Stderr, "The 'compress-query' and "+
+			"'iterative-queries' flags can't both be set.")
+		flags.Usage()
+	}
+	if program == "psiblast" && (flagIterations < 1 || searcher.Workers < 1) {
+		fmt.Fprintln(os.Stderr,
+			"The 'iterations' and 'query-workers' flags must be at least 1.")
+		flags.Usage()
+	}
+	if flagIterativeQuery {
+		if flagQueryBatch < 1 || searcher.Workers < 1 {
+			fmt.Fprintln(os.Stderr,
+				"The 'query-batch' and 'query-workers' flags must be "+
+					"at least 1.")
+			flags.Usage()
+		}
+		if flagDmndDaa {
+			fmt.Fprintln(os.Stderr, "The 'daa-file' and "+
+				"'iterative-queries' flags can't both be set.")
+			flags.Usage()
+		}
+		searcher.QueryBatch = flagQueryBatch
+	}
+
+	// 'coarse-diamond' and 'dmnd-fine' predate the choice of aligners, and
+	// only pick DIAMOND when no aligner is given explicitly.
+	if len(flagCoarseAligner) == 0 {
+		flagCoarseAligner = "blast"
+		if flagCoarseDmnd {
+			flagCoarseAligner = "diamond"
+		}
+	}
+	if len(flagFineAligner) == 0 {
+		flagFineAligner = "blast"
+		if len(flagDmndFine) > 0 {
+			flagFineAligner = "diamond"
+		}
+	}
+
+	var err error
+	searcher.Coarse, err = aligner(flagCoarseAligner, program,
+		flagDmndCoarseTop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid 'coarse-aligner': %s\n", err)
+		flags.Usage()
+	}
+	switch coarse := searcher.Coarse.(type) {
+	case *mica.MMseqsAligner:
+		coarse.Evalue = searcher.CoarseEval
+	case *mica.NativeAligner:
+		coarse.Evalue = searcher.CoarseEval
+	}
+	searcher.Fine, err = aligner(flagFineAligner, program, flagDmndFineTop)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid 'fine-aligner': %s\n", err)
+		flags.Usage()
+	}
+	return flags
+}
+
+// aligner returns the aligner called 'name' (one of Aligners) for a search
+// with 'program'. 'dmndTop' is DIAMOND's '--top' parameter for the stage of
+// the search the aligner is used for.
+func aligner(name, program string, dmndTop int) (mica.Aligner, error) {
+	a, err := mica.NewAligner(name, program)
+	if err != nil {
+		return nil, err
+	}
+	switch a := a.(type) {
+	case *mica.BlastAligner:
+		a.Exec = flagProgramExec
+		a.MakeBlastDB = flagMakeBlastDB
+		a.Iterations = flagIterations
+		a.RPSDB = flagRPSDB
+	case *mica.DmndAligner:
+		a.Exec = flagDmnd
+		a.Top = dmndTop
+		a.Daa = flagDmndDaa
+	case *mica.MMseqsAligner:
+		a.Exec = flagMMseqs
+	}
+	return a, nil
+}
+
+// A querySearcher searches FASTA formatted queries, like mica.Searcher and
+// mica.PSISearcher do.
+type querySearcher interface {
+	Search(query []byte, out io.Writer) error
+	SearchHits(query []byte, hw mica.HitWriter) error
+}
+
+// newQuerySearcher returns the searcher of the queries for 'program'.
+// PSI-BLAST iterates over coarse and fine searches.
+func newQuerySearcher(
+	searcher *mica.Searcher, program string) querySearcher {
+
+	if program != "psiblast" {
+		return searcher
+	}
+	psi := mica.NewPSISearcher(searcher)
+	psi.Iterations = flagIterations
+	psi.InclusionEval = flagInclusionEval
+	psi.SavePSSM = flagSavePSSM
+	return psi
+}
+
+// search runs the search for the queries and writes the fine search's output
+// to 'out', either as is or in the format given to 'outfmt'.
+func search(searcher querySearcher, query []byte, out io.Writer) error {
+	if len(flagOutFmt) == 0 {
+		return searcher.Search(query, out)
+	}
+	hw, err := mica.NewHitWriter(out, flagOutFmt)
+	if err != nil {
+		return err
+	}
+	if err := searcher.SearchHits(query, hw); err != nil {
+		return err
+	}
+	return hw.Close()
+}
+
+// output returns the destination of the fine search output. When a DIAMOND
+// fine search is requested, its results are written to the file given.
+// Otherwise, BLAST's output is passed on to stdout.
+func output() (*os.File, error) {
+	if len(flagDmndFine) == 0 {
+		return os.Stdout, nil
+	}
+	outName := flagDmndFine
+	if flagDmndDaa {
+		outName += ".daa"
+	}
+	out, err := os.Create(outName)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create '%s': %s", outName, err)
+	}
+	return out, nil
+}
+
+func cleanup(db *mica.DB) {
+	common.StopProfiles()
+	db.ReadClose()
+}
@@ -0,0 +1,437 @@
+package serve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ndaniels/mica"
+)
+
+// The states of a job.
+const (
+	statusQueued   = "queued"
+	statusRunning  = "running"
+	statusDone     = "done"
+	statusFailed   = "failed"
+	statusCanceled = "canceled"
+)
+
+// programs are the search programs that a job may use.
+var programs = []string{"blastp", "blastx", "psiblast"}
+
+// A request is the body of a job submission. Only the query is required.
+type request struct {
+	// The FASTA formatted queries.
+	Query string `json:"query"`
+
+	// The search program, one of programs. (blastp by default.)
+	Program string `json:"program"`
+
+	// The aligners used for each stage of the search, each one of
+	// mica.AlignerNames. (blast by default.)
+	CoarseAligner string `json:"coarse_aligner"`
+	FineAligner   string `json:"fine_aligner"`
+
+	// The e-value threshold for the coarse search. (The server's default
+	// when not given.)
+	CoarseEval *float64 `json:"coarse_eval"`
+
+	// The number of PSI-BLAST iterations, for psiblast. Each iteration
+	// searches the coarse and then the fine database. (See
+	// mica.PSISearcher.)
+	Iterations int `json:"iterations"`
+
+	// When greater than zero, queries are searched in batches of this many
+	// queries. (See mica.Searcher.QueryBatch.)
+	QueryBatch int `json:"query_batch"`
+
+	// Additional arguments given to the fine aligner, which must be blast.
+	// Only the options of mica.SafeBlastArgs are accepted, each followed by
+	// its value.
+	Args []string `json:"args"`
+}
+
+// validate fills in the defaults of a request, and returns an error if it
+// can't be searched.
+func (req *request) validate() error {
+	if len(strings.TrimSpace(req.Query)) == 0 {
+		return fmt.Errorf("No query given.")
+	}
+	if len(req.Program) == 0 {
+		req.Program = "blastp"
+	}
+	valid := false
+	for _, p := range programs {
+		valid = valid || p == req.Program
+	}
+	if !valid {
+		return fmt.Errorf("'%s' is not one of %s.",
+			req.Program, strings.Join(programs, ", "))
+	}
+	if len(req.CoarseAligner) == 0 {
+		req.CoarseAligner = "blast"
+	}
+	if len(req.FineAligner) == 0 {
+		req.FineAligner = "blast"
+	}
+	if req.CoarseEval == nil {
+		req.CoarseEval = &flagCoarseEval
+	}
+	if req.Iterations < 1 {
+		req.Iterations = 1
+	}
+	if len(req.Args) > 0 {
+		if req.FineAligner != "blast" {
+			return fmt.Errorf("Arguments can only be given to the blast " +
+				"fine aligner.")
+		}
+		if err := mica.CheckBlastArgs(req.Args); err != nil {
+			return fmt.Errorf("Invalid arguments: %s", err)
+		}
+	}
+	return nil
+}
+
+// A job is a search submitted to the server. Its fields are guarded by the
+// server's lock.
+type job struct {
+	id  string
+	req request
+
+	status                       string
+	err                          error
+	submitted, started, finished time.Time
+
+	// The hits found so far.
+	hits []mica.Hit
+
+	// Closed (and replaced) whenever hits are added or the status changes.
+	changed chan struct{}
+}
+
+// jobStatus describes a job in responses.
+type jobStatus struct {
+	Id        string     `json:"id"`
+	Status    string     `json:"status"`
+	Error     string     `json:"error,omitempty"`
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	NumHits   int        `json:"num_hits"`
+	Hits      []mica.Hit `json:"hits,omitempty"`
+}
+
+// describe returns the status of a job, along with its hits when 'hits' is
+// set. The server's lock must be held.
+func (j *job) describe(hits bool) jobStatus {
+	st := jobStatus{
+		Id:        j.id,
+		Status:    j.status,
+		Submitted: j.submitted,
+		NumHits:   len(j.hits),
+	}
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+	if !j.started.IsZero() {
+		st.Started = &j.started
+	}
+	if !j.finished.IsZero() {
+		st.Finished = &j.finished
+	}
+	if hits {
+		st.Hits = j.hits
+	}
+	return st
+}
+
+// over returns true if the job won't change anymore.
+func (j *job) over() bool {
+	return j.status != statusQueued && j.status != statusRunning
+}
+
+// notify wakes up everyone waiting for the job to change. The server's lock
+// must be held.
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// A jobs is the set of jobs known to the server, along with the queue of
+// jobs waiting to be searched.
+type jobs struct {
+	sync.Mutex
+	db     *mica.DB
+	byId   map[string]*job
+	queue  chan *job
+	nextId int
+	closed bool
+
+	// The directory in which each job gets a temporary directory.
+	tempDir string
+
+	// The native aligners of every job's coarse and fine searches are copies
+	// of these, so that they share the index each one keeps in memory.
+	coarseNative, fineNative *mica.NativeAligner
+
+	// Running jobs.
+	running sync.WaitGroup
+}
+
+// newJobs creates an empty set of jobs with a queue of 'size' jobs, whose
+// temporary directories are created in 'tempDir'.
+func newJobs(db *mica.DB, size int, tempDir string) *jobs {
+	return &jobs{
+		db:      db,
+		byId:    make(map[string]*job, 100),
+		queue:   make(chan *job, size),
+		tempDir: tempDir,
+
+		coarseNative: mica.NewNativeAligner(),
+		fineNative:   mica.NewNativeAligner(),
+	}
+}
+
+// errQueueFull is returned by submit when a job can't be queued.
+var errQueueFull = fmt.Errorf("The job queue is full. Try again later.")
+
+// errShutdown is returned by submit once the server is shutting down.
+var errShutdown = fmt.Errorf("The server is shutting down.")
+
+// submit queues a search for the request.
+func (js *jobs) submit(req request) (*job, error) {
+	js.Lock()
+	defer js.Unlock()
+
+	if js.closed {
+		return nil, errShutdown
+	}
+	js.forget()
+	js.nextId++
+	j := &job{
+		id:        fmt.Sprintf("%d", js.nextId),
+		req:       req,
+		status:    statusQueued,
+		submitted: time.Now(),
+		changed:   make(chan struct{}),
+	}
+	select {
+	case js.queue <- j:
+	default:
+		return nil, errQueueFull
+	}
+	js.byId[j.id] = j
+	return j, nil
+}
+
+// forget removes the jobs that finished more than 'job-ttl' ago. The lock
+// must be held.
+func (js *jobs) forget() {
+	for id, j := range js.byId {
+		if j.over() && time.Since(j.finished) > flagJobTTL {
+			delete(js.byId, id)
+		}
+	}
+}
+
+// get returns the job with the id given, or nil if there is none.
+func (js *jobs) get(id string) *job {
+	js.Lock()
+	defer js.Unlock()
+	return js.byId[id]
+}
+
+// remove cancels a queued job, or forgets a job that is over. Running jobs
+// can't be removed.
+func (js *jobs) remove(j *job) error {
+	js.Lock()
+	defer js.Unlock()
+
+	switch j.status {
+	case statusRunning:
+		return fmt.Errorf("Job %s is running, and can't be canceled.", j.id)
+	case statusQueued:
+		js.finish(j, statusCanceled, nil)
+	}
+	delete(js.byId, j.id)
+	return nil
+}
+
+// finish records the end of a job. The lock must be held.
+func (js *jobs) finish(j *job, status string, err error) {
+	j.status, j.err, j.finished = status, err, time.Now()
+	j.notify()
+}
+
+// work searches the jobs in the queue until it is closed.
+func (js *jobs) work() {
+	for j := range js.queue {
+		js.Lock()
+		if j.status != statusQueued {
+			js.Unlock()
+			continue
+		}
+		j.status, j.started = statusRunning, time.Now()
+		j.notify()
+		js.running.Add(1)
+		js.Unlock()
+
+		err := js.run(j)
+
+		js.Lock()
+		if err != nil {
+			js.finish(j, statusFailed, err)
+		} else {
+			js.finish(j, statusDone, nil)
+		}
+		js.Unlock()
+		js.running.Done()
+	}
+}
+
+// run searches a job's queries, in a temporary directory of its own.
+func (js *jobs) run(j *job) error {
+	mica.Vprintf("Searching job %s...\n", j.id)
+	dir, err := ioutil.TempDir(js.tempDir, "mica-job-")
+	if err != nil {
+		return fmt.Errorf("Could not create temporary directory: %s", err)
+	}
+	if !flagNoCleanup {
+		defer os.RemoveAll(dir)
+	}
+
+	searcher, err := js.newSearcher(j.req)
+	if err != nil {
+		return err
+	}
+	searcher.TempDir = dir
+	hw := jobHitWriter{js, j}
+	if j.req.Program == "psiblast" {
+		psi := mica.NewPSISearcher(searcher)
+		psi.Iterations = j.req.Iterations
+		err = psi.SearchHits([]byte(j.req.Query), hw)
+	} else {
+		err = searcher.SearchHits([]byte(j.req.Query), hw)
+	}
+	if err == mica.ErrNoCoarseHits {
+		err = nil
+	}
+	mica.Vprintf("Job %s is over.\n", j.id)
+	return err
+}
+
+// newSearcher returns a searcher for the request.
+func (js *jobs) newSearcher(req request) (*mica.Searcher, error) {
+	searcher := mica.NewSearcher(js.db)
+	var err error
+	searcher.Coarse, err = aligner(
+		req.CoarseAligner, req, flagDmndCoarseTop, js.coarseNative)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid coarse aligner: %s", err)
+	}
+	switch coarse := searcher.Coarse.(type) {
+	case *mica.MMseqsAligner:
+		coarse.Evalue = *req.CoarseEval
+	case *mica.NativeAligner:
+		coarse.Evalue = *req.CoarseEval
+	}
+	searcher.Fine, err = aligner(
+		req.FineAligner, req, flagDmndFineTop, js.fineNative)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid fine aligner: %s", err)
+	}
+	searcher.CoarseEval = *req.CoarseEval
+	searcher.Threads = common.GoMaxProcs / flagWorkers
+	if searcher.Threads < 1 {
+		searcher.Threads = 1
+	}
+	searcher.NoCleanup = flagNoCleanup
+	searcher.FineArgs = req.Args
+	searcher.QueryBatch = req.QueryBatch
+	return searcher, nil
+}
+
+// aligner returns the aligner called 'name' for a request. 'dmndTop' is
+// DIAMOND's '--top' parameter for the stage of the search the aligner is
+// used for, and 'native' is the native aligner that is copied for it.
+func aligner(name string, req request, dmndTop int,
+	native *mica.NativeAligner) (mica.Aligner, error) {
+
+	a, err := mica.NewAligner(name, req.Program)
+	if err != nil {
+		return nil, err
+	}
+	switch a := a.(type) {
+	case *mica.BlastAligner:
+		if len(flagBlastDir) > 0 {
+			a.Exec = filepath.Join(flagBlastDir, req.Program)
+			a.MakeBlastDB = filepath.Join(flagBlastDir, "makeblastdb")
+		}
+		a.Iterations = req.Iterations
+	case *mica.DmndAligner:
+		a.Exec = flagDmnd
+		a.Top = dmndTop
+	case *mica.MMseqsAligner:
+		a.Exec = flagMMseqs
+	case *mica.NativeAligner:
+		*a = *native
+	}
+	return a, nil
+}
+
+// jobHitWriter adds the hits of a job's search to the job.
+type jobHitWriter struct {
+	js *jobs
+	j  *job
+}
+
+func (hw jobHitWriter) Write(hit mica.Hit) error {
+	hw.js.Lock()
+	hw.j.hits = append(hw.j.hits, hit)
+	hw.j.notify()
+	hw.js.Unlock()
+	return nil
+}
+
+func (hw jobHitWriter) Close() error {
+	return nil
+}
+
+// close stops accepting jobs and cancels every queued job. Jobs that are
+// running are left to finish (see wait).
+func (js *jobs) close() {
+	js.Lock()
+	defer js.Unlock()
+
+	if js.closed {
+		return
+	}
+	js.closed = true
+	for _, j := range js.byId {
+		if j.status == statusQueued {
+			js.finish(j, statusCanceled, errShutdown)
+		}
+	}
+	close(js.queue)
+}
+
+// wait waits for the running jobs to finish, for at most 'timeout'. It
+// returns false if they didn't.
+func (js *jobs) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		js.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
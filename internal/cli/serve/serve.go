@@ -0,0 +1,351 @@
+// Package serve implements 'mica serve', which keeps a mica database open
+// and searches it for the clients of a local HTTP/JSON API. This saves the
+// cost of opening the database (and of starting a process) for every search.
+//
+// The API is:
+//
+//	GET    /               The server's database and the number of jobs.
+//	POST   /jobs           Submit a search. The body is a JSON request (see
+//	                       request), and the new job's status is returned.
+//	GET    /jobs           The status of every job.
+//	GET    /jobs/ID        The status of a job, with the hits found so far.
+//	GET    /jobs/ID/hits   The hits of a job, streamed until the job is over,
+//	                       in the format given by '?format=' (one of
+//	                       mica.HitFormats, jsonl by default).
+//	DELETE /jobs/ID        Cancel a queued job, or forget one that is over.
+//
+// Errors are JSON objects with an "error" field.
+package serve
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+var (
+	// The flags shared with other commands.
+	common *cli.Common
+
+	// Flags that affect the operation of the server.
+	flagAddr            = "localhost:8080"
+	flagWorkers         = 1
+	flagQueue           = 100
+	flagJobTTL          = time.Hour
+	flagShutdownTimeout = time.Minute
+	flagTempDir         = ""
+	flagNoCleanup       = false
+	flagCoarseEval      = 5.0
+	flagBlastDir        = ""
+	flagDmnd            = "diamond"
+	flagDmndCoarseTop   = 50
+	flagDmndFineTop     = 60
+	flagMMseqs          = "mmseqs"
+)
+
+// How long running jobs are waited for once their commands are killed.
+const killTimeout = 10 * time.Second
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command).
+func Main(prog string, args []string) {
+	flags := cli.NewFlagSet(prog, "database-directory")
+	common = cli.NewCommon(flags)
+	flags.StringVar(&flagAddr, "addr", flagAddr,
+		"The address the HTTP server listens on.")
+	flags.IntVar(&flagWorkers, "workers", flagWorkers,
+		"The number of jobs searched at the same time. Threads ('p')\n"+
+			"\tare shared between them.")
+	flags.IntVar(&flagQueue, "queue", flagQueue,
+		"The greatest number of jobs waiting to be searched. Jobs\n"+
+			"\tsubmitted while the queue is full are refused.")
+	flags.DurationVar(&flagJobTTL, "job-ttl", flagJobTTL,
+		"How long the results of a job are kept once it is over.")
+	flags.DurationVar(&flagShutdownTimeout, "shutdown-timeout",
+		flagShutdownTimeout,
+		"How long running jobs are waited for when the server is\n"+
+			"\tstopped (with SIGINT or SIGTERM), before the programs\n"+
+			"\tthey run are killed.")
+	flags.StringVar(&flagTempDir, "temp-dir", flagTempDir,
+		"The directory used for the temporary files of each job\n"+
+			"\t(including its fine database). By default, the system's\n"+
+			"\ttemporary directory is used.")
+	flags.BoolVar(&flagNoCleanup, "no-cleanup", flagNoCleanup,
+		"When set, the temporary files of each job are NOT deleted.")
+	flags.Float64Var(&flagCoarseEval, "coarse-eval", flagCoarseEval,
+		"The default e-value threshold for the coarse search of a job.")
+	flags.StringVar(&flagBlastDir, "blast-dir", flagBlastDir,
+		"The directory with the BLAST executables (including\n"+
+			"\t'makeblastdb'). By default, they are looked for in PATH.")
+	flags.StringVar(&flagDmnd, "diamond", flagDmnd,
+		"The location of the 'diamond' executable.")
+	flags.IntVar(&flagDmndCoarseTop, "dmnd-coarse-match", flagDmndCoarseTop,
+		"The matching threshold for coarse search with diamond")
+	flags.IntVar(&flagDmndFineTop, "dmnd-fine-match", flagDmndFineTop,
+		"The matching threshold for fine search with diamond")
+	flags.StringVar(&flagMMseqs, "mmseqs", flagMMseqs,
+		"The location of the 'mmseqs' executable.")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+	if flagWorkers < 1 || flagQueue < 1 {
+		fmt.Fprintln(os.Stderr,
+			"The 'workers' and 'queue' flags must be at least 1.")
+		flags.Usage()
+	}
+	common.Setup()
+
+	db, err := mica.NewReadDB(flags.Arg(0))
+	if err != nil {
+		cli.Fatalf("Could not open '%s' database: %s\n", flags.Arg(0), err)
+	}
+	defer db.ReadClose()
+
+	tempDir, err := ioutil.TempDir(flagTempDir, "mica-serve-")
+	if err != nil {
+		cli.Fatalf("Could not create temporary directory: %s\n", err)
+	}
+	if !flagNoCleanup {
+		defer os.RemoveAll(tempDir)
+	}
+
+	js := newJobs(db, flagQueue, tempDir)
+	for i := 0; i < flagWorkers; i++ {
+		go js.work()
+	}
+	srv := &http.Server{Addr: flagAddr, Handler: &handler{js}}
+
+	// Shut down gracefully on SIGINT or SIGTERM: stop accepting jobs, cancel
+	// the queued ones and wait (for a while) for the running ones, whose
+	// commands are then killed. The temporary directories are removed (and
+	// the database closed) once Main returns.
+	stopped := make(chan struct{})
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		<-sigs
+		mica.Vprintln("Shutting down...")
+		js.close()
+		if !js.wait(flagShutdownTimeout) {
+			mica.Vprintln("Some jobs are still running. Killing them.")
+			mica.KillCommands()
+			if !js.wait(killTimeout) {
+				mica.Vprintln("Giving up on the jobs still running.")
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		close(stopped)
+	}()
+
+	mica.Vprintf("Serving %s at http://%s/\n", db.Path, flagAddr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		cli.Fatalf("%s\n", err)
+	}
+	<-stopped
+}
+
+// handler serves the API of the server.
+type handler struct {
+	js *jobs
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/" && r.Method == "GET":
+		h.info(w)
+	case parts[0] != "jobs" || len(parts) > 3:
+		writeError(w, http.StatusNotFound, fmt.Errorf("Not found."))
+	case len(parts) == 1 && r.Method == "POST":
+		h.submit(w, r)
+	case len(parts) == 1 && r.Method == "GET":
+		h.list(w)
+	case len(parts) == 1:
+		writeError(w, http.StatusMethodNotAllowed,
+			fmt.Errorf("Use GET or POST."))
+	default:
+		j := h.js.get(parts[1])
+		if j == nil {
+			writeError(w, http.StatusNotFound,
+				fmt.Errorf("There is no job %s.", parts[1]))
+			return
+		}
+		switch {
+		case len(parts) == 3 && parts[2] == "hits" && r.Method == "GET":
+			h.hits(w, r, j)
+		case len(parts) == 3:
+			writeError(w, http.StatusNotFound, fmt.Errorf("Not found."))
+		case r.Method == "GET":
+			h.js.Lock()
+			st := j.describe(true)
+			h.js.Unlock()
+			writeJSON(w, http.StatusOK, st)
+		case r.Method == "DELETE":
+			if err := h.js.remove(j); err != nil {
+				writeError(w, http.StatusConflict, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed,
+				fmt.Errorf("Use GET or DELETE."))
+		}
+	}
+}
+
+// info describes the server.
+func (h *handler) info(w http.ResponseWriter) {
+	h.js.Lock()
+	counts := make(map[string]int, 5)
+	for _, j := range h.js.byId {
+		counts[j.status]++
+	}
+	h.js.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"database": h.js.db.Path,
+		"jobs":     counts,
+		"queue":    flagQueue,
+		"workers":  flagWorkers,
+	})
+}
+
+// submit queues a new job.
+func (h *handler) submit(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest,
+			fmt.Errorf("Could not read request: %s", err))
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if _, err := h.js.newSearcher(req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	j, err := h.js.submit(req)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	mica.Vprintf("Queued job %s.\n", j.id)
+	h.js.Lock()
+	st := j.describe(false)
+	h.js.Unlock()
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(w, http.StatusAccepted, st)
+}
+
+// list describes every job, in the order they were submitted.
+func (h *handler) list(w http.ResponseWriter) {
+	h.js.Lock()
+	h.js.forget()
+	sts := make([]jobStatus, 0, len(h.js.byId))
+	for _, j := range h.js.byId {
+		sts = append(sts, j.describe(false))
+	}
+	h.js.Unlock()
+	sort.Slice(sts, func(i, j int) bool {
+		a, _ := strconv.Atoi(sts[i].Id)
+		b, _ := strconv.Atoi(sts[j].Id)
+		return a < b
+	})
+	writeJSON(w, http.StatusOK, sts)
+}
+
+// hits writes the hits of a job as they are found, until the job is over or
+// the client goes away.
+func (h *handler) hits(w http.ResponseWriter, r *http.Request, j *job) {
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = mica.HitFormatJSON
+	}
+	if err := mica.ValidHitFormat(format); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch format {
+	case mica.HitFormatJSON:
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	case mica.HitFormatXML:
+		w.Header().Set("Content-Type", "application/xml")
+	default:
+		w.Header().Set("Content-Type", "text/plain")
+	}
+	fw := flushWriter{w: w}
+	fw.f, _ = w.(http.Flusher)
+	hw, err := mica.NewHitWriter(fw, format)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	written := 0
+	for {
+		h.js.Lock()
+		hits := j.hits[written:]
+		over, changed := j.over(), j.changed
+		h.js.Unlock()
+
+		for _, hit := range hits {
+			if err := hw.Write(hit); err != nil {
+				return
+			}
+		}
+		written += len(hits)
+		if over {
+			hw.Close()
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// flushWriter flushes a response each time it is written to, so that hits
+// are sent as soon as a HitWriter writes them.
+type flushWriter struct {
+	w io.Writer
+	f http.Flusher
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if fw.f != nil {
+		fw.f.Flush()
+	}
+	return n, err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
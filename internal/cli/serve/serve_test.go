@@ -0,0 +1,296 @@
+package serve
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+// The sequences of the test database. Jobs search them with the native
+// aligner, so no search program is needed.
+var testSeqs = []string{
+	"MKVLAAGIVAWHKRTPEDLCNYQFGSMKVLAAGIVAWHKRTPEDLCNYQFGSTTRQPLEDKW",
+	"GSHMLEDPVAGKTRWNQYFCEIHLPSTDAMKGRVEYWQNHFLDKAPTSMCGEIRLVNAQDW",
+}
+
+// testServer is a server of a database of testSeqs. The temporary
+// directories of its jobs are made in tempDir.
+type testServer struct {
+	*httptest.Server
+	tempDir string
+}
+
+// newTestServer starts a server with 'workers' workers and a queue of
+// 'queue' jobs. The function returned stops it and removes its files.
+func newTestServer(t *testing.T, workers, queue int) (*testServer, func()) {
+	dir, err := ioutil.TempDir("", "mica-test-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The BLAST and DIAMOND databases aren't needed, so they are made by a
+	// program that does nothing.
+	noop := path.Join(dir, "noop")
+	if err := ioutil.WriteFile(noop, []byte("#!/bin/sh\n"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	conf := mica.DefaultDBConf.DeepCopy()
+	conf.BlastMakeBlastDB, conf.Dmnd = noop, noop
+	dbDir := path.Join(dir, "db")
+	db, err := mica.NewWriteDB(false, conf, nil, dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := mica.NewCompressor(db)
+	c.Start()
+	for i, seq := range testSeqs {
+		name := fmt.Sprintf("s%d test sequence", i)
+		c.Compress(i, mica.NewOriginalSeq(i, name, []byte(seq)))
+	}
+	c.Done()
+	if err := db.Save(); err != nil {
+		t.Fatal(err)
+	}
+	db.WriteClose()
+	if db, err = mica.NewReadDB(dbDir); err != nil {
+		t.Fatal(err)
+	}
+
+	tempDir := path.Join(dir, "tmp")
+	if err := os.Mkdir(tempDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	common = &cli.Common{GoMaxProcs: 1}
+	js := newJobs(db, queue, tempDir)
+	for i := 0; i < workers; i++ {
+		go js.work()
+	}
+	srv := httptest.NewServer(&handler{js})
+	stop := func() {
+		srv.Close()
+		js.close()
+		js.wait(killTimeout)
+		db.ReadClose()
+		os.RemoveAll(dir)
+	}
+	return &testServer{srv, tempDir}, stop
+}
+
+// do sends a request with the JSON body given (if any), and decodes the JSON
+// response into 'v' (if it isn't nil). It returns the response's status.
+func (ts *testServer) do(t *testing.T, method, url string, body interface{},
+	v interface{}) int {
+
+	var reqBody *strings.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = strings.NewReader(string(bs))
+	} else {
+		reqBody = strings.NewReader("")
+	}
+	req, err := http.NewRequest(method, ts.URL+url, reqBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Could not decode the response to %s %s: %s",
+				method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// wait polls a job until it is over, and returns its last status.
+func (ts *testServer) wait(t *testing.T, id string) jobStatus {
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		var st jobStatus
+		if code := ts.do(t, "GET", "/jobs/"+id, nil, &st); code != 200 {
+			t.Fatalf("Polling job %s returned %d.", id, code)
+		}
+		if st.Status != statusQueued && st.Status != statusRunning {
+			return st
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %s never finished.", id)
+	return jobStatus{}
+}
+
+// nativeRequest is a request for a search of the sequence given with the
+// native aligner.
+func nativeRequest(seq string) request {
+	return request{
+		Query:         ">q\n" + seq + "\n",
+		CoarseAligner: "native",
+		FineAligner:   "native",
+	}
+}
+
+func TestSubmitAndPoll(t *testing.T) {
+	ts, stop := newTestServer(t, 1, 10)
+	defer stop()
+
+	var st jobStatus
+	code := ts.do(t, "POST", "/jobs", nativeRequest(testSeqs[1]), &st)
+	if code != http.StatusAccepted {
+		t.Fatalf("Submitting a job returned %d.", code)
+	}
+	st = ts.wait(t, st.Id)
+	if st.Status != statusDone || st.NumHits == 0 ||
+		len(st.Hits) != st.NumHits {
+		t.Fatalf("Unexpected status of a finished job: %+v", st)
+	}
+	if hit := st.Hits[0]; hit.SubjectId != "s1" || hit.OriginalId != 1 ||
+		hit.Backend != "native" {
+		t.Fatalf("Unexpected best hit: %+v", hit)
+	}
+
+	// The hits are streamed in the format asked for.
+	resp, err := http.Get(ts.URL + "/jobs/" + st.Id + "/hits?format=jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines++
+	}
+	resp.Body.Close()
+	if lines != st.NumHits {
+		t.Fatalf("Expected %d streamed hits, but got %d.", st.NumHits, lines)
+	}
+
+	var sts []jobStatus
+	if ts.do(t, "GET", "/jobs", nil, &sts); len(sts) != 1 ||
+		sts[0].Id != st.Id {
+		t.Fatalf("Expected job %s to be listed, but got %+v.", st.Id, sts)
+	}
+	if code := ts.do(t, "DELETE", "/jobs/"+st.Id, nil, nil); code !=
+		http.StatusNoContent {
+		t.Fatalf("Removing a finished job returned %d.", code)
+	}
+	if code := ts.do(t, "GET", "/jobs/"+st.Id, nil, nil); code !=
+		http.StatusNotFound {
+		t.Fatalf("Getting a removed job returned %d.", code)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	// Without workers, jobs stay in the queue.
+	ts, stop := newTestServer(t, 0, 1)
+	defer stop()
+
+	var st jobStatus
+	code := ts.do(t, "POST", "/jobs", nativeRequest(testSeqs[0]), &st)
+	if code != http.StatusAccepted {
+		t.Fatalf("Submitting a job returned %d.", code)
+	}
+	var errResp map[string]string
+	code = ts.do(t, "POST", "/jobs", nativeRequest(testSeqs[0]), &errResp)
+	if code != http.StatusServiceUnavailable ||
+		errResp["error"] != errQueueFull.Error() {
+		t.Fatalf("Submitting a job to a full queue returned %d: %v",
+			code, errResp)
+	}
+
+	// A queued job can be canceled.
+	if code := ts.do(t, "DELETE", "/jobs/"+st.Id, nil, nil); code !=
+		http.StatusNoContent {
+		t.Fatalf("Canceling a queued job returned %d.", code)
+	}
+	var sts []jobStatus
+	if ts.do(t, "GET", "/jobs", nil, &sts); len(sts) != 0 {
+		t.Fatalf("Expected no jobs, but got %+v.", sts)
+	}
+}
+
+func TestRejectedRequests(t *testing.T) {
+	ts, stop := newTestServer(t, 0, 10)
+	defer stop()
+
+	withArgs := func(fine string, args ...string) request {
+		req := nativeRequest(testSeqs[0])
+		req.FineAligner, req.Args = fine, args
+		return req
+	}
+	tests := []struct {
+		req    request
+		reason string
+	}{
+		{withArgs("blast", "-out", "/tmp/hits"), "an output file"},
+		{withArgs("blast", "-remote"), "an option that isn't whitelisted"},
+		{withArgs("blast", "-evalue"), "an option without its value"},
+		{withArgs("native", "-evalue", "1e-5"), "arguments to native"},
+		{request{Query: " \n"}, "no query"},
+		{request{Query: ">q\nMKV\n", Program: "tblastn"}, "a bad program"},
+		{request{Query: ">q\nMKV\n", CoarseAligner: "x"}, "a bad aligner"},
+	}
+	for _, test := range tests {
+		var errResp map[string]string
+		code := ts.do(t, "POST", "/jobs", test.req, &errResp)
+		if code != http.StatusBadRequest || len(errResp["error"]) == 0 {
+			t.Fatalf("A request with %s returned %d: %v",
+				test.reason, code, errResp)
+		}
+	}
+	var sts []jobStatus
+	if ts.do(t, "GET", "/jobs", nil, &sts); len(sts) != 0 {
+		t.Fatalf("Expected no jobs, but got %+v.", sts)
+	}
+}
+
+func TestJobTempDirs(t *testing.T) {
+	ts, stop := newTestServer(t, 2, 10)
+	defer stop()
+
+	// The fine search of the second job fails, since there is no BLAST.
+	defer func(dir string) { flagBlastDir = dir }(flagBlastDir)
+	flagBlastDir = path.Join(ts.tempDir, "no-such-blast")
+	failing := nativeRequest(testSeqs[0])
+	failing.FineAligner = "blast"
+
+	want := []string{statusDone, statusFailed}
+	for i, req := range []request{nativeRequest(testSeqs[0]), failing} {
+		var st jobStatus
+		if code := ts.do(t, "POST", "/jobs", req, &st); code !=
+			http.StatusAccepted {
+			t.Fatalf("Submitting job %d returned %d.", i, code)
+		}
+		if st = ts.wait(t, st.Id); st.Status != want[i] {
+			t.Fatalf("Job %d should be %s, but it is %s (%s).",
+				i, want[i], st.Status, st.Error)
+		}
+	}
+
+	// A job's directory is removed before the job is over, whether it
+	// failed or not.
+	left, err := ioutil.ReadDir(ts.tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) > 0 {
+		t.Fatalf("Expected no temporary files, but %s was left.",
+			left[0].Name())
+	}
+}
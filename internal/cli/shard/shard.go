@@ -0,0 +1,113 @@
+// Package shard implements 'mica shard' (and mica-shard), which splits
+// FASTA files into shards that can be compressed separately.
+package shard
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/TuftsBCB/io/fasta"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+var (
+	flagShards    = 2
+	flagPartition = mica.PartitionHash
+	flagKmerSize  = 8
+	flagQuiet     = false
+)
+
+// parseFlags parses the command line arguments 'args' of the command 'prog'.
+func parseFlags(prog string, args []string) *flag.FlagSet {
+	flags := cli.NewFlagSet(prog,
+		"output-prefix fasta-file [fasta-file ...]\n"+
+			"\nSplits the input into shards named output-prefix.N.fasta,\n"+
+			"which can be compressed separately and merged with\n"+
+			"'mica merge'.")
+
+	flags.IntVar(&flagShards, "shards", flagShards,
+		"The number of shards to split the input into.")
+	flags.StringVar(&flagPartition, "partition", flagPartition,
+		"How sequences are assigned to shards. 'hash' spreads them\n"+
+			"\tevenly by a hash of their residues (so exact duplicates\n"+
+			"\tare always in the same shard). 'minimizer' assigns them by\n"+
+			"\ttheir smallest K-mer, so that similar sequences tend to be\n"+
+			"\tin the same shard. (See 'kmer-size'.)")
+	flags.IntVar(&flagKmerSize, "kmer-size", flagKmerSize,
+		"The size of the K-mers used when 'partition' is 'minimizer'.")
+	flags.BoolVar(&flagQuiet, "quiet", flagQuiet,
+		"When set, the only outputs will be errors echoed to stderr.")
+
+	flags.Parse(args)
+	return flags
+}
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command).
+func Main(prog string, args []string) {
+	flags := parseFlags(prog, args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+	if flagShards < 1 {
+		cli.Fatalf("The number of shards must be at least 1.\n")
+	}
+	if flagKmerSize < 1 {
+		cli.Fatalf("The K-mer size must be at least 1.\n")
+	}
+	switch flagPartition {
+	case mica.PartitionHash, mica.PartitionMinimizer:
+	default:
+		cli.Fatalf("Unknown partition '%s'.\n", flagPartition)
+	}
+	if !flagQuiet {
+		mica.Verbose = true
+	}
+
+	prefix := flags.Arg(0)
+	files := make([]*os.File, flagShards)
+	writers := make([]*fasta.Writer, flagShards)
+	counts := make([]int, flagShards)
+	for i := range files {
+		name := fmt.Sprintf("%s.%d.fasta", prefix, i)
+		f, err := os.Create(name)
+		if err != nil {
+			cli.Fatalf("Could not create '%s': %s\n", name, err)
+		}
+		files[i] = f
+		writers[i] = fasta.NewWriter(f)
+	}
+
+	for _, arg := range flags.Args()[1:] {
+		seqChan, err := mica.ReadOriginalSeqs(arg, nil)
+		if err != nil {
+			cli.Fatalf("Could not read '%s': %s\n", arg, err)
+		}
+		for readSeq := range seqChan {
+			if readSeq.Err != nil {
+				cli.Fatalf("Could not read '%s': %s\n", arg, readSeq.Err)
+			}
+			oseq := readSeq.Seq
+			shard := mica.ShardOf(
+				oseq.Residues, flagShards, flagPartition, flagKmerSize)
+			if err := writers[shard].Write(oseq.FastaSeq()); err != nil {
+				cli.Fatalf("Could not write '%s': %s\n",
+					files[shard].Name(), err)
+			}
+			counts[shard]++
+		}
+	}
+
+	for i, f := range files {
+		if err := writers[i].Flush(); err != nil {
+			cli.Fatalf("Could not write '%s': %s\n", f.Name(), err)
+		}
+		if err := f.Close(); err != nil {
+			cli.Fatalf("Could not write '%s': %s\n", f.Name(), err)
+		}
+		mica.Vprintf("Wrote %d sequences to %s.\n", counts[i], f.Name())
+	}
+}
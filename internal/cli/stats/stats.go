@@ -0,0 +1,57 @@
+// Package stats implements 'mica stats', which describes a mica database:
+// how many sequences and residues it holds, and how well they compressed.
+package stats
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command).
+func Main(prog string, args []string) {
+	flags := cli.NewFlagSet(prog, "database-directory")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+
+	db, err := mica.NewReadDB(flags.Arg(0))
+	if err != nil {
+		cli.Fatalf("Could not open '%s' database: %s\n", flags.Arg(0), err)
+	}
+	defer db.ReadClose()
+
+	if err := db.CoarseDB.LoadSeqs(); err != nil {
+		cli.Fatalf("Could not read coarse sequences: %s\n", err)
+	}
+	coarseResidues := 0
+	for _, seq := range db.CoarseDB.Seqs {
+		coarseResidues += len(seq.Residues)
+	}
+	ratio := 0.0
+	if db.BlastDBSize > 0 {
+		ratio = float64(coarseResidues) / float64(db.BlastDBSize)
+	}
+	size, err := cli.DirSize(db.Path)
+	if err != nil {
+		cli.Fatalf("Could not measure '%s': %s\n", db.Path, err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Database:\t%s\n", db.Path)
+	fmt.Fprintf(w, "Original sequences:\t%d\n", db.ComDB.NumSequences())
+	fmt.Fprintf(w, "Original residues:\t%d\n", db.BlastDBSize)
+	fmt.Fprintf(w, "Coarse sequences:\t%d\n", len(db.CoarseDB.Seqs))
+	fmt.Fprintf(w, "Coarse residues:\t%d\n", coarseResidues)
+	fmt.Fprintf(w, "Compression ratio:\t%.4f\n", ratio)
+	fmt.Fprintf(w, "Read-only:\t%t\n", db.ReadOnly)
+	fmt.Fprintf(w, "Size on disk:\t%.1f MB\n", float64(size)/(1024*1024))
+	if err := w.Flush(); err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+}
@@ -0,0 +1,131 @@
+package tune
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/TuftsBCB/io/fasta"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+// result is what was measured for a single setting.
+type result struct {
+	// The wall clock time taken by mica-compress (including the creation of
+	// the coarse BLAST and DIAMOND databases), and its peak resident memory
+	// in bytes. (Zero if the platform doesn't report it.)
+	seconds float64
+	maxRSS  int64
+
+	// The fraction of residues left in the coarse database, and the size
+	// of the whole compressed database in bytes.
+	ratio  float64
+	dbSize int64
+
+	seqsPerSec float64
+
+	// The fraction of hits found by BLASTP on the uncompressed sample that
+	// were also found by a compressive search.
+	recall float64
+
+	dbDir string
+}
+
+// compress runs mica-compress on 'fastaFile' with the given setting.
+func compress(
+	dbDir, fastaFile string,
+	s setting,
+	extraArgs []string,
+) (*result, error) {
+	// Each database directory belongs to this work directory, so it's safe
+	// to remove one left over from an earlier run.
+	if err := os.RemoveAll(dbDir); err != nil {
+		return nil, err
+	}
+
+	args := append([]string{"--quiet"}, extraArgs...)
+	args = append(args, s.args()...)
+	args = append(args, dbDir, fastaFile)
+	cmd := exec.Command(flagMicaCompress, args...)
+
+	timer := time.Now()
+	if err := mica.Exec(cmd); err != nil {
+		return nil, err
+	}
+	return &result{
+		seconds: time.Since(timer).Seconds(),
+		maxRSS:  maxRSS(cmd.ProcessState),
+		dbDir:   dbDir,
+	}, nil
+}
+
+// measure computes the compression ratio and database size of the result.
+func (r *result) measure(sample sampleInfo) error {
+	f, err := os.Open(path.Join(r.dbDir, mica.FileCoarseFasta))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	coarseResidues := 0
+	reader := fasta.NewReader(f)
+	for {
+		seq, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Could not read '%s': %s", f.Name(), err)
+		}
+		coarseResidues += len(seq.Residues)
+	}
+	if sample.residues > 0 {
+		r.ratio = float64(coarseResidues) / float64(sample.residues)
+	}
+	if r.seconds > 0 {
+		r.seqsPerSec = float64(sample.seqs) / r.seconds
+	}
+
+	r.dbSize, err = cli.DirSize(r.dbDir)
+	return err
+}
+
+// writeHeader writes the header of the comparison table.
+func writeHeader(w io.Writer, g grid, withRecall bool) error {
+	columns := append([]string{}, g.names...)
+	columns = append(columns,
+		"ratio", "db-mb", "seconds", "seqs-per-sec", "max-rss-mb")
+	if withRecall {
+		columns = append(columns, "recall")
+	}
+	_, err := fmt.Fprintln(w, strings.Join(columns, "\t"))
+	return err
+}
+
+// write writes the result as a row of the comparison table.
+func (r *result) write(w io.Writer, s setting, withRecall bool) error {
+	columns := make([]string, 0, len(s)+6)
+	for _, p := range s {
+		columns = append(columns, p.value)
+	}
+	mb := func(bytes int64) string {
+		return fmt.Sprintf("%.1f", float64(bytes)/(1024*1024))
+	}
+	columns = append(columns,
+		fmt.Sprintf("%.4f", r.ratio),
+		mb(r.dbSize),
+		fmt.Sprintf("%.1f", r.seconds),
+		fmt.Sprintf("%.1f", r.seqsPerSec),
+		mb(r.maxRSS))
+	if withRecall {
+		columns = append(columns, fmt.Sprintf("%.4f", r.recall))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(columns, "\t"))
+	return err
+}
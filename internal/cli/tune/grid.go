@@ -0,0 +1,101 @@
+package tune
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The mica-compress flags that can be tuned. Each corresponds to a field of
+// mica.DBConf.
+var tunable = map[string]bool{
+	"min-match-len":          true,
+	"match-kmer-size":        true,
+	"gapped-window-size":     true,
+	"ungapped-window-size":   true,
+	"ext-seq-id-threshold":   true,
+	"match-seq-id-threshold": true,
+	"match-criterion":        true,
+	"ext-bits-threshold":     true,
+	"match-bits-threshold":   true,
+	"match-extend":           true,
+	"map-seed-size":          true,
+	"ext-seed-size":          true,
+	"low-complexity":         true,
+	"seed-low-complexity":    true,
+}
+
+// A grid is a list of parameters, each with the values to try.
+type grid struct {
+	names  []string
+	values [][]string
+}
+
+// parseGrid parses a grid of the form 'name=value,value,... name=...'.
+func parseGrid(spec string) (grid, error) {
+	var g grid
+	for _, field := range strings.Fields(spec) {
+		pieces := strings.SplitN(field, "=", 2)
+		if len(pieces) != 2 || len(pieces[1]) == 0 {
+			return grid{}, fmt.Errorf("Could not parse '%s' in the grid. "+
+				"Expected 'name=value,value,...'.", field)
+		}
+		name := strings.TrimLeft(pieces[0], "-")
+		if !tunable[name] {
+			return grid{}, fmt.Errorf("'%s' is not a compression "+
+				"parameter that can be tuned.", name)
+		}
+		for _, seen := range g.names {
+			if seen == name {
+				return grid{}, fmt.Errorf("'%s' appears in the grid "+
+					"more than once.", name)
+			}
+		}
+		g.names = append(g.names, name)
+		g.values = append(g.values, strings.Split(pieces[1], ","))
+	}
+	if len(g.names) == 0 {
+		return grid{}, fmt.Errorf("The grid is empty.")
+	}
+	return g, nil
+}
+
+// settings returns every combination of values in the grid. The values of
+// the first parameter change the slowest.
+func (g grid) settings() []setting {
+	settings := []setting{{}}
+	for i, name := range g.names {
+		next := make([]setting, 0, len(settings)*len(g.values[i]))
+		for _, s := range settings {
+			for _, value := range g.values[i] {
+				combined := append(setting{}, s...)
+				next = append(next, append(combined, param{name, value}))
+			}
+		}
+		settings = next
+	}
+	return settings
+}
+
+// A setting is a value for every parameter in a grid.
+type setting []param
+
+type param struct {
+	name, value string
+}
+
+func (s setting) String() string {
+	pieces := make([]string, len(s))
+	for i, p := range s {
+		pieces[i] = fmt.Sprintf("%s=%s", p.name, p.value)
+	}
+	return strings.Join(pieces, " ")
+}
+
+// args returns the mica-compress flags for the setting.
+func (s setting) args() []string {
+	args := make([]string, len(s))
+	for i, p := range s {
+		args[i] = fmt.Sprintf("--%s=%s", p.name, p.value)
+	}
+	return args
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package tune
+
+import "os"
+
+// maxRSS returns zero, since peak resident memory isn't available on this
+// platform.
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
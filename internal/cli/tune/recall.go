@@ -0,0 +1,170 @@
+package tune
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/ndaniels/mica"
+)
+
+// The BLAST tabular output used to compare hits. The subject's title is used
+// rather than its id, since BLAST numbers subjects differently in the
+// uncompressed and fine databases.
+const hitFormat = "6 qseqid evalue stitle"
+
+// A baseline holds the hits found by BLASTP when searching the queries
+// against the uncompressed sample.
+type baseline struct {
+	workDir string
+	queries []byte
+	hits    map[string]bool
+	dbSize  uint64
+}
+
+// newBaseline searches the queries in 'queryFasta' against the uncompressed
+// sample in 'sampleFasta' with BLASTP.
+func newBaseline(
+	workDir, sampleFasta, queryFasta string,
+	sample sampleInfo,
+) (*baseline, error) {
+	queries, err := mica.ReadQueryFile(queryFasta)
+	if err != nil {
+		return nil, err
+	}
+	base := &baseline{
+		workDir: workDir,
+		queries: queries,
+		dbSize:  sample.residues,
+	}
+
+	blastDB := path.Join(workDir, "baseline", "blastdb")
+	cmd := exec.Command(flagMakeBlastDB,
+		"-dbtype", "prot", "-in", sampleFasta, "-out", blastDB)
+	if err := mica.Exec(cmd); err != nil {
+		return nil, err
+	}
+
+	// The database size is set explicitly, so that e-values are the same as
+	// those of a compressive search.
+	args := []string{
+		"-db", blastDB,
+		"-dbsize", fmt.Sprintf("%d", base.dbSize),
+		"-num_threads", fmt.Sprintf("%d", flagGoMaxProcs),
+	}
+	stdout := new(bytes.Buffer)
+	cmd = exec.Command(flagBlastp, append(args, base.blastArgs()...)...)
+	cmd.Stdin = bytes.NewReader(queries)
+	cmd.Stdout = stdout
+	if err := mica.Exec(cmd); err != nil {
+		return nil, err
+	}
+	if base.hits, err = readHits(stdout, flagEvalue); err != nil {
+		return nil, err
+	}
+	if len(base.hits) == 0 {
+		return nil, fmt.Errorf("No hits were found, so recall cannot be " +
+			"measured.")
+	}
+	return base, nil
+}
+
+// blastArgs returns the arguments, other than the database, given to BLASTP
+// for both the uncompressed and the fine search.
+func (base *baseline) blastArgs() []string {
+	return []string{
+		"-outfmt", hitFormat,
+		"-evalue", fmt.Sprintf("%g", flagEvalue),
+		"-max_target_seqs", "1000000",
+	}
+}
+
+// recall searches the queries against the compressed database in 'dbDir',
+// and returns the fraction of the baseline's hits that were found.
+func (base *baseline) recall(dbDir string) (float64, error) {
+	db, err := mica.NewReadDB(dbDir)
+	if err != nil {
+		return 0, err
+	}
+	defer db.ReadClose()
+
+	blastp := mica.NewBlastAligner("blastp")
+	blastp.Exec = flagBlastp
+	blastp.MakeBlastDB = flagMakeBlastDB
+
+	searcher := mica.NewSearcher(db)
+	searcher.Coarse, searcher.Fine = blastp, blastp
+	searcher.CoarseEval = flagCoarseEval
+	searcher.Threads = flagGoMaxProcs
+	searcher.TempDir = base.workDir
+	searcher.FineArgs = base.blastArgs()
+
+	coarseHits, err := searcher.CoarseSearch(base.queries)
+	if err != nil {
+		return 0, err
+	}
+	oseqs, err := searcher.Expand(coarseHits)
+	if err != nil {
+		return 0, err
+	}
+	if len(oseqs) == 0 {
+		return 0, nil
+	}
+
+	stdout := new(bytes.Buffer)
+	if err := searcher.FineSearch(base.queries, oseqs, stdout); err != nil {
+		return 0, err
+	}
+	hits, err := readHits(stdout, flagEvalue)
+	if err != nil {
+		return 0, err
+	}
+	return recall(base.hits, hits), nil
+}
+
+// recall returns the fraction of the hits in 'want' that are also in 'got'.
+// Hits in 'got' that aren't in 'want' don't count.
+func recall(want, got map[string]bool) float64 {
+	if len(want) == 0 {
+		return 0
+	}
+	found := 0
+	for hit := range got {
+		if want[hit] {
+			found++
+		}
+	}
+	return float64(found) / float64(len(want))
+}
+
+// readHits reads BLAST tabular output in 'hitFormat', and returns the set of
+// query and subject pairs found with an e-value of at most 'evalue'.
+func readHits(r io.Reader, evalue float64) (map[string]bool, error) {
+	hits := make(map[string]bool, 1000)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Could not parse BLAST hit '%s'.", line)
+		}
+		e, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse e-value in '%s': %s",
+				line, err)
+		}
+		if e <= evalue {
+			hits[fields[0]+"\t"+fields[2]] = true
+		}
+	}
+	return hits, scanner.Err()
+}
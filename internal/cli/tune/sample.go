@@ -0,0 +1,115 @@
+package tune
+
+import (
+	"math/rand"
+	"os"
+	"sort"
+
+	"github.com/TuftsBCB/io/fasta"
+
+	"github.com/ndaniels/mica"
+)
+
+// sampleInfo describes the sequences in a sample.
+type sampleInfo struct {
+	seqs     int
+	residues uint64
+}
+
+// sampledSeq is a sequence in a sample, along with its position in the
+// input.
+type sampledSeq struct {
+	index int
+	oseq  *mica.OriginalSeq
+}
+
+// writeSample picks 'size' sequences at random from the FASTA files given
+// (or every sequence, if 'size' is zero), and writes them to the FASTA file
+// 'out' in input order.
+func writeSample(
+	out string,
+	fileNames []string,
+	size int,
+	seed int64,
+) (sampleInfo, error) {
+	res := newReservoir(size, seed)
+	for _, fileName := range fileNames {
+		seqChan, err := mica.ReadOriginalSeqs(fileName, nil)
+		if err != nil {
+			return sampleInfo{}, err
+		}
+		for readSeq := range seqChan {
+			if readSeq.Err != nil {
+				return sampleInfo{}, readSeq.Err
+			}
+			res.add(readSeq.Seq)
+		}
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return sampleInfo{}, err
+	}
+	defer f.Close()
+
+	var info sampleInfo
+	writer := fasta.NewWriter(f)
+	for _, oseq := range res.sample() {
+		if err := writer.Write(oseq.FastaSeq()); err != nil {
+			return sampleInfo{}, err
+		}
+		info.seqs++
+		info.residues += uint64(oseq.Len())
+	}
+	if err := writer.Flush(); err != nil {
+		return sampleInfo{}, err
+	}
+	return info, nil
+}
+
+// A reservoir picks 'size' sequences at random from the sequences added to
+// it (or keeps every one, if 'size' is zero). This is reservoir sampling, so
+// the input is only read once, and needn't be counted first.
+type reservoir struct {
+	size  int
+	rng   *rand.Rand
+	added int
+	seqs  []sampledSeq
+}
+
+func newReservoir(size int, seed int64) *reservoir {
+	return &reservoir{
+		size: size,
+		rng:  rand.New(rand.NewSource(seed)),
+		seqs: make([]sampledSeq, 0, size),
+	}
+}
+
+// add considers the next sequence of the input for the sample.
+func (res *reservoir) add(oseq *mica.OriginalSeq) {
+	seq := sampledSeq{res.added, oseq}
+	if res.size == 0 || len(res.seqs) < res.size {
+		res.seqs = append(res.seqs, seq)
+	} else if j := res.rng.Intn(res.added + 1); j < res.size {
+		res.seqs[j] = seq
+	}
+	res.added++
+}
+
+// sample returns the sequences picked, in input order.
+func (res *reservoir) sample() []*mica.OriginalSeq {
+	sort.Sort(inputOrder(res.seqs))
+	oseqs := make([]*mica.OriginalSeq, len(res.seqs))
+	for i, seq := range res.seqs {
+		oseqs[i] = seq.oseq
+	}
+	return oseqs
+}
+
+type inputOrder []sampledSeq
+
+func (seqs inputOrder) Len() int      { return len(seqs) }
+func (seqs inputOrder) Swap(i, j int) { seqs[i], seqs[j] = seqs[j], seqs[i] }
+func (seqs inputOrder) Less(i, j int) bool {
+	return seqs[i].index < seqs[j].index
+}
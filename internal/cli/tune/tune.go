@@ -0,0 +1,175 @@
+// Package tune implements 'mica tune' (and mica-tune), which compares the
+// compression of a sample of sequences with a grid of compression parameters.
+package tune
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+
+	"github.com/ndaniels/mica"
+	"github.com/ndaniels/mica/internal/cli"
+)
+
+var (
+	flagGrid         = "min-match-len=30,40,50 map-seed-size=5,6"
+	flagSample       = 1000
+	flagQueries      = ""
+	flagQuerySample  = 100
+	flagSeed         = int64(1)
+	flagEvalue       = 1e-3
+	flagCoarseEval   = 5.0
+	flagCompressArgs = ""
+	flagMicaCompress = "mica-compress"
+	flagMakeBlastDB  = "makeblastdb"
+	flagBlastp       = "blastp"
+	flagGoMaxProcs   = runtime.NumCPU()
+	flagOut          = ""
+	flagKeep         = false
+	flagQuiet        = false
+)
+
+// parseFlags parses the command line arguments 'args' of the command 'prog'.
+func parseFlags(prog string, args []string) *flag.FlagSet {
+	flags := cli.NewFlagSet(prog,
+		"work-directory fasta-file [fasta-file ...]\n"+
+			"\nCompresses a sample of the input with every setting in a\n"+
+			"grid of compression parameters, and writes a table comparing\n"+
+			"them.")
+
+	flags.StringVar(&flagGrid, "grid", flagGrid,
+		"The settings to try, as a list of 'name=value,value,...'\n"+
+			"\tseparated by spaces, where each name is a compression\n"+
+			"\tparameter flag of mica-compress (e.g., 'min-match-len',\n"+
+			"\t'map-seed-size', 'ext-seq-id-threshold' or\n"+
+			"\t'gapped-window-size'). Every combination is tried.")
+	flags.IntVar(&flagSample, "sample", flagSample,
+		"The number of sequences sampled (at random) from the input\n"+
+			"\tand compressed with each setting. Setting to zero uses\n"+
+			"\tthe entire input.")
+	flags.StringVar(&flagQueries, "queries", flagQueries,
+		"When set, a sample of the queries in this FASTA file is\n"+
+			"\tsearched against each compressed database, and recall is\n"+
+			"\tmeasured against BLASTP on the uncompressed sample.")
+	flags.IntVar(&flagQuerySample, "query-sample", flagQuerySample,
+		"The number of queries sampled from 'queries'. Setting to zero\n"+
+			"\tuses every query.")
+	flags.Int64Var(&flagSeed, "seed", flagSeed,
+		"The seed used to sample the input and the queries.")
+	flags.Float64Var(&flagEvalue, "evalue", flagEvalue,
+		"The e-value threshold of the hits counted when measuring recall.")
+	flags.Float64Var(&flagCoarseEval, "coarse-eval", flagCoarseEval,
+		"The e-value threshold for the coarse search when measuring\n"+
+			"\trecall.")
+	flags.StringVar(&flagCompressArgs, "compress-args", flagCompressArgs,
+		"Additional arguments given to mica-compress for every setting\n"+
+			"\t(e.g., '-p 4 --max-seeds 2').")
+	flags.StringVar(&flagMicaCompress, "mica-compress", flagMicaCompress,
+		"The location of the 'mica-compress' executable.")
+	flags.StringVar(&flagMakeBlastDB, "makeblastdb", flagMakeBlastDB,
+		"The location of the 'makeblastdb' executable.")
+	flags.StringVar(&flagBlastp, "blastp", flagBlastp,
+		"The location of the 'blastp' executable.")
+	flags.IntVar(&flagGoMaxProcs, "p", flagGoMaxProcs,
+		"The number of threads given to BLAST when measuring recall.")
+	flags.StringVar(&flagOut, "out", flagOut,
+		"When set, the comparison table is written to this file instead\n"+
+			"\tof stdout.")
+	flags.BoolVar(&flagKeep, "keep", flagKeep,
+		"When set, the compressed databases are left in the work\n"+
+			"\tdirectory.")
+	flags.BoolVar(&flagQuiet, "quiet", flagQuiet,
+		"When set, the only outputs will be errors echoed to stderr.")
+
+	flags.Parse(args)
+	return flags
+}
+
+// Main runs the command 'prog' with the command line arguments 'args' (which
+// don't include the name of the command).
+func Main(prog string, args []string) {
+	flags := parseFlags(prog, args)
+	if flags.NArg() < 2 {
+		flags.Usage()
+	}
+	if !flagQuiet {
+		mica.Verbose = true
+	}
+
+	grid, err := parseGrid(flagGrid)
+	if err != nil {
+		cli.Fatalf("%s\n", err)
+	}
+
+	workDir := flags.Arg(0)
+	if err := os.MkdirAll(workDir, 0777); err != nil {
+		cli.Fatalf("Could not create '%s': %s\n", workDir, err)
+	}
+	sampleFasta := path.Join(workDir, "sample.fasta")
+	sample, err := writeSample(
+		sampleFasta, flags.Args()[1:], flagSample, flagSeed)
+	if err != nil {
+		cli.Fatalf("Could not sample the input: %s\n", err)
+	}
+	mica.Vprintf("Sampled %d sequences (%d residues).\n",
+		sample.seqs, sample.residues)
+
+	var base *baseline
+	if len(flagQueries) > 0 {
+		queryFasta := path.Join(workDir, "queries.fasta")
+		_, err := writeSample(
+			queryFasta, []string{flagQueries}, flagQuerySample, flagSeed)
+		if err != nil {
+			cli.Fatalf("Could not sample the queries: %s\n", err)
+		}
+		base, err = newBaseline(workDir, sampleFasta, queryFasta, sample)
+		if err != nil {
+			cli.Fatalf("Could not search the uncompressed sample: %s\n", err)
+		}
+		mica.Vprintf("BLASTP found %d hits in the uncompressed sample.\n",
+			len(base.hits))
+	}
+
+	var out io.Writer = os.Stdout
+	if len(flagOut) > 0 {
+		f, err := os.Create(flagOut)
+		if err != nil {
+			cli.Fatalf("Could not create '%s': %s\n", flagOut, err)
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := writeHeader(out, grid, base != nil); err != nil {
+		cli.Fatalf("Could not write table: %s\n", err)
+	}
+
+	extraArgs := strings.Fields(flagCompressArgs)
+	for i, setting := range grid.settings() {
+		dbDir := path.Join(workDir, fmt.Sprintf("setting-%d", i))
+		mica.Vprintf("\nCompressing with %s...\n", setting)
+		result, err := compress(dbDir, sampleFasta, setting, extraArgs)
+		if err != nil {
+			cli.Fatalf("%s\n", err)
+		}
+		if err := result.measure(sample); err != nil {
+			cli.Fatalf("Could not measure %s: %s\n", dbDir, err)
+		}
+
+		if base != nil {
+			result.recall, err = base.recall(dbDir)
+			if err != nil {
+				cli.Fatalf("Could not measure recall of %s: %s\n", setting, err)
+			}
+		}
+		if err := result.write(out, setting, base != nil); err != nil {
+			cli.Fatalf("Could not write table: %s\n", err)
+		}
+		if !flagKeep {
+			os.RemoveAll(dbDir)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package tune
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ndaniels/mica"
+)
+
+func TestParseGrid(t *testing.T) {
+	tests := []struct {
+		spec  string
+		names []string
+
+		// The settings of the grid, or nil if it can't be parsed.
+		settings []string
+	}{
+		{
+			"min-match-len=30,40",
+			[]string{"min-match-len"},
+			[]string{"min-match-len=30", "min-match-len=40"},
+		},
+		{
+			"--min-match-len=30,40  map-seed-size=5,6,7",
+			[]string{"min-match-len", "map-seed-size"},
+			[]string{
+				"min-match-len=30 map-seed-size=5",
+				"min-match-len=30 map-seed-size=6",
+				"min-match-len=30 map-seed-size=7",
+				"min-match-len=40 map-seed-size=5",
+				"min-match-len=40 map-seed-size=6",
+				"min-match-len=40 map-seed-size=7",
+			},
+		},
+		{
+			"match-criterion=identity,bits",
+			[]string{"match-criterion"},
+			[]string{"match-criterion=identity", "match-criterion=bits"},
+		},
+		{"", nil, nil},
+		{"min-match-len", nil, nil},
+		{"min-match-len=", nil, nil},
+		{"max-seeds=1,2", nil, nil},
+		{"min-match-len=30 min-match-len=40", nil, nil},
+	}
+	for _, test := range tests {
+		g, err := parseGrid(test.spec)
+		if test.settings == nil {
+			if err == nil {
+				t.Fatalf("Expected an error parsing '%s', but got %v.",
+					test.spec, g)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Could not parse '%s': %s", test.spec, err)
+		}
+		if !reflect.DeepEqual(g.names, test.names) {
+			t.Fatalf("Expected the parameters of '%s' to be %v, but got %v.",
+				test.spec, test.names, g.names)
+		}
+		settings := make([]string, 0)
+		for _, s := range g.settings() {
+			settings = append(settings, s.String())
+		}
+		if !reflect.DeepEqual(settings, test.settings) {
+			t.Fatalf("Expected the settings of '%s' to be\n%s\nbut got\n%s",
+				test.spec, strings.Join(test.settings, "\n"),
+				strings.Join(settings, "\n"))
+		}
+	}
+}
+
+func TestSettingArgs(t *testing.T) {
+	s := setting{{"min-match-len", "30"}, {"match-criterion", "bits"}}
+	want := []string{"--min-match-len=30", "--match-criterion=bits"}
+	if args := s.args(); !reflect.DeepEqual(args, want) {
+		t.Fatalf("Expected the arguments %v, but got %v.", want, args)
+	}
+}
+
+func TestReservoir(t *testing.T) {
+	tests := []struct {
+		size, seqs int
+		seed       int64
+		sampled    int
+	}{
+		{0, 10, 1, 10},
+		{3, 10, 1, 3},
+		{3, 10, 2, 3},
+		{10, 10, 1, 10},
+		{20, 10, 1, 10},
+		{5, 0, 1, 0},
+	}
+	for _, test := range tests {
+		sample := func() []string {
+			res := newReservoir(test.size, test.seed)
+			for i := 0; i < test.seqs; i++ {
+				name := fmt.Sprintf("%d", i)
+				res.add(mica.NewOriginalSeq(i, name, []byte("MKV")))
+			}
+			names := make([]string, 0)
+			for _, oseq := range res.sample() {
+				names = append(names, oseq.Name)
+			}
+			return names
+		}
+		names := sample()
+		if len(names) != test.sampled {
+			t.Fatalf("Sampling %d of %d sequences picked %d: %v",
+				test.size, test.seqs, len(names), names)
+		}
+
+		// Sequences are picked once each, and are kept in input order.
+		last := -1
+		for _, name := range names {
+			var i int
+			fmt.Sscanf(name, "%d", &i)
+			if i <= last {
+				t.Fatalf("Sampling %d of %d sequences picked %v, which "+
+					"isn't in input order.", test.size, test.seqs, names)
+			}
+			last = i
+		}
+
+		// The same seed picks the same sample.
+		if again := sample(); !reflect.DeepEqual(names, again) {
+			t.Fatalf("Sampling %d of %d sequences with seed %d picked %v "+
+				"and then %v.", test.size, test.seqs, test.seed, names, again)
+		}
+	}
+
+	// Every sequence is about as likely to be picked, including the last
+	// ones.
+	picked := make([]int, 10)
+	for seed := int64(0); seed < 2000; seed++ {
+		res := newReservoir(3, seed)
+		for i := range picked {
+			res.add(mica.NewOriginalSeq(i, fmt.Sprintf("%d", i), nil))
+		}
+		for _, oseq := range res.sample() {
+			picked[oseq.Id]++
+		}
+	}
+	for i, n := range picked {
+		if n < 450 || n > 750 {
+			t.Fatalf("Sequence %d was picked %d times out of 2000, but "+
+				"should have been picked about 600 times: %v", i, n, picked)
+		}
+	}
+}
+
+func TestReadHits(t *testing.T) {
+	out := "# BLASTP 2.2.28+\n" +
+		"q1\t1e-20\ts1 first subject\n" +
+		"\n" +
+		"q1\t0.5\ts2 second subject\n" +
+		"q2\t0.001\ts1 first subject\n"
+	tests := []struct {
+		evalue float64
+		hits   []string
+	}{
+		{1e-3, []string{"q1\ts1 first subject", "q2\ts1 first subject"}},
+		{1e-4, []string{"q1\ts1 first subject"}},
+		{1, []string{"q1\ts1 first subject", "q1\ts2 second subject",
+			"q2\ts1 first subject"}},
+		{1e-30, []string{}},
+	}
+	for _, test := range tests {
+		hits, err := readHits(strings.NewReader(out), test.evalue)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[string]bool)
+		for _, hit := range test.hits {
+			want[hit] = true
+		}
+		if !reflect.DeepEqual(hits, want) {
+			t.Fatalf("Expected the hits %v with an e-value of at most %g, "+
+				"but got %v.", want, test.evalue, hits)
+		}
+	}
+
+	for _, bad := range []string{"q1\t1e-20\n", "q1\tx\ts1\n"} {
+		if _, err := readHits(strings.NewReader(bad), 1); err == nil {
+			t.Fatalf("Expected an error reading %q.", bad)
+		}
+	}
+}
+
+func TestRecall(t *testing.T) {
+	set := func(hits ...string) map[string]bool {
+		m := make(map[string]bool)
+		for _, hit := range hits {
+			m[hit] = true
+		}
+		return m
+	}
+	tests := []struct {
+		want, got map[string]bool
+		recall    float64
+	}{
+		{set("a", "b", "c", "d"), set("a", "b", "c", "d"), 1},
+		{set("a", "b", "c", "d"), set("a", "c"), 0.5},
+		{set("a", "b", "c", "d"), set("a", "x", "y", "z"), 0.25},
+		{set("a", "b"), set(), 0},
+		{set(), set("a"), 0},
+	}
+	for _, test := range tests {
+		if r := recall(test.want, test.got); r != test.recall {
+			t.Fatalf("Expected a recall of %g for %v out of %v, but got %g.",
+				test.recall, test.got, test.want, r)
+		}
+	}
+}
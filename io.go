@@ -41,15 +41,18 @@ func (coarsedb *CoarseDB) saveFasta() (err error) {
 	Vprintf("Writing %s...\n", FileCoarseFastaIndex)
 	timer := time.Now()
 
-	byteOff := int64(0)
 	buf := new(bytes.Buffer)
 
-	if coarsedb.fastaIndexSize > 0 {
-		info, err := coarsedb.FileFasta.Stat()
-		if err != nil {
-			return err
-		}
-		byteOff = info.Size()
+	// New sequences are always added to the end of the coarse database (and
+	// its index), which may already have sequences from a previous run or
+	// checkpoint.
+	info, err := coarsedb.FileFasta.Stat()
+	if err != nil {
+		return err
+	}
+	byteOff := info.Size()
+	if _, err = coarsedb.FileFastaIndex.Seek(0, os.SEEK_END); err != nil {
+		return
 	}
 
 	for i := coarsedb.seqsRead; i < len(coarsedb.Seqs); i++ {
@@ -66,6 +69,8 @@ func (coarsedb *CoarseDB) saveFasta() (err error) {
 
 		byteOff += int64(buf.Len())
 	}
+	coarsedb.fastaIndexSize += 8 * int64(len(coarsedb.Seqs)-coarsedb.seqsRead)
+	coarsedb.seqsRead = len(coarsedb.Seqs)
 
 	Vprintf("Done writing %s (%s).\n", FileCoarseFasta, time.Since(timer))
 	Vprintf("Done writing %s (%s).\n", FileCoarseFastaIndex, time.Since(timer))
@@ -80,46 +85,44 @@ func (coarsedb *CoarseDB) readSeeds() error {
 	if err != nil {
 		return fmt.Errorf("Could not create gzip reader: %s", err)
 	}
+	if err := coarsedb.readSeedsFrom(gr); err != nil {
+		return err
+	}
+	if err := gr.Close(); err != nil {
+		return fmt.Errorf("Could not close gzip reader: %s", err)
+	}
+
+	Vprintf("\t\tDone reading %s (%s).\n", FileCoarseSeeds, time.Since(timer))
+	return nil
+}
 
+// readSeedsFrom adds every seed in 'r' (as written by writeSeeds) to the
+// seeds table. Seeds are read until the end of 'r'.
+func (coarsedb *CoarseDB) readSeedsFrom(r io.Reader) (err error) {
 	var hash, cnt, seqInd uint32
 	var resInd uint16
 	for {
-		if err = binary.Read(gr, binary.BigEndian, &hash); err != nil {
+		if err = binary.Read(r, binary.BigEndian, &hash); err != nil {
 			break
 		}
-		if err = binary.Read(gr, binary.BigEndian, &cnt); err != nil {
+		if err = binary.Read(r, binary.BigEndian, &cnt); err != nil {
 			return fmt.Errorf("Could not read seed count: %s", err)
 		}
 		for i := uint32(0); i < cnt; i++ {
-			if err = binary.Read(gr, binary.BigEndian, &seqInd); err != nil {
+			if err = binary.Read(r, binary.BigEndian, &seqInd); err != nil {
 				return fmt.Errorf("Could not read seed sequence index: %s", err)
 			}
-			if err = binary.Read(gr, binary.BigEndian, &resInd); err != nil {
+			if err = binary.Read(r, binary.BigEndian, &resInd); err != nil {
 				return fmt.Errorf("Could not read seed residue index: %s", err)
 			}
 
-			sl := NewSeedLoc(seqInd, resInd)
-			if coarsedb.Seeds.Locs[hash] == nil {
-				coarsedb.Seeds.Locs[hash] = sl
-			} else {
-				lk := coarsedb.Seeds.Locs[hash]
-				for ; lk.Next != nil; lk = lk.Next {
-				}
-				lk.Next = sl
-			}
+			coarsedb.Seeds.add(int(hash), seqInd, resInd)
 		}
 	}
-	if err := gr.Close(); err != nil {
-		return fmt.Errorf("Could not close gzip reader: %s", err)
-	}
-
-	Vprintf("\t\tDone reading %s (%s).\n", FileCoarseSeeds, time.Since(timer))
 	return nil
 }
 
 func (coarsedb *CoarseDB) saveSeeds() error {
-	var i int32
-
 	Vprintf("Writing %s... (this could take a while)\n", FileCoarseSeeds)
 	timer := time.Now()
 
@@ -127,12 +130,27 @@ func (coarsedb *CoarseDB) saveSeeds() error {
 	if err != nil {
 		return err
 	}
+	if err := coarsedb.writeSeeds(gzipWriter); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+
+	Vprintf("Done writing %s (%s).\n", FileCoarseSeeds, time.Since(timer))
+	return nil
+}
+
+// writeSeeds writes every seed in the seeds table to 'w'.
+func (coarsedb *CoarseDB) writeSeeds(w io.Writer) (err error) {
+	var i int32
+
 	for i = 0; i < int32(coarsedb.Seeds.powers[coarsedb.Seeds.SeedSize]); i++ {
 		if coarsedb.Seeds.Locs[i] == nil {
 			continue
 		}
 
-		if err := binary.Write(gzipWriter, binary.BigEndian, i); err != nil {
+		if err := binary.Write(w, binary.BigEndian, i); err != nil {
 			return err
 		}
 
@@ -140,25 +158,20 @@ func (coarsedb *CoarseDB) saveSeeds() error {
 		for loc := coarsedb.Seeds.Locs[i]; loc != nil; loc = loc.Next {
 			cnt++
 		}
-		if err := binary.Write(gzipWriter, binary.BigEndian, cnt); err != nil {
+		if err := binary.Write(w, binary.BigEndian, cnt); err != nil {
 			return err
 		}
 		for loc := coarsedb.Seeds.Locs[i]; loc != nil; loc = loc.Next {
-			err = binary.Write(gzipWriter, binary.BigEndian, loc.SeqInd)
+			err = binary.Write(w, binary.BigEndian, loc.SeqInd)
 			if err != nil {
 				return err
 			}
-			err = binary.Write(gzipWriter, binary.BigEndian, loc.ResInd)
+			err = binary.Write(w, binary.BigEndian, loc.ResInd)
 			if err != nil {
 				return err
 			}
 		}
 	}
-	if err := gzipWriter.Close(); err != nil {
-		return err
-	}
-
-	Vprintf("Done writing %s (%s).\n", FileCoarseSeeds, time.Since(timer))
 	return nil
 }
 
@@ -194,27 +207,37 @@ func (coarsedb *CoarseDB) readLinks() error {
 	Vprintf("\t\tReading %s...\n", FileCoarseLinks)
 	timer := time.Now()
 
+	if err := coarsedb.readLinksFrom(coarsedb.FileLinks); err != nil {
+		return err
+	}
+
+	Vprintf("\t\tDone reading %s (%s).\n", FileCoarseLinks, time.Since(timer))
+	return nil
+}
+
+// readLinksFrom reads the links of each coarse sequence from 'r' (as written
+// by writeLinks), stopping after the last coarse sequence or at the end of
+// 'r'.
+func (coarsedb *CoarseDB) readLinksFrom(r io.Reader) error {
 	var cnt int32
-	for coarseSeqId := 0; true; coarseSeqId++ {
-		if binary.Read(coarsedb.FileLinks, binary.BigEndian, &cnt) != nil {
+	for coarseSeqId := range coarsedb.Seqs {
+		if binary.Read(r, binary.BigEndian, &cnt) != nil {
 			break
 		}
 		for i := int32(0); i < cnt; i++ {
-			newLink, err := coarsedb.readLink()
+			newLink, err := readLink(r)
 			if err != nil {
 				return err
 			}
 			coarsedb.Seqs[coarseSeqId].addLink(newLink)
 		}
 	}
-
-	Vprintf("\t\tDone reading %s (%s).\n", FileCoarseLinks, time.Since(timer))
 	return nil
 }
 
-func (coarsedb *CoarseDB) readLink() (_ *LinkToCompressed, err error) {
+func readLink(r io.Reader) (_ *LinkToCompressed, err error) {
 	br := func(data interface{}) error {
-		return binary.Read(coarsedb.FileLinks, binary.BigEndian, data)
+		return binary.Read(r, binary.BigEndian, data)
 	}
 
 	var orgSeqId uint32
@@ -237,6 +260,19 @@ func (coarsedb *CoarseDB) saveLinks() (err error) {
 	Vprintf("Writing %s...\n", FileCoarseLinksIndex)
 	timer := time.Now()
 
+	err = coarsedb.writeLinks(coarsedb.FileLinks, coarsedb.FileLinksIndex)
+	if err != nil {
+		return
+	}
+
+	Vprintf("Done writing %s (%s).\n", FileCoarseLinks, time.Since(timer))
+	Vprintf("Done writing %s (%s).\n", FileCoarseLinksIndex, time.Since(timer))
+	return nil
+}
+
+// writeLinks writes the links of every coarse sequence to 'links', and the
+// byte offset of each coarse sequence's links to 'index'.
+func (coarsedb *CoarseDB) writeLinks(links, index io.Writer) (err error) {
 	byteOff := int64(0)
 	buf := new(bytes.Buffer)
 
@@ -273,13 +309,13 @@ func (coarsedb *CoarseDB) saveLinks() (err error) {
 		}
 
 		// Write the bytes to the links file.
-		if _, err = coarsedb.FileLinks.Write(buf.Bytes()); err != nil {
+		if _, err = links.Write(buf.Bytes()); err != nil {
 			return
 		}
 
 		// Now write the byte offset that points to the start of this
 		// set of links.
-		err = binary.Write(coarsedb.FileLinksIndex, binary.BigEndian, byteOff)
+		err = binary.Write(index, binary.BigEndian, byteOff)
 		if err != nil {
 			return
 		}
@@ -287,9 +323,6 @@ func (coarsedb *CoarseDB) saveLinks() (err error) {
 		// Set the byte offset to be at the end of this set of links.
 		byteOff += int64(buf.Len())
 	}
-
-	Vprintf("Done writing %s (%s).\n", FileCoarseLinks, time.Since(timer))
-	Vprintf("Done writing %s (%s).\n", FileCoarseLinksIndex, time.Since(timer))
 	return nil
 }
 
@@ -499,15 +532,21 @@ func (comdb *CompressedDB) writer() {
 	}
 
 	for possible := range comdb.writerChan {
-		// We have to preserve the order of compressed sequences, so we don't
-		// write anything until we have the next sequence that we expect.
-		if possible.Id < nextIndex {
+		if !comdb.anyOrder && possible.Id < nextIndex {
 			panic(fmt.Sprintf("BUG: Next sequence expected is '%d', but "+
 				"we have an earlier sequence: %d", nextIndex, possible.Id))
 		}
-		saved = append(saved, possible)
-
-		cseq, saved = nextSeqToWrite(nextIndex, saved)
+		if comdb.anyOrder {
+			// Each index entry is written in place, so records can be
+			// written as soon as they arrive.
+			cseq = &possible
+		} else {
+			// We have to preserve the order of compressed sequences, so we
+			// don't write anything until we have the next sequence that we
+			// expect.
+			saved = append(saved, possible)
+			cseq, saved = nextSeqToWrite(nextIndex, saved)
+		}
 		for cseq != nil {
 
 			// Reset the buffer so it's empty. We want it to only contain
@@ -550,7 +589,13 @@ func (comdb *CompressedDB) writer() {
 			}
 
 			// Now write the byte offset that points to the start of this record
-			err = binary.Write(comdb.Index, binary.BigEndian, byteOffset)
+			if comdb.anyOrder {
+				entry := make([]byte, 8)
+				binary.BigEndian.PutUint64(entry, uint64(byteOffset))
+				_, err = comdb.Index.WriteAt(entry, int64(cseq.Id)*8)
+			} else {
+				err = binary.Write(comdb.Index, binary.BigEndian, byteOffset)
+			}
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%s\n", err)
 				os.Exit(1)
@@ -559,6 +604,9 @@ func (comdb *CompressedDB) writer() {
 			// Increment the byte offset to be at the end of this record.
 			byteOffset += int64(buf.Len())
 
+			if comdb.anyOrder {
+				break
+			}
 			nextIndex++
 			cseq, saved = nextSeqToWrite(nextIndex, saved)
 		}
@@ -566,6 +614,8 @@ func (comdb *CompressedDB) writer() {
 	if comdb.CompressedSource {
 		compressedWriter.Close()
 	}
+	comdb.Index.Sync()
+	comdb.File.Sync()
 	comdb.Index.Close()
 	comdb.File.Close()
 	comdb.writerDone <- struct{}{}
@@ -0,0 +1,478 @@
+package mica
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/TuftsBCB/io/fasta"
+)
+
+// MergeStats describes the result of merging compressed databases.
+type MergeStats struct {
+	// The number of databases merged.
+	Shards int
+
+	// The number of original sequences in the merged database.
+	OrgSeqs int
+
+	// The number of coarse sequences in all of the databases merged, and the
+	// number of residues in them.
+	CoarseSeqs     int
+	CoarseResidues int64
+
+	// The number of those coarse sequences that were found verbatim in the
+	// merged database, and the number of residues in them.
+	Duplicates        int
+	DuplicateResidues int64
+
+	// The number of coarse sequences in the merged database, and the number
+	// of residues in them.
+	MergedSeqs     int
+	MergedResidues int64
+}
+
+func (stats MergeStats) String() string {
+	return fmt.Sprintf("%d databases, %d original sequences, %d coarse "+
+		"sequences (%d residues) merged into %d coarse sequences "+
+		"(%d residues), %d of them (%d residues) found verbatim",
+		stats.Shards, stats.OrgSeqs, stats.CoarseSeqs, stats.CoarseResidues,
+		stats.MergedSeqs, stats.MergedResidues, stats.Duplicates,
+		stats.DuplicateResidues)
+}
+
+// Merge adds every sequence in the compressed databases in 'shardDirs' to
+// 'db', which must be a new database opened for writing. The databases are
+// added in the order given, and the original sequences of each are numbered
+// after those of the databases before it.
+//
+// The coarse sequences of the first database are added to 'db' as they are.
+// The coarse sequences of every later database are compressed against the
+// coarse sequences already in 'db' (with a Compressor, using the seeds table
+// of 'db'), so that the redundancy between databases is removed as well.
+// Only the residues that can't be matched are added to 'db'. The links of
+// each original sequence are then rewritten to point to the regions of 'db'
+// that its coarse sequences were compressed into. Every original sequence
+// still decompresses to exactly the same residues.
+//
+// Like compression, merging keeps the coarse sequences and the seeds table
+// of 'db' in memory. The compressed coarse sequences of a database being
+// merged are kept in a temporary directory in db.Path (or in the default
+// directory for temporary files, when 'db' isn't on disk), which is removed
+// once the database has been merged.
+func (db *DB) Merge(shardDirs []string) (MergeStats, error) {
+	stats := MergeStats{Shards: len(shardDirs)}
+	if db.ComDB.NumSequences() > 0 || len(db.CoarseDB.Seqs) > 0 {
+		return stats, fmt.Errorf("Databases can only be merged into a new " +
+			"database.")
+	}
+
+	c := NewCompressor(db)
+	mem := newMemory()
+	for i, dir := range shardDirs {
+		shard, err := NewReadDB(dir)
+		if err != nil {
+			return stats, err
+		}
+		err = db.mergeShard(shard, c, i > 0, mem, &stats)
+		shard.ReadClose()
+		if err != nil {
+			return stats, fmt.Errorf("Could not merge '%s': %s", dir, err)
+		}
+	}
+
+	stats.MergedSeqs = len(db.CoarseDB.Seqs)
+	for _, corSeq := range db.CoarseDB.Seqs {
+		stats.MergedResidues += int64(corSeq.Len())
+	}
+	return stats, nil
+}
+
+// mergeShard adds the coarse and compressed sequences of 'shard' to 'db'.
+// When 'recompress' is set, the coarse sequences of 'shard' are compressed
+// against 'db' with 'c'. Otherwise, they are added as they are.
+func (db *DB) mergeShard(shard *DB, c *Compressor, recompress bool,
+	mem *memory, stats *MergeStats) error {
+
+	Vprintf("Merging %s...\n", shard.Path)
+	timer := time.Now()
+
+	if shard.SaveCompressed {
+		return fmt.Errorf("Databases created with 'compress-source' " +
+			"cannot be merged.")
+	}
+	orgOffset := stats.OrgSeqs
+
+	dir, err := ioutil.TempDir(db.Path, "mica-merge-")
+	if err != nil {
+		return fmt.Errorf("Could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	reps, err := db.mergeReps(shard, c, recompress, dir, mem, stats)
+	if err != nil {
+		return err
+	}
+	defer reps.readClose()
+
+	// Now rewrite the links of each compressed sequence, which point to
+	// the coarse sequences of 'shard', to point to the regions of 'db' that
+	// those coarse sequences were compressed into. The compressed sequences
+	// are read by id, since they aren't necessarily in order in the
+	// compressed file.
+	coarsedb := db.CoarseDB
+	numSeqs, numReps := shard.ComDB.NumSequences(), reps.NumSequences()
+	rep := &mergeRep{id: -1}
+	for orgSeqId := 0; orgSeqId < numSeqs; orgSeqId++ {
+		cseq, err := shard.ComDB.readCompressedSeq(orgSeqId)
+		if err != nil {
+			return fmt.Errorf("Could not read compressed sequence %d: %s",
+				orgSeqId, err)
+		}
+
+		merged := NewCompressedSeq(orgOffset+orgSeqId, cseq.Name)
+		for _, lk := range cseq.Links {
+			if lk.CoarseSeqId >= uint(numReps) {
+				return fmt.Errorf("Compressed sequence %d refers to an "+
+					"invalid coarse sequence id: %d.",
+					orgSeqId, lk.CoarseSeqId)
+			}
+			if rep.id != int(lk.CoarseSeqId) {
+				rep, err = db.readMergeRep(reps, int(lk.CoarseSeqId))
+				if err != nil {
+					return fmt.Errorf("Could not read coarse sequence %d: %s",
+						lk.CoarseSeqId, err)
+				}
+			}
+			links, err := db.relink(lk, rep, mem)
+			if err != nil {
+				return fmt.Errorf("Could not relink compressed sequence "+
+					"%d: %s", orgSeqId, err)
+			}
+			for _, link := range links {
+				merged.Add(link)
+				coarsedb.Seqs[link.CoarseSeqId].AddLink(NewLinkToCompressed(
+					uint32(merged.Id), link.CoarseStart, link.CoarseEnd))
+			}
+		}
+		db.ComDB.Write(merged)
+	}
+	stats.OrgSeqs += numSeqs
+	db.BlastDBSize += shard.BlastDBSize
+
+	Vprintf("Done merging %s (%s).\n", shard.Path, time.Since(timer))
+	return nil
+}
+
+// mergeReps compresses every coarse sequence of 'shard' against 'db' (see
+// mergeShard), and writes the compressed coarse sequences to a compressed
+// database in 'dir', which is returned opened for reading. The id of each
+// compressed sequence is the id of the coarse sequence in 'shard'.
+func (db *DB) mergeReps(shard *DB, c *Compressor, recompress bool,
+	dir string, mem *memory, stats *MergeStats) (*CompressedDB, error) {
+
+	scratch := &DB{DBConf: &DBConf{}, Storage: DirStorage(dir)}
+	reps, err := newWriteCompressedDB(false, scratch)
+	if err != nil {
+		return nil, err
+	}
+
+	coarsedb := db.CoarseDB
+	fastaReader := fasta.NewReader(bufio.NewReader(shard.CoarseDB.FileFasta))
+	numReps := 0
+	for ; ; numReps++ {
+		seq, err := fastaReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			reps.writeClose()
+			return nil, fmt.Errorf("Could not read %s: %s",
+				FileCoarseFasta, err)
+		}
+		residues := make([]byte, len(seq.Residues))
+		for i, r := range seq.Residues {
+			residues[i] = byte(r)
+		}
+		stats.CoarseSeqs++
+		stats.CoarseResidues += int64(len(residues))
+
+		rep := NewCompressedSeq(numReps, "")
+		if link, ok := coarsedb.findExact(residues, mem); ok {
+			rep.Add(link)
+			stats.Duplicates++
+			stats.DuplicateResidues += int64(len(residues))
+		} else if recompress {
+			c.compress(NewOriginalSeq(numReps, "", residues), mem,
+				mergeLinker{coarsedb: coarsedb, rep: &rep})
+		} else {
+			id, _ := coarsedb.Add(residues)
+			rep.Add(NewLinkToCoarseNoDiff(uint(id), 0, uint(len(residues))))
+		}
+		reps.Write(rep)
+	}
+	reps.writeClose()
+	if numReps != shard.CoarseDB.NumSequences() {
+		return nil, fmt.Errorf("Expected %d coarse sequences, but read %d.",
+			shard.CoarseDB.NumSequences(), numReps)
+	}
+	return newReadCompressedDB(scratch)
+}
+
+// mergeLinker records the pieces of a coarse sequence being merged in 'rep'.
+// Unlike directLinker, it adds no links to the coarse sequences matched,
+// since those must point to the original sequences instead.
+type mergeLinker struct {
+	coarsedb *CoarseDB
+	rep      *CompressedSeq
+}
+
+func (lk mergeLinker) match(corSeq *CoarseSeq,
+	corSeqId, corStart, corEnd int, alignment [2][]byte) {
+
+	lk.rep.Add(NewLinkToCoarse(
+		uint(corSeqId), uint(corStart), uint(corEnd), alignment))
+}
+
+func (lk mergeLinker) unmatched(orgSub *OriginalSeq) {
+	subCpy := make([]byte, len(orgSub.Residues))
+	copy(subCpy, orgSub.Residues)
+
+	id, _ := lk.coarsedb.Add(subCpy)
+	lk.rep.Add(NewLinkToCoarseNoDiff(uint(id), 0, uint(len(subCpy))))
+}
+
+// findExact looks for 'residues' in the coarse database, by looking up the
+// first K-mer of 'residues' that has seeds. It returns a link to the
+// region of a coarse sequence with exactly those residues, if there is one.
+func (coarsedb *CoarseDB) findExact(
+	residues []byte, mem *memory) (LinkToCoarse, bool) {
+
+	k := coarsedb.Seeds.SeedSize
+	for i := 0; i+k <= len(residues); i++ {
+		kmer := residues[i : i+k]
+		if !validKmer(kmer) {
+			continue
+		}
+		seeds := coarsedb.Seeds.Lookup(kmer, &mem.seeds)
+		if len(seeds) == 0 {
+			continue
+		}
+		for _, seedLoc := range seeds {
+			corSeqId, start := int(seedLoc[0]), int(seedLoc[1])-i
+			corSeq := coarsedb.Seqs[corSeqId]
+			end := start + len(residues)
+			if start < 0 || end > corSeq.Len() {
+				continue
+			}
+			if bytes.Equal(corSeq.Residues[start:end], residues) {
+				coarsedb.Seeds.Hit(corSeqId)
+				return NewLinkToCoarseNoDiff(
+					uint(corSeqId), uint(start), uint(end)), true
+			}
+		}
+		break
+	}
+	return LinkToCoarse{}, false
+}
+
+// A mergeRep is a coarse sequence of a database being merged, along with
+// the pieces of the merged database that it was compressed into.
+type mergeRep struct {
+	id       int
+	residues []byte
+	pieces   []repPiece
+}
+
+// A repPiece is the region of a coarse sequence of the merged database that
+// the residues start to end (exclusive) of a mergeRep were compressed into.
+// toCoarse[i] is the position in link's coarse range that corresponds to
+// the residue start+i.
+type repPiece struct {
+	link       LinkToCoarse
+	start, end int
+	toCoarse   []int
+}
+
+// readMergeRep reads the compressed coarse sequence with id 'id' from
+// 'reps', and decompresses it with the coarse sequences of 'db'.
+func (db *DB) readMergeRep(reps *CompressedDB, id int) (*mergeRep, error) {
+	cseq, err := reps.readCompressedSeq(id)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := &mergeRep{id: id, pieces: make([]repPiece, len(cseq.Links))}
+	for i, lk := range cseq.Links {
+		if lk.CoarseSeqId >= uint(len(db.CoarseDB.Seqs)) {
+			return nil, fmt.Errorf("Invalid coarse sequence id: %d.",
+				lk.CoarseSeqId)
+		}
+		script, err := NewEditScriptParse(lk.Diff)
+		if err != nil {
+			return nil, err
+		}
+		corRes := db.CoarseDB.Seqs[lk.CoarseSeqId].Residues
+		corRes = corRes[lk.CoarseStart:lk.CoarseEnd]
+		_, toCoarse := editOffsets(script, len(corRes))
+
+		start := len(rep.residues)
+		rep.residues = append(rep.residues, script.Apply(corRes)...)
+		rep.pieces[i] = repPiece{
+			link:     lk,
+			start:    start,
+			end:      len(rep.residues),
+			toCoarse: toCoarse,
+		}
+	}
+	return rep, nil
+}
+
+// relink rewrites 'lk', a link to the coarse sequence 'rep' of a database
+// being merged, into links to the coarse sequences of 'db' that decompress
+// to the same residues.
+//
+// The residues of the original sequence are split where the pieces of 'rep'
+// meet, and each part is linked to the piece of 'db' it falls in. A link to
+// a piece that 'rep' was linked to without a diff keeps the diff of 'lk'.
+// Otherwise, the part is aligned again with the corresponding residues of
+// the piece.
+func (db *DB) relink(
+	lk LinkToCoarse, rep *mergeRep, mem *memory) ([]LinkToCoarse, error) {
+
+	s, e := int(lk.CoarseStart), int(lk.CoarseEnd)
+	if s > e || e > len(rep.residues) {
+		return nil, fmt.Errorf("Invalid range of coarse sequence %d: "+
+			"(%d, %d).", rep.id, s, e)
+	}
+	script, err := NewEditScriptParse(lk.Diff)
+	if err != nil {
+		return nil, err
+	}
+	orgRes := script.Apply(rep.residues[s:e])
+	toOrg, _ := editOffsets(script, e-s)
+
+	// Find the pieces of 'rep' that the range of 'lk' overlaps. An empty
+	// range (of inserted residues only) goes to the piece it touches.
+	first, last := -1, -1
+	for i, p := range rep.pieces {
+		if (p.start < e && p.end > s) || (s == e && p.end >= s) {
+			if first == -1 {
+				first = i
+			}
+			last = i
+			if s == e {
+				break
+			}
+		}
+	}
+	if first == -1 {
+		return nil, fmt.Errorf("Coarse sequence %d has no residues.", rep.id)
+	}
+
+	if p := rep.pieces[first]; first == last && len(p.link.Diff) == 0 {
+		off := int(p.link.CoarseStart) - p.start
+		return []LinkToCoarse{{
+			Diff:        lk.Diff,
+			CoarseSeqId: p.link.CoarseSeqId,
+			CoarseStart: uint16(s + off),
+			CoarseEnd:   uint16(e + off),
+		}}, nil
+	}
+
+	links := make([]LinkToCoarse, 0, last-first+1)
+	for i := first; i <= last; i++ {
+		p := rep.pieces[i]
+		x, y := max(s, p.start), min(e, p.end)
+		orgStart, orgEnd := toOrg[x-s], toOrg[y-s]
+		if i == first {
+			orgStart = 0
+		}
+		if i == last {
+			orgEnd = len(orgRes)
+		}
+		if orgStart == orgEnd {
+			continue
+		}
+		sub := orgRes[orgStart:orgEnd]
+
+		corStart := int(p.link.CoarseStart) + p.toCoarse[x-p.start]
+		corEnd := int(p.link.CoarseStart) + p.toCoarse[y-p.start]
+		corSub := db.CoarseDB.Seqs[p.link.CoarseSeqId].Residues
+		corSub = corSub[corStart:corEnd]
+
+		id := p.link.CoarseSeqId
+		if bytes.Equal(corSub, sub) {
+			links = append(links, NewLinkToCoarseNoDiff(
+				id, uint(corStart), uint(corEnd)))
+		} else {
+			links = append(links, NewLinkToCoarse(
+				id, uint(corStart), uint(corEnd),
+				NWAlign(corSub, sub, mem.align)))
+		}
+	}
+	return links, nil
+}
+
+// editOffsets returns, for a sequence 'from' of 'n' residues and an edit
+// script that turns it into the sequence 'to', the position in 'to' of every
+// position (from 0 to n, inclusive) in 'from', and the position in 'from' of
+// every position in 'to'. A residue that is inserted or deleted goes with
+// the residues before it.
+func editOffsets(script *EditScript, n int) (fromTo, toFrom []int) {
+	fromTo, toFrom = make([]int, 0, n+1), make([]int, 0, n+1)
+	from, to := 0, 0
+	copyTo := func(end int) {
+		for ; from < end; from, to = from+1, to+1 {
+			fromTo = append(fromTo, to)
+			toFrom = append(toFrom, from)
+		}
+	}
+	for _, m := range script.mods {
+		copyTo(m.Start)
+		switch m.Kind {
+		case ModSubstitution:
+			copyTo(m.End)
+		case ModDeletion:
+			for ; from < m.End; from++ {
+				fromTo = append(fromTo, to)
+			}
+		case ModInsertion:
+			for range m.Residues {
+				toFrom = append(toFrom, from)
+				to++
+			}
+		}
+	}
+	copyTo(n)
+	return append(fromTo, to), append(toFrom, from)
+}
+
+// readCompressedSeq reads the compressed sequence with id 'orgSeqId' from
+// disk, without decompressing it.
+func (comdb *CompressedDB) readCompressedSeq(
+	orgSeqId int) (CompressedSeq, error) {
+
+	off, err := comdb.orgSeqOffset(orgSeqId)
+	if err != nil {
+		return CompressedSeq{}, err
+	}
+	if _, err = comdb.File.Seek(off, os.SEEK_SET); err != nil {
+		return CompressedSeq{}, err
+	}
+
+	csvReader := csv.NewReader(comdb.File)
+	csvReader.LazyQuotes = true
+	csvReader.Comma = ','
+	csvReader.FieldsPerRecord = -1
+	record, err := csvReader.Read()
+	if err != nil {
+		return CompressedSeq{}, err
+	}
+	return readCompressedSeq(orgSeqId, record)
+}
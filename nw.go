@@ -0,0 +1,272 @@
+package mica
+
+import (
+	"github.com/ndaniels/mica/blosum"
+)
+
+// MaxAlignBand is the largest number of diagonals on either side of the main
+// diagonal that NWAlign will consider. (The band also always covers the
+// difference in length between the two sequences.)
+const MaxAlignBand = 64
+
+// minAlignBand is the smallest number of diagonals on either side of the main
+// diagonal that NWAlign will consider. Alignments of short sequences are
+// therefore (almost) never constrained.
+const minAlignBand = 12
+
+// Traceback flags stored for each cell of the dynamic programming band.
+const (
+	// The best alignment ending at a cell ends in a residue pair (nwDiag),
+	// a gap in the first sequence (nwLeft) or a gap in the second sequence
+	// (nwUp).
+	nwDiag = 0
+	nwLeft = 1
+	nwUp   = 2
+
+	// Set when the best alignment ending in a gap at a cell extends a gap
+	// ending at the previous cell, rather than opening a new one.
+	nwLeftExt = 4
+	nwUpExt   = 8
+)
+
+// Scores are never lower than this, which leaves plenty of room to subtract
+// gap penalties without overflowing.
+const nwNegInf = -(1 << 30)
+
+var (
+	resTrans [256]int
+)
+
+// Initialize the alignment lookup table. (i.e., translate ASCII residue
+// characters to BLOSUM62 matrix indices.)
+func init() {
+	for i := 0; i < len(blosum.Alphabet62); i++ {
+		resTrans[blosum.Alphabet62[i]] = i
+	}
+}
+
+// AlignMemory is the memory used by NWAlign. It is reused from one alignment
+// to the next, so that aligning doesn't allocate in the common case. An
+// AlignMemory must not be used by more than one goroutine at a time.
+//
+// Memory use is proportional to the band width times the length of the
+// sequences being aligned, rather than the product of their lengths.
+type AlignMemory struct {
+	// Scores of the previous and current rows of the band, for alignments
+	// ending in a residue pair or either kind of gap.
+	prevH, prevE, prevF []int
+	curH, curE, curF    []int
+
+	// Traceback flags for every cell in the band.
+	trace []byte
+
+	// The aligned sequences that are returned.
+	ref, org []byte
+}
+
+// NewAlignMemory allocates memory for aligning sequences of typical length.
+// It will grow if longer sequences are aligned.
+func NewAlignMemory() *AlignMemory {
+	width := 2*MaxAlignBand + 1
+	return &AlignMemory{
+		prevH: make([]int, width),
+		prevE: make([]int, width),
+		prevF: make([]int, width),
+		curH:  make([]int, width),
+		curE:  make([]int, width),
+		curF:  make([]int, width),
+		trace: make([]byte, width*1000),
+		ref:   make([]byte, 0, 1000),
+		org:   make([]byte, 0, 1000),
+	}
+}
+
+// grow makes sure there is room for a band of 'width' diagonals over 'rows'
+// rows.
+func (mem *AlignMemory) grow(width, rows int) {
+	if len(mem.curH) < width {
+		mem.prevH = make([]int, width)
+		mem.prevE = make([]int, width)
+		mem.prevF = make([]int, width)
+		mem.curH = make([]int, width)
+		mem.curE = make([]int, width)
+		mem.curF = make([]int, width)
+	}
+	if len(mem.trace) < width*rows {
+		mem.trace = make([]byte, width*rows)
+	}
+}
+
+// The gap penalties used by NWAlign: a gap of length k costs
+// nwGapOpen + k * nwGapExtend. Opening a gap costs much less than it does in
+// BLAST's alignments, since a gap in a match costs little more to store than
+// a substitution does, and matches that keep more residues identical are
+// more likely to be accepted.
+const (
+	nwGapOpen   = 2
+	nwGapExtend = 2
+)
+
+// NWAlign performs banded Needleman-Wunsch global alignment of rseq and oseq
+// with BLOSUM62 and affine gap penalties (a gap of length k costs 2 + 2k).
+// The two aligned sequences, with gaps represented by '-', are returned.
+// They are only valid until the next call to NWAlign with the same memory.
+//
+// Only cells within a band around the main diagonal are computed. The band
+// spans a quarter of the length of the longer sequence on either side of
+// the diagonal (but no more than MaxAlignBand), plus the difference in length
+// between the sequences. This bounds the number of gaps in the alignment in
+// proportion to the length of the sequences, and keeps the memory used to
+// O(band * length).
+func NWAlign(rseq, oseq []byte, mem *AlignMemory) [2][]byte {
+	return nwAlign(rseq, oseq, mem, nwGapOpen+nwGapExtend, nwGapExtend)
+}
+
+// nwAlign is NWAlign, where the first residue of a gap costs 'open' and
+// every other residue costs 'ext'.
+func nwAlign(rseq, oseq []byte, mem *AlignMemory, open, ext int) [2][]byte {
+	r, c := len(rseq), len(oseq)
+
+	band := max(r, c) / 4
+	if band < minAlignBand {
+		band = minAlignBand
+	}
+	if band > MaxAlignBand {
+		band = MaxAlignBand
+	}
+
+	// The band covers the diagonals (j - i) from lo to hi, inclusive, and
+	// each row of the band is indexed by (j - i - lo).
+	lo, hi := min(0, c-r)-band, max(0, c-r)+band
+	width := hi - lo + 1
+	mem.grow(width, r+1)
+
+	matrix := blosum.Matrix62
+	prevH, curH := mem.prevH[:width], mem.curH[:width]
+	prevE, curE := mem.prevE[:width], mem.curE[:width]
+	prevF, curF := mem.prevF[:width], mem.curF[:width]
+	trace := mem.trace[:width*(r+1)]
+
+	// The first row: gaps in rseq only.
+	for t := 0; t < width; t++ {
+		prevH[t], prevE[t], prevF[t] = nwNegInf, nwNegInf, nwNegInf
+	}
+	for j := 0; j <= min(c, hi); j++ {
+		t := j - lo
+		if j == 0 {
+			prevH[t] = 0
+			continue
+		}
+		prevE[t] = -(open + ext*(j-1))
+		prevH[t] = prevE[t]
+		trace[t] = nwLeft
+		if j > 1 {
+			trace[t] |= nwLeftExt
+		}
+	}
+
+	var h, e, f, diag int
+	var flags byte
+	for i := 1; i <= r; i++ {
+		jlo, jhi := max(0, i+lo), min(c, i+hi)
+
+		// Only cells just outside of this row of the band are ever read
+		// from this row or the next, so they are the only ones that need
+		// to be cleared.
+		if t := jlo - i - lo - 1; t >= 0 {
+			curH[t], curE[t], curF[t] = nwNegInf, nwNegInf, nwNegInf
+		}
+		if t := jhi - i - lo + 1; t < width {
+			curH[t], curE[t], curF[t] = nwNegInf, nwNegInf, nwNegInf
+		}
+		row := trace[i*width : (i+1)*width]
+		rVal := matrix[resTrans[rseq[i-1]]]
+		for j := jlo; j <= jhi; j++ {
+			t := j - i - lo
+			flags = 0
+
+			// A gap in rseq, which comes from the cell to the left.
+			e = nwNegInf
+			if t > 0 {
+				e = curH[t-1] - open
+				if extended := curE[t-1] - ext; extended > e {
+					e = extended
+					flags |= nwLeftExt
+				}
+			}
+
+			// A gap in oseq, which comes from the cell above.
+			f = nwNegInf
+			if t+1 < width {
+				f = prevH[t+1] - open
+				if extended := prevF[t+1] - ext; extended > f {
+					f = extended
+					flags |= nwUpExt
+				}
+			}
+
+			// A residue pair, which comes from the cell above and to the
+			// left. Residue pairs are preferred over gaps on ties.
+			if j == 0 {
+				h = f
+				flags |= nwUp
+			} else {
+				diag = prevH[t] + rVal[resTrans[oseq[j-1]]]
+				switch {
+				case diag >= f && diag >= e:
+					h = diag
+				case f >= e:
+					h = f
+					flags |= nwUp
+				default:
+					h = e
+					flags |= nwLeft
+				}
+			}
+			curH[t], curE[t], curF[t] = h, e, f
+			row[t] = flags
+		}
+		prevH, curH = curH, prevH
+		prevE, curE = curE, prevE
+		prevF, curF = curF, prevF
+	}
+
+	// Follow the traceback flags from the last cell back to the first.
+	refAln, orgAln := mem.ref[:0], mem.org[:0]
+	i, j := r, c
+	state := byte(nwDiag)
+	for i > 0 || j > 0 {
+		flags = trace[i*width+(j-i-lo)]
+		if state == nwDiag {
+			state = flags & 3
+		}
+		switch state {
+		case nwDiag:
+			i--
+			j--
+			refAln = append(refAln, rseq[i])
+			orgAln = append(orgAln, oseq[j])
+		case nwLeft:
+			j--
+			refAln = append(refAln, '-')
+			orgAln = append(orgAln, oseq[j])
+			if flags&nwLeftExt == 0 {
+				state = nwDiag
+			}
+		case nwUp:
+			i--
+			refAln = append(refAln, rseq[i])
+			orgAln = append(orgAln, '-')
+			if flags&nwUpExt == 0 {
+				state = nwDiag
+			}
+		}
+	}
+	for i, j := 0, len(refAln)-1; i < j; i, j = i+1, j-1 {
+		refAln[i], refAln[j] = refAln[j], refAln[i]
+		orgAln[i], orgAln[j] = orgAln[j], orgAln[i]
+	}
+	mem.ref, mem.org = refAln, orgAln
+
+	return [2][]byte{refAln, orgAln}
+}
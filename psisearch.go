@@ -0,0 +1,383 @@
+package mica
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// A PSISearcher runs compressive PSI-BLAST. Each iteration is a two stage
+// search of its own: the query's position-specific scoring matrix (PSSM) is
+// searched against the coarse database, the coarse hits are expanded, and
+// the PSSM is searched against the fine database of the expanded original
+// sequences, where psiblast refines it. The refined PSSM is searched by the
+// next iteration, so that every iteration explores (and learns from) the
+// neighborhood of the sequences found by the previous one.
+//
+// The first iteration searches the query itself. Iterations stop once they
+// have converged (the fine hits included in the PSSM are the same as the
+// previous iteration's), or once Iterations have been run. The results are
+// those of the fine search of the last iteration.
+//
+// Both stages must search with psiblast (see NewBlastAligner), except that
+// the coarse search may use any aligner when there is a single iteration.
+// Since each query has a PSSM of its own, queries are searched one at a
+// time, with up to Workers queries at the same time. (QueryBatch is
+// ignored, unless there is a single iteration and no PSSM to save, in which
+// case the search is the same as Searcher's.)
+type PSISearcher struct {
+	*Searcher
+
+	// The greatest number of iterations run for each query.
+	Iterations int
+
+	// The e-value threshold for including a fine hit in the PSSM (i.e.,
+	// psiblast's '-inclusion_ethresh').
+	InclusionEval float64
+
+	// When set, the last PSSM of each query is saved to this file, as
+	// psiblast's '-out_pssm' does. When there is more than one query, the
+	// position of the query (from 1) is added to the file's name, e.g.,
+	// "query.pssm.2".
+	SavePSSM string
+
+	// The number of queries searched.
+	queries int
+}
+
+// NewPSISearcher returns a PSISearcher that searches with the settings of
+// 's', with default settings of its own.
+func NewPSISearcher(s *Searcher) *PSISearcher {
+	return &PSISearcher{
+		Searcher:      s,
+		Iterations:    1,
+		InclusionEval: 0.002,
+		SavePSSM:      "",
+	}
+}
+
+// Search runs compressive PSI-BLAST for each of the FASTA formatted queries,
+// and writes the output of the last fine search of each query to 'out', in
+// the order of the queries.
+func (s *PSISearcher) Search(query []byte, out io.Writer) error {
+	if s.single() {
+		return s.Searcher.Search(query, out)
+	}
+	ps, err := s.perQuery(query)
+	if err != nil {
+		return err
+	}
+	return ps.searchBatches(query, func(i int, q []byte) (
+		func() error, error) {
+
+		buf := new(bytes.Buffer)
+		err := ps.iterate(i, q, buf, func(it *psiIteration) error {
+			if it.written {
+				return nil
+			}
+			fine := ps.fineAligner(it.inPSSM, "")
+			return fine.Search(ps.alignQuery(it.index, q, ps.FineArgs), buf)
+		})
+		return func() error {
+			_, err := buf.WriteTo(out)
+			return err
+		}, err
+	})
+}
+
+// SearchHits is like Search, except that the hits of the last fine search of
+// each query are written with 'hw'. (See Searcher.FineHits.)
+func (s *PSISearcher) SearchHits(query []byte, hw HitWriter) error {
+	if s.single() {
+		return s.Searcher.SearchHits(query, hw)
+	}
+	ps, err := s.perQuery(query)
+	if err != nil {
+		return err
+	}
+	return ps.searchBatches(query, func(i int, q []byte) (
+		func() error, error) {
+
+		var hits []Hit
+		err := ps.iterate(i, q, nil, func(it *psiIteration) error {
+			hits = it.hits
+			return nil
+		})
+		return func() error {
+			for _, hit := range hits {
+				if err := hw.Write(hit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, err
+	})
+}
+
+// single returns true if there is a single iteration and no PSSM to save.
+// Such a search needs no PSSM, so it is an ordinary two stage search (of
+// every query at once, unless QueryBatch is set).
+func (s *PSISearcher) single() bool {
+	return s.Iterations <= 1 && len(s.SavePSSM) == 0
+}
+
+// perQuery checks the aligners of the search, and returns a copy of the
+// PSISearcher that searches the queries given one at a time.
+func (s *PSISearcher) perQuery(query []byte) (*PSISearcher, error) {
+	if !isPSIBlast(s.Fine) {
+		return nil, fmt.Errorf("The fine search must use psiblast, not %s.",
+			s.Fine.Name())
+	}
+	if s.Iterations > 1 && !isPSIBlast(s.Coarse) {
+		return nil, fmt.Errorf("The coarse search must use psiblast, not "+
+			"%s, to search more than one iteration.", s.Coarse.Name())
+	}
+
+	searcher := *s.Searcher
+	searcher.QueryBatch = 1
+	ps := *s
+	ps.Searcher = &searcher
+	ps.queries = len(splitQueries(query, 1))
+	return &ps, nil
+}
+
+func isPSIBlast(a Aligner) bool {
+	b, ok := a.(*BlastAligner)
+	return ok && b.Program == "psiblast"
+}
+
+// psiIteration describes an iteration of the search of a query.
+type psiIteration struct {
+	// The fine database searched, and the PSSM it was searched with (or ""
+	// when the query itself was searched).
+	index, inPSSM string
+
+	// The fine hits found, and the ids of the original sequences that they
+	// included in the PSSM.
+	hits     []Hit
+	included map[int]bool
+
+	// Set when the output of the fine search was written instead of its
+	// hits being found.
+	written bool
+
+	// The PSSM refined by the fine search, or "" if none was saved.
+	outPSSM string
+}
+
+// iterate runs the iterations of the search of the 'i'th query (from 0) in
+// 'query', and gives the last one to 'last'. Its fine database is removed
+// once 'last' returns (unless NoCleanup is set).
+//
+// When 'out' isn't nil, the fine search of the Iterations'th iteration
+// writes its output to 'out' (with FineArgs) instead of finding hits, since
+// no iteration needs them after it. A query that converges earlier has had
+// its hits found, so its output must be written by 'last'.
+func (s *PSISearcher) iterate(i int, query []byte, out io.Writer,
+	last func(it *psiIteration) error) error {
+
+	dir, err := ioutil.TempDir(s.TempDir, "mica-psiblast")
+	if err != nil {
+		return fmt.Errorf("Could not create temporary directory: %s", err)
+	}
+	if s.NoCleanup {
+		Vprintf("Created temporary PSSM directory in %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+
+	var prev *psiIteration
+	cleanup := func() {}
+	defer func() { cleanup() }()
+	for n := 1; n <= s.Iterations; n++ {
+		var itOut io.Writer
+		if n == s.Iterations {
+			itOut = out
+		}
+		it, itCleanup, err := s.iteration(n, dir, query, prev, itOut)
+		if err == ErrNoCoarseHits && prev != nil {
+			Vprintf("No coarse hits in iteration %d of query %d.\n", n, i+1)
+			break
+		} else if err != nil {
+			return err
+		}
+		cleanup()
+		cleanup = itCleanup
+
+		converged := !it.written && prev != nil &&
+			sameIds(prev.included, it.included)
+		prev = it
+		if converged {
+			Vprintf("Query %d converged after %d iterations.\n", i+1, n)
+			break
+		}
+		if len(it.outPSSM) == 0 {
+			break
+		}
+	}
+
+	if len(s.SavePSSM) > 0 {
+		if err := s.savePSSM(i, prev.outPSSM); err != nil {
+			return err
+		}
+	}
+	return last(prev)
+}
+
+// iteration runs the 'n'th iteration of the search of a query, with the
+// PSSM refined by the previous iteration 'prev' (if any). PSSMs are saved in
+// the directory 'dir'. When 'out' isn't nil, the output of the fine search
+// is written to it, and no hits are found. The function returned removes the
+// iteration's fine database (unless NoCleanup is set).
+func (s *PSISearcher) iteration(n int, dir string, query []byte,
+	prev *psiIteration, out io.Writer) (*psiIteration, func(), error) {
+
+	it := &psiIteration{included: make(map[int]bool, 100)}
+	if prev != nil {
+		it.inPSSM = prev.outPSSM
+	}
+
+	Vprintf("\nIteration %d: searching coarse database with %s...\n",
+		n, s.Coarse.Name())
+	hits, err := s.coarseHits(query, it.inPSSM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error searching coarse database: %s",
+			err)
+	}
+
+	Vprintln("Expanding coarse hits...")
+	oseqs, err := s.Expand(hits)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(oseqs) == 0 {
+		return nil, nil, ErrNoCoarseHits
+	}
+
+	fineIndex, cleanup, err := s.fineIndex(oseqs, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	it.index = fineIndex
+
+	// The PSSM refined by the last iteration is only needed to be saved.
+	if n < s.Iterations || len(s.SavePSSM) > 0 {
+		it.outPSSM = path.Join(dir, fmt.Sprintf("iteration%d.pssm", n))
+	}
+	Vprintf("Searching fine database with %s...\n", s.Fine.Name())
+	fine := s.fineAligner(it.inPSSM, it.outPSSM)
+	inclusion := []string{
+		"-inclusion_ethresh", fmt.Sprintf("%g", s.InclusionEval)}
+	if out != nil {
+		args := append(append([]string{}, s.FineArgs...), inclusion...)
+		err = fine.Search(s.alignQuery(fineIndex, query, args), out)
+		it.written = true
+	} else {
+		args := append(withoutOutputArgs(s.FineArgs), inclusion...)
+		q := s.alignQuery(fineIndex, query, args)
+		err = fineHits(fine, q, oseqs, func(i int, hit Hit) error {
+			it.hits = append(it.hits, hit)
+			if hit.Evalue <= s.InclusionEval {
+				it.included[oseqs[i].Id] = true
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("Error searching fine database: %s", err)
+	}
+	if len(it.outPSSM) > 0 && fileExists(it.outPSSM) != nil {
+		Vprintf("No PSSM was saved by iteration %d.\n", n)
+		it.outPSSM = ""
+	}
+	if !it.written {
+		Vprintf("Iteration %d found %d fine hits, %d of which are "+
+			"included in the PSSM.\n", n, len(it.hits), len(it.included))
+	}
+	return it, cleanup, nil
+}
+
+// coarseHits searches the coarse database like Searcher.CoarseSearch does,
+// except that the PSSM 'pssm' is searched instead of the query when it isn't
+// empty.
+func (s *PSISearcher) coarseHits(query []byte, pssm string) ([]Hit, error) {
+	index, err := s.coarseIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	coarse := s.Coarse
+	if b, ok := coarse.(*BlastAligner); ok {
+		psi := *b
+		psi.Iterations, psi.InPSSM, psi.OutPSSM = 1, pssm, ""
+		coarse = &psi
+	}
+	hits := make([]Hit, 0, 100)
+	for readHit := range coarse.Hits(s.alignQuery(index, query, nil)) {
+		if readHit.Err != nil {
+			return nil, readHit.Err
+		}
+		hits = append(hits, readHit.Hit)
+	}
+	return hits, nil
+}
+
+// fineAligner returns the fine aligner for a single psiblast iteration that
+// searches the PSSM 'in' (or the query, if empty) and saves its refined PSSM
+// to 'out' (unless empty).
+func (s *PSISearcher) fineAligner(in, out string) *BlastAligner {
+	psi := *s.Fine.(*BlastAligner)
+	psi.Iterations, psi.InPSSM, psi.OutPSSM = 1, in, out
+	return &psi
+}
+
+// savePSSM copies the PSSM 'pssm' of the 'i'th query (from 0) to SavePSSM.
+func (s *PSISearcher) savePSSM(i int, pssm string) error {
+	if len(pssm) == 0 {
+		return fmt.Errorf("There is no PSSM to save for query %d.", i+1)
+	}
+	name := s.SavePSSM
+	if s.queries > 1 {
+		name = fmt.Sprintf("%s.%d", name, i+1)
+	}
+	bs, err := ioutil.ReadFile(pssm)
+	if err != nil {
+		return fmt.Errorf("Could not read PSSM: %s", err)
+	}
+	if err := ioutil.WriteFile(name, bs, 0666); err != nil {
+		return fmt.Errorf("Could not save PSSM: %s", err)
+	}
+	return nil
+}
+
+// sameIds returns true if 'a' and 'b' have the same ids.
+func sameIds(a, b map[int]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id := range a {
+		if !b[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutOutputArgs returns the BLAST arguments 'args' without those that
+// change where or how BLAST writes its output ('-out' and '-outfmt'), so that
+// its hits can be parsed.
+func withoutOutputArgs(args []string) []string {
+	kept := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] == "-out" || args[i] == "-outfmt" {
+			i++
+			continue
+		}
+		kept = append(kept, args[i])
+	}
+	return kept
+}
@@ -0,0 +1,215 @@
+package mica
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+)
+
+// queryStorage keeps a query database in a directory on disk, just like
+// DirStorage, except that no BLAST or DIAMOND databases are made when it is
+// saved. (Nothing is ever searched against a query database.)
+type queryStorage struct {
+	DirStorage
+}
+
+// CompressQueries compresses the nucleotide queries in the file 'fileName'
+// into a new query database with the configuration 'conf', and opens it for
+// reading. The query database is made in a temporary directory in TempDir.
+// The function returned closes it, and removes it unless NoCleanup is set.
+//
+// Since similar queries share a coarse sequence, searching the coarse
+// queries (see SearchQueryDB) may save a lot of work when there are many
+// redundant queries (e.g., the reads of a metagenome).
+func (s *Searcher) CompressQueries(
+	fileName string, conf *DBConf) (*DB, func(), error) {
+
+	dir, err := ioutil.TempDir(s.TempDir, "mica-query-db")
+	if err != nil {
+		return nil, nil, fmt.Errorf(
+			"Could not create temporary directory: %s", err)
+	}
+	remove := func() { os.RemoveAll(dir) }
+	if s.NoCleanup {
+		Vprintf("Created temporary query database in %s\n", dir)
+		remove = func() {}
+	}
+	if err := compressQueries(fileName, conf, dir); err != nil {
+		remove()
+		return nil, nil, err
+	}
+
+	qdb, err := NewReadStorageDB(queryStorage{DirStorage(dir)})
+	if err != nil {
+		remove()
+		return nil, nil, fmt.Errorf("Could not open query database: %s", err)
+	}
+	return qdb, func() {
+		qdb.ReadClose()
+		remove()
+	}, nil
+}
+
+// compressQueries compresses the queries in the file 'fileName' into a
+// query database in the directory 'dir'.
+func compressQueries(fileName string, conf *DBConf, dir string) error {
+	conf = conf.DeepCopy()
+	db, err := NewWriteStorageDB(
+		false, conf, nil, queryStorage{DirStorage(dir)})
+	if err != nil {
+		return fmt.Errorf("Could not create query database: %s", err)
+	}
+
+	seqChan, err := ReadOriginalSeqs(fileName, nil)
+	if err != nil {
+		db.WriteClose()
+		return fmt.Errorf("Could not read queries: %s", err)
+	}
+
+	// Queries that are similar are usually close to one another, so the
+	// queries are compressed with one worker.
+	Vprintln("Compressing queries...")
+	compressor := NewCompressor(db)
+	compressor.Alphabet = NucleotideAlphabet
+	compressor.Workers = 1
+	compressor.Start()
+	seqId := 0
+	for readSeq := range seqChan {
+		if readSeq.Err != nil {
+			compressor.Done()
+			db.WriteClose()
+			for range seqChan {
+			}
+			return fmt.Errorf("Could not read query: %s", readSeq.Err)
+		}
+		conf.BlastDBSize += uint64(readSeq.Seq.Len())
+		seqId = compressor.Compress(seqId, readSeq.Seq)
+	}
+	compressor.Done()
+
+	if err := db.Save(); err != nil {
+		db.WriteClose()
+		return fmt.Errorf("Could not save query database: %s", err)
+	}
+	db.WriteClose()
+	Vprintf("Compressed %d queries into %d coarse queries.\n",
+		seqId, db.CoarseDB.NumSequences())
+	return nil
+}
+
+// SearchQueryDB is like Search, except that the queries are those of the
+// query database 'qdb' (see CompressQueries). The coarse queries are
+// searched against the coarse database, and then every query that belongs
+// to a coarse query with a coarse hit is searched against the original
+// sequences expanded for the coarse hits. (See ExpandQueryHits.)
+func (s *Searcher) SearchQueryDB(qdb *DB, out io.Writer) error {
+	return s.searchQueryDB(qdb,
+		func(query []byte, oseqs []OriginalSeq) error {
+			return s.FineSearch(query, oseqs, out)
+		})
+}
+
+// SearchQueryDBHits is like SearchQueryDB, except that the hits of the fine
+// search are parsed and written with 'hw'. (See FineHits.)
+func (s *Searcher) SearchQueryDBHits(qdb *DB, hw HitWriter) error {
+	return s.searchQueryDB(qdb,
+		func(query []byte, oseqs []OriginalSeq) error {
+			return s.FineHits(query, oseqs, hw.Write)
+		})
+}
+
+// searchQueryDB runs the coarse search for the coarse queries of 'qdb',
+// expands its hits and gives the expanded queries and original sequences to
+// 'fine', which runs the fine search.
+func (s *Searcher) searchQueryDB(
+	qdb *DB, fine func(query []byte, oseqs []OriginalSeq) error) error {
+
+	coarseQueries, err := ReadQueryFile(path.Join(qdb.Path, FileCoarseFasta))
+	if err != nil {
+		return fmt.Errorf("Could not read coarse queries: %s", err)
+	}
+
+	Vprintf("\nSearching coarse queries on coarse database with %s...\n",
+		s.Coarse.Name())
+	hits, err := s.CoarseSearch(coarseQueries)
+	if err != nil {
+		return fmt.Errorf("Error searching coarse database: %s", err)
+	}
+
+	Vprintln("Expanding coarse hits (queries and targets)...")
+	oseqs, queries, err := s.ExpandQueryHits(qdb, hits)
+	if err != nil {
+		return err
+	}
+	if len(oseqs) == 0 {
+		return ErrNoCoarseHits
+	}
+
+	fineQueries := new(bytes.Buffer)
+	if err := WriteFasta(fineQueries, queries); err != nil {
+		return fmt.Errorf("Could not create FASTA input from coarse "+
+			"query hits: %s", err)
+	}
+	Vprintf("Searching %d queries on fine database with %s...\n",
+		len(queries), s.Fine.Name())
+	if err := fine(fineQueries.Bytes(), oseqs); err != nil {
+		return fmt.Errorf("Error searching fine database: %s", err)
+	}
+	return nil
+}
+
+// ExpandQueryHits expands the coarse hits from a search of the coarse
+// queries of the query database 'qdb' against the coarse database. The
+// original sequences are expanded like Triage does, while each coarse query
+// with a hit is expanded into every query that belongs to it (wherever the
+// hit is), so that no query is missed. Queries are returned in the order
+// they were compressed in.
+func (s *Searcher) ExpandQueryHits(
+	qdb *DB, hits []Hit) ([]OriginalSeq, []OriginalSeq, error) {
+
+	oseqs, stats, err := s.Triage(hits)
+	if err != nil {
+		return nil, nil, err
+	}
+	Vprintf("Triaged %s.\n", stats)
+
+	ranges := make([]CoarseRange, 0, len(hits))
+	for _, hit := range hits {
+		if hit.Evalue > s.CoarseEval {
+			continue
+		}
+		coarseQueryId, err := strconv.Atoi(hit.QueryId)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Could not parse coarse query id "+
+				"'%s': %s", hit.QueryId, err)
+		}
+		ranges = append(ranges, CoarseRange{
+			CoarseId: coarseQueryId,
+			Start:    0,
+			End:      math.MaxUint16,
+		})
+	}
+	ranges = MergeCoarseRanges(ranges)
+	queries, err := qdb.ExpandRanges(ranges)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not expand coarse queries: %s",
+			err)
+	}
+	sort.Sort(seqsById(queries))
+	Vprintf("Expanded %d coarse queries into %d queries.\n",
+		len(ranges), len(queries))
+	return oseqs, queries, nil
+}
+
+// seqsById sorts original sequences by their ids.
+type seqsById []OriginalSeq
+
+func (seqs seqsById) Len() int           { return len(seqs) }
+func (seqs seqsById) Less(i, j int) bool { return seqs[i].Id < seqs[j].Id }
+func (seqs seqsById) Swap(i, j int)      { seqs[i], seqs[j] = seqs[j], seqs[i] }
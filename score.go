@@ -0,0 +1,175 @@
+package mica
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/ndaniels/mica/blosum"
+)
+
+// Criteria that can be used to decide whether an alignment between a coarse
+// sequence and an original sequence is good enough to be a match. See
+// DBConf.MatchCriterion.
+const (
+	// Percent identity. (Gap columns count as mismatches.)
+	CriterionIdentity = "identity"
+
+	// Percent positives: columns with a positive BLOSUM62 score.
+	CriterionPositives = "positives"
+
+	// BLOSUM62 bit score per alignment column.
+	CriterionBits = "bits"
+)
+
+// The Karlin-Altschul lambda and K for BLOSUM62 with BLAST's default gap
+// penalties (open 11, extend 1). They are used to convert raw alignment
+// scores to bits and e-values.
+const (
+	blosumLambda    = 0.267
+	blosumK         = 0.041
+	blosumGapOpen   = 11
+	blosumGapExtend = 1
+)
+
+// An AcceptFunc reports whether two aligned sequences are similar enough to
+// be considered part of the same match. Both sequences must have the same
+// length, where gaps are represented by '-'.
+type AcceptFunc func(aligned1, aligned2 []byte) bool
+
+// IdentityAtLeast returns an AcceptFunc that accepts alignments with a
+// sequence identity (see SeqIdentity) of at least 'threshold'.
+func IdentityAtLeast(threshold int) AcceptFunc {
+	return func(aligned1, aligned2 []byte) bool {
+		return SeqIdentity(aligned1, aligned2) >= threshold
+	}
+}
+
+// PositivesAtLeast returns an AcceptFunc that accepts alignments where at
+// least 'threshold' percent of the columns are positives (see SeqPositives).
+func PositivesAtLeast(threshold int) AcceptFunc {
+	return func(aligned1, aligned2 []byte) bool {
+		return SeqPositives(aligned1, aligned2) >= threshold
+	}
+}
+
+// BitsAtLeast returns an AcceptFunc that accepts alignments with a bit score
+// per column (see SeqBitsPerResidue) of at least 'threshold'.
+func BitsAtLeast(threshold float64) AcceptFunc {
+	return func(aligned1, aligned2 []byte) bool {
+		return SeqBitsPerResidue(aligned1, aligned2) >= threshold
+	}
+}
+
+// ExtAccept returns the function used to decide whether each step of
+// [un]gapped extension is acceptable, according to the configured match
+// criterion.
+func (conf *DBConf) ExtAccept() AcceptFunc {
+	return conf.accept(conf.ExtSeqIdThreshold, conf.ExtBitsThreshold)
+}
+
+// MatchAccept returns the function used to decide whether an entire match
+// is acceptable, according to the configured match criterion.
+func (conf *DBConf) MatchAccept() AcceptFunc {
+	return conf.accept(conf.MatchSeqIdThreshold, conf.MatchBitsThreshold)
+}
+
+func (conf *DBConf) accept(percent int, bits float64) AcceptFunc {
+	switch conf.MatchCriterion {
+	case CriterionPositives:
+		return PositivesAtLeast(percent)
+	case CriterionBits:
+		return BitsAtLeast(bits)
+	}
+	return IdentityAtLeast(percent)
+}
+
+// ValidCriterion returns an error if 'criterion' is not a match criterion
+// known to mica.
+func ValidCriterion(criterion string) error {
+	switch criterion {
+	case CriterionIdentity, CriterionPositives, CriterionBits:
+		return nil
+	}
+	return fmt.Errorf("Unknown match criterion '%s'. Valid criteria are "+
+		"'%s', '%s' and '%s'.", criterion,
+		CriterionIdentity, CriterionPositives, CriterionBits)
+}
+
+// SeqPositives computes the percentage of columns in an alignment with a
+// positive BLOSUM62 score. Gap columns are never positive.
+// The number returned is an integer in the range 0-100, inclusive.
+// SeqPositives returns zero if the lengths of both seq1 and seq2 are zero.
+//
+// If the lengths of seq1 and seq2 are not equal, SeqPositives will panic.
+func SeqPositives(seq1, seq2 []byte) int {
+	if len(seq1) != len(seq2) {
+		log.Panicf("Sequence positives requires that len(seq1) == len(seq2), "+
+			"but %d != %d.", len(seq1), len(seq2))
+	}
+	if len(seq1) == 0 {
+		return 0
+	}
+
+	positives := 0
+	for i, r1 := range seq1 {
+		r2 := seq2[i]
+		if r1 == '-' || r2 == '-' {
+			continue
+		}
+		if blosum.Matrix62[resTrans[r1]][resTrans[r2]] > 0 {
+			positives++
+		}
+	}
+	return (positives * 100) / len(seq1)
+}
+
+// SeqBitsPerResidue computes the BLOSUM62 score of an alignment (with affine
+// gap penalties) in bits, divided by the number of columns in the alignment.
+// The constant ln(K) term of a bit score is left out, so that the result
+// doesn't depend on the length of the alignment. (An alignment of identical
+// residues scores roughly 2 bits per residue.)
+// SeqBitsPerResidue returns zero if the lengths of both seq1 and seq2 are
+// zero.
+//
+// If the lengths of seq1 and seq2 are not equal, SeqBitsPerResidue will
+// panic.
+func SeqBitsPerResidue(seq1, seq2 []byte) float64 {
+	if len(seq1) != len(seq2) {
+		log.Panicf("Sequence bit score requires that len(seq1) == len(seq2), "+
+			"but %d != %d.", len(seq1), len(seq2))
+	}
+	if len(seq1) == 0 {
+		return 0
+	}
+	bits := blosumLambda * float64(AlignmentScore(seq1, seq2)) / math.Ln2
+	return bits / float64(len(seq1))
+}
+
+// AlignmentScore computes the raw BLOSUM62 score of an alignment, where a gap
+// of length k costs 11 + k.
+func AlignmentScore(seq1, seq2 []byte) int {
+	score := 0
+	gap1, gap2 := false, false
+	for i, r1 := range seq1 {
+		r2 := seq2[i]
+		switch {
+		case r1 == '-':
+			if !gap1 {
+				score -= blosumGapOpen
+			}
+			score -= blosumGapExtend
+			gap1, gap2 = true, false
+		case r2 == '-':
+			if !gap2 {
+				score -= blosumGapOpen
+			}
+			score -= blosumGapExtend
+			gap1, gap2 = false, true
+		default:
+			score += blosum.Matrix62[resTrans[r1]][resTrans[r2]]
+			gap1, gap2 = false, false
+		}
+	}
+	return score
+}
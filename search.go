@@ -0,0 +1,526 @@
+package mica
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+// A Searcher runs the two stage search pipeline against a single mica
+// database that has been opened for reading.
+//
+// In the first stage (CoarseSearch), queries are searched against the coarse
+// database with a relaxed e-value. The hits are then expanded into the
+// original sequences that they correspond to (Expand, see Triage). In the
+// second stage (FineSearch), the expanded sequences are indexed as a
+// temporary "fine" database and the queries are searched against it.
+//
+// A Searcher should be created with NewSearcher, after which its fields may
+// be changed to suit. A Searcher should not be used concurrently, but more
+// than one Searcher may search the same DB at a time.
+type Searcher struct {
+	DB *DB
+
+	// The aligners used for the coarse and fine stages. They needn't be the
+	// same. Both default to blastp.
+	Coarse, Fine Aligner
+
+	// The e-value threshold for coarse hits. Only coarse hits with an e-value
+	// less than or equal to this threshold are expanded.
+	CoarseEval float64
+
+	// The number of threads given to each search program.
+	Threads int
+
+	// The directory in which temporary files (including the fine database)
+	// are created. When empty, the system's temporary directory is used.
+	TempDir string
+
+	// When set, temporary files and databases are left on disk.
+	NoCleanup bool
+
+	// Additional arguments passed to the program used for the fine search.
+	// (For example, an e-value threshold or an output format.)
+	FineArgs []string
+
+	// When greater than zero, the queries are split into batches of this
+	// many queries, and each batch is searched on its own: it gets its own
+	// coarse hits and its own fine database. Otherwise, the original
+	// sequences expanded for every query are pooled into one fine database.
+	QueryBatch int
+
+	// The number of batches searched at the same time when QueryBatch is
+	// set. Threads are shared evenly between them.
+	Workers int
+}
+
+// NewSearcher returns a Searcher for the given database with default
+// settings.
+func NewSearcher(db *DB) *Searcher {
+	return &Searcher{
+		DB:         db,
+		Coarse:     NewBlastAligner("blastp"),
+		Fine:       NewBlastAligner("blastp"),
+		CoarseEval: 5.0,
+		Threads:    runtime.NumCPU(),
+		TempDir:    "",
+		NoCleanup:  false,
+		FineArgs:   nil,
+		QueryBatch: 0,
+		Workers:    4,
+	}
+}
+
+// Search runs the full two stage search for the FASTA formatted queries and
+// writes the output of the fine search to 'out'.
+//
+// When QueryBatch is set, the output of each batch's fine search is written
+// in the order of the batches. (So output formats with a header, like BLAST's
+// default, have one for each batch.)
+func (s *Searcher) Search(query []byte, out io.Writer) error {
+	if s.QueryBatch <= 0 {
+		return s.search(query, func(oseqs []OriginalSeq) error {
+			return s.FineSearch(query, oseqs, out)
+		})
+	}
+	return s.searchBatches(query, func(_ int, batch []byte) (
+		func() error, error) {
+
+		buf := new(bytes.Buffer)
+		err := s.search(batch, func(oseqs []OriginalSeq) error {
+			return s.FineSearch(batch, oseqs, buf)
+		})
+		return func() error {
+			_, err := buf.WriteTo(out)
+			return err
+		}, err
+	})
+}
+
+// ErrNoCoarseHits is returned by a search when none of its queries have a
+// coarse hit.
+var ErrNoCoarseHits = fmt.Errorf("No coarse hits. Aborting.")
+
+// search runs the coarse search for the queries, expands its hits and
+// gives the original sequences to 'fine', which runs the fine search.
+func (s *Searcher) search(
+	query []byte, fine func(oseqs []OriginalSeq) error) error {
+
+	Vprintf("\nSearching query on coarse database with %s...\n",
+		s.Coarse.Name())
+	hits, err := s.CoarseSearch(query)
+	if err != nil {
+		return fmt.Errorf("Error searching coarse database: %s", err)
+	}
+
+	Vprintln("Expanding coarse hits...")
+	oseqs, err := s.Expand(hits)
+	if err != nil {
+		return err
+	}
+	if len(oseqs) == 0 {
+		return ErrNoCoarseHits
+	}
+
+	Vprintf("Searching query on fine database with %s...\n", s.Fine.Name())
+	if err := fine(oseqs); err != nil {
+		return fmt.Errorf("Error searching fine database: %s", err)
+	}
+	return nil
+}
+
+// searchBatches splits the queries into batches of QueryBatch queries and
+// calls 'search' for each of them (along with its index), with at most
+// Workers batches at a time. 'search' returns a function that writes the
+// batch's results, and these are called in the order of the batches.
+// Batches without coarse hits are skipped.
+func (s *Searcher) searchBatches(query []byte,
+	search func(i int, batch []byte) (func() error, error)) error {
+
+	// Build the coarse index (if need be) before any batch needs it.
+	if _, err := s.coarseIndex(); err != nil {
+		return fmt.Errorf("Error searching coarse database: %s", err)
+	}
+
+	type result struct {
+		write func() error
+		err   error
+	}
+	batches := splitQueries(query, s.QueryBatch)
+	results := make([]chan result, len(batches))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	workers := s.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	// Batches are started in order, so that the results of the batch that
+	// is written next are never waiting behind a later batch.
+	quit := make(chan struct{})
+	free := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	go func() {
+		for i, batch := range batches {
+			select {
+			case free <- struct{}{}:
+			case <-quit:
+				return
+			}
+			wg.Add(1)
+			go func(i int, batch []byte) {
+				defer wg.Done()
+				write, err := search(i, batch)
+				results[i] <- result{write, err}
+				<-free
+			}(i, batch)
+		}
+	}()
+
+	var err error
+	for i := range batches {
+		r := <-results[i]
+		if r.err == ErrNoCoarseHits {
+			Vprintf("No coarse hits for query batch %d.\n", i+1)
+			continue
+		} else if r.err != nil {
+			err = fmt.Errorf("Error searching query batch %d: %s",
+				i+1, r.err)
+		} else if werr := r.write(); werr != nil {
+			err = fmt.Errorf("Could not write results: %s", werr)
+		}
+		if err != nil {
+			break
+		}
+	}
+	close(quit)
+	wg.Wait()
+	return err
+}
+
+// splitQueries splits FASTA formatted queries into batches of 'size'
+// queries each.
+func splitQueries(query []byte, size int) [][]byte {
+	batches := make([][]byte, 0, 10)
+	start, count := 0, 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '>' || (i > 0 && query[i-1] != '\n') {
+			continue
+		}
+		if count == size {
+			batches = append(batches, query[start:i])
+			start, count = i, 0
+		}
+		count++
+	}
+	if count > 0 {
+		batches = append(batches, query[start:])
+	}
+	return batches
+}
+
+// CoarseSearch searches the FASTA formatted queries against the coarse
+// database and returns every hit found. The subject of each hit is a coarse
+// sequence identifier. (See Hit.CoarseSeqId.)
+//
+// If the coarse database has no index for the coarse aligner yet (e.g., it
+// was compressed before the aligner was added to mica), one is built in the
+// database's directory first.
+//
+// Note that hits are NOT filtered by CoarseEval here.
+func (s *Searcher) CoarseSearch(query []byte) ([]Hit, error) {
+	index, err := s.coarseIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make([]Hit, 0, 100)
+	for readHit := range s.Coarse.Hits(s.alignQuery(index, query, nil)) {
+		if readHit.Err != nil {
+			return nil, readHit.Err
+		}
+		hits = append(hits, readHit.Hit)
+	}
+	return hits, nil
+}
+
+// coarseIndex returns the path of the coarse aligner's index, after building
+// it if it doesn't exist yet.
+func (s *Searcher) coarseIndex() (string, error) {
+	s.DB.indexLock.Lock()
+	defer s.DB.indexLock.Unlock()
+
+	index := path.Join(s.DB.Path, s.Coarse.CoarseIndex())
+	if !s.Coarse.HasIndex(index) {
+		Vprintf("Creating coarse %s index at %s...\n",
+			s.Coarse.Name(), index)
+		fasta := path.Join(s.DB.Path, FileCoarseFasta)
+		if err := s.Coarse.Index(fasta, index); err != nil {
+			return "", err
+		}
+	}
+	return index, nil
+}
+
+// Expand decompresses the original sequences that correspond to each of the
+// coarse hits given. Hits with an e-value greater than CoarseEval are skipped,
+// and each original sequence is only returned once. (See Triage.)
+func (s *Searcher) Expand(hits []Hit) ([]OriginalSeq, error) {
+	oseqs, stats, err := s.Triage(hits)
+	if err != nil {
+		return nil, err
+	}
+	Vprintf("Triaged %s.\n", stats)
+	return oseqs, nil
+}
+
+// SearchHits runs the full two stage search for the FASTA formatted queries
+// like Search does, except that the hits of the fine search are parsed and
+// written with 'hw'. (See FineHits.)
+func (s *Searcher) SearchHits(query []byte, hw HitWriter) error {
+	if s.QueryBatch <= 0 {
+		return s.search(query, func(oseqs []OriginalSeq) error {
+			return s.FineHits(query, oseqs, hw.Write)
+		})
+	}
+	return s.searchBatches(query, func(_ int, batch []byte) (
+		func() error, error) {
+
+		hits := make([]Hit, 0, 100)
+		err := s.search(batch, func(oseqs []OriginalSeq) error {
+			return s.FineHits(batch, oseqs, func(hit Hit) error {
+				hits = append(hits, hit)
+				return nil
+			})
+		})
+		return func() error {
+			for _, hit := range hits {
+				if err := hw.Write(hit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, err
+	})
+}
+
+// FineSearch builds a temporary fine database from the original sequences
+// given, searches the FASTA formatted queries against it and writes the
+// search program's output to 'out'.
+//
+// The e-values reported are corrected for the size of the entire original
+// database, if the fine aligner supports it.
+func (s *Searcher) FineSearch(
+	query []byte, oseqs []OriginalSeq, out io.Writer) error {
+
+	fineIndex, cleanup, err := s.fineIndex(oseqs, false)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	return s.Fine.Search(s.alignQuery(fineIndex, query, s.FineArgs), out)
+}
+
+// FineHits is like FineSearch, except that the hits found are parsed and
+// given to 'fn', whatever the fine aligner is. The subject of each hit is the
+// original sequence it was found in: SubjectId is the first word of its
+// header, SubjectHeader is its entire header and OriginalId is its id.
+// Backend is the name of the fine aligner.
+//
+// Searching stops at the first error returned by 'fn'.
+func (s *Searcher) FineHits(
+	query []byte, oseqs []OriginalSeq, fn func(Hit) error) error {
+
+	fineIndex, cleanup, err := s.fineIndex(oseqs, true)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	q := s.alignQuery(fineIndex, query, s.FineArgs)
+	return fineHits(s.Fine, q, oseqs, func(_ int, hit Hit) error {
+		return fn(hit)
+	})
+}
+
+// fineHits searches a fine database built by fineIndex (with ordinals) of
+// the original sequences 'oseqs' with the aligner 'fine', and gives each hit
+// found to 'fn' along with the index in 'oseqs' of its subject. Hits are
+// changed like FineHits does.
+func fineHits(fine Aligner, q AlignQuery, oseqs []OriginalSeq,
+	fn func(i int, hit Hit) error) error {
+
+	readHits := fine.Hits(q)
+	defer func() {
+		for range readHits {
+		}
+	}()
+	for readHit := range readHits {
+		if readHit.Err != nil {
+			return readHit.Err
+		}
+		hit := readHit.Hit
+		i, err := strconv.Atoi(hit.SubjectId)
+		if err != nil || i < 0 || i >= len(oseqs) {
+			return fmt.Errorf("Subject '%s' is not in the fine database.",
+				hit.SubjectId)
+		}
+		hit.SubjectId = firstWord(oseqs[i].Name)
+		hit.SubjectHeader = oseqs[i].Name
+		hit.OriginalId = oseqs[i].Id
+		hit.Backend = fine.Name()
+		if err := fn(i, hit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fineIndex builds a temporary fine database of the original sequences
+// given with the fine aligner, and returns its path along with a function
+// that removes it (unless NoCleanup is set).
+//
+// When 'ordinals' is set, each sequence's header in the fine database is
+// its index in 'oseqs', so that every aligner reports subjects the same way.
+func (s *Searcher) fineIndex(
+	oseqs []OriginalSeq, ordinals bool) (string, func(), error) {
+
+	tmpDir, err := ioutil.TempDir(s.TempDir, "mica-fine-search-db")
+	if err != nil {
+		return "", nil, fmt.Errorf(
+			"Could not create temporary directory: %s", err)
+	}
+	cleanup := func() { os.RemoveAll(tmpDir) }
+	if s.NoCleanup {
+		Vprintf("Created temporary fine database in %s\n", tmpDir)
+		cleanup = func() {}
+	}
+
+	fineFasta := new(bytes.Buffer)
+	if ordinals {
+		for i, oseq := range oseqs {
+			fmt.Fprintf(fineFasta, ">%d\n%s\n", i, string(oseq.Residues))
+		}
+	} else if err := WriteFasta(fineFasta, oseqs); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf(
+			"Could not create FASTA input from coarse hits: %s", err)
+	}
+	fineFastaFile := path.Join(tmpDir, "fine.fasta")
+	err = ioutil.WriteFile(fineFastaFile, fineFasta.Bytes(), 0666)
+	if err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf(
+			"Could not write fine sequence file: %s", err)
+	}
+
+	Vprintf("Building fine %s index...\n", s.Fine.Name())
+	fineIndex := path.Join(tmpDir, FileBlastFine)
+	if err := s.Fine.Index(fineFastaFile, fineIndex); err != nil {
+		cleanup()
+		return "", nil, fmt.Errorf("Could not create fine database: %s", err)
+	}
+	return fineIndex, cleanup, nil
+}
+
+// alignQuery describes a search of the index given by either stage.
+func (s *Searcher) alignQuery(
+	index string, query []byte, args []string) AlignQuery {
+
+	return AlignQuery{
+		Index:     index,
+		Query:     query,
+		DBSize:    s.DB.BlastDBSize,
+		DBSeqs:    s.DB.ComDB.NumSequences(),
+		Threads:   s.threads(),
+		TempDir:   s.TempDir,
+		NoCleanup: s.NoCleanup,
+		Args:      args,
+	}
+}
+
+// threads returns the number of threads given to each search program, which
+// is Threads shared between Workers when queries are searched in batches.
+func (s *Searcher) threads() int {
+	if s.QueryBatch <= 0 || s.Workers <= 1 {
+		return s.Threads
+	}
+	if threads := s.Threads / s.Workers; threads > 1 {
+		return threads
+	}
+	return 1
+}
+
+// WriteFasta writes the original sequences given in FASTA format.
+func WriteFasta(w io.Writer, oseqs []OriginalSeq) error {
+	for _, oseq := range oseqs {
+		_, err := fmt.Fprintf(w, ">%s\n%s\n",
+			oseq.Name, string(oseq.Residues))
+		if err != nil {
+			return fmt.Errorf("Could not write FASTA: %s", err)
+		}
+	}
+	return nil
+}
+
+// ReadQueryFile reads the entire contents of a query file into memory, as
+// FASTA. The file may be in any of the formats read by ReadOriginalSeqs,
+// and may be compressed.
+func ReadQueryFile(fileName string) ([]byte, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open '%s': %s.", fileName, err)
+	}
+	defer f.Close()
+
+	bs, err := readQueries(f)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read '%s': %s", fileName, err)
+	}
+	return bs, nil
+}
+
+// readQueries reads all of the queries in 'r' as FASTA. FASTA input is
+// returned as is.
+func readQueries(r io.Reader) ([]byte, error) {
+	br, done, err := decompressInput(r)
+	if err != nil {
+		return nil, err
+	}
+	format, err := DetectFormat(br)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	if format == FormatFasta {
+		bs, err := ioutil.ReadAll(br)
+		if err != nil {
+			done()
+			return nil, err
+		}
+		return bs, done()
+	}
+
+	seqChan, err := ReadOriginalSeqsFrom(br, nil)
+	if err != nil {
+		done()
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	for readSeq := range seqChan {
+		if readSeq.Err != nil {
+			done()
+			return nil, readSeq.Err
+		}
+		fmt.Fprintf(buf, ">%s\n%s\n",
+			readSeq.Seq.Name, string(readSeq.Seq.Residues))
+	}
+	return buf.Bytes(), done()
+}
@@ -1,9 +1,14 @@
 package mica
 
 import (
+	"encoding/binary"
+	"fmt"
+	"io"
 	"log"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/ndaniels/mica/blosum"
 )
@@ -80,6 +85,88 @@ type Seeds struct {
 
 	// The total number of seeds in the table.
 	numSeeds int64
+
+	// Bookkeeping for bounding the size of the table. See Seeds.Limit.
+	limit *seedLimit
+}
+
+// Policies for evicting seeds when the seeds table grows past its limit.
+// See Seeds.Limit.
+const (
+	// Evict every seed.
+	EvictWipe = "wipe"
+
+	// Evict all seeds of the oldest coarse sequences.
+	EvictOldest = "oldest"
+
+	// Evict all seeds of the coarse sequences that have been matched the
+	// fewest times. Sequences added since the last eviction are spared.
+	EvictLeastHit = "least-hit"
+
+	// Evict seeds of the most frequent K-mers, so that no K-mer has more
+	// than some number of (evenly spaced) seed locations. If that isn't
+	// enough, the seeds of the oldest coarse sequences are evicted too.
+	EvictSubsample = "subsample"
+)
+
+// When a seeds table is full, seeds are evicted until the table is at this
+// fraction of its limit. This keeps evictions (which scan the entire table)
+// from happening too often.
+const seedEvictTarget = 0.9
+
+// The size in bytes of a single seed location.
+const seedLocSize = 16
+
+// SeedStats describes the seeds evicted from a seeds table.
+type SeedStats struct {
+	// The number of times that seeds were evicted.
+	Evictions int
+
+	// The total number of seeds evicted.
+	EvictedSeeds int64
+
+	// The number of coarse sequences that lost all of their seeds.
+	EvictedSeqs int
+}
+
+func (stats SeedStats) String() string {
+	return fmt.Sprintf("%d seeds evicted from %d coarse sequences "+
+		"in %d evictions", stats.EvictedSeeds, stats.EvictedSeqs,
+		stats.Evictions)
+}
+
+// seedLimit keeps track of the information needed to evict seeds.
+// It is protected by the seeds table's lock, except for the hits counters,
+// which are updated atomically with only a read lock held.
+type seedLimit struct {
+	maxSeeds int64
+	policy   string
+
+	// The number of seeds and the number of matches for each coarse
+	// sequence, indexed by coarse sequence id.
+	seqSeeds []uint32
+	hits     []uint32
+
+	// Coarse sequences with an id of at least 'young' were added since the
+	// last eviction.
+	young int
+
+	// All coarse sequences with an id less than 'oldest' have no seeds.
+	oldest int
+
+	stats SeedStats
+}
+
+// ValidEvictPolicy returns an error if 'policy' is not a seed eviction
+// policy known to mica.
+func ValidEvictPolicy(policy string) error {
+	switch policy {
+	case EvictWipe, EvictOldest, EvictLeastHit, EvictSubsample:
+		return nil
+	}
+	return fmt.Errorf("Unknown seed eviction policy '%s'. Valid policies "+
+		"are '%s', '%s', '%s' and '%s'.", policy,
+		EvictWipe, EvictOldest, EvictLeastHit, EvictSubsample)
 }
 
 // NewSeeds creates a new table of seed location lists. The table is
@@ -104,6 +191,7 @@ func NewSeeds(seedSize, lowComplexityWindow int) Seeds {
 		lock:                &sync.RWMutex{},
 		powers:              powers,
 		numSeeds:            0,
+		limit:               &seedLimit{},
 	}
 }
 
@@ -116,26 +204,90 @@ func (ss Seeds) NumSeeds() int64 {
 	return ss.numSeeds
 }
 
-// MaybeWipe completely wipes the seeds table if the memory of the seeds table
-// exceeds seedTableSizeGB (which is the number of gigabytes).
-func (ss *Seeds) MaybeWipe(seedTableSizeGB float64) {
-	seedLocSize := int64(16)
+// Limit bounds the memory used by the seeds table to seedTableSizeGB
+// gigabytes. Whenever adding a coarse sequence makes the table bigger than
+// that, seeds are evicted according to 'policy' (one of the Evict*
+// constants). A size of zero removes the limit.
+func (ss *Seeds) Limit(seedTableSizeGB float64, policy string) error {
+	if err := ValidEvictPolicy(policy); err != nil {
+		return err
+	}
+
+	ss.lock.Lock()
 	maxSeedBytes := seedTableSizeGB * 1024.0 * 1024.0 * 1024.0
-	maxSeeds := int64(maxSeedBytes) / seedLocSize
-	if ss.NumSeeds() >= maxSeeds {
-		println("Blowing away seeds table...")
-		ss.lock.Lock() // acquire write lock to blow away seeds table
-		defer ss.lock.Unlock()
+	ss.limit.maxSeeds = int64(maxSeedBytes) / seedLocSize
+	ss.limit.policy = policy
+	ss.lock.Unlock()
+	return nil
+}
 
-		for i := range ss.Locs {
-			ss.Locs[i] = nil
-		}
-		ss.numSeeds = 0
+// Stats returns statistics on the seeds evicted so far.
+func (ss Seeds) Stats() SeedStats {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+
+	return ss.limit.stats
+}
+
+// writeLimit writes the bookkeeping used to decide which seeds to evict, so
+// that eviction continues exactly as before when it is restored by
+// readLimit.
+func (ss Seeds) writeLimit(w io.Writer) error {
+	ss.lock.RLock()
+	defer ss.lock.RUnlock()
+
+	lim := ss.limit
+	header := []int64{
+		int64(lim.young), int64(lim.oldest),
+		int64(lim.stats.Evictions), lim.stats.EvictedSeeds,
+		int64(lim.stats.EvictedSeqs), int64(len(lim.hits)),
+	}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
 	}
+	return binary.Write(w, binary.BigEndian, lim.hits)
+}
+
+// readLimit restores the bookkeeping written by writeLimit. It must be
+// called before any seeds are added to the table.
+func (ss *Seeds) readLimit(r io.Reader) error {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	header := make([]int64, 6)
+	if err := binary.Read(r, binary.BigEndian, header); err != nil {
+		return fmt.Errorf("Could not read seed eviction state: %s", err)
+	}
+	hits := make([]uint32, header[5])
+	if err := binary.Read(r, binary.BigEndian, hits); err != nil {
+		return fmt.Errorf("Could not read seed hits: %s", err)
+	}
+
+	lim := ss.limit
+	lim.young, lim.oldest = int(header[0]), int(header[1])
+	lim.stats = SeedStats{
+		Evictions:    int(header[2]),
+		EvictedSeeds: header[3],
+		EvictedSeqs:  int(header[4]),
+	}
+	lim.hits = hits
+	lim.seqSeeds = make([]uint32, len(hits))
+	return nil
+}
+
+// Hit records that a match was found in the coarse sequence with index
+// 'coarseSeqIndex'. This is used to decide which seeds to keep when the
+// table is full.
+func (ss Seeds) Hit(coarseSeqIndex int) {
+	ss.lock.RLock()
+	if coarseSeqIndex < len(ss.limit.hits) {
+		atomic.AddUint32(&ss.limit.hits[coarseSeqIndex], 1)
+	}
+	ss.lock.RUnlock()
 }
 
 // Add will create seed locations for all K-mers in corSeq and add them to
-// the seeds table.
+// the seeds table. If the table is then too big, seeds are evicted.
 func (ss *Seeds) Add(coarseSeqIndex int, corSeq *CoarseSeq) {
 	ss.lock.Lock()
 	// Don't use defer. It comes with a performance penalty in hot spots.
@@ -146,22 +298,246 @@ func (ss *Seeds) Add(coarseSeqIndex int, corSeq *CoarseSeq) {
 		}
 
 		kmer := corSeq.Residues[i : i+ss.SeedSize]
+		ss.add(ss.hashKmer(kmer), uint32(coarseSeqIndex), uint16(i))
+	}
+	if ss.limit.maxSeeds > 0 && ss.numSeeds > ss.limit.maxSeeds {
+		ss.evict()
+	}
+
+	ss.lock.Unlock()
+}
+
+// add appends a single seed location to the list for the K-mer with hash
+// 'kmerIndex'. The caller must hold the write lock.
+func (ss *Seeds) add(kmerIndex int, seqInd uint32, resInd uint16) {
+	loc := NewSeedLoc(seqInd, resInd)
+	ss.numSeeds++
 
-		kmerIndex := ss.hashKmer(kmer)
-		loc := NewSeedLoc(uint32(coarseSeqIndex), uint16(i))
+	lim := ss.limit
+	for int(seqInd) >= len(lim.seqSeeds) {
+		lim.seqSeeds = append(lim.seqSeeds, 0)
+		lim.hits = append(lim.hits, 0)
+	}
+	lim.seqSeeds[seqInd]++
+
+	if ss.Locs[kmerIndex] == nil {
+		ss.Locs[kmerIndex] = loc
+	} else {
+		lk := ss.Locs[kmerIndex]
+		for ; lk.Next != nil; lk = lk.Next {
+		}
+		lk.Next = loc
+	}
+}
+
+// addFront is like Add, except that each seed location is put at the front
+// of its list, so that adding takes the same time no matter how long the
+// lists grow. (The order of the locations in a list only matters during
+// compression.) Seeds for K-mers with residues outside of the BLOSUM62
+// alphabet are skipped, and the table's limit is not enforced.
+func (ss *Seeds) addFront(seqInd int, residues []byte) {
+	ss.lock.Lock()
+	for i := 0; i+ss.SeedSize <= len(residues); i++ {
+		kmer := residues[i : i+ss.SeedSize]
+		if !validKmer(kmer) {
+			continue
+		}
+		if IsLowComplexity(residues, i, ss.lowComplexityWindow) {
+			continue
+		}
+		hash := ss.hashKmer(kmer)
+		loc := NewSeedLoc(uint32(seqInd), uint16(i))
+		loc.Next = ss.Locs[hash]
+		ss.Locs[hash] = loc
 		ss.numSeeds++
+	}
+	ss.lock.Unlock()
+}
 
-		if ss.Locs[kmerIndex] == nil {
-			ss.Locs[kmerIndex] = loc
-		} else {
-			lk := ss.Locs[kmerIndex]
-			for ; lk.Next != nil; lk = lk.Next {
+// validKmer returns true if every residue in 'kmer' is in the BLOSUM62
+// alphabet (and can therefore be hashed).
+func validKmer(kmer []byte) bool {
+	for _, b := range kmer {
+		if b < 'A' || b > 'Z' || SeedAlphaNums[b-'A'] == -1 {
+			return false
+		}
+	}
+	return true
+}
+
+// evict removes seeds from the table, according to the eviction policy,
+// until the table is at seedEvictTarget of its limit. The caller must hold
+// the write lock.
+func (ss *Seeds) evict() {
+	lim := ss.limit
+	target := int64(float64(lim.maxSeeds) * seedEvictTarget)
+	before := ss.numSeeds
+
+	switch lim.policy {
+	case EvictWipe:
+		ss.removeSeqs(func(seqInd uint32) bool { return true })
+	case EvictOldest:
+		ss.evictOldest(before - target)
+	case EvictLeastHit:
+		ss.evictLeastHit(before - target)
+	case EvictSubsample:
+		ss.evictSubsample(target)
+		if ss.numSeeds > target {
+			ss.evictOldest(ss.numSeeds - target)
+		}
+	}
+	lim.young = len(lim.seqSeeds)
+	lim.stats.Evictions++
+	lim.stats.EvictedSeeds += before - ss.numSeeds
+
+	Vprintf("\nEvicted %d seeds (%s policy); %d seeds remain.\n",
+		before-ss.numSeeds, lim.policy, ss.numSeeds)
+}
+
+// evictOldest evicts all seeds of the oldest coarse sequences that still
+// have seeds, until at least 'need' seeds are evicted.
+func (ss *Seeds) evictOldest(need int64) {
+	lim := ss.limit
+	cutoff, evicting := lim.oldest, int64(0)
+	for ; cutoff < len(lim.seqSeeds) && evicting < need; cutoff++ {
+		evicting += int64(lim.seqSeeds[cutoff])
+	}
+	lim.oldest = cutoff
+	ss.removeSeqs(func(seqInd uint32) bool {
+		return int(seqInd) < cutoff
+	})
+}
+
+// evictLeastHit evicts all seeds of the coarse sequences with the fewest
+// hits, until at least 'need' seeds are evicted. Sequences added since the
+// last eviction haven't had a chance to be matched yet, so they are only
+// evicted as a last resort. Hit counts are halved afterwards, so that old
+// hits count for less than new ones.
+func (ss *Seeds) evictLeastHit(need int64) {
+	lim := ss.limit
+	candidates := make([]int, 0, len(lim.seqSeeds))
+	for id := lim.oldest; id < len(lim.seqSeeds); id++ {
+		if lim.seqSeeds[id] > 0 {
+			candidates = append(candidates, id)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		idi, idj := candidates[i], candidates[j]
+		youngi, youngj := idi >= lim.young, idj >= lim.young
+		if youngi != youngj {
+			return youngj
+		}
+		return lim.hits[idi] < lim.hits[idj]
+	})
+
+	evicted := make(map[uint32]bool, 100)
+	evicting := int64(0)
+	for _, id := range candidates {
+		if evicting >= need {
+			break
+		}
+		evicted[uint32(id)] = true
+		evicting += int64(lim.seqSeeds[id])
+	}
+	ss.removeSeqs(func(seqInd uint32) bool { return evicted[seqInd] })
+
+	for id := range lim.hits {
+		lim.hits[id] /= 2
+	}
+}
+
+// evictSubsample caps the number of seed locations for every K-mer, so that
+// there are at most 'target' seeds in the table. The locations that are kept
+// for each K-mer are evenly spaced over its list. Every K-mer keeps at least
+// one location, so there may still be more than 'target' seeds left.
+func (ss *Seeds) evictSubsample(target int64) {
+	// Find the biggest cap that leaves at most 'target' seeds. 'longer[n]'
+	// is the number of K-mers with more than n locations.
+	longer := make([]int64, 1)
+	for _, loc := range ss.Locs {
+		n := 0
+		for ; loc != nil; loc = loc.Next {
+			if n == len(longer) {
+				longer = append(longer, 0)
 			}
-			lk.Next = loc
+			longer[n]++
+			n++
 		}
 	}
+	keep, total := 0, int64(0)
+	for ; keep < len(longer) && total+longer[keep] <= target; keep++ {
+		total += longer[keep]
+	}
+	keep = max(1, keep)
 
-	ss.lock.Unlock()
+	lim := ss.limit
+	for i, loc := range ss.Locs {
+		if loc == nil {
+			continue
+		}
+		n := 0
+		for lk := loc; lk != nil; lk = lk.Next {
+			n++
+		}
+		if n <= keep {
+			continue
+		}
+
+		// Keep the k'th location whenever k*keep/n ticks over.
+		var head, tail *SeedLoc
+		for k, lk := 0, loc; lk != nil; k, lk = k+1, lk.Next {
+			if (k*keep)/n != ((k+1)*keep)/n {
+				if head == nil {
+					head = lk
+				} else {
+					tail.Next = lk
+				}
+				tail = lk
+				continue
+			}
+			ss.numSeeds--
+			lim.seqSeeds[lk.SeqInd]--
+			if lim.seqSeeds[lk.SeqInd] == 0 {
+				lim.stats.EvictedSeqs++
+			}
+		}
+		if tail != nil {
+			tail.Next = nil
+		}
+		ss.Locs[i] = head
+	}
+}
+
+// removeSeqs removes every seed location of the coarse sequences for which
+// 'evict' returns true.
+func (ss *Seeds) removeSeqs(evict func(seqInd uint32) bool) {
+	lim := ss.limit
+	for i, loc := range ss.Locs {
+		if loc == nil {
+			continue
+		}
+		var head, tail *SeedLoc
+		for lk := loc; lk != nil; lk = lk.Next {
+			if !evict(lk.SeqInd) {
+				if head == nil {
+					head = lk
+				} else {
+					tail.Next = lk
+				}
+				tail = lk
+				continue
+			}
+			ss.numSeeds--
+			lim.seqSeeds[lk.SeqInd]--
+			if lim.seqSeeds[lk.SeqInd] == 0 {
+				lim.stats.EvictedSeqs++
+			}
+		}
+		if tail != nil {
+			tail.Next = nil
+		}
+		ss.Locs[i] = head
+	}
 }
 
 // Lookup returns a list of all seed locations corresponding to a particular
@@ -0,0 +1,267 @@
+package mica
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// The number of bytes of memory assumed to be used by each sequence in a
+// sorted run, in addition to its name and residues.
+const seqSortOverhead = 64
+
+// ReadSortedOriginalSeqs reads every sequence in the FASTA files given (in
+// the same way as ReadOriginalSeqs) and returns a channel that they are sent
+// to, longest first. Sequences of the same length are sent in input order.
+//
+// Sequences are numbered in input order, starting at 'firstId', so that
+// every sequence has the id that it would have had without sorting.
+//
+// At most (about) 'maxMem' bytes of sequences are sorted in memory at once.
+// If the input is bigger than that, sorted runs are written to temporary
+// files in 'tempDir' (or the default directory for temporary files, if
+// 'tempDir' is empty) and merged. The temporary files are removed once the
+// last sequence has been sent.
+func ReadSortedOriginalSeqs(
+	fileNames []string,
+	ignore []byte,
+	firstId int,
+	maxMem int64,
+	tempDir string,
+) (chan ReadOriginalSeq, error) {
+	inputs := make([]chan ReadOriginalSeq, len(fileNames))
+	for i, fileName := range fileNames {
+		seqChan, err := ReadOriginalSeqs(fileName, ignore)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = seqChan
+	}
+	return SortOriginalSeqs(inputs, firstId, maxMem, tempDir), nil
+}
+
+// SortOriginalSeqs is ReadSortedOriginalSeqs, except that the sequences are
+// read from the channels in 'inputs' (in order) instead of files. Their ids
+// are replaced with their position in the input, starting at 'firstId'.
+func SortOriginalSeqs(
+	inputs []chan ReadOriginalSeq,
+	firstId int,
+	maxMem int64,
+	tempDir string,
+) chan ReadOriginalSeq {
+	seqChan := make(chan ReadOriginalSeq, 200)
+	go func() {
+		defer close(seqChan)
+
+		sorter := &seqSorter{maxMem: maxMem, tempDir: tempDir}
+		defer sorter.cleanup()
+
+		id := firstId
+		for _, input := range inputs {
+			for readSeq := range input {
+				if readSeq.Err != nil {
+					seqChan <- readSeq
+					return
+				}
+				readSeq.Seq.Id = id
+				id++
+				if err := sorter.add(readSeq.Seq); err != nil {
+					seqChan <- ReadOriginalSeq{Err: err}
+					return
+				}
+			}
+		}
+		if err := sorter.send(seqChan); err != nil {
+			seqChan <- ReadOriginalSeq{Err: err}
+		}
+	}()
+	return seqChan
+}
+
+// seqSorter is an external memory sort of original sequences, longest first.
+type seqSorter struct {
+	maxMem  int64
+	tempDir string
+
+	// The current run, which is kept in memory until it is too big.
+	run     []*OriginalSeq
+	runSize int64
+
+	// Sorted runs that have been written to disk.
+	runFiles []*os.File
+}
+
+// add adds a sequence to the current run, and writes the run to disk if it
+// has become too big.
+func (sorter *seqSorter) add(oseq *OriginalSeq) error {
+	sorter.run = append(sorter.run, oseq)
+	sorter.runSize += int64(len(oseq.Name)+oseq.Len()) + seqSortOverhead
+	if sorter.runSize >= sorter.maxMem {
+		return sorter.flush()
+	}
+	return nil
+}
+
+// flush sorts the current run and writes it to a temporary file.
+func (sorter *seqSorter) flush() error {
+	if len(sorter.run) == 0 {
+		return nil
+	}
+	sort.Sort(longestFirst(sorter.run))
+
+	f, err := ioutil.TempFile(sorter.tempDir, "mica-sort-run")
+	if err != nil {
+		return fmt.Errorf("Could not create temporary file: %s", err)
+	}
+	sorter.runFiles = append(sorter.runFiles, f)
+
+	w := bufio.NewWriter(f)
+	for _, oseq := range sorter.run {
+		if err := writeRunSeq(w, oseq); err != nil {
+			return fmt.Errorf("Could not write to '%s': %s", f.Name(), err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("Could not write to '%s': %s", f.Name(), err)
+	}
+	if _, err := f.Seek(0, os.SEEK_SET); err != nil {
+		return fmt.Errorf("Could not seek in '%s': %s", f.Name(), err)
+	}
+
+	sorter.run = sorter.run[:0]
+	sorter.runSize = 0
+	return nil
+}
+
+// send sends every sequence added, longest first. If every sequence fit in
+// memory, no temporary files are used.
+func (sorter *seqSorter) send(seqChan chan ReadOriginalSeq) error {
+	if len(sorter.runFiles) == 0 {
+		sort.Sort(longestFirst(sorter.run))
+		for _, oseq := range sorter.run {
+			seqChan <- ReadOriginalSeq{Seq: oseq}
+		}
+		return nil
+	}
+	if err := sorter.flush(); err != nil {
+		return err
+	}
+
+	// Merge the runs by repeatedly taking the longest sequence at the head
+	// of any run.
+	runs := make(runHeap, 0, len(sorter.runFiles))
+	for _, f := range sorter.runFiles {
+		r := &runReader{name: f.Name(), r: bufio.NewReader(f)}
+		if err := r.next(); err != nil {
+			return err
+		}
+		if r.head != nil {
+			runs = append(runs, r)
+		}
+	}
+	heap.Init(&runs)
+	for len(runs) > 0 {
+		r := runs[0]
+		seqChan <- ReadOriginalSeq{Seq: r.head}
+		if err := r.next(); err != nil {
+			return err
+		}
+		if r.head == nil {
+			heap.Pop(&runs)
+		} else {
+			heap.Fix(&runs, 0)
+		}
+	}
+	return nil
+}
+
+// cleanup removes all temporary files.
+func (sorter *seqSorter) cleanup() {
+	for _, f := range sorter.runFiles {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
+
+// writeRunSeq writes a single sequence to a run file: its id, followed by
+// the length and bytes of its name and its residues.
+func writeRunSeq(w io.Writer, oseq *OriginalSeq) error {
+	header := []int64{
+		int64(oseq.Id), int64(len(oseq.Name)), int64(len(oseq.Residues)),
+	}
+	if err := binary.Write(w, binary.BigEndian, header); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, oseq.Name); err != nil {
+		return err
+	}
+	_, err := w.Write(oseq.Residues)
+	return err
+}
+
+// runReader reads sequences back from a run file, one at a time.
+type runReader struct {
+	name string
+	r    *bufio.Reader
+	head *OriginalSeq
+}
+
+// next reads the next sequence in the run into 'head', which is nil when
+// the run is exhausted.
+func (run *runReader) next() error {
+	header := make([]int64, 3)
+	if err := binary.Read(run.r, binary.BigEndian, header); err != nil {
+		if err == io.EOF {
+			run.head = nil
+			return nil
+		}
+		return fmt.Errorf("Could not read from '%s': %s", run.name, err)
+	}
+
+	buf := make([]byte, header[1]+header[2])
+	if _, err := io.ReadFull(run.r, buf); err != nil {
+		return fmt.Errorf("Could not read from '%s': %s", run.name, err)
+	}
+	run.head = &OriginalSeq{Sequence: &Sequence{
+		Name:     string(buf[:header[1]]),
+		Residues: buf[header[1]:],
+		Offset:   0,
+		Id:       int(header[0]),
+	}}
+	return nil
+}
+
+// longestFirst sorts sequences by length (longest first), and then by id.
+type longestFirst []*OriginalSeq
+
+func (seqs longestFirst) Len() int      { return len(seqs) }
+func (seqs longestFirst) Swap(i, j int) { seqs[i], seqs[j] = seqs[j], seqs[i] }
+func (seqs longestFirst) Less(i, j int) bool {
+	return seqBefore(seqs[i], seqs[j])
+}
+
+func seqBefore(a, b *OriginalSeq) bool {
+	if a.Len() != b.Len() {
+		return a.Len() > b.Len()
+	}
+	return a.Id < b.Id
+}
+
+// runHeap is a heap of runs, ordered by the sequence at the head of each.
+type runHeap []*runReader
+
+func (h runHeap) Len() int            { return len(h) }
+func (h runHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h runHeap) Less(i, j int) bool  { return seqBefore(h[i].head, h[j].head) }
+func (h *runHeap) Push(x interface{}) { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() interface{} {
+	old := *h
+	run := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return run
+}
@@ -0,0 +1,51 @@
+package mica
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+)
+
+// Ways of assigning sequences to shards. (See ShardOf.)
+const (
+	PartitionHash      = "hash"
+	PartitionMinimizer = "minimizer"
+)
+
+// ShardOf returns the shard (from 0 to shards-1) that a sequence with the
+// given residues belongs to.
+//
+// With PartitionHash, sequences are spread evenly over the shards by a hash
+// of their residues, so exact duplicates always end up in the same shard.
+//
+// With PartitionMinimizer, sequences are assigned by their minimizer: the
+// K-mer (of size 'k') with the smallest hash. Similar sequences usually
+// share their minimizer, so they tend to end up in the same shard, where
+// they can be compressed against each other. Sequences shorter than 'k' are
+// assigned by a hash of their residues.
+func ShardOf(residues []byte, shards int, partition string, k int) int {
+	switch partition {
+	case PartitionHash:
+		return hashShard(residues, shards)
+	case PartitionMinimizer:
+		if len(residues) < k {
+			return hashShard(residues, shards)
+		}
+		min := uint32(0)
+		for i := 0; i+k <= len(residues); i++ {
+			h := fnv.New32a()
+			h.Write(residues[i : i+k])
+			if sum := h.Sum32(); i == 0 || sum < min {
+				min = sum
+			}
+		}
+		return int(min % uint32(shards))
+	}
+	panic(fmt.Sprintf("Unknown partition '%s'.", partition))
+}
+
+func hashShard(residues []byte, shards int) int {
+	digest := md5.Sum(residues)
+	return int(binary.BigEndian.Uint64(digest[:8]) % uint64(shards))
+}
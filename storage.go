@@ -0,0 +1,270 @@
+package mica
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+// A File is one of the files of a mica database. An *os.File is a File.
+type File interface {
+	io.Reader
+	io.Writer
+	io.WriterAt
+	io.Seeker
+	io.Closer
+
+	Name() string
+	Stat() (os.FileInfo, error)
+	Truncate(size int64) error
+	Sync() error
+}
+
+// A Storage holds the files of a mica database. The files of a database
+// stored in a directory on disk are in a DirStorage, and the files of a
+// database that is only kept in memory are in a MemStorage.
+type Storage interface {
+	// OpenFile opens the named file with the given flags (os.O_RDONLY,
+	// os.O_RDWR, os.O_CREATE, os.O_TRUNC and os.O_APPEND), just like
+	// os.OpenFile.
+	OpenFile(name string, flag int) (File, error)
+}
+
+// storageCreate creates the named file in 'store' (truncating it if it
+// already exists) and opens it for reading and writing.
+func storageCreate(store Storage, name string) (File, error) {
+	return store.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+}
+
+// storageOpen opens the named file in 'store' for reading.
+func storageOpen(store Storage, name string) (File, error) {
+	return store.OpenFile(name, os.O_RDONLY)
+}
+
+// DirStorage is the Storage of a database in a directory on disk.
+type DirStorage string
+
+func (dir DirStorage) OpenFile(name string, flag int) (File, error) {
+	f, err := os.OpenFile(path.Join(string(dir), name), flag, 0666)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// MemStorage is the Storage of a database that is only kept in memory. It
+// is meant for tests and small sets of sequences. Note that programs like
+// BLAST cannot search a database in memory, since they need files on disk.
+//
+// A MemStorage is safe to use from multiple goroutines, but each File opened
+// from it may only be used by one goroutine at a time.
+type MemStorage struct {
+	lock  *sync.Mutex
+	files map[string]*memData
+}
+
+// NewMemStorage returns an empty MemStorage.
+func NewMemStorage() MemStorage {
+	return MemStorage{
+		lock:  &sync.Mutex{},
+		files: make(map[string]*memData, 10),
+	}
+}
+
+// memData is the contents of a file in a MemStorage. The contents are
+// shared by every File opened with the same name.
+type memData struct {
+	lock    *sync.Mutex
+	bytes   []byte
+	modTime time.Time
+}
+
+func (store MemStorage) OpenFile(name string, flag int) (File, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	data, ok := store.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{
+				Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		data = &memData{lock: &sync.Mutex{}, modTime: time.Now()}
+		store.files[name] = data
+	}
+	f := &memFile{
+		name:     name,
+		data:     data,
+		readOnly: flag&(os.O_WRONLY|os.O_RDWR) == 0,
+		appnd:    flag&os.O_APPEND != 0,
+	}
+	if flag&os.O_TRUNC != 0 && !f.readOnly {
+		f.Truncate(0)
+	}
+	return f, nil
+}
+
+// memFile is a File opened from a MemStorage.
+type memFile struct {
+	name     string
+	data     *memData
+	off      int64
+	readOnly bool
+	appnd    bool
+	closed   bool
+}
+
+func (f *memFile) check(op string, write bool) error {
+	if f.closed {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrClosed}
+	}
+	if write && f.readOnly {
+		return &os.PathError{Op: op, Path: f.name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	f.data.lock.Lock()
+	defer f.data.lock.Unlock()
+
+	if f.off >= int64(len(f.data.bytes)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data.bytes[f.off:])
+	f.off += int64(n)
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	f.data.lock.Lock()
+	defer f.data.lock.Unlock()
+
+	if f.appnd {
+		f.off = int64(len(f.data.bytes))
+	}
+	f.off = f.data.writeAt(p, f.off)
+	return len(p), nil
+}
+
+func (f *memFile) WriteAt(p []byte, off int64) (int, error) {
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.appnd {
+		return 0, fmt.Errorf("WriteAt on %s, which was opened with "+
+			"O_APPEND.", f.name)
+	}
+	f.data.lock.Lock()
+	defer f.data.lock.Unlock()
+
+	f.data.writeAt(p, off)
+	return len(p), nil
+}
+
+// writeAt writes 'p' at offset 'off', growing the file if necessary, and
+// returns the offset of the end of 'p'. The caller must hold the lock.
+func (data *memData) writeAt(p []byte, off int64) int64 {
+	end := off + int64(len(p))
+	if end > int64(len(data.bytes)) {
+		data.bytes = append(data.bytes,
+			make([]byte, end-int64(len(data.bytes)))...)
+	}
+	copy(data.bytes[off:], p)
+	data.modTime = time.Now()
+	return end
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.check("seek", false); err != nil {
+		return 0, err
+	}
+	f.data.lock.Lock()
+	defer f.data.lock.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += int64(len(f.data.bytes))
+	default:
+		return 0, fmt.Errorf("Invalid whence: %d", whence)
+	}
+	if offset < 0 {
+		return 0, &os.PathError{
+			Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	f.off = offset
+	return offset, nil
+}
+
+func (f *memFile) Close() error {
+	if err := f.check("close", false); err != nil {
+		return err
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *memFile) Name() string {
+	return f.name
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	if err := f.check("stat", false); err != nil {
+		return nil, err
+	}
+	f.data.lock.Lock()
+	defer f.data.lock.Unlock()
+
+	return memFileInfo{
+		name:    f.name,
+		size:    int64(len(f.data.bytes)),
+		modTime: f.data.modTime,
+	}, nil
+}
+
+func (f *memFile) Truncate(size int64) error {
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	f.data.lock.Lock()
+	defer f.data.lock.Unlock()
+
+	if size < int64(len(f.data.bytes)) {
+		f.data.bytes = f.data.bytes[:size]
+	} else {
+		f.data.bytes = append(f.data.bytes,
+			make([]byte, size-int64(len(f.data.bytes)))...)
+	}
+	f.data.modTime = time.Now()
+	return nil
+}
+
+func (f *memFile) Sync() error {
+	return f.check("sync", false)
+}
+
+// memFileInfo describes a file in a MemStorage.
+type memFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (info memFileInfo) Name() string       { return info.name }
+func (info memFileInfo) Size() int64        { return info.size }
+func (info memFileInfo) Mode() os.FileMode  { return 0666 }
+func (info memFileInfo) ModTime() time.Time { return info.modTime }
+func (info memFileInfo) IsDir() bool        { return false }
+func (info memFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,220 @@
+package mica
+
+import (
+	"github.com/ndaniels/mica/blosum"
+)
+
+// Traceback flags stored for each cell of a Smith-Waterman alignment. The low
+// two bits say where the best local alignment ending at a cell comes from,
+// while the others say whether gaps ending at the cell extend a gap rather
+// than open one.
+const (
+	swStop = 0
+	swDiag = 1
+	swLeft = 2 // a gap in the query
+	swUp   = 3 // a gap in the subject
+
+	swLeftExt = 4
+	swUpExt   = 8
+)
+
+// swAlignment is the best local alignment between a query and a subject.
+// Coordinates are 0-based and half-open.
+type swAlignment struct {
+	Score int
+
+	QueryStart, QueryEnd     int
+	SubjectStart, SubjectEnd int
+
+	// The number of columns, identical columns, mismatched columns (columns
+	// without a gap that aren't identical) and gaps.
+	Length, Identities, Mismatches, GapOpens int
+}
+
+// SWMemory is the memory used by swAlign. It is reused from one alignment to
+// the next. A SWMemory must not be used by more than one goroutine at a time.
+type SWMemory struct {
+	prevH, prevF, curH, curF []int
+	trace                    []byte
+}
+
+// NewSWMemory allocates memory for aligning sequences of typical length with
+// the band of the native aligner. It will grow if longer sequences are
+// aligned.
+func NewSWMemory() *SWMemory {
+	return &SWMemory{trace: make([]byte, 0, 1000*(2*nativeBand+1))}
+}
+
+// swAlign finds the best local alignment of 'query' and 'subject' with
+// BLOSUM62 and affine gap penalties, where a gap of length k costs 11 + k
+// (just like AlignmentScore). Both sequences must only contain residues in
+// the BLOSUM62 alphabet.
+//
+// The alignment is banded: only the residues query[i] and subject[j] with
+// j - i within 'band' of 'diag' are aligned. Time and memory are therefore
+// proportional to the length of the query times the width of the band (plus
+// the length of the subject).
+func swAlign(mem *SWMemory, query, subject []byte, diag, band int) swAlignment {
+	rows, cols, width := len(query)+1, len(subject)+1, 2*band+1
+	if cap(mem.prevH) < cols {
+		mem.prevH, mem.prevF = make([]int, cols), make([]int, cols)
+		mem.curH, mem.curF = make([]int, cols), make([]int, cols)
+	}
+	prevH, prevF := mem.prevH[:cols], mem.prevF[:cols]
+	curH, curF := mem.curH[:cols], mem.curF[:cols]
+	if cap(mem.trace) < rows*width {
+		mem.trace = make([]byte, rows*width)
+	}
+	trace := mem.trace[:rows*width]
+
+	// The cell (i, j) is in the band of row i when j - i is within 'band'
+	// of 'diag'. Its traceback is at trace[at(i, j)].
+	first := func(i int) int { return i + diag - band }
+	at := func(i, j int) int { return i*width + j - first(i) }
+
+	// Cells outside of the band are never written, so they start (and stay)
+	// as if no alignment ended there.
+	openExt := blosumGapOpen + blosumGapExtend
+	for j := 0; j < cols; j++ {
+		prevH[j], prevF[j] = 0, nwNegInf
+		curH[j], curF[j] = 0, nwNegInf
+	}
+
+	best, bestI, bestJ := 0, 0, 0
+	for i := 1; i < rows; i++ {
+		lo, hi := max(1, first(i)), min(cols-1, first(i)+width-1)
+		if lo > hi {
+			if lo > cols-1 {
+				break
+			}
+			continue
+		}
+		scores := blosum.Matrix62[resTrans[query[i-1]]]
+
+		// The cell left of the band still holds the row before last.
+		curH[lo-1], curF[lo-1] = 0, nwNegInf
+		e := nwNegInf
+		for j := lo; j <= hi; j++ {
+			var flags byte
+
+			// A gap in the query, which consumes a subject residue.
+			if e-blosumGapExtend > curH[j-1]-openExt {
+				e -= blosumGapExtend
+				flags |= swLeftExt
+			} else {
+				e = curH[j-1] - openExt
+			}
+
+			// A gap in the subject, which consumes a query residue.
+			f := prevF[j] - blosumGapExtend
+			if f > prevH[j]-openExt {
+				flags |= swUpExt
+			} else {
+				f = prevH[j] - openExt
+			}
+			curF[j] = f
+
+			h, from := 0, byte(swStop)
+			if diag := prevH[j-1] + scores[resTrans[subject[j-1]]]; diag > h {
+				h, from = diag, swDiag
+			}
+			if e > h {
+				h, from = e, swLeft
+			}
+			if f > h {
+				h, from = f, swUp
+			}
+			curH[j] = h
+			trace[at(i, j)] = flags | from
+
+			if h > best {
+				best, bestI, bestJ = h, i, j
+			}
+		}
+		prevH, curH = curH, prevH
+		prevF, curF = curF, prevF
+	}
+
+	aln := swAlignment{
+		Score:      best,
+		QueryEnd:   bestI,
+		SubjectEnd: bestJ,
+	}
+	i, j, state := bestI, bestJ, byte(swDiag)
+	for i > 0 && j > 0 {
+		t := trace[at(i, j)]
+		switch state {
+		case swDiag:
+			from := t & 3
+			if from == swStop {
+				aln.QueryStart, aln.SubjectStart = i, j
+				return aln
+			}
+			if from != swDiag {
+				state = from
+				aln.GapOpens++
+				continue
+			}
+			aln.Length++
+			if query[i-1] == subject[j-1] {
+				aln.Identities++
+			} else {
+				aln.Mismatches++
+			}
+			i, j = i-1, j-1
+		case swLeft:
+			aln.Length++
+			if t&swLeftExt == 0 {
+				state = swDiag
+			}
+			j--
+		case swUp:
+			aln.Length++
+			if t&swUpExt == 0 {
+				state = swDiag
+			}
+			i--
+		}
+	}
+	aln.QueryStart, aln.SubjectStart = i, j
+	return aln
+}
+
+// xdropExtend extends an exact seed match of 'size' residues between the
+// query at 'qpos' and the subject at 'spos' in both directions without gaps.
+// Each extension stops once its score falls more than 'xdrop' below the best
+// score seen. The best score is returned along with the query position that
+// the extension ends at (exclusive).
+func xdropExtend(query, subject []byte, qpos, spos, size, xdrop int) (
+	best, qend int) {
+
+	pair := func(q, s int) int {
+		return blosum.Matrix62[resTrans[query[q]]][resTrans[subject[s]]]
+	}
+	for k := 0; k < size; k++ {
+		best += pair(qpos+k, spos+k)
+	}
+
+	qend = qpos + size
+	run := best
+	for q, s := qpos+size, spos+size; q < len(query) && s < len(subject); {
+		run += pair(q, s)
+		q, s = q+1, s+1
+		if run > best {
+			best, qend = run, q
+		} else if best-run > xdrop {
+			break
+		}
+	}
+
+	run = best
+	for q, s := qpos-1, spos-1; q >= 0 && s >= 0; q, s = q-1, s-1 {
+		run += pair(q, s)
+		if run > best {
+			best = run
+		} else if best-run > xdrop {
+			break
+		}
+	}
+	return best, qend
+}
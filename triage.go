@@ -0,0 +1,144 @@
+package mica
+
+import (
+	"fmt"
+	"sort"
+)
+
+// A CoarseRange is a range of residues in a coarse sequence that should be
+// expanded into the original sequences linked to it. Start and End are
+// inclusive and use the same coordinates as the hits they come from.
+type CoarseRange struct {
+	CoarseId   int
+	Start, End int
+}
+
+// MergeCoarseRanges merges the ranges of each coarse sequence that overlap
+// (or are adjacent), so that every coarse sequence is expanded once per
+// union of ranges. Expanding the merged ranges yields exactly the same
+// original sequences as expanding each of the given ranges.
+//
+// Coarse sequences are returned in the order they first appear in `ranges`,
+// and the ranges of each coarse sequence are sorted by their start.
+func MergeCoarseRanges(ranges []CoarseRange) []CoarseRange {
+	order := make([]int, 0, len(ranges))
+	byId := make(map[int][]CoarseRange, len(ranges))
+	for _, r := range ranges {
+		if r.Start > r.End {
+			r.Start, r.End = r.End, r.Start
+		}
+		if _, ok := byId[r.CoarseId]; !ok {
+			order = append(order, r.CoarseId)
+		}
+		byId[r.CoarseId] = append(byId[r.CoarseId], r)
+	}
+
+	merged := make([]CoarseRange, 0, len(order))
+	for _, id := range order {
+		rs := byId[id]
+		sort.Slice(rs, func(i, j int) bool { return rs[i].Start < rs[j].Start })
+
+		cur := rs[0]
+		for _, r := range rs[1:] {
+			if r.Start <= cur.End+1 {
+				if r.End > cur.End {
+					cur.End = r.End
+				}
+				continue
+			}
+			merged = append(merged, cur)
+			cur = r
+		}
+		merged = append(merged, cur)
+	}
+	return merged
+}
+
+// ExpandRanges decompresses the original sequences linked to each of the
+// coarse ranges given. Each original sequence is decompressed (and returned)
+// only once, even if it is linked to more than one range.
+//
+// ExpandRanges may be called concurrently.
+func (db *DB) ExpandRanges(ranges []CoarseRange) ([]OriginalSeq, error) {
+	db.expandLock.Lock()
+	defer db.expandLock.Unlock()
+
+	used := make(map[int]bool, 100)
+	oseqs := make([]OriginalSeq, 0, 100)
+	for _, r := range ranges {
+		someOseqs, err := db.CoarseDB.expand(db.ComDB,
+			r.CoarseId, r.Start, r.End, used)
+		if err != nil {
+			return nil, fmt.Errorf("Could not decompress coarse sequence "+
+				"%d (%d, %d): %s", r.CoarseId, r.Start, r.End, err)
+		}
+		oseqs = append(oseqs, someOseqs...)
+	}
+	return oseqs, nil
+}
+
+// TriageStats describes how a set of coarse hits was triaged before it was
+// expanded. (See Searcher.Triage.)
+type TriageStats struct {
+	// The number of coarse hits, and how many of those were rejected because
+	// their e-value is greater than the coarse e-value threshold.
+	Hits, Rejected int
+
+	// The number of distinct coarse sequences hit, and the number of ranges
+	// expanded once overlapping hits were merged.
+	CoarseSeqs, Ranges int
+
+	// The number of original sequences decompressed.
+	Expanded int
+}
+
+// Saved returns the number of coarse sequence expansions saved by triage,
+// compared to expanding every coarse hit on its own.
+func (st TriageStats) Saved() int {
+	return st.Hits - st.Ranges
+}
+
+func (st TriageStats) String() string {
+	return fmt.Sprintf("%d coarse hits (%d rejected by e-value) in %d coarse "+
+		"sequences; %d merged ranges expanded (%d expansions saved) into %d "+
+		"original sequences", st.Hits, st.Rejected, st.CoarseSeqs,
+		st.Ranges, st.Saved(), st.Expanded)
+}
+
+// Triage expands the coarse hits given into the original sequences that
+// they correspond to. Hits with an e-value greater than CoarseEval are
+// rejected before anything is decompressed. The remaining hits are
+// aggregated by coarse sequence, and their overlapping ranges are merged so
+// that each coarse sequence is expanded once per union of ranges. Each
+// original sequence is only returned once.
+func (s *Searcher) Triage(hits []Hit) ([]OriginalSeq, TriageStats, error) {
+	stats := TriageStats{Hits: len(hits)}
+	ranges := make([]CoarseRange, 0, len(hits))
+	for _, hit := range hits {
+		if hit.Evalue > s.CoarseEval {
+			stats.Rejected++
+			continue
+		}
+		coarseId, err := hit.CoarseSeqId()
+		if err != nil {
+			return nil, stats, err
+		}
+		ranges = append(ranges,
+			CoarseRange{coarseId, hit.SubjectStart, hit.SubjectEnd})
+	}
+
+	ranges = MergeCoarseRanges(ranges)
+	stats.Ranges = len(ranges)
+	for i, r := range ranges {
+		if i == 0 || r.CoarseId != ranges[i-1].CoarseId {
+			stats.CoarseSeqs++
+		}
+	}
+
+	oseqs, err := s.DB.ExpandRanges(ranges)
+	if err != nil {
+		return nil, stats, err
+	}
+	stats.Expanded = len(oseqs)
+	return oseqs, stats, nil
+}